@@ -0,0 +1,175 @@
+package checkout
+
+import (
+	"embed"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/internal/utils"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultPrimaryColor = "#1a73e8"
+	defaultAccentColor  = "#fbbc04"
+)
+
+//go:embed templates/*.html
+var templateFs embed.FS
+
+var checkoutTemplate = template.Must(template.ParseFS(templateFs, "templates/checkout.html"))
+
+type checkoutPage struct {
+	PaymentId      string
+	State          string
+	Selection      bool
+	Open           bool
+	Paid           bool
+	Failed         bool
+	Currencies     []enum.Currency
+	Currency       string
+	CurrencyName   string
+	PayAddress     string
+	PayAmount      string
+	PaymentUri     template.URL
+	ActuallyPaid   string
+	PriceAmount    float64
+	PriceCurrency  string
+	ExpireTime     string
+	QrCode         template.HTML
+	SuccessPageUrl string
+	FailurePageUrl string
+	Mode           string
+	Branding       model.Branding
+}
+
+// ServeCheckout renders the hosted checkout page of an invoice.
+// The page listens on the websocket and reloads itself on every state update.
+func ServeCheckout(w http.ResponseWriter, r *http.Request, paymentRepository repository.IPaymentRepository, merchantRepository repository.IMerchantRepository) {
+	paymentId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid payment id", http.StatusBadRequest)
+		return
+	}
+
+	payment, err := paymentRepository.FindByPaymentId(r.Context(), paymentId)
+	if err != nil {
+		http.Error(w, "payment not found", http.StatusNotFound)
+		return
+	}
+
+	merchant, err := merchantRepository.FindById(r.Context(), payment.MerchantId)
+	if err != nil {
+		http.Error(w, "payment not found", http.StatusNotFound)
+		return
+	}
+
+	page, err := newCheckoutPage(payment, merchant)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Checkout page failed", "payment_id", payment.ID, "error", err)
+		http.Error(w, "checkout page could not be rendered", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Frame-Options", "DENY")
+	err = checkoutTemplate.Execute(w, page)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "Checkout page failed", "payment_id", payment.ID, "error", err)
+	}
+}
+
+func newCheckoutPage(payment *model.Payment, merchant *model.Merchant) (*checkoutPage, error) {
+	currentState := payment.PaymentStates[0] //states are sorted
+	page := &checkoutPage{
+		PaymentId:      payment.ID.String(),
+		State:          currentState.PaymentState.String(),
+		PriceAmount:    payment.PriceAmount,
+		PriceCurrency:  strings.ToUpper(payment.PriceCurrency.String()),
+		SuccessPageUrl: payment.SuccessPageUrl,
+		FailurePageUrl: payment.FailurePageUrl,
+		Mode:           payment.Mode.String(),
+		Branding:       merchant.Branding,
+	}
+	if page.Branding.PrimaryColor == "" {
+		page.Branding.PrimaryColor = defaultPrimaryColor
+	}
+	if page.Branding.AccentColor == "" {
+		page.Branding.AccentColor = defaultAccentColor
+	}
+
+	switch currentState.PaymentState {
+	case enum.CurrencySelection:
+		page.Selection = true
+		page.Currencies = availableCurrencies(payment.Mode, merchant.Wallets)
+		return page, nil
+	case enum.Waiting, enum.PartiallyPaid:
+		page.Open = true
+	case enum.Paid, enum.Confirmed, enum.Forwarded, enum.Finished:
+		page.Paid = true
+	case enum.Expired, enum.Failed:
+		page.Failed = true
+	}
+
+	payAmount, err := utils.ConvertAmountToBaseString(payment.PayCurrency, currentState.PayAmount.Int)
+	if err != nil {
+		return nil, err
+	}
+	actuallyPaid, err := utils.ConvertAmountToBaseString(payment.PayCurrency, currentState.ActuallyPaid.Int)
+	if err != nil {
+		return nil, err
+	}
+	page.Currency = strings.ToUpper(payment.PayCurrency.String())
+	page.CurrencyName = currencyName(payment.PayCurrency)
+	page.PayAddress = payment.PayAddress
+	page.PayAmount = utils.TrimAmount(payAmount)
+	page.ActuallyPaid = utils.TrimAmount(actuallyPaid)
+	page.ExpireTime = model.GetWaitingCreateDate(payment).Add(15 * time.Minute).Format(time.RFC3339)
+
+	if page.Open {
+		paymentUri, err := model.GetPaymentUri(payment)
+		if err != nil {
+			return nil, err
+		}
+		// bitcoin: and ethereum: are no safe schemes for html/template, the uri is built by us
+		page.PaymentUri = template.URL(paymentUri)
+		qrCode, err := utils.QrCodeSvg(paymentUri)
+		if err != nil {
+			return nil, err
+		}
+		// the svg is generated by us and contains no user input
+		page.QrCode = template.HTML(qrCode)
+	}
+	return page, nil
+}
+
+// availableCurrencies returns the currencies the merchant has a wallet for in the mode of the payment
+func availableCurrencies(mode enum.Mode, wallets []model.Wallet) []enum.Currency {
+	var currencies []enum.Currency
+	for _, c := range enum.GetCryptoCurrencyDetails() {
+		for _, w := range wallets {
+			if w.Mode == mode && w.Currency.String() == c.ShortName {
+				currencies = append(currencies, c)
+				break
+			}
+		}
+	}
+	return currencies
+}
+
+func currencyName(currency enum.CryptoCurrency) string {
+	for _, c := range enum.GetCryptoCurrencyDetails() {
+		if c.ShortName == currency.String() {
+			return c.Name
+		}
+	}
+	return currency.String()
+}
@@ -0,0 +1,92 @@
+package checkout
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/utils"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+)
+
+func newTestPayment(state enum.State) *model.Payment {
+	waiting := model.PaymentState{
+		PaymentState: enum.Waiting,
+		PayAmount:    model.NewBigIntFromString("12300000000000000"),
+		ActuallyPaid: model.NewBigIntFromInt(0),
+	}
+	waiting.CreatedAt = time.Now()
+	current := waiting
+	current.PaymentState = state
+	return &model.Payment{
+		Base:           model.Base{ID: uuid.New()},
+		Mode:           enum.Test,
+		PriceAmount:    10.5,
+		PriceCurrency:  enum.CHF,
+		PayCurrency:    enum.ETH,
+		PayAddress:     "0x1dB3439a222C519ab44bb1144fC28167b4Fa6EE6",
+		SuccessPageUrl: "https://shop.example.com/success",
+		PaymentStates:  []model.PaymentState{current, waiting},
+	}
+}
+
+var testMerchant = &model.Merchant{
+	Branding: model.Branding{LogoUrl: "https://shop.example.com/logo.png", PrimaryColor: "#112233"},
+	Wallets: []model.Wallet{
+		{Currency: enum.ETH, Mode: enum.Test},
+		{Currency: enum.BTC, Mode: enum.Main},
+	},
+}
+
+func renderPage(t *testing.T, payment *model.Payment) string {
+	utils.Opts = &utils.OptsType{EthereumTestChainId: 5}
+	page, err := newCheckoutPage(payment, testMerchant)
+	if err != nil {
+		t.Fatalf("newCheckoutPage: got error %s", err.Error())
+	}
+	var html bytes.Buffer
+	err = checkoutTemplate.Execute(&html, page)
+	if err != nil {
+		t.Fatalf("template execution: got error %s", err.Error())
+	}
+	return html.String()
+}
+
+func TestCheckoutWaiting(t *testing.T) {
+	html := renderPage(t, newTestPayment(enum.Waiting))
+
+	expected := []string{"0x1dB3439a222C519ab44bb1144fC28167b4Fa6EE6", "0.0123 ETH", "ethereum:0x1dB3439a222C519ab44bb1144fC28167b4Fa6EE6@5?value=12300000000000000", "<svg", "#112233", "#fbbc04", "https://shop.example.com/logo.png", "test mode"}
+	for _, e := range expected {
+		if !strings.Contains(html, e) {
+			t.Errorf("Expected checkout page to contain %s", e)
+		}
+	}
+}
+
+func TestCheckoutCurrencySelection(t *testing.T) {
+	payment := newTestPayment(enum.CurrencySelection)
+	payment.PaymentStates = payment.PaymentStates[:1]
+	html := renderPage(t, payment)
+
+	if !strings.Contains(html, `data-currency="eth"`) {
+		t.Errorf("Expected ethereum to be selectable")
+	}
+	// the merchant has no bitcoin wallet in test mode
+	if strings.Contains(html, `data-currency="btc"`) {
+		t.Errorf("Expected bitcoin not to be selectable")
+	}
+}
+
+func TestCheckoutPaid(t *testing.T) {
+	html := renderPage(t, newTestPayment(enum.Confirmed))
+
+	if !strings.Contains(html, "https://shop.example.com/success") {
+		t.Errorf("Expected link to the success page")
+	}
+	if strings.Contains(html, "<svg") {
+		t.Errorf("Expected no qr code after the payment")
+	}
+}
@@ -0,0 +1,76 @@
+package checkout
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/internal/utils"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultQrCodeSize = 256
+	minQrCodeSize     = 64
+	maxQrCodeSize     = 1024
+)
+
+// ServeQrCode returns the payment uri of a payment as png or svg image, depending on the format path variable.
+// The png size in pixels can be set with the size query parameter.
+func ServeQrCode(w http.ResponseWriter, r *http.Request, paymentRepository repository.IPaymentRepository) {
+	paymentId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid payment id", http.StatusBadRequest)
+		return
+	}
+
+	payment, err := paymentRepository.FindByPaymentId(r.Context(), paymentId)
+	if err != nil {
+		http.Error(w, "payment not found", http.StatusNotFound)
+		return
+	}
+
+	if payment.PaymentStates[0].PaymentState == enum.CurrencySelection {
+		http.Error(w, "no currency selected", http.StatusConflict)
+		return
+	}
+
+	paymentUri, err := model.GetPaymentUri(payment)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+	switch mux.Vars(r)["format"] {
+	case "svg":
+		svg, err := utils.QrCodeSvg(paymentUri)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/svg+xml")
+		w.Write([]byte(svg))
+	case "png":
+		size := defaultQrCodeSize
+		if s, err := strconv.Atoi(r.URL.Query().Get("size")); err == nil {
+			size = s
+		}
+		if size < minQrCodeSize || size > maxQrCodeSize {
+			http.Error(w, "size must be between 64 and 1024", http.StatusBadRequest)
+			return
+		}
+		png, err := utils.QrCodePng(paymentUri, size)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(png)
+	default:
+		http.Error(w, "format must be png or svg", http.StatusNotFound)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/CHainGate/backend/internal/service"
+	"github.com/CHainGate/backend/internal/utils"
+	"github.com/google/uuid"
+)
+
+// the new key is not a flag, flags show up in the process list and the shell history
+const newKeyEnv = "NEW_API_KEY_SECRET"
+
+// command is a subcommand with its arguments, -json has to come before the arguments
+type command struct {
+	name string
+	json bool
+	args []string
+}
+
+// parseCommand fails if the subcommand does not have the number of arguments
+func parseCommand(args []string, nArgs int) (*command, error) {
+	if len(args) == 0 {
+		return nil, errUsage
+	}
+	cmd := &command{name: args[0]}
+	fs := flag.NewFlagSet(args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.BoolVar(&cmd.json, "json", false, "print json")
+	if err := fs.Parse(args[1:]); err != nil || fs.NArg() != nArgs {
+		return nil, errUsage
+	}
+	cmd.args = fs.Args()
+	return cmd, nil
+}
+
+func runMerchants(adminService service.IAdminService, args []string) (err error) {
+	ctx := context.Background()
+	nArgs := 1
+	if len(args) > 0 && args[0] == "list" {
+		nArgs = 0
+	}
+	cmd, err := parseCommand(args, nArgs)
+	if err != nil {
+		return err
+	}
+	if nArgs == 1 {
+		defer func() { err = wrapError("merchant", cmd.args[0], err) }()
+	}
+
+	switch cmd.name {
+	case "list":
+		merchants, err := adminService.ListMerchants(ctx)
+		if err != nil {
+			return err
+		}
+		views := make([]merchantView, len(merchants))
+		for i := range merchants {
+			views[i] = newMerchantView(&merchants[i])
+		}
+		return printMerchants(os.Stdout, cmd.json, views)
+	case "find":
+		merchant, err := adminService.FindMerchant(ctx, cmd.args[0])
+		if err != nil {
+			return err
+		}
+		return printMerchants(os.Stdout, cmd.json, []merchantView{newMerchantView(merchant)})
+	case "activate", "deactivate":
+		merchant, err := adminService.SetMerchantActive(ctx, cmd.args[0], cmd.name == "activate")
+		if err != nil {
+			return err
+		}
+		return printMerchants(os.Stdout, cmd.json, []merchantView{newMerchantView(merchant)})
+	default:
+		return errUsage
+	}
+}
+
+func runPayments(adminService service.IAdminService, args []string) (err error) {
+	ctx := context.Background()
+	cmd, err := parseCommand(args, 1)
+	if err != nil {
+		return err
+	}
+	id, err := uuid.Parse(cmd.args[0])
+	if err != nil {
+		return fmt.Errorf("invalid payment id %s", cmd.args[0])
+	}
+	defer func() { err = wrapError("payment", cmd.args[0], err) }()
+
+	switch cmd.name {
+	case "show":
+		payment, err := adminService.FindPayment(ctx, id)
+		if err != nil {
+			return err
+		}
+		return printPayment(os.Stdout, cmd.json, newPaymentView(payment))
+	case "replay-webhook":
+		payment, err := adminService.ReplayWebhook(ctx, id)
+		if err != nil {
+			return err
+		}
+		return printPayment(os.Stdout, cmd.json, newPaymentView(payment))
+	case "expire":
+		payment, err := adminService.ExpirePayment(ctx, id)
+		if payment == nil {
+			return err
+		}
+		// the payment is expired, only the webhook failed
+		printErr := printPayment(os.Stdout, cmd.json, newPaymentView(payment))
+		return errors.Join(err, printErr)
+	default:
+		return errUsage
+	}
+}
+
+// wrapError tells which merchant or payment failed, e.g. was not found
+func wrapError(kind string, id string, err error) error {
+	if err == nil || errors.Is(err, errUsage) {
+		return err
+	}
+	return fmt.Errorf("%s %s: %w", kind, id, err)
+}
+
+func runKeys(adminService service.IAdminService, args []string) error {
+	cmd, err := parseCommand(args, 0)
+	if err != nil || cmd.name != "rotate" {
+		return errUsage
+	}
+	newKey := os.Getenv(newKeyEnv)
+	if len(newKey) != 16 && len(newKey) != 24 && len(newKey) != 32 {
+		return fmt.Errorf("%s must be 16, 24 or 32 bytes long", newKeyEnv)
+	}
+	if newKey == utils.Opts.ApiKeySecret {
+		return fmt.Errorf("%s must differ from API_KEY_SECRET", newKeyEnv)
+	}
+
+	rotation, err := adminService.RotateEncryptionKey(context.Background(), utils.Opts.ApiKeySecret, newKey)
+	if err != nil {
+		return err
+	}
+	return printKeyRotation(os.Stdout, cmd.json, keyRotationView{ApiKeys: rotation.ApiKeys, TwoFactorSecrets: rotation.TwoFactorSecrets})
+}
@@ -0,0 +1,92 @@
+// Command chaingate-admin is used by operators to manage merchants and payments. It is configured like the
+// server and uses the same repositories and services, so it has to run with the same options.
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/CHainGate/backend/internal/cli"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/internal/service"
+	"github.com/CHainGate/backend/internal/utils"
+	"gorm.io/gorm/logger"
+)
+
+const usage = `usage: chaingate-admin [options] <command>
+
+commands:
+  merchants list [-json]                          list all merchants
+  merchants find [-json] <id|email>               show a merchant
+  merchants activate [-json] <id|email>           activate a merchant without email verification
+  merchants deactivate [-json] <id|email>         deactivate a merchant, its sessions and api keys stop working
+  payments show [-json] <id>                      show a payment with its state history
+  payments replay-webhook [-json] <id>            send the webhook of the current state again
+  payments expire [-json] <id>                    expire a payment which did not receive all funds
+  keys rotate [-json]                             encrypt the secrets with NEW_API_KEY_SECRET instead of API_KEY_SECRET
+  migrate <up|down [n]|status|to <version>>       change the database schema
+
+the options are the ones of the server, see backend-service -h`
+
+func main() {
+	args, err := utils.NewOpts(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+	// the output is written to stdout, logs and queries which failed to stderr
+	logger.Default = logger.New(log.New(os.Stderr, "", log.LstdFlags), logger.Config{
+		SlowThreshold:             200 * time.Millisecond,
+		LogLevel:                  logger.Warn,
+		IgnoreRecordNotFoundError: true,
+	})
+	if args[0] == "migrate" {
+		cli.RunMigrate("chaingate-admin", args[1:])
+		return
+	}
+
+	err = utils.Opts.Validate()
+	if err != nil {
+		log.Fatalf("Invalid configuration, run backend-service config check:\n%s", err.Error())
+	}
+	database, err := repository.SetupDatabase()
+	if err != nil {
+		log.Fatalf("Could not setup database: %s", err.Error())
+	}
+	internalPaymentService := service.NewInternalPaymentService(database.Payment, database.ApiKey, database.UnitOfWork)
+	adminService := service.NewAdminService(database.Merchant, database.Payment, database.RefreshToken, database.UnitOfWork, internalPaymentService)
+
+	err = run(adminService, args)
+	if closeErr := database.Health.Close(); closeErr != nil {
+		log.Printf("Could not close database: %s", closeErr.Error())
+	}
+	if errors.Is(err, errUsage) {
+		log.Fatal(usage)
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+var errUsage = errors.New("usage")
+
+func run(adminService service.IAdminService, args []string) error {
+	switch args[0] {
+	case "merchants":
+		return runMerchants(adminService, args[1:])
+	case "payments":
+		return runPayments(adminService, args[1:])
+	case "keys":
+		return runKeys(adminService, args[1:])
+	default:
+		return errUsage
+	}
+}
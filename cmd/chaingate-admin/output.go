@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"text/tabwriter"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/utils"
+	"github.com/CHainGate/backend/pkg/enum"
+)
+
+// the views only have the fields an operator needs, passwords and secrets are never printed
+
+type merchantView struct {
+	Id          string     `json:"id"`
+	Email       string     `json:"email"`
+	FirstName   string     `json:"first_name"`
+	LastName    string     `json:"last_name"`
+	Active      bool       `json:"active"`
+	TwoFactor   bool       `json:"two_factor"`
+	LockedUntil *time.Time `json:"locked_until,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+}
+
+type paymentView struct {
+	Id            string      `json:"id"`
+	MerchantId    string      `json:"merchant_id"`
+	Mode          string      `json:"mode"`
+	PriceAmount   float64     `json:"price_amount"`
+	PriceCurrency string      `json:"price_currency"`
+	PayCurrency   string      `json:"pay_currency"`
+	PayAddress    string      `json:"pay_address"`
+	CallbackUrl   string      `json:"callback_url"`
+	TxHash        string      `json:"tx_hash,omitempty"`
+	State         string      `json:"state"`
+	CreatedAt     time.Time   `json:"created_at"`
+	States        []stateView `json:"states"`
+}
+
+type stateView struct {
+	State               string    `json:"state"`
+	PayCurrency         string    `json:"pay_currency"`
+	PayAmount           string    `json:"pay_amount"`
+	ActuallyPaid        string    `json:"actually_paid"`
+	BlockchainPaymentId string    `json:"blockchain_payment_id"`
+	CreatedAt           time.Time `json:"created_at"`
+}
+
+type keyRotationView struct {
+	ApiKeys          int `json:"api_keys"`
+	TwoFactorSecrets int `json:"two_factor_secrets"`
+}
+
+func newMerchantView(merchant *model.Merchant) merchantView {
+	return merchantView{
+		Id:          merchant.ID.String(),
+		Email:       merchant.Email,
+		FirstName:   merchant.FirstName,
+		LastName:    merchant.LastName,
+		Active:      merchant.IsActive,
+		TwoFactor:   merchant.TwoFactor.Enabled,
+		LockedUntil: merchant.LockedUntil,
+		CreatedAt:   merchant.CreatedAt,
+	}
+}
+
+// newPaymentView lists the states in the order they were added, the repository returns the latest first
+func newPaymentView(payment *model.Payment) paymentView {
+	view := paymentView{
+		Id:            payment.ID.String(),
+		MerchantId:    payment.MerchantId.String(),
+		Mode:          payment.Mode.String(),
+		PriceAmount:   payment.PriceAmount,
+		PriceCurrency: payment.PriceCurrency.String(),
+		PayCurrency:   payment.PayCurrency.String(),
+		PayAddress:    payment.PayAddress,
+		CallbackUrl:   payment.CallbackUrl,
+		TxHash:        payment.TxHash,
+		CreatedAt:     payment.CreatedAt,
+		States:        make([]stateView, len(payment.PaymentStates)),
+	}
+	for i, state := range payment.PaymentStates {
+		view.States[len(payment.PaymentStates)-1-i] = stateView{
+			State:               state.PaymentState.String(),
+			PayCurrency:         state.PayCurrency.String(),
+			PayAmount:           formatAmount(state.PayCurrency, state.PayAmount),
+			ActuallyPaid:        formatAmount(state.PayCurrency, state.ActuallyPaid),
+			BlockchainPaymentId: state.BlockchainPaymentId.String(),
+			CreatedAt:           state.CreatedAt,
+		}
+	}
+	if len(payment.PaymentStates) > 0 {
+		view.State = payment.PaymentStates[0].PaymentState.String()
+	}
+	return view
+}
+
+// formatAmount returns the amount in the unit of the currency, like the webhooks
+func formatAmount(currency enum.CryptoCurrency, amount *model.BigInt) string {
+	if amount == nil {
+		return "0"
+	}
+	formatted, err := utils.ConvertAmountToBaseString(currency, amount.Int)
+	if err != nil {
+		return amount.String()
+	}
+	return formatted
+}
+
+func printJson(w io.Writer, v interface{}) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
+
+func printMerchants(w io.Writer, asJson bool, merchants []merchantView) error {
+	if asJson {
+		return printJson(w, merchants)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tEMAIL\tNAME\tACTIVE\t2FA\tCREATED AT")
+	for _, m := range merchants {
+		fmt.Fprintf(tw, "%s\t%s\t%s %s\t%t\t%t\t%s\n", m.Id, m.Email, m.FirstName, m.LastName, m.Active, m.TwoFactor, m.CreatedAt.Format(time.DateTime))
+	}
+	return tw.Flush()
+}
+
+func printPayment(w io.Writer, asJson bool, payment paymentView) error {
+	if asJson {
+		return printJson(w, payment)
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "ID\t%s\n", payment.Id)
+	fmt.Fprintf(tw, "MERCHANT\t%s\n", payment.MerchantId)
+	fmt.Fprintf(tw, "MODE\t%s\n", payment.Mode)
+	fmt.Fprintf(tw, "PRICE\t%v %s\n", payment.PriceAmount, payment.PriceCurrency)
+	fmt.Fprintf(tw, "PAY ADDRESS\t%s %s\n", payment.PayAddress, payment.PayCurrency)
+	fmt.Fprintf(tw, "CALLBACK URL\t%s\n", payment.CallbackUrl)
+	fmt.Fprintf(tw, "TX HASH\t%s\n", payment.TxHash)
+	fmt.Fprintf(tw, "STATE\t%s\n\n", payment.State)
+	fmt.Fprintln(tw, "STATE\tPAY AMOUNT\tACTUALLY PAID\tBLOCKCHAIN PAYMENT\tCREATED AT")
+	for _, s := range payment.States {
+		fmt.Fprintf(tw, "%s\t%s %s\t%s\t%s\t%s\n", s.State, s.PayAmount, s.PayCurrency, s.ActuallyPaid, s.BlockchainPaymentId, s.CreatedAt.Format(time.DateTime))
+	}
+	return tw.Flush()
+}
+
+func printKeyRotation(w io.Writer, asJson bool, rotation keyRotationView) error {
+	if asJson {
+		return printJson(w, rotation)
+	}
+	_, err := fmt.Fprintf(w, "Encrypted %d api keys and %d two factor secrets with the new key.\n"+
+		"Set API_KEY_SECRET to the new key and OLD_API_KEY_SECRET to the previous one and restart the servers.\n"+
+		"Remove OLD_API_KEY_SECRET once the merchants copied their new api keys, the webhooks are signed with them already.\n",
+		rotation.ApiKeys, rotation.TwoFactorSecrets)
+	return err
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"text/tabwriter"
+
+	"github.com/CHainGate/backend/internal/utils"
+)
+
+const configUsage = `usage: backend-service config <command>
+
+commands:
+  check  list the options with their source and validate them, secrets are redacted`
+
+// runConfig is the config subcommand, it exits with 1 if the options are invalid
+func runConfig(args []string) {
+	if len(args) == 0 || args[0] != "check" {
+		log.Fatal(configUsage)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tVALUE\tSOURCE")
+	for _, setting := range utils.Settings() {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", setting.Name, setting.Value, setting.Source)
+	}
+	err := w.Flush()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = utils.Opts.Validate()
+	if err != nil {
+		fmt.Printf("\nInvalid configuration:\n%s\n", err.Error())
+		os.Exit(1)
+	}
+	fmt.Println("\nConfiguration is valid")
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/CHainGate/backend/internal/config"
+	"github.com/CHainGate/backend/internal/tracing"
+	"github.com/CHainGate/backend/internal/utils"
+)
+
+// server is a listener of the backend, listen blocks until the server is shut down or fails
+type server struct {
+	name   string
+	http   *http.Server
+	listen func() error
+}
+
+// withTimeouts limits slow clients, the websockets clear the deadlines after the upgrade
+func withTimeouts(s *http.Server) *http.Server {
+	s.ReadHeaderTimeout = time.Duration(utils.Opts.ServerReadTimeout) * time.Second
+	s.ReadTimeout = time.Duration(utils.Opts.ServerReadTimeout) * time.Second
+	s.WriteTimeout = time.Duration(utils.Opts.ServerWriteTimeout) * time.Second
+	s.IdleTimeout = time.Duration(utils.Opts.ServerIdleTimeout) * time.Second
+	return s
+}
+
+// serve runs the servers until SIGTERM or SIGINT is received or one of them fails and shuts down gracefully:
+// the listeners are closed, the requests in flight are finished including the webhooks they send, the websocket
+// clients receive a going away close frame and the sse and long-poll subscribers are released, so they reconnect
+// to another replica. Afterwards the spans are exported and the database is closed.
+func serve(servers []server, closeDatabase func() error) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	failed := make(chan error, len(servers))
+	for _, s := range servers {
+		go func(s server) {
+			slog.Info("Starting "+s.name, "addr", s.http.Addr)
+			err := s.listen()
+			if !errors.Is(err, http.ErrServerClosed) {
+				slog.Error(s.name+" failed", "error", err)
+				failed <- err
+			}
+		}(s)
+	}
+
+	exitCode := 0
+	select {
+	case <-ctx.Done():
+		slog.Info("Shutting down")
+	case <-failed:
+		exitCode = 1
+	}
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), time.Duration(utils.Opts.ShutdownTimeout)*time.Second)
+	defer cancel()
+	shutdownErrs := make(chan error, len(servers))
+	for _, s := range servers {
+		go func(s server) {
+			err := s.http.Shutdown(shutdownCtx)
+			if err != nil {
+				slog.Error("Could not shut down "+s.name, "error", err)
+			}
+			shutdownErrs <- err
+		}(s)
+	}
+	// streams keep their requests open, they have to be released for the shutdown of the servers
+	if err := config.DrainPools(shutdownCtx); err != nil {
+		slog.Error("Could not drain websocket pools", "error", err)
+	}
+	for range servers {
+		if err := <-shutdownErrs; err != nil {
+			exitCode = 1
+		}
+	}
+
+	if err := tracing.Shutdown(shutdownCtx); err != nil {
+		slog.Error("Could not export spans", "error", err)
+	}
+	if err := closeDatabase(); err != nil {
+		slog.Error("Could not close database", "error", err)
+	}
+	slog.Info("Shut down")
+	os.Exit(exitCode)
+}
@@ -1,71 +1,180 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
 	"strconv"
+	"time"
 
-	"github.com/CHainGate/backend/internal/config"
-
+	"github.com/CHainGate/backend/checkout"
 	"github.com/CHainGate/backend/configApi"
+	"github.com/CHainGate/backend/internal/cli"
+	"github.com/CHainGate/backend/internal/health"
+	"github.com/CHainGate/backend/internal/logging"
+	"github.com/CHainGate/backend/internal/metrics"
+	"github.com/CHainGate/backend/internal/ratelimit"
 	"github.com/CHainGate/backend/internal/repository"
 	"github.com/CHainGate/backend/internal/service"
 	"github.com/CHainGate/backend/internal/service/configService"
 	"github.com/CHainGate/backend/internal/service/internalService"
 	"github.com/CHainGate/backend/internal/service/publicService"
+	"github.com/CHainGate/backend/internal/tracing"
 	"github.com/CHainGate/backend/internal/utils"
 	"github.com/CHainGate/backend/internalApi"
+	"github.com/CHainGate/backend/pkg/hmacauth"
 	"github.com/CHainGate/backend/publicApi"
 	"github.com/CHainGate/backend/websocket"
-	"github.com/google/uuid"
+	"github.com/gorilla/mux"
 	"github.com/rs/cors"
 )
 
 func main() {
-	utils.NewOpts() // create utils.Opts (env variables)
-	merchantRepo, apiKeyRepo, paymentRepo, err := repository.SetupDatabase()
+	// create utils.Opts (flags, env variables and config file)
+	args, err := utils.NewOpts(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		log.Fatalf("Could not load configuration: %s", err.Error())
+	}
+	if len(args) > 0 {
+		switch args[0] {
+		case "migrate":
+			cli.RunMigrate("backend-service", args[1:])
+		case "config":
+			runConfig(args[1:])
+		default:
+			log.Fatalf("unknown command %s, use migrate or config", args[0])
+		}
+		return
+	}
+	// secrets or urls which are only used by the first request must not make it fail, the server does not start instead
+	err = utils.Opts.Validate()
 	if err != nil {
-		log.Fatalf("Could not setup database, got error: %s", err.Error())
+		log.Fatalf("Invalid configuration, run the config check command:\n%s", err.Error())
+	}
+	logging.Setup(os.Stdout, utils.Opts.LogLevel)
+	slog.Debug("Configuration loaded", "settings", utils.Settings())
+	err = tracing.Setup(utils.Opts.TracingExporter, utils.Opts.TracingEndpoint, utils.Opts.TracingSampleRatio)
+	if err != nil {
+		fatal("Could not setup tracing", err)
+	}
+	requestIdMiddleware := logging.NewRequestIdMiddleware()
+	metricsMiddleware := metrics.NewMiddleware()
+
+	database, err := repository.SetupDatabase()
+	if err != nil {
+		fatal("Could not setup database", err)
 	}
 
-	authService := service.NewAuthenticationService(merchantRepo, apiKeyRepo)
+	var rateLimitStore ratelimit.Store = ratelimit.NewMemoryStore()
+	if utils.Opts.RateLimitStore == "postgres" {
+		rateLimitStore = database.RateLimit
+	}
+
+	authService := service.NewAuthenticationService(database.Merchant, database.ApiKey, database.RefreshToken, database.UnitOfWork)
+	teamService := service.NewTeamService(database.Merchant, database.Team, database.UnitOfWork)
 	// config api
-	ApiKeyApiService := configService.NewApiKeyApiService(authService, apiKeyRepo, merchantRepo)
+	ApiKeyApiService := configService.NewApiKeyApiService(authService, database.ApiKey, database.Merchant)
 	ApiKeyApiController := configApi.NewApiKeyApiController(ApiKeyApiService)
 
 	AuthenticationApiService := configService.NewAuthenticationApiService(authService)
 	AuthenticationApiController := configApi.NewAuthenticationApiController(AuthenticationApiService)
 
-	LoggingApiService := configService.NewLoggingApiService(authService, paymentRepo)
+	LoggingApiService := configService.NewLoggingApiService(authService, database.Payment)
 	LoggingApiController := configApi.NewLoggingApiController(LoggingApiService)
 
-	WalletApiService := configService.NewWalletApiService(authService, merchantRepo)
+	WalletApiService := configService.NewWalletApiService(authService, database.Merchant)
 	WalletApiController := configApi.NewWalletApiController(WalletApiService)
 
 	ConfigApiService := configService.NewConfigApiService(authService)
 	ConfigApiController := configApi.NewConfigApiController(ConfigApiService)
 
-	configRouter := configApi.NewRouter(ApiKeyApiController, AuthenticationApiController, LoggingApiController, WalletApiController, ConfigApiController)
+	BrandingApiService := configService.NewBrandingApiService(authService, database.Merchant)
+	BrandingApiController := configApi.NewBrandingApiController(BrandingApiService)
+
+	TwoFactorApiService := configService.NewTwoFactorApiService(authService)
+	TwoFactorApiController := configApi.NewTwoFactorApiController(TwoFactorApiService)
+
+	TeamApiService := configService.NewTeamApiService(teamService)
+	TeamApiController := configApi.NewTeamApiController(TeamApiService)
+
+	configRouter := configApi.NewRouter(ApiKeyApiController, AuthenticationApiController, LoggingApiController, WalletApiController, ConfigApiController, BrandingApiController, TwoFactorApiController, TeamApiController)
+	configRouter.Use(
+		tracing.NewMiddleware("config"),
+		requestIdMiddleware,
+		metricsMiddleware,
+		configService.NewClientIpMiddleware(),
+		configService.NewIpRateLimitMiddleware(rateLimitStore),
+		configService.NewAuthorizationMiddleware(authService, teamService),
+		configService.NewMerchantRateLimitMiddleware(rateLimitStore),
+	)
 
 	// internal api
-	internalPaymentService := service.NewInternalPaymentService(paymentRepo, apiKeyRepo)
+	internalPaymentService := service.NewInternalPaymentService(database.Payment, database.ApiKey, database.UnitOfWork)
 	PaymentUpdateApiService := internalService.NewPaymentUpdateApiService(internalPaymentService)
 	PaymentUpdateApiController := internalApi.NewPaymentUpdateApiController(PaymentUpdateApiService)
 
 	// public api
-	publicPaymentService := service.NewPublicPaymentService(merchantRepo, paymentRepo, internalPaymentService)
-	publicInvoiceService := publicService.NewInvoiceApiService(publicPaymentService, authService, paymentRepo)
+	publicPaymentService := service.NewPublicPaymentService(database.Merchant, database.Payment, database.UnitOfWork, internalPaymentService)
+	publicInvoiceService := publicService.NewInvoiceApiService(publicPaymentService, authService, database.Payment)
 	PaymentApiService := publicService.NewPaymentApiService(publicPaymentService, authService)
 	PaymentApiController := publicApi.NewPaymentApiController(PaymentApiService)
 	InvoiceApiController := publicApi.NewInvoiceApiController(publicInvoiceService)
 
 	publicRouter := publicApi.NewRouter(PaymentApiController, InvoiceApiController)
+	publicRouter.Use(
+		tracing.NewMiddleware("public"),
+		requestIdMiddleware,
+		metricsMiddleware,
+		publicService.NewIpRateLimitMiddleware(rateLimitStore),
+		publicService.NewAuthenticationMiddleware(authService),
+		publicService.NewApiKeyRateLimitMiddleware(rateLimitStore),
+	)
 
 	internalRouter := internalApi.NewRouter(PaymentUpdateApiController)
+	internalRouter.Use(tracing.NewMiddleware("internal"), requestIdMiddleware, metricsMiddleware, internalService.NewAuthenticationMiddleware(hmacauth.NewVerifier([]byte(utils.Opts.InternalApiSecret))))
+	if utils.Opts.InternalApiSecret == "" {
+		slog.Warn("INTERNAL_API_SECRET is not set, all payment updates will be rejected")
+	}
+
+	// streams and images cannot be generated by openapi, everything else falls through to the generated router
+	publicStreamRouter := mux.NewRouter()
+	publicStreamRouter.HandleFunc("/api/public/payment/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		websocket.ServeSse(w, r, database.Payment)
+	}).Methods(http.MethodGet)
+	publicStreamRouter.HandleFunc("/api/public/payment/{id}/poll", func(w http.ResponseWriter, r *http.Request) {
+		websocket.ServeLongPoll(w, r, database.Payment)
+	}).Methods(http.MethodGet)
+	publicStreamRouter.HandleFunc("/api/public/payment/{id}/qr.{format:png|svg}", func(w http.ResponseWriter, r *http.Request) {
+		checkout.ServeQrCode(w, r, database.Payment)
+	}).Methods(http.MethodGet)
+	// middlewares only run for matched routes, the fall through to publicRouter is limited by its own middleware
+	publicStreamRouter.Use(tracing.NewMiddleware("public"), requestIdMiddleware, metricsMiddleware, publicService.NewIpRateLimitMiddleware(rateLimitStore))
+	publicStreamRouter.NotFoundHandler = publicRouter
 
 	http.Handle("/api/config/", cors.AllowAll().Handler(configRouter))
-	http.Handle("/api/public/", cors.AllowAll().Handler(publicRouter))
-	http.Handle("/api/internal/", cors.AllowAll().Handler(internalRouter))
+	http.Handle("/api/public/", cors.AllowAll().Handler(publicStreamRouter))
+	mainServer := withTimeouts(&http.Server{Addr: ":" + strconv.Itoa(utils.Opts.ServerPort)})
+	servers := []server{{name: "backend-service", http: mainServer, listen: mainServer.ListenAndServe}}
+	// the internal api is only called by the blockchain services, preferably on a port which is not exposed
+	if utils.Opts.InternalServerPort == 0 {
+		http.Handle("/api/internal/", internalRouter)
+	} else {
+		internalServer, err := internalService.NewServer(internalRouter)
+		if err != nil {
+			fatal("Could not setup internal api", err)
+		}
+		withTimeouts(internalServer)
+		servers = append(servers, server{name: "internal api", http: internalServer, listen: func() error {
+			return internalService.ListenAndServe(internalServer)
+		}})
+	}
 
 	// https://ribice.medium.com/serve-swaggerui-within-your-golang-application-5486748a5ed4
 	configFs := http.FileServer(http.Dir("./swaggerui/config"))
@@ -74,18 +183,41 @@ func main() {
 	http.Handle("/api/public/swaggerui/", http.StripPrefix("/api/public/swaggerui/", publicFs))
 	internalFs := http.FileServer(http.Dir("./swaggerui/internal"))
 	http.Handle("/api/internal/swaggerui/", http.StripPrefix("/api/internal/swaggerui/", internalFs))
-	http.HandleFunc("/ws", func(w http.ResponseWriter, r *http.Request) {
-		paymentIdParam := r.URL.Query().Get("pid")
-		paymentId := uuid.MustParse(paymentIdParam)
-		pool := config.Pools[paymentId]
-		if pool == nil {
-			pool = websocket.NewPool()
-			go pool.Start()
-			config.Pools[paymentId] = pool
-		}
-		websocket.ServeWs(pool, w, r, publicPaymentService, paymentRepo, paymentId)
-	})
+	// hosted checkout page, set PAYMENT_URL to http(s)://<host>/checkout/ to use it for invoices
+	checkoutRouter := mux.NewRouter()
+	checkoutRouter.HandleFunc("/checkout/{id}", func(w http.ResponseWriter, r *http.Request) {
+		checkout.ServeCheckout(w, r, database.Payment, database.Merchant)
+	}).Methods(http.MethodGet)
+	checkoutRouter.Use(tracing.NewMiddleware("checkout"), requestIdMiddleware, metricsMiddleware)
+	http.Handle("/checkout/", checkoutRouter)
+
+	http.Handle("/ws", tracing.NewMiddleware("websocket")(requestIdMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		websocket.ServeWs(w, r, publicPaymentService, database.Payment)
+	}))))
+
+	http.Handle("/metrics", metrics.Handler())
+
+	// probes of kubernetes, they are not traced or measured
+	checker := health.NewChecker(
+		time.Duration(utils.Opts.HealthCache)*time.Second,
+		time.Duration(utils.Opts.HealthTimeout)*time.Second,
+		health.Check{Name: "database", Check: database.Health.Ping},
+		health.Check{Name: "migrations", Check: database.Health.CheckSchemaVersion},
+		health.ReachableCheck("ethereum", utils.Opts.EthereumBaseUrl, http.DefaultClient),
+		health.ReachableCheck("bitcoin", utils.Opts.BitcoinBaseUrl, http.DefaultClient),
+		health.ReachableCheck("proxy", utils.Opts.ProxyBaseUrl, http.DefaultClient),
+	)
+	http.Handle("/healthz", health.LivenessHandler())
+	http.Handle("/readyz", health.ReadinessHandler(checker))
+
+	serve(servers, database.Health.Close)
+}
 
-	log.Println("Starting backend-service on port " + strconv.Itoa(utils.Opts.ServerPort))
-	log.Fatal(http.ListenAndServe(":"+strconv.Itoa(utils.Opts.ServerPort), nil))
+// fatal logs the error and exits, slog has no fatal level. The recorded spans are exported before.
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	if err := tracing.Shutdown(context.Background()); err != nil {
+		slog.Error("Could not export spans", "error", err)
+	}
+	os.Exit(1)
 }
@@ -0,0 +1,90 @@
+// Package cli has the subcommands which are shared by the binaries
+package cli
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/CHainGate/backend/internal/repository"
+)
+
+const migrateUsage = `usage: %s migrate <command>
+
+commands:
+  up            apply all migrations
+  down [n]      revert the last n migrations, default 1
+  status        list the migrations and when they were applied
+  to <version>  apply or revert migrations until the version, 0 reverts all`
+
+// RunMigrate is the migrate subcommand of the binary, the database is configured like the server
+func RunMigrate(binary string, args []string) {
+	usage := fmt.Sprintf(migrateUsage, binary)
+	if len(args) == 0 {
+		log.Fatal(usage)
+	}
+
+	db, err := repository.OpenDatabase()
+	if err != nil {
+		log.Fatalf("Could not connect to database, got error: %s", err.Error())
+	}
+	migrator, err := repository.NewMigrator(db)
+	if err != nil {
+		log.Fatalf("Could not load migrations, got error: %s", err.Error())
+	}
+
+	switch args[0] {
+	case "up":
+		err = migrator.Up()
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				log.Fatal(usage)
+			}
+		}
+		err = migrator.Down(steps)
+	case "to":
+		if len(args) < 2 {
+			log.Fatal(usage)
+		}
+		version, parseErr := strconv.ParseInt(args[1], 10, 64)
+		if parseErr != nil {
+			log.Fatal(usage)
+		}
+		err = migrator.To(version)
+	case "status":
+		err = printMigrationStatus(migrator)
+	default:
+		log.Fatal(usage)
+	}
+	if err != nil {
+		log.Fatalf("Migration failed: %s", err.Error())
+	}
+
+	version, err := migrator.Version()
+	if err != nil {
+		log.Fatalf("Could not read schema version, got error: %s", err.Error())
+	}
+	log.Printf("Schema version %d, latest version %d", version, migrator.LatestVersion())
+}
+
+func printMigrationStatus(migrator *repository.Migrator) error {
+	status, err := migrator.Status()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+	for _, s := range status {
+		appliedAt := "pending"
+		if s.AppliedAt != nil {
+			appliedAt = s.AppliedAt.Format("2006-01-02 15:04:05")
+		}
+		fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+	}
+	return w.Flush()
+}
@@ -1,8 +1,110 @@
 package config
 
 import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/CHainGate/backend/internal/metrics"
 	"github.com/CHainGate/backend/internal/model"
 	"github.com/google/uuid"
 )
 
-var Pools = make(map[uuid.UUID]*model.Pool)
+var (
+	Pools = make(map[uuid.UUID]*model.Pool)
+	// poolRefs counts the clients and subscribers which acquired a pool
+	poolRefs = make(map[uuid.UUID]int)
+	// poolStops stop the released pools after the grace period
+	poolStops  = make(map[uuid.UUID]*poolStop)
+	poolsMutex sync.Mutex
+	// poolGracePeriod keeps a released pool with its history and event ids, so
+	// long-poll clients and reconnecting event sources resume between requests
+	poolGracePeriod = time.Minute
+)
+
+type poolStop struct {
+	timer *time.Timer
+}
+
+// GetPool returns the pool of a payment if a client is listening to it or it was released within the grace period
+func GetPool(paymentId uuid.UUID) (*model.Pool, bool) {
+	poolsMutex.Lock()
+	defer poolsMutex.Unlock()
+	pool, ok := Pools[paymentId]
+	return pool, ok
+}
+
+// AcquirePool returns the pool of a payment and starts a new one if there is none yet.
+// Every acquired pool has to be released once its client or subscriber is gone.
+func AcquirePool(paymentId uuid.UUID) *model.Pool {
+	poolsMutex.Lock()
+	defer poolsMutex.Unlock()
+	if stop, ok := poolStops[paymentId]; ok {
+		stop.timer.Stop()
+		delete(poolStops, paymentId)
+	}
+	pool, ok := Pools[paymentId]
+	if !ok {
+		pool = model.NewPool()
+		pool.PaymentId = paymentId
+		go pool.Start()
+		Pools[paymentId] = pool
+		metrics.WebsocketPools.Set(float64(len(Pools)))
+	}
+	poolRefs[paymentId]++
+	return pool
+}
+
+// ReleasePool stops the pool once the grace period after the last release is over, so pools of payments nobody listens to do not leak.
+// The history is lost afterwards, clients resuming then get the initial message.
+func ReleasePool(pool *model.Pool) {
+	poolsMutex.Lock()
+	defer poolsMutex.Unlock()
+	poolRefs[pool.PaymentId]--
+	if poolRefs[pool.PaymentId] > 0 {
+		return
+	}
+	delete(poolRefs, pool.PaymentId)
+	stop := &poolStop{}
+	stop.timer = time.AfterFunc(poolGracePeriod, func() {
+		stopPool(pool, stop)
+	})
+	poolStops[pool.PaymentId] = stop
+}
+
+// stopPool removes the pool unless it was acquired again since the stop was scheduled
+func stopPool(pool *model.Pool, stop *poolStop) {
+	poolsMutex.Lock()
+	defer poolsMutex.Unlock()
+	if poolStops[pool.PaymentId] != stop {
+		return
+	}
+	delete(poolStops, pool.PaymentId)
+	delete(Pools, pool.PaymentId)
+	pool.Stop()
+	metrics.WebsocketPools.Set(float64(len(Pools)))
+}
+
+// DrainPools disconnects the clients of all pools on shutdown
+func DrainPools(ctx context.Context) error {
+	poolsMutex.Lock()
+	pools := make([]*model.Pool, 0, len(Pools))
+	for _, pool := range Pools {
+		pools = append(pools, pool)
+	}
+	poolsMutex.Unlock()
+
+	errs := make(chan error, len(pools))
+	for _, pool := range pools {
+		go func(pool *model.Pool) {
+			errs <- pool.Drain(ctx)
+		}(pool)
+	}
+	var err error
+	for range pools {
+		if drainErr := <-errs; drainErr != nil {
+			err = drainErr
+		}
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/google/uuid"
+)
+
+func TestReleasePool(t *testing.T) {
+	defer func(gracePeriod time.Duration) { poolGracePeriod = gracePeriod }(poolGracePeriod)
+	poolGracePeriod = 50 * time.Millisecond
+
+	paymentId := uuid.New()
+	pool := AcquirePool(paymentId)
+	if AcquirePool(paymentId) != pool {
+		t.Fatal("Expected the same pool for the same payment")
+	}
+
+	ReleasePool(pool)
+	if _, ok := GetPool(paymentId); !ok {
+		t.Fatal("Expected the pool to be kept while it is acquired")
+	}
+
+	ReleasePool(pool)
+	if _, ok := GetPool(paymentId); !ok {
+		t.Fatal("Expected the pool to be kept during the grace period")
+	}
+	if AcquirePool(paymentId) != pool {
+		t.Fatal("Expected the released pool to be reused during the grace period")
+	}
+
+	ReleasePool(pool)
+	time.Sleep(2 * poolGracePeriod)
+	if _, ok := GetPool(paymentId); ok {
+		t.Fatal("Expected the pool to be removed after the grace period")
+	}
+
+	published := make(chan struct{})
+	go func() {
+		pool.Publish(model.Message{MessageType: "paid"})
+		close(published)
+	}()
+	select {
+	case <-published:
+	case <-time.After(time.Second):
+		t.Fatal("Expected publishing to a stopped pool not to block")
+	}
+	if err := pool.Drain(context.Background()); err != nil {
+		t.Errorf("Drain: got error %s", err.Error())
+	}
+
+	if AcquirePool(paymentId) == pool {
+		t.Error("Expected a new pool after the old one was stopped")
+	}
+}
@@ -0,0 +1,142 @@
+// Package health serves the liveness and readiness endpoints. The readiness checks run in parallel
+// and their results are cached, so frequent probes do not put load on the database and the other services.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const StatusOk = "ok"
+const StatusFail = "fail"
+
+// Check returns an error if the dependency is not usable
+type Check struct {
+	Name  string
+	Check func(ctx context.Context) error
+}
+
+type Result struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+type Response struct {
+	Status    string    `json:"status"`
+	CheckedAt time.Time `json:"checked_at"`
+	Checks    []Result  `json:"checks,omitempty"`
+}
+
+// Checker runs the checks at most once per cache duration, each check is canceled after the timeout
+type Checker struct {
+	checks    []Check
+	cache     time.Duration
+	timeout   time.Duration
+	now       func() time.Time
+	mu        sync.Mutex
+	response  Response
+	checkedAt time.Time
+}
+
+func NewChecker(cache time.Duration, timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, cache: cache, timeout: timeout, now: time.Now}
+}
+
+// Run returns the cached response or runs the checks. Concurrent callers wait for the same run.
+func (c *Checker) Run(ctx context.Context) Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.checkedAt.IsZero() && c.now().Sub(c.checkedAt) < c.cache {
+		return c.response
+	}
+
+	results := make([]Result, len(c.checks))
+	var wg sync.WaitGroup
+	for i, check := range c.checks {
+		wg.Add(1)
+		go func(i int, check Check) {
+			defer wg.Done()
+			results[i] = c.run(ctx, check)
+		}(i, check)
+	}
+	wg.Wait()
+
+	c.checkedAt = c.now()
+	c.response = Response{Status: StatusOk, CheckedAt: c.checkedAt, Checks: results}
+	for _, result := range results {
+		if result.Status != StatusOk {
+			c.response.Status = StatusFail
+		}
+	}
+	return c.response
+}
+
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	start := c.now()
+	err := check.Check(ctx)
+	result := Result{
+		Name:      check.Name,
+		Status:    StatusOk,
+		LatencyMs: float64(c.now().Sub(start).Microseconds()) / 1000,
+	}
+	if err != nil {
+		result.Status = StatusFail
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// LivenessHandler only tells that the process is serving requests, the dependencies are not checked,
+// otherwise an unavailable database would restart all replicas
+func LivenessHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeResponse(w, Response{Status: StatusOk, CheckedAt: time.Now()})
+	})
+}
+
+// ReadinessHandler returns 503 if a check failed, so no traffic is routed to this replica
+func ReadinessHandler(checker *Checker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// a canceled probe must not cache failed results
+		writeResponse(w, checker.Run(context.Background()))
+	})
+}
+
+func writeResponse(w http.ResponseWriter, response Response) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+	if response.Status != StatusOk {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	_ = json.NewEncoder(w).Encode(response)
+}
+
+// ReachableCheck fails if the service cannot be reached or answers with a server error.
+// Other statuses are fine, the base url of a service usually has no route.
+func ReachableCheck(name string, url string, client *http.Client) Check {
+	return Check{Name: name, Check: func(ctx context.Context) error {
+		r, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := client.Do(r)
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return errors.New("status " + resp.Status)
+		}
+		return nil
+	}}
+}
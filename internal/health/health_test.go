@@ -0,0 +1,95 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheckerCachesResults(t *testing.T) {
+	calls := 0
+	var checkErr error
+	checker := NewChecker(time.Second*10, time.Second, Check{Name: "database", Check: func(ctx context.Context) error {
+		calls++
+		return checkErr
+	}})
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	checker.now = func() time.Time { return now }
+
+	checker.Run(context.Background())
+	checkErr = errors.New("connection refused")
+	response := checker.Run(context.Background())
+	if calls != 1 || response.Status != StatusOk {
+		t.Errorf("Expected the cached result, but got %d calls and status %s", calls, response.Status)
+	}
+
+	now = now.Add(time.Second * 10)
+	response = checker.Run(context.Background())
+	if calls != 2 || response.Status != StatusFail || response.Checks[0].Error != "connection refused" {
+		t.Errorf("Expected a new failed result, but got %d calls and %+v", calls, response)
+	}
+}
+
+func TestCheckerTimeout(t *testing.T) {
+	checker := NewChecker(0, time.Millisecond, Check{Name: "slow", Check: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+	response := checker.Run(context.Background())
+	if response.Status != StatusFail {
+		t.Errorf("Expected a failed check after the timeout, but got %+v", response)
+	}
+}
+
+func TestReadinessHandler(t *testing.T) {
+	tests := map[string]struct {
+		err    error
+		status int
+	}{
+		"ok":     {nil, http.StatusOK},
+		"failed": {errors.New("down"), http.StatusServiceUnavailable},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			checker := NewChecker(0, time.Second,
+				Check{Name: "database", Check: func(ctx context.Context) error { return nil }},
+				Check{Name: "ethereum", Check: func(ctx context.Context) error { return test.err }},
+			)
+			w := httptest.NewRecorder()
+			ReadinessHandler(checker).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+			if w.Code != test.status {
+				t.Errorf("Expected status %d, but got %d", test.status, w.Code)
+			}
+			var response Response
+			err := json.Unmarshal(w.Body.Bytes(), &response)
+			if err != nil || len(response.Checks) != 2 || response.Checks[0].Name != "database" || response.Checks[1].Name != "ethereum" {
+				t.Errorf("Unexpected body %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestReachableCheck(t *testing.T) {
+	status := http.StatusNotFound
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	check := ReachableCheck("proxy", server.URL, server.Client())
+
+	if err := check.Check(context.Background()); err != nil {
+		t.Errorf("Expected a reachable service, but got %s", err.Error())
+	}
+	status = http.StatusBadGateway
+	if err := check.Check(context.Background()); err == nil {
+		t.Errorf("Expected an error of a server error")
+	}
+	server.Close()
+	if err := check.Check(context.Background()); err == nil {
+		t.Errorf("Expected an error of an unreachable service")
+	}
+}
@@ -0,0 +1,84 @@
+// Package logging configures the structured json logs. Attributes stored in the context of a request,
+// e.g. the request id and the ids of a payment, are added to every line logged with that context, as well as
+// the ids of the current span.
+package logging
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+type attrsKey struct{}
+
+// Setup sets the default logger, the log package writes to it as well
+func Setup(w io.Writer, level string) {
+	slog.SetDefault(New(w, level))
+}
+
+// New returns a json logger, unknown levels are info
+func New(w io.Writer, level string) *slog.Logger {
+	var l slog.Level
+	err := l.UnmarshalText([]byte(strings.ToUpper(level)))
+	if err != nil {
+		l = slog.LevelInfo
+	}
+	return slog.New(contextHandler{slog.NewJSONHandler(w, &slog.HandlerOptions{Level: l})})
+}
+
+// With returns a context whose log lines have the attributes. Attributes of the parent are kept,
+// unless they have the key of a new attribute.
+func With(ctx context.Context, attrs ...slog.Attr) context.Context {
+	parent := attrsFrom(ctx)
+	combined := make([]slog.Attr, 0, len(parent)+len(attrs))
+	for _, attr := range parent {
+		if !hasKey(attrs, attr.Key) {
+			combined = append(combined, attr)
+		}
+	}
+	combined = append(combined, attrs...)
+	return context.WithValue(ctx, attrsKey{}, combined)
+}
+
+func hasKey(attrs []slog.Attr, key string) bool {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+func attrsFrom(ctx context.Context) []slog.Attr {
+	if ctx == nil {
+		return nil
+	}
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// contextHandler adds the attributes of the context and the ids of the current span to the record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	r.AddAttrs(attrsFrom(ctx)...)
+	if ctx != nil {
+		if span := trace.SpanContextFromContext(ctx); span.IsValid() {
+			r.AddAttrs(slog.String("trace_id", span.TraceID().String()), slog.String("span_id", span.SpanID().String()))
+		}
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,113 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestContextAttributes(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "info")
+
+	ctx := With(context.Background(), slog.String("payment_id", "1"), slog.String("mode", "TEST"))
+	ctx = With(ctx, slog.String("payment_id", "2"))
+	logger.InfoContext(ctx, "message", "state", "PAID")
+	logger.DebugContext(ctx, "not logged")
+
+	var line map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &line)
+	if err != nil {
+		t.Fatalf("Expected one json line, but got %s", buf.String())
+	}
+	if line["msg"] != "message" || line["payment_id"] != "2" || line["mode"] != "TEST" || line["state"] != "PAID" {
+		t.Errorf("Unexpected log line %s", buf.String())
+	}
+}
+
+func TestTraceIds(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, "info")
+	traceId, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanId, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	ctx := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceId, SpanID: spanId}))
+
+	logger.InfoContext(ctx, "message")
+
+	var line map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &line)
+	if err != nil {
+		t.Fatalf("Expected one json line, but got %s", buf.String())
+	}
+	if line["trace_id"] != traceId.String() || line["span_id"] != spanId.String() {
+		t.Errorf("Unexpected log line %s", buf.String())
+	}
+}
+
+func TestRequestIdMiddleware(t *testing.T) {
+	tests := map[string]struct {
+		header   string
+		expected string
+	}{
+		"valid id":   {"abc-123", "abc-123"},
+		"invalid id": {"abc 123\n", ""},
+		"missing id": {"", ""},
+	}
+	for name, test := range tests {
+		var requestId string
+		handler := NewRequestIdMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requestId = RequestId(r.Context())
+		}))
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.Header.Set(RequestIdHeader, test.header)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+
+		if requestId == "" || w.Header().Get(RequestIdHeader) != requestId {
+			t.Errorf("%s: expected request id in context and response, but got %q and %q", name, requestId, w.Header().Get(RequestIdHeader))
+		}
+		if test.expected != "" && requestId != test.expected {
+			t.Errorf("%s: expected request id %s, but got %s", name, test.expected, requestId)
+		}
+		if test.expected == "" && requestId == test.header {
+			t.Errorf("%s: expected a new request id", name)
+		}
+	}
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestTransport(t *testing.T) {
+	var sent string
+	transport := &Transport{Base: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		sent = r.Header.Get(RequestIdHeader)
+		return &http.Response{StatusCode: http.StatusOK}, nil
+	})}
+
+	var ctx context.Context
+	NewRequestIdMiddleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx = r.Context()
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	r, _ := http.NewRequestWithContext(ctx, http.MethodPost, "http://localhost/api/payment", nil)
+	_, err := transport.RoundTrip(r)
+	if err != nil {
+		t.Fatalf("RoundTrip: got error %s", err.Error())
+	}
+	if sent == "" || sent != RequestId(ctx) {
+		t.Errorf("Expected request id %s to be sent, but got %s", RequestId(ctx), sent)
+	}
+	if r.Header.Get(RequestIdHeader) != "" {
+		t.Errorf("Expected the original request not to be changed")
+	}
+}
@@ -0,0 +1,68 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// RequestIdHeader is read from incoming requests and sent to the blockchain services and back to the client
+const RequestIdHeader = "X-Request-Id"
+
+type requestIdKey struct{}
+
+// validRequestId limits the ids of clients, they end up in the logs
+var validRequestId = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// NewRequestIdMiddleware keeps a valid request id of the client or creates a new one.
+// A request passing several routers keeps the id of the first one.
+func NewRequestIdMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if RequestId(r.Context()) != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			id := r.Header.Get(RequestIdHeader)
+			if !validRequestId.MatchString(id) {
+				id = uuid.NewString()
+			}
+			w.Header().Set(RequestIdHeader, id)
+
+			ctx := context.WithValue(r.Context(), requestIdKey{}, id)
+			ctx = With(ctx, slog.String("request_id", id))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// RequestId returns the id of the request or an empty string outside of a request
+func RequestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// Transport sends the request id of the context to the called service
+type Transport struct {
+	Base http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	id := RequestId(r.Context())
+	if id == "" || r.Header.Get(RequestIdHeader) != "" {
+		return base.RoundTrip(r)
+	}
+	// a round tripper must not change the request
+	r = r.Clone(r.Context())
+	r.Header.Set(RequestIdHeader, id)
+	return base.RoundTrip(r)
+}
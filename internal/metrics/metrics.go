@@ -0,0 +1,94 @@
+// Package metrics collects the prometheus metrics of the backend, they are served on /metrics.
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "chaingate"
+
+var (
+	PaymentsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payments_created_total",
+		Help:      "Payments and invoices created, invoices have the currency NOT_SELECTED.",
+	}, []string{"currency", "mode"})
+
+	PaymentStateTransitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "payment_state_transitions_total",
+		Help:      "New states of payments.",
+	}, []string{"state"})
+
+	ReplacedPaymentFunds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "replaced_payment_funds_total",
+		Help:      "Updates with funds of blockchain payments which were replaced by a currency change, by outcome switched or manual.",
+	}, []string{"currency", "outcome"})
+
+	WebhookDeliveries = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhooks sent to the proxy by outcome, success or failure.",
+	}, []string{"outcome"})
+
+	WebhookDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "webhook_duration_seconds",
+		Help:      "Duration of the webhook deliveries.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	ClientRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "client_request_duration_seconds",
+		Help:      "Duration of the requests to the blockchain services and the proxy.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"service"})
+
+	ClientRequestErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "client_request_errors_total",
+		Help:      "Requests to the blockchain services and the proxy which failed or were answered with a server error.",
+	}, []string{"service"})
+
+	WebsocketConnections = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections",
+		Help:      "Open websocket connections of buyers.",
+	})
+
+	WebsocketPools = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_pools",
+		Help:      "Payments with a pool of websocket connections.",
+	})
+
+	HttpRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Duration of the http requests by route template.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "code"})
+
+	DbQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Duration of the database queries by operation and table.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1},
+	}, []string{"operation", "table"})
+)
+
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Since returns the seconds since start, for the observation of a histogram
+func Since(start time.Time) float64 {
+	return time.Since(start).Seconds()
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMiddlewareUsesRouteTemplate(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/api/public/payment/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	})
+	router.Use(NewMiddleware())
+
+	before := testutil.CollectAndCount(HttpRequestDuration)
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/public/payment/1", nil))
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/public/payment/2", nil))
+
+	if count := testutil.CollectAndCount(HttpRequestDuration); count != before+1 {
+		t.Errorf("Expected one new series for both payments, but got %d", count-before)
+	}
+	if !HttpRequestDuration.DeleteLabelValues("/api/public/payment/{id}", http.MethodGet, "404") {
+		t.Errorf("Expected series of the route template with status 404")
+	}
+}
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestTransportCountsErrors(t *testing.T) {
+	responses := []func() (*http.Response, error){
+		func() (*http.Response, error) { return &http.Response{StatusCode: http.StatusOK}, nil },
+		func() (*http.Response, error) { return &http.Response{StatusCode: http.StatusBadRequest}, nil },
+		func() (*http.Response, error) { return &http.Response{StatusCode: http.StatusBadGateway}, nil },
+		func() (*http.Response, error) { return nil, errors.New("connection refused") },
+	}
+	for _, response := range responses {
+		transport := &Transport{Service: "test", Base: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			return response()
+		})}
+		r := httptest.NewRequest(http.MethodPost, "http://localhost/api/payment", nil)
+		_, _ = transport.RoundTrip(r)
+	}
+
+	if errs := testutil.ToFloat64(ClientRequestErrors.WithLabelValues("test")); errs != 2 {
+		t.Errorf("Expected 2 errors, but got %f", errs)
+	}
+}
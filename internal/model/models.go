@@ -1,16 +1,20 @@
 package model
 
 import (
+	"context"
 	"database/sql/driver"
 	"fmt"
-	"log"
+	"log/slog"
 	"math/big"
 	"reflect"
+	"sync"
 	"time"
 
 	"github.com/gorilla/websocket"
 	"golang.org/x/exp/slices"
 
+	"github.com/CHainGate/backend/internal/metrics"
+	"github.com/CHainGate/backend/internal/utils"
 	"github.com/CHainGate/backend/pkg/enum"
 	"gorm.io/gorm"
 
@@ -18,12 +22,20 @@ import (
 )
 
 type Base struct {
-	ID        uuid.UUID `gorm:"type:uuid;default:uuid_generate_v4()"`
+	ID        uuid.UUID `gorm:"type:uuid"`
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	DeletedAt gorm.DeletedAt `gorm:"index"`
 }
 
+// BeforeCreate generates the id, so no database specific default is needed
+func (base *Base) BeforeCreate(*gorm.DB) error {
+	if base.ID == uuid.Nil {
+		base.ID = uuid.New()
+	}
+	return nil
+}
+
 type Merchant struct {
 	Base
 	FirstName         string
@@ -32,16 +44,97 @@ type Merchant struct {
 	Password          string
 	Salt              []byte
 	IsActive          bool
+	TokenVersion      int
+	FailedLogins      int
+	LockedUntil       *time.Time
+	Branding          Branding  `gorm:"embedded;embeddedPrefix:branding_"`
+	TwoFactor         TwoFactor `gorm:"embedded;embeddedPrefix:two_factor_"`
 	EmailVerification EmailVerification
+	Membership        *Membership
+	PasswordResets    []PasswordReset
+	RecoveryCodes     []RecoveryCode
 	Wallets           []Wallet
 	ApiKeys           []ApiKey
 	Payments          []Payment
 }
 
+// Branding customizes the hosted checkout page of a merchant
+type Branding struct {
+	LogoUrl      string
+	PrimaryColor string
+	AccentColor  string
+}
+
+// EmailVerification holds the hash of the token sent to a new merchant, it is locked after too many wrong attempts
 type EmailVerification struct {
 	Base
-	MerchantId       uuid.UUID `gorm:"type:uuid"`
-	VerificationCode uint64
+	MerchantId uuid.UUID `gorm:"type:uuid"`
+	TokenHash  string
+	ExpiresAt  time.Time
+	SentAt     time.Time
+	Attempts   int
+}
+
+// Membership makes a merchant account a member of the organization of another merchant.
+// The merchant owning the wallets, api keys and payments is the organization and implicitly its owner.
+type Membership struct {
+	Base
+	OrganizationId uuid.UUID `gorm:"type:uuid;index"`
+	MerchantId     uuid.UUID `gorm:"type:uuid;uniqueIndex"`
+	Role           enum.Role
+}
+
+// Invitation to join an organization, only the hash of the token is stored
+type Invitation struct {
+	Base
+	OrganizationId uuid.UUID `gorm:"type:uuid;index"`
+	Email          string
+	Role           enum.Role
+	TokenHash      string `gorm:"uniqueIndex"`
+	ExpiresAt      time.Time
+	AcceptedAt     *time.Time
+}
+
+// TwoFactor is the TOTP configuration of a merchant. The secret is encrypted and
+// only used for logins and sensitive operations once the enrolment is confirmed.
+type TwoFactor struct {
+	Secret       string
+	Enabled      bool
+	LastUsedStep int64
+}
+
+// RecoveryCode can be used once instead of a TOTP code, only the hash is stored
+type RecoveryCode struct {
+	Base
+	MerchantId uuid.UUID `gorm:"type:uuid;index"`
+	CodeHash   string
+	UsedAt     *time.Time
+}
+
+// PasswordReset is a single-use token to set a new password, only the hash of the token is stored
+type PasswordReset struct {
+	Base
+	MerchantId uuid.UUID `gorm:"type:uuid;index"`
+	TokenHash  string    `gorm:"uniqueIndex"`
+	ExpiresAt  time.Time
+	UsedAt     *time.Time
+}
+
+// RefreshToken belongs to a login session (Family) and is replaced on every refresh, only the hash is stored
+type RefreshToken struct {
+	Base
+	MerchantId uuid.UUID `gorm:"type:uuid;index"`
+	FamilyId   uuid.UUID `gorm:"type:uuid;index"`
+	TokenHash  string    `gorm:"uniqueIndex"`
+	ExpiresAt  time.Time
+	RevokedAt  *time.Time
+}
+
+// RateLimitBucket is the token bucket of a rate limit key, shared by all replicas
+type RateLimitBucket struct {
+	Key       string `gorm:"primaryKey"`
+	Tokens    float64
+	UpdatedAt time.Time `gorm:"autoUpdateTime:false;index"`
 }
 
 type Wallet struct {
@@ -81,32 +174,86 @@ type Payment struct {
 
 type PaymentState struct {
 	Base
-	PaymentId    uuid.UUID `gorm:"type:uuid"`
-	PayAmount    *BigInt   `gorm:"type:numeric"`
-	ActuallyPaid *BigInt   `gorm:"type:numeric"`
-	PaymentState enum.State
+	PaymentId           uuid.UUID `gorm:"type:uuid"`
+	BlockchainPaymentId uuid.UUID `gorm:"type:uuid;index"`
+	PayCurrency         enum.CryptoCurrency
+	PayAddress          string
+	PayAmount           *BigInt `gorm:"type:numeric"`
+	ActuallyPaid        *BigInt `gorm:"type:numeric"`
+	PaymentState        enum.State
 }
 
+// BigInt is stored as a decimal string, which postgres converts to numeric and sqlite keeps as text
 type BigInt struct {
 	big.Int
 }
 
+const (
+	// maxPoolHistory is the number of broadcast events a pool keeps for Last-Event-ID resumption
+	maxPoolHistory = 32
+	// initialEventId is the id of the state a pool starts with, so 0 is never handed out as an event id
+	initialEventId = 1
+	// clientSendBuffer is the number of messages queued for a websocket client before it is dropped
+	clientSendBuffer = 16
+	// time allowed to write a message to the client
+	writeWait = 10 * time.Second
+	// time allowed to read the next pong message from the client
+	pongWait = 60 * time.Second
+	// pings are sent with this period, must be less than pongWait
+	pingPeriod = (pongWait * 9) / 10
+	// maximum message size allowed from the client
+	maxMessageSize = 512
+)
+
 type Message struct {
+	Version     int         `json:"version"`
 	Type        string      `json:"type"`
 	MessageType string      `json:"messageType"`
 	Body        interface{} `json:"body"`
 }
+
+// Event is a broadcast message with its sequence number inside the pool
+type Event struct {
+	Id      uint64  `json:"id"`
+	Message Message `json:"message"`
+}
+
+// Subscriber receives the events of a pool over a channel (used by SSE and long-polling).
+// The pool closes Events if the subscriber cannot keep up, it has to resume with its last event id.
+type Subscriber struct {
+	LastEventId uint64
+	Events      chan Event
+}
+
 type Pool struct {
-	Register   chan *Client
-	Unregister chan *Client
-	Clients    map[*Client]bool
-	Broadcast  chan Message
+	PaymentId   uuid.UUID
+	Register    chan *Client
+	Unregister  chan *Client
+	Subscribe   chan *Subscriber
+	Unsubscribe chan *Subscriber
+	Clients     map[*Client]bool
+	Subscribers map[*Subscriber]bool
+	Broadcast   chan Message
+	History     []Event
+	drain       chan chan []*Client
+	stop        chan struct{}
+	done        chan struct{}
+	lastEventId uint64
+	mu          sync.Mutex
 }
 
 type Client struct {
 	ID   string
 	Conn *websocket.Conn
 	Pool *Pool
+	Send chan Message
+	// closeCode is sent in the close frame once Send is closed
+	closeCode int
+	// done is closed when the write pump stopped
+	done chan struct{}
+	// closed is set when Send is closed, Queue must not send to it anymore
+	closed bool
+	mu     sync.Mutex
 }
 
 type SocketBody struct {
@@ -114,6 +261,7 @@ type SocketBody struct {
 	Currency       string    `json:"currency"`
 	PayAddress     string    `json:"payAddress"`
 	PayAmount      string    `json:"payAmount"`
+	PaymentUri     string    `json:"paymentUri"`
 	ActuallyPaid   string    `json:"actuallyPaid"`
 	ExpireTime     time.Time `json:"expireTime"`
 	Mode           string    `json:"mode"`
@@ -121,86 +269,322 @@ type SocketBody struct {
 	FailurePageURL string    `json:"failurePageURL"`
 }
 
-func (c *Client) SendInitialCoins() {
-	message := Message{MessageType: enum.CurrencySelection.String(), Body: enum.GetCryptoCurrencyDetails()}
-	c.Conn.WriteJSON(message)
+func NewPool() *Pool {
+	return &Pool{
+		Register:    make(chan *Client),
+		Unregister:  make(chan *Client),
+		Subscribe:   make(chan *Subscriber),
+		Unsubscribe: make(chan *Subscriber),
+		Clients:     make(map[*Client]bool),
+		Subscribers: make(map[*Subscriber]bool),
+		Broadcast:   make(chan Message),
+		drain:       make(chan chan []*Client),
+		stop:        make(chan struct{}),
+		done:        make(chan struct{}),
+		lastEventId: initialEventId,
+	}
 }
 
-func GetWaitingCreateDate(payment *Payment) time.Time {
-	index := slices.IndexFunc(payment.PaymentStates, func(ps PaymentState) bool { return ps.PaymentState == enum.Waiting })
-	return payment.PaymentStates[index].CreatedAt
+func NewClient(conn *websocket.Conn, pool *Pool) *Client {
+	return &Client{
+		Conn: conn,
+		Pool: pool,
+		Send: make(chan Message, clientSendBuffer),
+		done: make(chan struct{}),
+	}
 }
 
-func (c *Client) SendWaiting(body SocketBody) {
-	message := Message{MessageType: enum.Waiting.String(), Body: body}
-	c.Pool.Broadcast <- message
+func NewSubscriber(lastEventId uint64) *Subscriber {
+	return &Subscriber{
+		LastEventId: lastEventId,
+		Events:      make(chan Event, maxPoolHistory),
+	}
 }
 
-func (c *Client) SendPartiallyPaid(body SocketBody) {
-	message := Message{MessageType: enum.PartiallyPaid.String(), Body: body}
-	c.Pool.Broadcast <- message
+func NewSocketBody(payment *Payment, initialState bool) SocketBody {
+	paymentUri, err := GetPaymentUri(payment)
+	if err != nil {
+		slog.Error("Could not create payment uri", "payment_id", payment.ID, "error", err)
+	}
+	return SocketBody{
+		InitialState:   initialState,
+		Currency:       payment.PayCurrency.String(),
+		PayAddress:     payment.PayAddress,
+		PayAmount:      payment.PaymentStates[0].PayAmount.String(),
+		PaymentUri:     paymentUri,
+		ActuallyPaid:   payment.PaymentStates[0].ActuallyPaid.String(),
+		ExpireTime:     GetWaitingCreateDate(payment).Add(15 * time.Minute),
+		Mode:           payment.Mode.String(),
+		SuccessPageURL: payment.SuccessPageUrl,
+		FailurePageURL: payment.FailurePageUrl,
+	}
 }
 
-func (c *Client) SendReceivedTX(body SocketBody) {
-	message := Message{MessageType: enum.Paid.String(), Body: body}
-	c.Pool.Broadcast <- message
+// NewInitialMessage returns the message a newly connected client needs to render the current state of the payment
+func NewInitialMessage(payment *Payment) Message {
+	state := payment.PaymentStates[0].PaymentState
+	if state == enum.CurrencySelection {
+		return NewStateMessage(state, enum.GetCryptoCurrencyDetails())
+	}
+	return NewStateMessage(state, NewSocketBody(payment, true))
 }
 
-func (c *Client) SendConfirmed(body SocketBody) {
-	message := Message{MessageType: enum.Confirmed.String(), Body: body}
-	c.Pool.Broadcast <- message
+// GetPaymentUri returns the BIP21 or EIP-681 uri with the amount which is still open, for a partially paid payment the rest
+func GetPaymentUri(payment *Payment) (string, error) {
+	state := payment.PaymentStates[0]
+	amount := new(big.Int).Set(&state.PayAmount.Int)
+	if state.ActuallyPaid != nil {
+		amount.Sub(amount, &state.ActuallyPaid.Int)
+	}
+	if amount.Sign() < 0 {
+		amount.SetInt64(0)
+	}
+	return utils.PaymentUri(payment.PayCurrency, payment.Mode, payment.PayAddress, *amount)
 }
 
-func (c *Client) SendExpired(body SocketBody) {
-	message := Message{MessageType: enum.Expired.String(), Body: body}
-	c.Pool.Broadcast <- message
+func GetWaitingCreateDate(payment *Payment) time.Time {
+	index := slices.IndexFunc(payment.PaymentStates, func(ps PaymentState) bool { return ps.PaymentState == enum.Waiting })
+	return payment.PaymentStates[index].CreatedAt
 }
 
-func (c *Client) SendFailed(body SocketBody) {
-	message := Message{MessageType: enum.Failed.String(), Body: body}
-	c.Pool.Broadcast <- message
+// Queue adds a message to the send buffer of the client without blocking.
+// It returns false if the buffer is full.
+func (c *Client) Queue(message Message) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return false
+	}
+	select {
+	case c.Send <- message:
+		return true
+	default:
+		return false
+	}
 }
 
-func (c *Client) Read() string {
-	selected := ""
+// close closes the send buffer, the write pump sends a close frame with the code and stops
+func (c *Client) close(code int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+	c.closeCode = code
+	close(c.Send)
+}
+
+func (c *Client) SendError(code string, message string) {
+	c.Queue(NewErrorMessage(code, message))
+}
+
+// ReadPump reads the messages of the client until the connection is closed or the client
+// stops answering pings. Valid messages except pings are passed to the handler.
+func (c *Client) ReadPump(handle func(message *ClientMessage)) {
+	defer func() {
+		c.Pool.Unregister <- c
+		c.Conn.Close()
+	}()
+	c.Conn.SetReadLimit(maxMessageSize)
+	c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	c.Conn.SetPongHandler(func(string) error {
+		return c.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
 	for {
-		var message Message
-		err := c.Conn.ReadJSON(&message)
+		_, data, err := c.Conn.ReadMessage()
 		if err != nil {
-			log.Println("read failed:", err)
-			c.Pool.Unregister <- c
-			c.Conn.Close()
-			break
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				slog.Debug("WebSocket read failed", "payment_id", c.Pool.PaymentId, "error", err)
+			}
+			return
+		}
+		message, err := DecodeClientMessage(data)
+		if err != nil {
+			c.SendError(ErrorCode(err), err.Error())
+			continue
+		}
+		switch message.Type {
+		case PingMessage:
+			c.Queue(NewPongMessage())
+		case PongMessage:
+		default:
+			handle(message)
+		}
+	}
+}
+
+// WritePump writes the queued messages to the connection and pings the client.
+// It sends a close frame once the pool closes the send buffer.
+func (c *Client) WritePump() {
+	ticker := time.NewTicker(pingPeriod)
+	defer func() {
+		ticker.Stop()
+		c.Conn.Close()
+		close(c.done)
+	}()
+	for {
+		select {
+		case message, ok := <-c.Send:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if !ok {
+				c.Conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(c.closeCode, ""))
+				return
+			}
+			if err := c.Conn.WriteJSON(message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
 		}
-		mapCurrency := message.Body.(map[string]interface{})
-		selected = mapCurrency["currency"].(string)
-		break
 	}
-	return selected
 }
 
 func (pool *Pool) Start() {
+	defer close(pool.done)
 	for {
 		select {
+		case <-pool.stop:
+			return
 		case client := <-pool.Register:
 			pool.Clients[client] = true
-			fmt.Println("Size of Connection Pool: ", len(pool.Clients))
+			metrics.WebsocketConnections.Inc()
+			slog.Debug("WebSocket client registered", "payment_id", pool.PaymentId, "clients", len(pool.Clients))
 			break
 		case client := <-pool.Unregister:
-			delete(pool.Clients, client)
-			fmt.Println("Size of Connection Pool: ", len(pool.Clients))
+			if _, ok := pool.Clients[client]; ok {
+				delete(pool.Clients, client)
+				client.close(websocket.CloseNormalClosure)
+				metrics.WebsocketConnections.Dec()
+			}
+			slog.Debug("WebSocket client unregistered", "payment_id", pool.PaymentId, "clients", len(pool.Clients))
 			break
+		case subscriber := <-pool.Subscribe:
+			for _, event := range pool.eventsSince(subscriber.LastEventId) {
+				subscriber.Events <- event
+			}
+			pool.Subscribers[subscriber] = true
+		case subscriber := <-pool.Unsubscribe:
+			if _, ok := pool.Subscribers[subscriber]; ok {
+				delete(pool.Subscribers, subscriber)
+				close(subscriber.Events)
+			}
 		case message := <-pool.Broadcast:
-			fmt.Println("Sending message to all clients in Pool")
-			for client, _ := range pool.Clients {
-				if err := client.Conn.WriteJSON(message); err != nil {
-					fmt.Println(err)
-					client.Pool.Unregister <- client
-					client.Conn.Close()
+			slog.Debug("Broadcasting payment update", "payment_id", pool.PaymentId, "clients", len(pool.Clients), "subscribers", len(pool.Subscribers))
+			event := pool.addEvent(message)
+			for client := range pool.Clients {
+				if !client.Queue(message) {
+					// the client is too slow, the closed send buffer makes it disconnect
+					delete(pool.Clients, client)
+					client.close(websocket.CloseNormalClosure)
+					metrics.WebsocketConnections.Dec()
+				}
+			}
+			for subscriber := range pool.Subscribers {
+				select {
+				case subscriber.Events <- event:
+				default:
+					// the subscriber is too slow, it has to reconnect with its last event id
+					delete(pool.Subscribers, subscriber)
+					close(subscriber.Events)
 				}
 			}
+		case reply := <-pool.drain:
+			clients := make([]*Client, 0, len(pool.Clients))
+			for client := range pool.Clients {
+				delete(pool.Clients, client)
+				client.close(websocket.CloseGoingAway)
+				metrics.WebsocketConnections.Dec()
+				clients = append(clients, client)
+			}
+			for subscriber := range pool.Subscribers {
+				delete(pool.Subscribers, subscriber)
+				close(subscriber.Events)
+			}
+			reply <- clients
+		}
+	}
+}
+
+// Stop ends the goroutine of the pool, it must not have clients or subscribers anymore
+func (pool *Pool) Stop() {
+	close(pool.stop)
+}
+
+// Publish broadcasts the message to the clients and subscribers, it is dropped if the pool was stopped
+func (pool *Pool) Publish(message Message) {
+	select {
+	case pool.Broadcast <- message:
+	case <-pool.done:
+	}
+}
+
+// Drain disconnects all clients and subscribers on shutdown, so they reconnect to another replica.
+// Websocket clients receive a going away close frame, Drain waits until it was written or the context is done.
+func (pool *Pool) Drain(ctx context.Context) error {
+	reply := make(chan []*Client, 1)
+	select {
+	case pool.drain <- reply:
+	case <-pool.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	for _, client := range <-reply {
+		select {
+		case <-client.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// LastEventId returns the id of the latest broadcast event, initialEventId if nothing was broadcast yet
+func (pool *Pool) LastEventId() uint64 {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	return pool.lastEventId
+}
+
+// CanResume reports whether all events after lastEventId are still in the history
+func (pool *Pool) CanResume(lastEventId uint64) bool {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	if lastEventId > pool.lastEventId {
+		return false
+	}
+	if len(pool.History) == 0 {
+		return lastEventId == pool.lastEventId
+	}
+	return lastEventId+1 >= pool.History[0].Id
+}
+
+func (pool *Pool) addEvent(message Message) Event {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	pool.lastEventId++
+	event := Event{Id: pool.lastEventId, Message: message}
+	pool.History = append(pool.History, event)
+	if len(pool.History) > maxPoolHistory {
+		pool.History = pool.History[len(pool.History)-maxPoolHistory:]
+	}
+	return event
+}
+
+func (pool *Pool) eventsSince(lastEventId uint64) []Event {
+	pool.mu.Lock()
+	defer pool.mu.Unlock()
+	var events []Event
+	for _, event := range pool.History {
+		if event.Id > lastEventId {
+			events = append(events, event)
 		}
 	}
+	return events
 }
 
 func NewBigIntFromInt(value int64) *BigInt {
@@ -211,7 +595,7 @@ func NewBigIntFromInt(value int64) *BigInt {
 func NewBigIntFromString(value string) *BigInt {
 	x, ok := new(big.Int).SetString(value, 10)
 	if !ok {
-		fmt.Println("SetString: error")
+		slog.Error("Invalid big integer", "value", value)
 		return NewBigIntFromInt(0)
 	}
 	return NewBigInt(x)
@@ -0,0 +1,178 @@
+package model
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CHainGate/backend/internal/utils"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/gorilla/websocket"
+)
+
+func TestPoolSubscriberResume(t *testing.T) {
+	pool := NewPool()
+	go pool.Start()
+
+	pool.Broadcast <- Message{MessageType: "waiting"}
+	pool.Broadcast <- Message{MessageType: "paid"}
+
+	subscriber := NewSubscriber(2)
+	pool.Subscribe <- subscriber
+
+	select {
+	case event := <-subscriber.Events:
+		if event.Id != 3 || event.Message.MessageType != "paid" {
+			t.Errorf("Expected event 3 with message paid, but got %d with %s", event.Id, event.Message.MessageType)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the missed event to be replayed")
+	}
+
+	pool.Broadcast <- Message{MessageType: "confirmed"}
+	select {
+	case event := <-subscriber.Events:
+		if event.Id != 4 {
+			t.Errorf("Expected event 4, but got %d", event.Id)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the broadcast event")
+	}
+
+	pool.Unsubscribe <- subscriber
+	if _, ok := <-subscriber.Events; ok {
+		t.Error("Expected the events channel to be closed after unsubscribe")
+	}
+}
+
+func TestPoolCanResume(t *testing.T) {
+	pool := NewPool()
+	go pool.Start()
+
+	if pool.LastEventId() != initialEventId {
+		t.Errorf("Expected a new pool to start at event %d, but got %d", initialEventId, pool.LastEventId())
+	}
+	if !pool.CanResume(initialEventId) {
+		t.Error("Expected an empty pool to be resumable from its initial event")
+	}
+	if pool.CanResume(0) {
+		t.Error("Expected event 0 not to be resumable")
+	}
+
+	for i := 0; i < maxPoolHistory+5; i++ {
+		pool.Broadcast <- Message{MessageType: "partially_paid"}
+	}
+	// the pool handles one channel at a time, after this the last broadcast is processed
+	pool.Unsubscribe <- NewSubscriber(0)
+
+	if pool.LastEventId() != initialEventId+maxPoolHistory+5 {
+		t.Errorf("Expected last event id %d, but got %d", initialEventId+maxPoolHistory+5, pool.LastEventId())
+	}
+	if pool.CanResume(initialEventId + 1) {
+		t.Error("Expected the first broadcast event to be dropped from the history")
+	}
+	if !pool.CanResume(initialEventId + 5) {
+		t.Error("Expected to resume after the fifth broadcast event")
+	}
+	if pool.CanResume(initialEventId + maxPoolHistory + 6) {
+		t.Error("Expected an unknown event id not to be resumable")
+	}
+}
+
+func TestClientQueueAfterSlowClientDropped(t *testing.T) {
+	pool := NewPool()
+	go pool.Start()
+
+	// the client has no write pump, so its send buffer fills up
+	client := NewClient(nil, pool)
+	pool.Register <- client
+	for i := 0; i < clientSendBuffer+1; i++ {
+		pool.Broadcast <- Message{MessageType: "partially_paid"}
+	}
+	pool.Unregister <- client
+
+	if client.Queue(NewPongMessage()) {
+		t.Error("Expected a dropped client not to queue messages")
+	}
+	client.SendError(PaymentFailedError, "payment could not be loaded")
+}
+
+func TestPoolDrain(t *testing.T) {
+	pool := NewPool()
+	go pool.Start()
+
+	upgrader := websocket.Upgrader{}
+	registered := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := NewClient(conn, pool)
+		pool.Register <- client
+		close(registered)
+		go client.WritePump()
+		client.ReadPump(func(message *ClientMessage) {})
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	<-registered
+	subscriber := NewSubscriber(0)
+	pool.Subscribe <- subscriber
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	err = pool.Drain(ctx)
+	if err != nil {
+		t.Fatalf("Drain: got error %s", err.Error())
+	}
+
+	for {
+		_, _, err = conn.ReadMessage()
+		if err != nil {
+			break
+		}
+	}
+	if !websocket.IsCloseError(err, websocket.CloseGoingAway) {
+		t.Errorf("Expected a going away close frame, but got %v", err)
+	}
+	for range subscriber.Events {
+	}
+}
+
+func TestGetPaymentUri(t *testing.T) {
+	utils.Opts = &utils.OptsType{EthereumTestChainId: 5}
+	tests := []struct {
+		state        enum.State
+		actuallyPaid *BigInt
+		expected     string
+	}{
+		{enum.Waiting, NewBigIntFromInt(0), "ethereum:0xeth@5?value=1000"},
+		{enum.Waiting, nil, "ethereum:0xeth@5?value=1000"},
+		{enum.PartiallyPaid, NewBigIntFromInt(400), "ethereum:0xeth@5?value=600"},
+		{enum.Paid, NewBigIntFromInt(1200), "ethereum:0xeth@5"},
+	}
+	for _, test := range tests {
+		payment := &Payment{
+			PayCurrency:   enum.ETH,
+			Mode:          enum.Test,
+			PayAddress:    "0xeth",
+			PaymentStates: []PaymentState{{PaymentState: test.state, PayAmount: NewBigIntFromInt(1000), ActuallyPaid: test.actuallyPaid}},
+		}
+		uri, err := GetPaymentUri(payment)
+		if err != nil {
+			t.Fatalf("GetPaymentUri: got error %s", err.Error())
+		}
+		if uri != test.expected {
+			t.Errorf("Expected uri %s for state %s, but got %s", test.expected, test.state.String(), uri)
+		}
+	}
+}
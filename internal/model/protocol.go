@@ -0,0 +1,124 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/CHainGate/backend/pkg/enum"
+)
+
+// ProtocolVersion is the version of the websocket message protocol sent with every message.
+// Messages without a version are from clients before the protocol was versioned.
+const ProtocolVersion = 1
+
+// Types of the websocket messages
+const (
+	StateUpdateMessage    = "state_update"
+	SelectCurrencyMessage = "select_currency"
+	ChangeCurrencyMessage = "change_currency"
+	PingMessage           = "ping"
+	PongMessage           = "pong"
+	ErrorMessage          = "error"
+)
+
+// Codes of the error messages sent to the client
+const (
+	InvalidMessageError     = "invalid_message"
+	UnsupportedVersionError = "unsupported_version"
+	UnknownTypeError        = "unknown_type"
+	InvalidCurrencyError    = "invalid_currency"
+	InvalidStateError       = "invalid_state"
+	PaymentFailedError      = "payment_failed"
+)
+
+var (
+	ErrInvalidMessage     = errors.New("invalid message")
+	ErrUnsupportedVersion = errors.New("unsupported protocol version")
+	ErrUnknownType        = errors.New("unknown message type")
+	ErrInvalidCurrency    = errors.New("invalid currency")
+)
+
+// ClientMessage is a validated message received from the websocket client
+type ClientMessage struct {
+	Version  int
+	Type     string
+	Currency enum.CryptoCurrency
+}
+
+type SelectCurrencyBody struct {
+	Currency string `json:"currency"`
+}
+
+type ErrorBody struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type rawClientMessage struct {
+	Version int             `json:"version"`
+	Type    string          `json:"type"`
+	Body    json.RawMessage `json:"body"`
+}
+
+func NewStateMessage(state enum.State, body interface{}) Message {
+	return Message{Version: ProtocolVersion, Type: StateUpdateMessage, MessageType: state.String(), Body: body}
+}
+
+func NewErrorMessage(code string, message string) Message {
+	return Message{Version: ProtocolVersion, Type: ErrorMessage, Body: ErrorBody{Code: code, Message: message}}
+}
+
+func NewPongMessage() Message {
+	return Message{Version: ProtocolVersion, Type: PongMessage}
+}
+
+// DecodeClientMessage parses and validates a message of the websocket client.
+// Unversioned messages without a type are treated as currency selection.
+func DecodeClientMessage(data []byte) (*ClientMessage, error) {
+	var raw rawClientMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, ErrInvalidMessage
+	}
+
+	if raw.Version < 0 || raw.Version > ProtocolVersion {
+		return nil, ErrUnsupportedVersion
+	}
+
+	messageType := raw.Type
+	if messageType == "" && raw.Version == 0 {
+		messageType = SelectCurrencyMessage
+	}
+
+	message := &ClientMessage{Version: raw.Version, Type: messageType}
+	switch messageType {
+	case PingMessage, PongMessage:
+		return message, nil
+	case SelectCurrencyMessage, ChangeCurrencyMessage:
+		var body SelectCurrencyBody
+		if len(raw.Body) == 0 || json.Unmarshal(raw.Body, &body) != nil {
+			return nil, ErrInvalidMessage
+		}
+		currency, ok := enum.ParseStringToCryptoCurrencyEnum(body.Currency)
+		if !ok {
+			return nil, ErrInvalidCurrency
+		}
+		message.Currency = currency
+		return message, nil
+	default:
+		return nil, ErrUnknownType
+	}
+}
+
+// ErrorCode maps a decoding error to the code of the error message
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrUnsupportedVersion):
+		return UnsupportedVersionError
+	case errors.Is(err, ErrUnknownType):
+		return UnknownTypeError
+	case errors.Is(err, ErrInvalidCurrency):
+		return InvalidCurrencyError
+	default:
+		return InvalidMessageError
+	}
+}
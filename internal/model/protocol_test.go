@@ -0,0 +1,77 @@
+package model
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/CHainGate/backend/pkg/enum"
+)
+
+type decodeTest struct {
+	name     string
+	data     string
+	msgType  string
+	currency enum.CryptoCurrency
+	err      error
+}
+
+var decodeTests = []decodeTest{
+	{name: "select currency", data: `{"version":1,"type":"select_currency","body":{"currency":"eth"}}`, msgType: SelectCurrencyMessage, currency: enum.ETH},
+	{name: "change currency", data: `{"version":1,"type":"change_currency","body":{"currency":"btc"}}`, msgType: ChangeCurrencyMessage, currency: enum.BTC},
+	{name: "legacy select currency", data: `{"body":{"currency":"btc"}}`, msgType: SelectCurrencyMessage, currency: enum.BTC},
+	{name: "ping", data: `{"version":1,"type":"ping"}`, msgType: PingMessage},
+	{name: "pong", data: `{"version":1,"type":"pong"}`, msgType: PongMessage},
+	{name: "unknown currency", data: `{"version":1,"type":"select_currency","body":{"currency":"doge"}}`, err: ErrInvalidCurrency},
+	{name: "currency of wrong type", data: `{"version":1,"type":"select_currency","body":{"currency":5}}`, err: ErrInvalidMessage},
+	{name: "missing body", data: `{"version":1,"type":"select_currency"}`, err: ErrInvalidMessage},
+	{name: "body not an object", data: `{"body":"eth"}`, err: ErrInvalidMessage},
+	{name: "future version", data: `{"version":2,"type":"ping"}`, err: ErrUnsupportedVersion},
+	{name: "unknown type", data: `{"version":1,"type":"transfer"}`, err: ErrUnknownType},
+	{name: "no json", data: `currency=eth`, err: ErrInvalidMessage},
+}
+
+func TestDecodeClientMessage(t *testing.T) {
+	for _, test := range decodeTests {
+		message, err := DecodeClientMessage([]byte(test.data))
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s: expected error %v, but got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: got error %s", test.name, err.Error())
+			continue
+		}
+		if message.Type != test.msgType {
+			t.Errorf("%s: expected type %s, but got %s", test.name, test.msgType, message.Type)
+		}
+		if message.Currency != test.currency {
+			t.Errorf("%s: expected currency %d, but got %d", test.name, test.currency, message.Currency)
+		}
+	}
+}
+
+func FuzzDecodeClientMessage(f *testing.F) {
+	for _, test := range decodeTests {
+		f.Add([]byte(test.data))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		message, err := DecodeClientMessage(data)
+		if err != nil {
+			if message != nil {
+				t.Errorf("expected no message together with error %v", err)
+			}
+			return
+		}
+		switch message.Type {
+		case PingMessage, PongMessage:
+		case SelectCurrencyMessage, ChangeCurrencyMessage:
+			if message.Currency != enum.ETH && message.Currency != enum.BTC {
+				t.Errorf("decoded selection with invalid currency %d", message.Currency)
+			}
+		default:
+			t.Errorf("decoded unknown message type %q", message.Type)
+		}
+	})
+}
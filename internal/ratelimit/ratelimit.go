@@ -0,0 +1,68 @@
+// Package ratelimit limits requests with token buckets. Every key has a bucket holding up to
+// Burst tokens which refills with Rate tokens per second, each request takes one token.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute allows n requests per minute, all of them at once
+func PerMinute(n int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: n}
+}
+
+// Enabled is false for a limit of 0 requests, which disables rate limiting
+func (l Limit) Enabled() bool {
+	return l.Burst > 0 && l.Rate > 0
+}
+
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	RetryAfter time.Duration
+	Reset      time.Duration
+}
+
+// Store keeps the buckets. Take has to be atomic per key if the store is shared by several replicas.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit, now time.Time) (Result, error)
+}
+
+// Take refills the bucket since the last request and takes a token if one is left.
+// A new bucket is passed with a zero last time and is full.
+func Take(tokens *float64, last *time.Time, limit Limit, now time.Time) Result {
+	if last.IsZero() {
+		*tokens = float64(limit.Burst)
+	} else if elapsed := now.Sub(*last).Seconds(); elapsed > 0 {
+		*tokens = math.Min(float64(limit.Burst), *tokens+elapsed*limit.Rate)
+	}
+	*last = now
+
+	result := Result{Limit: limit.Burst}
+	if *tokens >= 1 {
+		*tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = seconds((1 - *tokens) / limit.Rate)
+	}
+	result.Remaining = int(*tokens)
+	result.Reset = seconds((float64(limit.Burst) - *tokens) / limit.Rate)
+	return result
+}
+
+// full reports whether the bucket would be full again, full buckets can be deleted
+func full(tokens float64, last time.Time, limit Limit, now time.Time) bool {
+	return tokens+now.Sub(last).Seconds()*limit.Rate >= float64(limit.Burst)
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(math.Ceil(s * float64(time.Second)))
+}
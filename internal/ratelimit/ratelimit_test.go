@@ -0,0 +1,118 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTake(t *testing.T) {
+	limit := PerMinute(2)
+	now := time.Unix(1650000000, 0)
+	var tokens float64
+	var last time.Time
+
+	for i := 0; i < limit.Burst; i++ {
+		if result := Take(&tokens, &last, limit, now); !result.Allowed {
+			t.Fatalf("Expected request %d to be allowed", i+1)
+		}
+	}
+
+	result := Take(&tokens, &last, limit, now)
+	if result.Allowed {
+		t.Fatalf("Expected request to be limited")
+	}
+	if result.Remaining != 0 || result.RetryAfter != 30*time.Second || result.Reset != time.Minute {
+		t.Errorf("Unexpected result %+v", result)
+	}
+
+	result = Take(&tokens, &last, limit, now.Add(30*time.Second))
+	if !result.Allowed {
+		t.Errorf("Expected bucket to be refilled after 30s")
+	}
+
+	result = Take(&tokens, &last, limit, now.Add(time.Hour))
+	if !result.Allowed || result.Remaining != limit.Burst-1 {
+		t.Errorf("Expected bucket to be refilled only up to the burst, but got %+v", result)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	now := time.Unix(1650000000, 0)
+	limit := PerMinute(1)
+
+	if result, _ := store.Take(context.Background(), "a", limit, now); !result.Allowed {
+		t.Errorf("Expected first request of a to be allowed")
+	}
+	if result, _ := store.Take(context.Background(), "a", limit, now); result.Allowed {
+		t.Errorf("Expected second request of a to be limited")
+	}
+	if result, _ := store.Take(context.Background(), "b", limit, now); !result.Allowed {
+		t.Errorf("Expected keys to have their own bucket")
+	}
+
+	memory := store.(*memoryStore)
+	memory.prune(now.Add(time.Minute))
+	if len(memory.buckets) != 0 {
+		t.Errorf("Expected full buckets to be pruned, but %d are left", len(memory.buckets))
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(context.Context, string, Limit, time.Time) (Result, error) {
+	return Result{}, errors.New("store down")
+}
+
+func serve(middleware func(http.Handler) http.Handler) *httptest.ResponseRecorder {
+	handler := middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w
+}
+
+func TestMiddleware(t *testing.T) {
+	middleware := NewMiddleware(NewMemoryStore(), func(r *http.Request) (string, Limit) {
+		return "key", PerMinute(1)
+	})
+
+	w := serve(middleware)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("Expected status %d, but got %d", http.StatusNoContent, w.Code)
+	}
+	if w.Header().Get("X-RateLimit-Limit") != "1" || w.Header().Get("X-RateLimit-Remaining") != "0" || w.Header().Get("X-RateLimit-Reset") != "60" {
+		t.Errorf("Unexpected rate limit headers %v", w.Header())
+	}
+
+	w = serve(middleware)
+	if w.Code != http.StatusTooManyRequests {
+		t.Fatalf("Expected status %d, but got %d", http.StatusTooManyRequests, w.Code)
+	}
+	if w.Header().Get("Retry-After") != "60" {
+		t.Errorf("Expected Retry-After 60, but got %s", w.Header().Get("Retry-After"))
+	}
+}
+
+func TestMiddlewareWithoutLimit(t *testing.T) {
+	unlimited := NewMiddleware(failingStore{}, func(r *http.Request) (string, Limit) {
+		return "", PerMinute(1)
+	})
+	disabled := NewMiddleware(failingStore{}, func(r *http.Request) (string, Limit) {
+		return "key", PerMinute(0)
+	})
+	failing := NewMiddleware(failingStore{}, func(r *http.Request) (string, Limit) {
+		return "key", PerMinute(1)
+	})
+
+	for _, middleware := range []func(http.Handler) http.Handler{unlimited, disabled, failing} {
+		if w := serve(middleware); w.Code != http.StatusNoContent {
+			t.Errorf("Expected request to pass, but got status %d", w.Code)
+		}
+	}
+}
@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"context"
+
 	"github.com/CHainGate/backend/internal/model"
 	"github.com/CHainGate/backend/pkg/enum"
 	"github.com/google/uuid"
@@ -12,35 +14,54 @@ type apiKeyRepository struct {
 }
 
 type IApiKeyRepository interface {
-	FindById(id string) (*model.ApiKey, error)
-	FindByMerchantAndMode(merchantId uuid.UUID, mode enum.Mode) (*model.ApiKey, error)
-	Delete(merchantId uuid.UUID, apiKeyId string) error
+	FindById(ctx context.Context, id string) (*model.ApiKey, error)
+	FindByMerchantAndMode(ctx context.Context, merchantId uuid.UUID, mode enum.Mode) (*model.ApiKey, error)
+	FindAll(ctx context.Context) ([]model.ApiKey, error)
+	Update(ctx context.Context, apiKey *model.ApiKey) error
+	Delete(ctx context.Context, merchantId uuid.UUID, apiKeyId string) error
 }
 
 func NewApiKeyRepository(db *gorm.DB) (IApiKeyRepository, error) {
 	return &apiKeyRepository{db}, nil
 }
 
-func (r *apiKeyRepository) FindById(id string) (*model.ApiKey, error) {
+func (r *apiKeyRepository) FindById(ctx context.Context, id string) (*model.ApiKey, error) {
 	var apiKey model.ApiKey
-	result := r.DB.Where("id = ?", id).Find(&apiKey)
+	result := r.DB.WithContext(ctx).Where("id = ?", id).Find(&apiKey)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return &apiKey, nil
 }
 
-func (r *apiKeyRepository) FindByMerchantAndMode(merchantId uuid.UUID, mode enum.Mode) (*model.ApiKey, error) {
+func (r *apiKeyRepository) FindByMerchantAndMode(ctx context.Context, merchantId uuid.UUID, mode enum.Mode) (*model.ApiKey, error) {
 	var key model.ApiKey
-	result := r.DB.Where("merchant_id = ? and mode = ?", merchantId, mode).Find(&key)
+	result := r.DB.WithContext(ctx).Where("merchant_id = ? and mode = ?", merchantId, mode).Find(&key)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return &key, nil
 }
 
-func (r *apiKeyRepository) Delete(merchantId uuid.UUID, apiKeyId string) error {
-	result := r.DB.Model(&model.ApiKey{}).Where("id = ? AND merchant_id = ?", apiKeyId, merchantId).Delete(&model.ApiKey{})
+func (r *apiKeyRepository) FindAll(ctx context.Context) ([]model.ApiKey, error) {
+	var keys []model.ApiKey
+	result := r.DB.WithContext(ctx).Order("created_at, id").Find(&keys)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return keys, nil
+}
+
+func (r *apiKeyRepository) Update(ctx context.Context, apiKey *model.ApiKey) error {
+	result := r.DB.WithContext(ctx).Save(apiKey)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (r *apiKeyRepository) Delete(ctx context.Context, merchantId uuid.UUID, apiKeyId string) error {
+	result := r.DB.WithContext(ctx).Model(&model.ApiKey{}).Where("id = ? AND merchant_id = ?", apiKeyId, merchantId).Delete(&model.ApiKey{})
 	if result.Error != nil {
 		return result.Error
 	}
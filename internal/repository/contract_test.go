@@ -0,0 +1,87 @@
+package repository_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/internal/repository/repositorytest"
+)
+
+// TestContractPostgres runs against the postgres database in TEST_DATABASE_DSN, all data in it is deleted
+func TestContractPostgres(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm.Open: got error %s", err.Error())
+	}
+	migrate(t, db)
+
+	truncate := func(t *testing.T) {
+		err := db.Exec("TRUNCATE payment_states, payments, api_keys, wallets, recovery_codes, password_resets, memberships, " +
+			"invitations, refresh_tokens, email_verifications, merchants CASCADE").Error
+		if err != nil {
+			t.Fatalf("truncate: got error %s", err.Error())
+		}
+	}
+	repositorytest.Run(t, func(t *testing.T) repository.Repositories {
+		truncate(t)
+		return newRepositories(db)
+	}, func(t *testing.T) (repository.IUnitOfWork, repository.Repositories) {
+		truncate(t)
+		unitOfWork, _ := repository.NewUnitOfWork(db)
+		return unitOfWork, newRepositories(db)
+	})
+}
+
+// TestContractSqlite creates a database file per test
+func TestContractSqlite(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repositories {
+		return newRepositories(openSqlite(t))
+	}, func(t *testing.T) (repository.IUnitOfWork, repository.Repositories) {
+		db := openSqlite(t)
+		unitOfWork, _ := repository.NewUnitOfWork(db)
+		return unitOfWork, newRepositories(db)
+	})
+}
+
+func openSqlite(t *testing.T) *gorm.DB {
+	db, err := repository.OpenSqlite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("OpenSqlite: got error %s", err.Error())
+	}
+	t.Cleanup(func() {
+		conn, _ := db.DB()
+		_ = conn.Close()
+	})
+	migrate(t, db)
+	return db
+}
+
+func migrate(t *testing.T, db *gorm.DB) {
+	migrator, err := repository.NewMigrator(db)
+	if err != nil {
+		t.Fatalf("NewMigrator: got error %s", err.Error())
+	}
+	err = migrator.Up()
+	if err != nil {
+		t.Fatalf("Up: got error %s", err.Error())
+	}
+}
+
+func newRepositories(db *gorm.DB) repository.Repositories {
+	var repos repository.Repositories
+	repos.Merchant, _ = repository.NewMerchantRepository(db)
+	repos.ApiKey, _ = repository.NewApiKeyRepository(db)
+	repos.Payment, _ = repository.NewPaymentRepository(db)
+	repos.Team, _ = repository.NewTeamRepository(db)
+	repos.RefreshToken, _ = repository.NewRefreshTokenRepository(db)
+	return repos
+}
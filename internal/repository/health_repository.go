@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type healthRepository struct {
+	DB       *gorm.DB
+	migrator *Migrator
+}
+
+// IHealthRepository is used by the readiness check and closes the database on shutdown
+type IHealthRepository interface {
+	Ping(ctx context.Context) error
+	CheckSchemaVersion(ctx context.Context) error
+	// Close closes the connection pool, no repository can be used afterwards
+	Close() error
+}
+
+func NewHealthRepository(db *gorm.DB) (IHealthRepository, error) {
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		return nil, err
+	}
+	return &healthRepository{db, migrator}, nil
+}
+
+func (r *healthRepository) Ping(ctx context.Context) error {
+	sqlDB, err := r.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.PingContext(ctx)
+}
+
+// CheckSchemaVersion fails if the migrations of the database do not match this binary,
+// e.g. after a rollback of the binary or while another replica is migrating. It only reads the version table.
+func (r *healthRepository) CheckSchemaVersion(ctx context.Context) error {
+	return r.migrator.checkVersion(r.DB.WithContext(ctx))
+}
+
+func (r *healthRepository) Close() error {
+	sqlDB, err := r.DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Close()
+}
@@ -0,0 +1,41 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/CHainGate/backend/internal/repository"
+)
+
+func TestHealthRepository(t *testing.T) {
+	db, err := repository.OpenSqlite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("OpenSqlite: got error %s", err.Error())
+	}
+	healthRepo, _ := repository.NewHealthRepository(db)
+	ctx := context.Background()
+
+	if err := healthRepo.Ping(ctx); err != nil {
+		t.Errorf("Ping: got error %s", err.Error())
+	}
+	if err := healthRepo.CheckSchemaVersion(ctx); !errors.Is(err, repository.ErrSchemaOutdated) {
+		t.Errorf("Expected outdated schema before the migrations, but got %v", err)
+	}
+	if db.Migrator().HasTable("schema_migrations") {
+		t.Errorf("Expected the check not to create the version table")
+	}
+
+	migrate(t, db)
+	if err := healthRepo.CheckSchemaVersion(ctx); err != nil {
+		t.Errorf("CheckSchemaVersion: got error %s", err.Error())
+	}
+
+	if err := healthRepo.Close(); err != nil {
+		t.Errorf("Close: got error %s", err.Error())
+	}
+	if err := healthRepo.Ping(ctx); err == nil {
+		t.Errorf("Expected an error of a closed database")
+	}
+}
@@ -0,0 +1,71 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+)
+
+type apiKeyRepository struct {
+	DB *DB
+}
+
+func NewApiKeyRepository(db *DB) (repository.IApiKeyRepository, error) {
+	return &apiKeyRepository{db}, nil
+}
+
+// FindById returns an empty api key if there is none, like the postgres repository
+func (r *apiKeyRepository) FindById(_ context.Context, id string) (*model.ApiKey, error) {
+	apiKeyId, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	return r.findApiKey(func(k *model.ApiKey) bool { return k.ID == apiKeyId }), nil
+}
+
+func (r *apiKeyRepository) FindByMerchantAndMode(_ context.Context, merchantId uuid.UUID, mode enum.Mode) (*model.ApiKey, error) {
+	return r.findApiKey(func(k *model.ApiKey) bool { return k.MerchantId == merchantId && k.Mode == mode }), nil
+}
+
+func (r *apiKeyRepository) findApiKey(match func(k *model.ApiKey) bool) *model.ApiKey {
+	var key model.ApiKey
+	r.DB.read(func() {
+		keys := r.DB.apiKeys.find(match)
+		if len(keys) > 0 {
+			key = keys[0]
+		}
+	})
+	return &key
+}
+
+func (r *apiKeyRepository) FindAll(_ context.Context) ([]model.ApiKey, error) {
+	var keys []model.ApiKey
+	r.DB.read(func() {
+		keys = r.DB.apiKeys.find(func(k *model.ApiKey) bool { return true })
+	})
+	return keys, nil
+}
+
+func (r *apiKeyRepository) Update(_ context.Context, apiKey *model.ApiKey) error {
+	return r.DB.transaction(func(now time.Time) error {
+		if !r.DB.merchants.exists(apiKey.MerchantId) {
+			return foreignKeyViolation("fk_merchants_api_keys")
+		}
+		return r.DB.apiKeys.save(apiKey, now)
+	})
+}
+
+func (r *apiKeyRepository) Delete(_ context.Context, merchantId uuid.UUID, apiKeyId string) error {
+	id, err := uuid.Parse(apiKeyId)
+	if err != nil {
+		return err
+	}
+	return r.DB.transaction(func(now time.Time) error {
+		r.DB.apiKeys.softDelete(func(k *model.ApiKey) bool { return k.ID == id && k.MerchantId == merchantId }, now)
+		return nil
+	})
+}
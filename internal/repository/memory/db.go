@@ -0,0 +1,372 @@
+// Package memory implements the repositories without a database. It keeps the semantics of the
+// postgres repositories (soft delete, unique indexes, ordering), so services can be tested without mocks.
+package memory
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DB holds the tables shared by the repositories. Associations are stored in their own tables like in postgres.
+// A unit of work holds the lock until it is done, so transactions are serializable.
+type DB struct {
+	mu                 *sync.Mutex
+	inTx               bool
+	merchants          *table[model.Merchant]
+	emailVerifications *table[model.EmailVerification]
+	memberships        *table[model.Membership]
+	passwordResets     *table[model.PasswordReset]
+	recoveryCodes      *table[model.RecoveryCode]
+	wallets            *table[model.Wallet]
+	apiKeys            *table[model.ApiKey]
+	payments           *table[model.Payment]
+	paymentStates      *table[model.PaymentState]
+	invitations        *table[model.Invitation]
+	refreshTokens      *table[model.RefreshToken]
+}
+
+func NewDB() *DB {
+	return &DB{
+		mu: &sync.Mutex{},
+		merchants: newTable("merchants", func(m *model.Merchant) *model.Base { return &m.Base }, cloneMerchant,
+			unique[model.Merchant]{name: "merchants_email_key", withDeleted: true, key: func(m *model.Merchant) string { return m.Email }}),
+		emailVerifications: newTable("email_verifications", func(v *model.EmailVerification) *model.Base { return &v.Base }, identity[model.EmailVerification]),
+		memberships: newTable("memberships", func(m *model.Membership) *model.Base { return &m.Base }, identity[model.Membership],
+			unique[model.Membership]{name: "idx_memberships_merchant_id", withDeleted: true, key: func(m *model.Membership) string { return m.MerchantId.String() }}),
+		passwordResets: newTable("password_resets", func(r *model.PasswordReset) *model.Base { return &r.Base }, clonePasswordReset,
+			unique[model.PasswordReset]{name: "idx_password_resets_token_hash", withDeleted: true, key: func(r *model.PasswordReset) string { return r.TokenHash }}),
+		recoveryCodes: newTable("recovery_codes", func(c *model.RecoveryCode) *model.Base { return &c.Base }, cloneRecoveryCode),
+		wallets: newTable("wallets", func(w *model.Wallet) *model.Base { return &w.Base }, identity[model.Wallet],
+			unique[model.Wallet]{name: "wallet_index", key: func(w *model.Wallet) string {
+				return fmt.Sprint(w.MerchantId, w.Currency, w.Mode)
+			}}),
+		apiKeys: newTable("api_keys", func(k *model.ApiKey) *model.Base { return &k.Base }, cloneApiKey,
+			unique[model.ApiKey]{name: "api_key_index", key: func(k *model.ApiKey) string { return fmt.Sprint(k.MerchantId, k.Mode) }}),
+		payments:      newTable("payments", func(p *model.Payment) *model.Base { return &p.Base }, clonePayment),
+		paymentStates: newTable("payment_states", func(s *model.PaymentState) *model.Base { return &s.Base }, clonePaymentState),
+		invitations: newTable("invitations", func(i *model.Invitation) *model.Base { return &i.Base }, cloneInvitation,
+			unique[model.Invitation]{name: "idx_invitations_token_hash", withDeleted: true, key: func(i *model.Invitation) string { return i.TokenHash }}),
+		refreshTokens: newTable("refresh_tokens", func(t *model.RefreshToken) *model.Base { return &t.Base }, cloneRefreshToken,
+			unique[model.RefreshToken]{name: "idx_refresh_tokens_token_hash", withDeleted: true, key: func(t *model.RefreshToken) string { return t.TokenHash }}),
+	}
+}
+
+// transaction restores all tables if fn fails, like the default transaction of gorm
+func (db *DB) transaction(fn func(now time.Time) error) error {
+	unlock := db.lock()
+	defer unlock()
+
+	restore := db.snapshot()
+	err := fn(time.Now())
+	if err != nil {
+		restore()
+	}
+	return err
+}
+
+func (db *DB) read(fn func()) {
+	unlock := db.lock()
+	defer unlock()
+	fn()
+}
+
+// withTx runs fn with a DB which is already locked, the repositories of fn must use it instead of db
+func (db *DB) withTx(fn func(tx *DB) error) error {
+	unlock := db.lock()
+	defer unlock()
+
+	tx := *db
+	tx.inTx = true
+	restore := db.snapshot()
+	err := fn(&tx)
+	if err != nil {
+		restore()
+	}
+	return err
+}
+
+// lock does nothing inside a unit of work, the lock is held by withTx
+func (db *DB) lock() func() {
+	if db.inTx {
+		return func() {}
+	}
+	db.mu.Lock()
+	return db.mu.Unlock
+}
+
+func (db *DB) snapshot() func() {
+	restores := []func(){
+		db.merchants.snapshot(), db.emailVerifications.snapshot(), db.memberships.snapshot(),
+		db.passwordResets.snapshot(), db.recoveryCodes.snapshot(), db.wallets.snapshot(),
+		db.apiKeys.snapshot(), db.payments.snapshot(), db.paymentStates.snapshot(),
+		db.invitations.snapshot(), db.refreshTokens.snapshot(),
+	}
+	return func() {
+		for _, restore := range restores {
+			restore()
+		}
+	}
+}
+
+// unique is a unique index. Indexes with a deleted_at IS NULL condition ignore soft deleted rows.
+type unique[T any] struct {
+	name        string
+	withDeleted bool
+	key         func(row *T) string
+}
+
+// table stores copies of the rows, the callers never get a reference to a stored row
+type table[T any] struct {
+	name    string
+	rows    []T
+	base    func(row *T) *model.Base
+	clone   func(row T) T
+	uniques []unique[T]
+}
+
+func newTable[T any](name string, base func(row *T) *model.Base, clone func(row T) T, uniques ...unique[T]) *table[T] {
+	return &table[T]{name: name, base: base, clone: clone, uniques: uniques}
+}
+
+func (t *table[T]) snapshot() func() {
+	rows := make([]T, len(t.rows))
+	copy(rows, t.rows)
+	return func() { t.rows = rows }
+}
+
+// find returns copies of the rows which are not soft deleted, in insertion order
+func (t *table[T]) find(match func(row *T) bool) []T {
+	var rows []T
+	for i := range t.rows {
+		if t.base(&t.rows[i]).DeletedAt.Valid || !match(&t.rows[i]) {
+			continue
+		}
+		rows = append(rows, t.clone(t.rows[i]))
+	}
+	return rows
+}
+
+func (t *table[T]) first(match func(row *T) bool) (*T, error) {
+	rows := t.find(match)
+	if len(rows) == 0 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &rows[0], nil
+}
+
+func (t *table[T]) exists(id uuid.UUID) bool {
+	for i := range t.rows {
+		if t.base(&t.rows[i]).ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// insert sets the id and the timestamps of the row like the database defaults and gorm do
+func (t *table[T]) insert(row *T, now time.Time) error {
+	base := t.base(row)
+	if base.ID == uuid.Nil {
+		base.ID = uuid.New()
+	}
+	if base.CreatedAt.IsZero() {
+		base.CreatedAt = now
+	}
+	if base.UpdatedAt.IsZero() {
+		base.UpdatedAt = now
+	}
+	if t.exists(base.ID) {
+		return fmt.Errorf("duplicate key value violates unique constraint %q ", t.name+"_pkey")
+	}
+	err := t.checkUnique(row, -1)
+	if err != nil {
+		return err
+	}
+	t.rows = append(t.rows, t.clone(*row))
+	return nil
+}
+
+// save updates all columns of an existing row or inserts it, soft deleted rows are updated as well
+func (t *table[T]) save(row *T, now time.Time) error {
+	base := t.base(row)
+	for i := range t.rows {
+		if t.base(&t.rows[i]).ID != base.ID || base.ID == uuid.Nil {
+			continue
+		}
+		base.UpdatedAt = now
+		err := t.checkUnique(row, i)
+		if err != nil {
+			return err
+		}
+		t.rows[i] = t.clone(*row)
+		return nil
+	}
+	base.UpdatedAt = now
+	return t.insert(row, now)
+}
+
+// insertOrKeep inserts an association. Existing rows are only moved to the new owner,
+// like the ON CONFLICT clause used by gorm when saving associations.
+func (t *table[T]) insertOrKeep(row *T, now time.Time, setOwner func(row *T)) error {
+	base := t.base(row)
+	for i := range t.rows {
+		if base.ID != uuid.Nil && t.base(&t.rows[i]).ID == base.ID {
+			setOwner(&t.rows[i])
+			return nil
+		}
+	}
+	return t.insert(row, now)
+}
+
+// update changes the rows which are not soft deleted and returns the number of changed rows
+func (t *table[T]) update(match func(row *T) bool, change func(row *T)) int {
+	updated := 0
+	for i := range t.rows {
+		if t.base(&t.rows[i]).DeletedAt.Valid || !match(&t.rows[i]) {
+			continue
+		}
+		change(&t.rows[i])
+		updated++
+	}
+	return updated
+}
+
+// delete removes the rows permanently, soft deleted ones as well, like an unscoped delete of gorm
+func (t *table[T]) delete(match func(row *T) bool) int {
+	rows := t.rows[:0:0]
+	for i := range t.rows {
+		if !match(&t.rows[i]) {
+			rows = append(rows, t.rows[i])
+		}
+	}
+	deleted := len(t.rows) - len(rows)
+	t.rows = rows
+	return deleted
+}
+
+func (t *table[T]) softDelete(match func(row *T) bool, now time.Time) int {
+	return t.update(match, func(row *T) {
+		t.base(row).DeletedAt = gorm.DeletedAt{Time: now, Valid: true}
+	})
+}
+
+func (t *table[T]) checkUnique(row *T, self int) error {
+	for _, u := range t.uniques {
+		if !u.withDeleted && t.base(row).DeletedAt.Valid {
+			continue
+		}
+		key := u.key(row)
+		for i := range t.rows {
+			if i == self || (!u.withDeleted && t.base(&t.rows[i]).DeletedAt.Valid) {
+				continue
+			}
+			if u.key(&t.rows[i]) == key {
+				return fmt.Errorf("duplicate key value violates unique constraint %q ", u.name)
+			}
+		}
+	}
+	return nil
+}
+
+func foreignKeyViolation(constraint string) error {
+	return fmt.Errorf("insert or update violates foreign key constraint %q ", constraint)
+}
+
+// sortPaymentStates orders the states by created_at DESC like the preload of the postgres repository
+func sortPaymentStates(states []model.PaymentState) {
+	sort.SliceStable(states, func(i, j int) bool {
+		return states[i].CreatedAt.After(states[j].CreatedAt)
+	})
+}
+
+func identity[T any](row T) T {
+	return row
+}
+
+func cloneTime(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+	c := *t
+	return &c
+}
+
+func cloneBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte{}, b...)
+}
+
+func cloneBigInt(i *model.BigInt) *model.BigInt {
+	if i == nil {
+		return nil
+	}
+	return model.NewBigInt(&i.Int)
+}
+
+// cloneMerchant copies the columns of the merchant, the associations are stored in their own tables
+func cloneMerchant(m model.Merchant) model.Merchant {
+	return model.Merchant{
+		Base:         m.Base,
+		FirstName:    m.FirstName,
+		LastName:     m.LastName,
+		Email:        m.Email,
+		Password:     m.Password,
+		Salt:         cloneBytes(m.Salt),
+		IsActive:     m.IsActive,
+		TokenVersion: m.TokenVersion,
+		FailedLogins: m.FailedLogins,
+		LockedUntil:  cloneTime(m.LockedUntil),
+		Branding:     m.Branding,
+		TwoFactor:    m.TwoFactor,
+	}
+}
+
+func clonePasswordReset(r model.PasswordReset) model.PasswordReset {
+	r.UsedAt = cloneTime(r.UsedAt)
+	return r
+}
+
+func cloneRecoveryCode(c model.RecoveryCode) model.RecoveryCode {
+	c.UsedAt = cloneTime(c.UsedAt)
+	return c
+}
+
+func cloneInvitation(i model.Invitation) model.Invitation {
+	i.AcceptedAt = cloneTime(i.AcceptedAt)
+	return i
+}
+
+func cloneRefreshToken(t model.RefreshToken) model.RefreshToken {
+	t.RevokedAt = cloneTime(t.RevokedAt)
+	return t
+}
+
+func cloneApiKey(k model.ApiKey) model.ApiKey {
+	k.SecretSalt = cloneBytes(k.SecretSalt)
+	return k
+}
+
+// clonePayment copies the columns of the payment, the states and the wallet are stored in their own tables
+func clonePayment(p model.Payment) model.Payment {
+	p.Wallet = nil
+	p.PaymentStates = nil
+	if p.WalletId != nil {
+		walletId := *p.WalletId
+		p.WalletId = &walletId
+	}
+	return p
+}
+
+func clonePaymentState(s model.PaymentState) model.PaymentState {
+	s.PayAmount = cloneBigInt(s.PayAmount)
+	s.ActuallyPaid = cloneBigInt(s.ActuallyPaid)
+	return s
+}
@@ -0,0 +1,19 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/internal/repository/memory"
+	"github.com/CHainGate/backend/internal/repository/repositorytest"
+)
+
+func TestContract(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repository.Repositories {
+		return memory.NewRepositories(memory.NewDB())
+	}, func(t *testing.T) (repository.IUnitOfWork, repository.Repositories) {
+		db := memory.NewDB()
+		unitOfWork, _ := memory.NewUnitOfWork(db)
+		return unitOfWork, memory.NewRepositories(db)
+	})
+}
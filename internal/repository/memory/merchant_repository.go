@@ -0,0 +1,240 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/google/uuid"
+)
+
+type merchantRepository struct {
+	DB *DB
+}
+
+func NewMerchantRepository(db *DB) (repository.IMerchantRepository, error) {
+	return &merchantRepository{db}, nil
+}
+
+func (r *merchantRepository) FindById(_ context.Context, id uuid.UUID) (*model.Merchant, error) {
+	return r.findMerchant(func(m *model.Merchant) bool { return m.ID == id })
+}
+
+func (r *merchantRepository) FindByEmail(_ context.Context, email string) (*model.Merchant, error) {
+	return r.findMerchant(func(m *model.Merchant) bool { return m.Email == email })
+}
+
+// FindAll returns the merchants without associations, the oldest first
+func (r *merchantRepository) FindAll(_ context.Context) ([]model.Merchant, error) {
+	var merchants []model.Merchant
+	r.DB.read(func() {
+		merchants = r.DB.merchants.find(func(m *model.Merchant) bool { return true })
+	})
+	return merchants, nil
+}
+
+// findMerchant preloads the email verification and the wallets
+func (r *merchantRepository) findMerchant(match func(m *model.Merchant) bool) (*model.Merchant, error) {
+	var merchant *model.Merchant
+	var err error
+	r.DB.read(func() {
+		merchant, err = r.DB.merchants.first(match)
+		if err != nil {
+			return
+		}
+		verification, findErr := r.DB.emailVerifications.first(func(v *model.EmailVerification) bool { return v.MerchantId == merchant.ID })
+		if findErr == nil {
+			merchant.EmailVerification = *verification
+		}
+		merchant.Wallets = r.DB.wallets.find(func(w *model.Wallet) bool { return w.MerchantId == merchant.ID })
+	})
+	if err != nil {
+		return nil, err
+	}
+	return merchant, nil
+}
+
+func (r *merchantRepository) Create(_ context.Context, merchant *model.Merchant) error {
+	return r.DB.transaction(func(now time.Time) error {
+		err := r.DB.merchants.insert(merchant, now)
+		if err != nil {
+			return err
+		}
+		return r.saveAssociations(merchant, now)
+	})
+}
+
+func (r *merchantRepository) UpdateEmailVerification(_ context.Context, verification *model.EmailVerification) error {
+	return r.DB.transaction(func(now time.Time) error {
+		if !r.DB.merchants.exists(verification.MerchantId) {
+			return foreignKeyViolation("fk_merchants_email_verification")
+		}
+		return r.DB.emailVerifications.save(verification, now)
+	})
+}
+
+// Update saves all columns of the merchant. New associations are created, existing ones are not changed.
+func (r *merchantRepository) Update(_ context.Context, merchant *model.Merchant) error {
+	return r.DB.transaction(func(now time.Time) error {
+		err := r.DB.merchants.save(merchant, now)
+		if err != nil {
+			return err
+		}
+		return r.saveAssociations(merchant, now)
+	})
+}
+
+func (r *merchantRepository) saveAssociations(merchant *model.Merchant, now time.Time) error {
+	if merchant.EmailVerification != (model.EmailVerification{}) {
+		merchant.EmailVerification.MerchantId = merchant.ID
+		err := r.DB.emailVerifications.insertOrKeep(&merchant.EmailVerification, now, func(v *model.EmailVerification) { v.MerchantId = merchant.ID })
+		if err != nil {
+			return err
+		}
+	}
+	if merchant.Membership != nil {
+		merchant.Membership.MerchantId = merchant.ID
+		err := r.DB.memberships.insertOrKeep(merchant.Membership, now, func(m *model.Membership) { m.MerchantId = merchant.ID })
+		if err != nil {
+			return err
+		}
+	}
+	for i := range merchant.PasswordResets {
+		merchant.PasswordResets[i].MerchantId = merchant.ID
+		err := r.DB.passwordResets.insertOrKeep(&merchant.PasswordResets[i], now, func(p *model.PasswordReset) { p.MerchantId = merchant.ID })
+		if err != nil {
+			return err
+		}
+	}
+	for i := range merchant.RecoveryCodes {
+		merchant.RecoveryCodes[i].MerchantId = merchant.ID
+		err := r.DB.recoveryCodes.insertOrKeep(&merchant.RecoveryCodes[i], now, func(c *model.RecoveryCode) { c.MerchantId = merchant.ID })
+		if err != nil {
+			return err
+		}
+	}
+	for i := range merchant.Wallets {
+		merchant.Wallets[i].MerchantId = merchant.ID
+		err := r.DB.wallets.insertOrKeep(&merchant.Wallets[i], now, func(w *model.Wallet) { w.MerchantId = merchant.ID })
+		if err != nil {
+			return err
+		}
+	}
+	for i := range merchant.ApiKeys {
+		merchant.ApiKeys[i].MerchantId = merchant.ID
+		err := r.DB.apiKeys.insertOrKeep(&merchant.ApiKeys[i], now, func(k *model.ApiKey) { k.MerchantId = merchant.ID })
+		if err != nil {
+			return err
+		}
+	}
+	for i := range merchant.Payments {
+		merchant.Payments[i].MerchantId = merchant.ID
+		err := savePayment(r.DB, &merchant.Payments[i], now, false)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *merchantRepository) DeleteWalletById(_ context.Context, merchantId uuid.UUID, id string) error {
+	walletId, err := uuid.Parse(id)
+	if err != nil {
+		return err
+	}
+	return r.DB.transaction(func(now time.Time) error {
+		r.DB.wallets.softDelete(func(w *model.Wallet) bool { return w.ID == walletId && w.MerchantId == merchantId }, now)
+		return nil
+	})
+}
+
+func (r *merchantRepository) FindPasswordResetByTokenHash(_ context.Context, tokenHash string) (*model.PasswordReset, error) {
+	var reset *model.PasswordReset
+	var err error
+	r.DB.read(func() {
+		reset, err = r.DB.passwordResets.first(func(p *model.PasswordReset) bool { return p.TokenHash == tokenHash })
+	})
+	return reset, err
+}
+
+// MarkPasswordResetUsed returns false if the reset was already used by a concurrent request
+func (r *merchantRepository) MarkPasswordResetUsed(_ context.Context, id uuid.UUID) (bool, error) {
+	updated := 0
+	err := r.DB.transaction(func(now time.Time) error {
+		updated = r.DB.passwordResets.update(
+			func(p *model.PasswordReset) bool { return p.ID == id && p.UsedAt == nil },
+			func(p *model.PasswordReset) { p.UsedAt = &now; p.UpdatedAt = now },
+		)
+		return nil
+	})
+	return updated == 1, err
+}
+
+// MarkRecoveryCodeUsed returns false if the code does not exist or was already used
+func (r *merchantRepository) MarkRecoveryCodeUsed(_ context.Context, merchantId uuid.UUID, codeHash string) (bool, error) {
+	updated := 0
+	err := r.DB.transaction(func(now time.Time) error {
+		updated = r.DB.recoveryCodes.update(
+			func(c *model.RecoveryCode) bool {
+				return c.MerchantId == merchantId && c.CodeHash == codeHash && c.UsedAt == nil
+			},
+			func(c *model.RecoveryCode) { c.UsedAt = &now; c.UpdatedAt = now },
+		)
+		return nil
+	})
+	return updated == 1, err
+}
+
+// MarkTotpStepUsed returns false if the step or a later one was already used, e.g. by a concurrent request
+func (r *merchantRepository) MarkTotpStepUsed(_ context.Context, id uuid.UUID, step int64) (bool, error) {
+	updated := 0
+	err := r.DB.transaction(func(now time.Time) error {
+		updated = r.DB.merchants.update(
+			func(m *model.Merchant) bool { return m.ID == id && m.TwoFactor.LastUsedStep < step },
+			func(m *model.Merchant) { m.TwoFactor.LastUsedStep = step; m.UpdatedAt = now },
+		)
+		return nil
+	})
+	return updated == 1, err
+}
+
+func (r *merchantRepository) DeleteRecoveryCodes(_ context.Context, merchantId uuid.UUID) error {
+	return r.DB.transaction(func(now time.Time) error {
+		r.DB.recoveryCodes.softDelete(func(c *model.RecoveryCode) bool { return c.MerchantId == merchantId }, now)
+		return nil
+	})
+}
+
+// RecordFailedLogin increments the failed logins atomically and returns the new count
+func (r *merchantRepository) RecordFailedLogin(_ context.Context, id uuid.UUID) (int, error) {
+	failedLogins := 0
+	err := r.DB.transaction(func(now time.Time) error {
+		r.DB.merchants.update(
+			func(m *model.Merchant) bool { return m.ID == id },
+			func(m *model.Merchant) { m.FailedLogins++; m.UpdatedAt = now; failedLogins = m.FailedLogins },
+		)
+		return nil
+	})
+	return failedLogins, err
+}
+
+func (r *merchantRepository) LockMerchant(_ context.Context, id uuid.UUID, until time.Time) error {
+	return r.DB.transaction(func(now time.Time) error {
+		r.DB.merchants.update(
+			func(m *model.Merchant) bool { return m.ID == id },
+			func(m *model.Merchant) { m.FailedLogins = 0; m.LockedUntil = &until; m.UpdatedAt = now },
+		)
+		return nil
+	})
+}
+
+func (r *merchantRepository) ResetFailedLogins(_ context.Context, id uuid.UUID) error {
+	return r.DB.transaction(func(now time.Time) error {
+		r.DB.merchants.update(
+			func(m *model.Merchant) bool { return m.ID == id },
+			func(m *model.Merchant) { m.FailedLogins = 0; m.LockedUntil = nil; m.UpdatedAt = now },
+		)
+		return nil
+	})
+}
@@ -0,0 +1,153 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+)
+
+type paymentRepository struct {
+	DB *DB
+}
+
+func NewPaymentRepository(db *DB) (repository.IPaymentRepository, error) {
+	return &paymentRepository{db}, nil
+}
+
+func (r *paymentRepository) FindByPaymentId(_ context.Context, paymentId uuid.UUID) (*model.Payment, error) {
+	return r.findPayment(func(p *model.Payment) bool { return p.ID == paymentId })
+}
+
+// FindByPaymentIdForUpdate is the same as FindByPaymentId, every call is serialized anyway
+func (r *paymentRepository) FindByPaymentIdForUpdate(ctx context.Context, paymentId uuid.UUID) (*model.Payment, error) {
+	return r.FindByPaymentId(ctx, paymentId)
+}
+
+// FindByMerchantIdAndMode returns the payments ordered by updated_at DESC
+func (r *paymentRepository) FindByMerchantIdAndMode(_ context.Context, merchantId uuid.UUID, mode enum.Mode) ([]model.Payment, error) {
+	var payments []model.Payment
+	r.DB.read(func() {
+		payments = r.DB.payments.find(func(p *model.Payment) bool { return p.MerchantId == merchantId && p.Mode == mode })
+		for i := range payments {
+			payments[i].PaymentStates = findPaymentStates(r.DB, payments[i].ID)
+		}
+	})
+	sort.SliceStable(payments, func(i, j int) bool {
+		return payments[i].UpdatedAt.After(payments[j].UpdatedAt)
+	})
+	return payments, nil
+}
+
+func (r *paymentRepository) FindByBlockchainIdAndCurrency(_ context.Context, id string, currency enum.CryptoCurrency) (*model.Payment, error) {
+	blockchainPaymentId, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	return r.findPayment(func(p *model.Payment) bool {
+		return p.BlockchainPaymentId == blockchainPaymentId && p.PayCurrency == currency
+	})
+}
+
+// FindByBlockchainIdAndCurrencyForUpdate is the same as FindByBlockchainIdAndCurrency, every call is serialized anyway
+func (r *paymentRepository) FindByBlockchainIdAndCurrencyForUpdate(ctx context.Context, id string, currency enum.CryptoCurrency) (*model.Payment, error) {
+	return r.FindByBlockchainIdAndCurrency(ctx, id, currency)
+}
+
+// FindByPreviousBlockchainId finds a payment by a blockchain payment which was replaced after a currency change
+func (r *paymentRepository) FindByPreviousBlockchainId(_ context.Context, id string) (*model.Payment, error) {
+	blockchainPaymentId, err := uuid.Parse(id)
+	if err != nil {
+		return nil, err
+	}
+	var payment *model.Payment
+	r.DB.read(func() {
+		paymentIds := map[uuid.UUID]bool{}
+		for _, state := range r.DB.paymentStates.find(func(s *model.PaymentState) bool { return s.BlockchainPaymentId == blockchainPaymentId }) {
+			paymentIds[state.PaymentId] = true
+		}
+		payment, err = r.DB.payments.first(func(p *model.Payment) bool {
+			return paymentIds[p.ID] && p.BlockchainPaymentId != blockchainPaymentId
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+// Update saves all columns of the payment. New states are created, existing ones are not changed.
+func (r *paymentRepository) Update(_ context.Context, payment *model.Payment) error {
+	return r.DB.transaction(func(now time.Time) error {
+		return savePayment(r.DB, payment, now, true)
+	})
+}
+
+func (r *paymentRepository) Create(_ context.Context, payment *model.Payment) error {
+	return r.DB.transaction(func(now time.Time) error {
+		return savePayment(r.DB, payment, now, false)
+	})
+}
+
+// findPayment preloads the states ordered by created_at DESC
+func (r *paymentRepository) findPayment(match func(p *model.Payment) bool) (*model.Payment, error) {
+	var payment *model.Payment
+	var err error
+	r.DB.read(func() {
+		payment, err = r.DB.payments.first(match)
+		if err == nil {
+			payment.PaymentStates = findPaymentStates(r.DB, payment.ID)
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return payment, nil
+}
+
+func findPaymentStates(db *DB, paymentId uuid.UUID) []model.PaymentState {
+	states := db.paymentStates.find(func(s *model.PaymentState) bool { return s.PaymentId == paymentId })
+	sortPaymentStates(states)
+	return states
+}
+
+// savePayment creates the wallet first, the payment refers to it
+func savePayment(db *DB, payment *model.Payment, now time.Time, update bool) error {
+	if !db.merchants.exists(payment.MerchantId) {
+		return foreignKeyViolation("fk_merchants_payments")
+	}
+	if payment.Wallet != nil {
+		err := db.wallets.insertOrKeep(payment.Wallet, now, func(*model.Wallet) {})
+		if err != nil {
+			return err
+		}
+		walletId := payment.Wallet.ID
+		payment.WalletId = &walletId
+	}
+	if payment.WalletId != nil && !db.wallets.exists(*payment.WalletId) {
+		return foreignKeyViolation("fk_payments_wallet")
+	}
+
+	var err error
+	if update {
+		err = db.payments.save(payment, now)
+	} else {
+		err = db.payments.insert(payment, now)
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := range payment.PaymentStates {
+		payment.PaymentStates[i].PaymentId = payment.ID
+		err = db.paymentStates.insertOrKeep(&payment.PaymentStates[i], now, func(s *model.PaymentState) { s.PaymentId = payment.ID })
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
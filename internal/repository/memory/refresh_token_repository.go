@@ -0,0 +1,62 @@
+package memory
+
+import (
+	"context"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/google/uuid"
+)
+
+type refreshTokenRepository struct {
+	DB *DB
+}
+
+func NewRefreshTokenRepository(db *DB) (repository.IRefreshTokenRepository, error) {
+	return &refreshTokenRepository{db}, nil
+}
+
+func (r *refreshTokenRepository) Create(_ context.Context, refreshToken *model.RefreshToken) error {
+	return r.DB.transaction(func(now time.Time) error {
+		return r.DB.refreshTokens.insert(refreshToken, now)
+	})
+}
+
+func (r *refreshTokenRepository) FindByTokenHash(_ context.Context, tokenHash string) (*model.RefreshToken, error) {
+	var refreshToken *model.RefreshToken
+	var err error
+	r.DB.read(func() {
+		refreshToken, err = r.DB.refreshTokens.first(func(t *model.RefreshToken) bool { return t.TokenHash == tokenHash })
+	})
+	return refreshToken, err
+}
+
+// Revoke returns false if the token was already revoked, e.g. by a concurrent refresh
+func (r *refreshTokenRepository) Revoke(_ context.Context, id uuid.UUID) (bool, error) {
+	revoked, err := r.revoke(func(t *model.RefreshToken) bool { return t.ID == id })
+	return revoked == 1, err
+}
+
+func (r *refreshTokenRepository) RevokeFamily(_ context.Context, familyId uuid.UUID) error {
+	_, err := r.revoke(func(t *model.RefreshToken) bool { return t.FamilyId == familyId })
+	return err
+}
+
+func (r *refreshTokenRepository) RevokeAllByMerchantId(_ context.Context, merchantId uuid.UUID) error {
+	_, err := r.revoke(func(t *model.RefreshToken) bool { return t.MerchantId == merchantId })
+	return err
+}
+
+// revoke sets revoked_at of the matching tokens which are not revoked yet
+func (r *refreshTokenRepository) revoke(match func(t *model.RefreshToken) bool) (int, error) {
+	revoked := 0
+	err := r.DB.transaction(func(now time.Time) error {
+		revoked = r.DB.refreshTokens.update(
+			func(t *model.RefreshToken) bool { return t.RevokedAt == nil && match(t) },
+			func(t *model.RefreshToken) { t.RevokedAt = &now; t.UpdatedAt = now },
+		)
+		return nil
+	})
+	return revoked, err
+}
@@ -0,0 +1,97 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+)
+
+type teamRepository struct {
+	DB *DB
+}
+
+func NewTeamRepository(db *DB) (repository.ITeamRepository, error) {
+	return &teamRepository{db}, nil
+}
+
+func (r *teamRepository) FindMembershipByMerchantId(_ context.Context, merchantId uuid.UUID) (*model.Membership, error) {
+	var membership *model.Membership
+	var err error
+	r.DB.read(func() {
+		membership, err = r.DB.memberships.first(func(m *model.Membership) bool { return m.MerchantId == merchantId })
+	})
+	return membership, err
+}
+
+// FindMembershipsByOrganizationId returns the memberships ordered by created_at
+func (r *teamRepository) FindMembershipsByOrganizationId(_ context.Context, organizationId uuid.UUID) ([]model.Membership, error) {
+	var memberships []model.Membership
+	r.DB.read(func() {
+		memberships = r.DB.memberships.find(func(m *model.Membership) bool { return m.OrganizationId == organizationId })
+	})
+	sort.SliceStable(memberships, func(i, j int) bool {
+		return memberships[i].CreatedAt.Before(memberships[j].CreatedAt)
+	})
+	return memberships, nil
+}
+
+// UpdateMembershipRole returns false if the merchant is not a member of the organization
+func (r *teamRepository) UpdateMembershipRole(_ context.Context, organizationId uuid.UUID, merchantId uuid.UUID, role enum.Role) (bool, error) {
+	updated := 0
+	err := r.DB.transaction(func(now time.Time) error {
+		updated = r.DB.memberships.update(
+			func(m *model.Membership) bool {
+				return m.OrganizationId == organizationId && m.MerchantId == merchantId
+			},
+			func(m *model.Membership) { m.Role = role; m.UpdatedAt = now },
+		)
+		return nil
+	})
+	return updated == 1, err
+}
+
+// DeleteMembership returns false if the merchant is not a member of the organization.
+// The membership is deleted permanently, so the merchant can be added to a team again.
+func (r *teamRepository) DeleteMembership(_ context.Context, organizationId uuid.UUID, merchantId uuid.UUID) (bool, error) {
+	deleted := 0
+	err := r.DB.transaction(func(time.Time) error {
+		deleted = r.DB.memberships.delete(func(m *model.Membership) bool {
+			return m.OrganizationId == organizationId && m.MerchantId == merchantId
+		})
+		return nil
+	})
+	return deleted == 1, err
+}
+
+func (r *teamRepository) CreateInvitation(_ context.Context, invitation *model.Invitation) error {
+	return r.DB.transaction(func(now time.Time) error {
+		return r.DB.invitations.insert(invitation, now)
+	})
+}
+
+func (r *teamRepository) FindInvitationByTokenHash(_ context.Context, tokenHash string) (*model.Invitation, error) {
+	var invitation *model.Invitation
+	var err error
+	r.DB.read(func() {
+		invitation, err = r.DB.invitations.first(func(i *model.Invitation) bool { return i.TokenHash == tokenHash })
+	})
+	return invitation, err
+}
+
+// MarkInvitationAccepted returns false if the invitation was already accepted
+func (r *teamRepository) MarkInvitationAccepted(_ context.Context, id uuid.UUID) (bool, error) {
+	updated := 0
+	err := r.DB.transaction(func(now time.Time) error {
+		updated = r.DB.invitations.update(
+			func(i *model.Invitation) bool { return i.ID == id && i.AcceptedAt == nil },
+			func(i *model.Invitation) { i.AcceptedAt = &now; i.UpdatedAt = now },
+		)
+		return nil
+	})
+	return updated == 1, err
+}
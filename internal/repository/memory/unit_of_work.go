@@ -0,0 +1,33 @@
+package memory
+
+import (
+	"context"
+
+	"github.com/CHainGate/backend/internal/repository"
+)
+
+type unitOfWork struct {
+	DB *DB
+}
+
+func NewUnitOfWork(db *DB) (repository.IUnitOfWork, error) {
+	return &unitOfWork{db}, nil
+}
+
+// WithTx holds the lock of the database until fn returns, the ...ForUpdate methods need no lock of their own
+func (u *unitOfWork) WithTx(_ context.Context, fn func(repos repository.Repositories) error) error {
+	return u.DB.withTx(func(tx *DB) error {
+		return fn(NewRepositories(tx))
+	})
+}
+
+// NewRepositories returns all repositories of the database
+func NewRepositories(db *DB) repository.Repositories {
+	return repository.Repositories{
+		Merchant:     &merchantRepository{db},
+		ApiKey:       &apiKeyRepository{db},
+		Payment:      &paymentRepository{db},
+		Team:         &teamRepository{db},
+		RefreshToken: &refreshTokenRepository{db},
+	}
+}
@@ -1,9 +1,13 @@
 package repository
 
 import (
+	"context"
+	"time"
+
 	"github.com/CHainGate/backend/internal/model"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type merchantRepository struct {
@@ -11,20 +15,30 @@ type merchantRepository struct {
 }
 
 type IMerchantRepository interface {
-	FindById(id uuid.UUID) (*model.Merchant, error)
-	FindByEmail(email string) (*model.Merchant, error)
-	Create(merchant *model.Merchant) error
-	Update(merchant *model.Merchant) error
-	DeleteWalletById(id string) error
+	FindById(ctx context.Context, id uuid.UUID) (*model.Merchant, error)
+	FindByEmail(ctx context.Context, email string) (*model.Merchant, error)
+	FindAll(ctx context.Context) ([]model.Merchant, error)
+	Create(ctx context.Context, merchant *model.Merchant) error
+	UpdateEmailVerification(ctx context.Context, verification *model.EmailVerification) error
+	Update(ctx context.Context, merchant *model.Merchant) error
+	DeleteWalletById(ctx context.Context, merchantId uuid.UUID, id string) error
+	FindPasswordResetByTokenHash(ctx context.Context, tokenHash string) (*model.PasswordReset, error)
+	MarkPasswordResetUsed(ctx context.Context, id uuid.UUID) (bool, error)
+	MarkRecoveryCodeUsed(ctx context.Context, merchantId uuid.UUID, codeHash string) (bool, error)
+	MarkTotpStepUsed(ctx context.Context, id uuid.UUID, step int64) (bool, error)
+	DeleteRecoveryCodes(ctx context.Context, merchantId uuid.UUID) error
+	RecordFailedLogin(ctx context.Context, id uuid.UUID) (int, error)
+	LockMerchant(ctx context.Context, id uuid.UUID, until time.Time) error
+	ResetFailedLogins(ctx context.Context, id uuid.UUID) error
 }
 
 func NewMerchantRepository(db *gorm.DB) (IMerchantRepository, error) {
 	return &merchantRepository{db}, nil
 }
 
-func (r *merchantRepository) FindById(id uuid.UUID) (*model.Merchant, error) {
+func (r *merchantRepository) FindById(ctx context.Context, id uuid.UUID) (*model.Merchant, error) {
 	var merchant model.Merchant
-	result := r.DB.
+	result := r.DB.WithContext(ctx).
 		Preload("EmailVerification").
 		Preload("Wallets").
 		Where("id = ?", id).
@@ -35,33 +49,134 @@ func (r *merchantRepository) FindById(id uuid.UUID) (*model.Merchant, error) {
 	return &merchant, nil
 }
 
-func (r *merchantRepository) FindByEmail(email string) (*model.Merchant, error) {
+func (r *merchantRepository) FindByEmail(ctx context.Context, email string) (*model.Merchant, error) {
 	var merchant model.Merchant
-	result := r.DB.Preload("EmailVerification").Preload("Wallets").Where("email = ?", email).First(&merchant)
+	result := r.DB.WithContext(ctx).Preload("EmailVerification").Preload("Wallets").Where("email = ?", email).First(&merchant)
 	if result.Error != nil {
 		return nil, result.Error
 	}
 	return &merchant, nil
 }
 
-func (r *merchantRepository) Create(merchant *model.Merchant) error {
-	result := r.DB.Create(&merchant)
+// FindAll returns the merchants without associations, the oldest first
+func (r *merchantRepository) FindAll(ctx context.Context) ([]model.Merchant, error) {
+	var merchants []model.Merchant
+	result := r.DB.WithContext(ctx).Order("created_at, id").Find(&merchants)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return merchants, nil
+}
+
+func (r *merchantRepository) Create(ctx context.Context, merchant *model.Merchant) error {
+	result := r.DB.WithContext(ctx).Create(&merchant)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (r *merchantRepository) UpdateEmailVerification(ctx context.Context, verification *model.EmailVerification) error {
+	result := r.DB.WithContext(ctx).Save(&verification)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (r *merchantRepository) Update(ctx context.Context, merchant *model.Merchant) error {
+	result := r.DB.WithContext(ctx).Save(&merchant)
 	if result.Error != nil {
 		return result.Error
 	}
 	return nil
 }
 
-func (r *merchantRepository) Update(merchant *model.Merchant) error {
-	result := r.DB.Save(&merchant)
+func (r *merchantRepository) DeleteWalletById(ctx context.Context, merchantId uuid.UUID, id string) error {
+	result := r.DB.WithContext(ctx).Where("id = ? AND merchant_id = ?", id, merchantId).Delete(&model.Wallet{})
 	if result.Error != nil {
 		return result.Error
 	}
 	return nil
 }
 
-func (r *merchantRepository) DeleteWalletById(id string) error {
-	result := r.DB.Where("id = ?", id).Delete(&model.Wallet{})
+func (r *merchantRepository) FindPasswordResetByTokenHash(ctx context.Context, tokenHash string) (*model.PasswordReset, error) {
+	var reset model.PasswordReset
+	result := r.DB.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&reset)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &reset, nil
+}
+
+// MarkPasswordResetUsed returns false if the reset was already used by a concurrent request
+func (r *merchantRepository) MarkPasswordResetUsed(ctx context.Context, id uuid.UUID) (bool, error) {
+	result := r.DB.WithContext(ctx).Model(&model.PasswordReset{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// MarkRecoveryCodeUsed returns false if the code does not exist or was already used
+func (r *merchantRepository) MarkRecoveryCodeUsed(ctx context.Context, merchantId uuid.UUID, codeHash string) (bool, error) {
+	result := r.DB.WithContext(ctx).Model(&model.RecoveryCode{}).
+		Where("merchant_id = ? AND code_hash = ? AND used_at IS NULL", merchantId, codeHash).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// MarkTotpStepUsed returns false if the step or a later one was already used, e.g. by a concurrent request
+func (r *merchantRepository) MarkTotpStepUsed(ctx context.Context, id uuid.UUID, step int64) (bool, error) {
+	result := r.DB.WithContext(ctx).Model(&model.Merchant{}).
+		Where("id = ? AND two_factor_last_used_step < ?", id, step).
+		Update("two_factor_last_used_step", step)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *merchantRepository) DeleteRecoveryCodes(ctx context.Context, merchantId uuid.UUID) error {
+	result := r.DB.WithContext(ctx).Where("merchant_id = ?", merchantId).Delete(&model.RecoveryCode{})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+// RecordFailedLogin increments the failed logins atomically and returns the new count
+func (r *merchantRepository) RecordFailedLogin(ctx context.Context, id uuid.UUID) (int, error) {
+	var merchant model.Merchant
+	result := r.DB.WithContext(ctx).Model(&merchant).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "failed_logins"}}}).
+		Where("id = ?", id).
+		Update("failed_logins", gorm.Expr("failed_logins + 1"))
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	return merchant.FailedLogins, nil
+}
+
+func (r *merchantRepository) LockMerchant(ctx context.Context, id uuid.UUID, until time.Time) error {
+	result := r.DB.WithContext(ctx).Model(&model.Merchant{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"failed_logins": 0, "locked_until": until})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (r *merchantRepository) ResetFailedLogins(ctx context.Context, id uuid.UUID) error {
+	result := r.DB.WithContext(ctx).Model(&model.Merchant{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{"failed_logins": 0, "locked_until": nil})
 	if result.Error != nil {
 		return result.Error
 	}
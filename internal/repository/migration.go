@@ -0,0 +1,292 @@
+package repository
+
+import (
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// migrationFiles has a directory of migrations per dialect, they are kept at the same versions
+//
+//go:embed migrations/*/*.sql
+var migrationFiles embed.FS
+
+// migrationLockId is the postgres advisory lock held while migrating, so replicas do not migrate concurrently
+const migrationLockId = 4242_0001
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var (
+	ErrSchemaOutdated = errors.New("database schema is outdated, run the migrate command")
+	ErrSchemaTooNew   = errors.New("database schema is newer than this binary")
+	ErrNoMigration    = errors.New("migration version does not exist")
+)
+
+// Migration is a pair of up and down sql files named <version>_<name>.up.sql and <version>_<name>.down.sql
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// SchemaMigration is a row of the version table, one per applied migration
+type SchemaMigration struct {
+	Version   int64 `gorm:"primaryKey;autoIncrement:false"`
+	Name      string
+	AppliedAt time.Time
+}
+
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+type Migrator struct {
+	db         *gorm.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *gorm.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(migrationFiles, "migrations/"+db.Dialector.Name())
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db, migrations}, nil
+}
+
+// LatestVersion is the version this binary expects
+func (m *Migrator) LatestVersion() int64 {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version of the last applied migration, 0 for an empty database
+func (m *Migrator) Version() (int64, error) {
+	err := m.createVersionTable(m.db)
+	if err != nil {
+		return 0, err
+	}
+	return currentVersion(m.db)
+}
+
+// CheckVersion fails if the database is not at the version of this binary, it does not change the database
+func (m *Migrator) CheckVersion() error {
+	return m.checkVersion(m.db)
+}
+
+func (m *Migrator) checkVersion(db *gorm.DB) error {
+	// a database without the version table was never migrated
+	if !db.Migrator().HasTable(&SchemaMigration{}) {
+		return fmt.Errorf("%w: no migrations applied", ErrSchemaOutdated)
+	}
+	version, err := currentVersion(db)
+	if err != nil {
+		return err
+	}
+	if version < m.LatestVersion() {
+		return fmt.Errorf("%w: version %d, expected %d", ErrSchemaOutdated, version, m.LatestVersion())
+	}
+	if version > m.LatestVersion() {
+		return fmt.Errorf("%w: version %d, expected %d", ErrSchemaTooNew, version, m.LatestVersion())
+	}
+	return nil
+}
+
+func (m *Migrator) Up() error {
+	return m.To(m.LatestVersion())
+}
+
+// Down reverts the last steps migrations
+func (m *Migrator) Down(steps int) error {
+	version, err := m.Version()
+	if err != nil {
+		return err
+	}
+	index := m.indexOf(version)
+	target := int64(0)
+	if index-steps >= 0 {
+		target = m.migrations[index-steps].Version
+	}
+	return m.To(target)
+}
+
+// To applies or reverts migrations until the database is at the version, 0 reverts all migrations
+func (m *Migrator) To(target int64) error {
+	if target != 0 && m.indexOf(target) < 0 {
+		return fmt.Errorf("%w: %d", ErrNoMigration, target)
+	}
+
+	return m.db.Connection(func(conn *gorm.DB) error {
+		// the connection is not a new session, without one the select of the version would be kept for the inserts
+		conn = conn.Session(&gorm.Session{})
+		// sqlite is only used by a single node, which migrates before serving
+		if conn.Dialector.Name() == "postgres" {
+			err := conn.Exec("SELECT pg_advisory_lock(?)", migrationLockId).Error
+			if err != nil {
+				return err
+			}
+			defer conn.Exec("SELECT pg_advisory_unlock(?)", migrationLockId)
+		}
+
+		err := m.createVersionTable(conn)
+		if err != nil {
+			return err
+		}
+		version, err := currentVersion(conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if migration.Version > version && migration.Version <= target {
+				err = apply(conn, migration)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		for i := len(m.migrations) - 1; i >= 0; i-- {
+			migration := m.migrations[i]
+			if migration.Version <= version && migration.Version > target {
+				err = revert(conn, migration)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	err := m.createVersionTable(m.db)
+	if err != nil {
+		return nil, err
+	}
+
+	var applied []SchemaMigration
+	err = m.db.Find(&applied).Error
+	if err != nil {
+		return nil, err
+	}
+	appliedAt := map[int64]time.Time{}
+	for _, a := range applied {
+		appliedAt[a.Version] = a.AppliedAt
+	}
+
+	status := make([]MigrationStatus, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		s := MigrationStatus{Migration: migration}
+		if t, ok := appliedAt[migration.Version]; ok {
+			s.AppliedAt = &t
+		}
+		status = append(status, s)
+	}
+	return status, nil
+}
+
+func (m *Migrator) indexOf(version int64) int {
+	for i, migration := range m.migrations {
+		if migration.Version == version {
+			return i
+		}
+	}
+	return -1
+}
+
+func (m *Migrator) createVersionTable(db *gorm.DB) error {
+	return db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version bigint PRIMARY KEY,
+		name text,
+		applied_at timestamptz
+	)`).Error
+}
+
+func currentVersion(db *gorm.DB) (int64, error) {
+	var version int64
+	err := db.Model(&SchemaMigration{}).Select("COALESCE(MAX(version), 0)").Scan(&version).Error
+	return version, err
+}
+
+// apply runs the migration and records it in one transaction
+func apply(db *gorm.DB, migration Migration) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(migration.Up).Error
+		if err != nil {
+			return fmt.Errorf("migration %d_%s up: %w", migration.Version, migration.Name, err)
+		}
+		return tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).Error
+	})
+}
+
+func revert(db *gorm.DB, migration Migration) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Exec(migration.Down).Error
+		if err != nil {
+			return fmt.Errorf("migration %d_%s down: %w", migration.Version, migration.Name, err)
+		}
+		return tx.Delete(&SchemaMigration{}, "version = ?", migration.Version).Error
+	})
+}
+
+// loadMigrations reads the migrations in dir sorted by version, every migration needs an up and a down file
+func loadMigrations(fsys fs.FS, dir string) ([]Migration, error) {
+	paths, err := fs.Glob(fsys, dir+"/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no migrations in %s", dir)
+	}
+
+	byVersion := map[int64]*Migration{}
+	for _, path := range paths {
+		name := path[len(dir)+1:]
+		match := migrationFileName.FindStringSubmatch(name)
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration version %s", name)
+		}
+		content, err := fs.ReadFile(fsys, path)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d is used by %s and %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" || migration.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs an up and a down file", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
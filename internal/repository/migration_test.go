@@ -0,0 +1,255 @@
+package repository
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var dialects = []string{"postgres", "sqlite"}
+
+func TestLoadMigrations(t *testing.T) {
+	fsys := fstest.MapFS{
+		"migrations/0002_add_column.up.sql":     {Data: []byte("ALTER TABLE a ADD COLUMN b text;")},
+		"migrations/0002_add_column.down.sql":   {Data: []byte("ALTER TABLE a DROP COLUMN b;")},
+		"migrations/0001_create_table.up.sql":   {Data: []byte("CREATE TABLE a (id uuid);")},
+		"migrations/0001_create_table.down.sql": {Data: []byte("DROP TABLE a;")},
+	}
+
+	migrations, err := loadMigrations(fsys, "migrations")
+	if err != nil {
+		t.Fatalf("loadMigrations: got error %s", err.Error())
+	}
+	if len(migrations) != 2 || migrations[0].Version != 1 || migrations[1].Version != 2 {
+		t.Fatalf("Expected migrations sorted by version, but got %+v", migrations)
+	}
+	if migrations[1].Name != "add_column" || migrations[1].Down != "ALTER TABLE a DROP COLUMN b;" {
+		t.Errorf("Unexpected migration %+v", migrations[1])
+	}
+}
+
+func TestLoadMigrationsInvalid(t *testing.T) {
+	tests := map[string]fstest.MapFS{
+		"missing down": {
+			"migrations/0001_create_table.up.sql": {Data: []byte("CREATE TABLE a (id uuid);")},
+		},
+		"invalid name": {
+			"migrations/create_table.up.sql": {Data: []byte("CREATE TABLE a (id uuid);")},
+		},
+		"duplicate version": {
+			"migrations/0001_a.up.sql":   {Data: []byte("SELECT 1;")},
+			"migrations/0001_a.down.sql": {Data: []byte("SELECT 1;")},
+			"migrations/0001_b.up.sql":   {Data: []byte("SELECT 1;")},
+			"migrations/0001_b.down.sql": {Data: []byte("SELECT 1;")},
+		},
+	}
+	for name, fsys := range tests {
+		if _, err := loadMigrations(fsys, "migrations"); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+// TestMigrationsMatchModels fails if a model field was added without a migration
+func TestMigrationsMatchModels(t *testing.T) {
+	for _, dialect := range dialects {
+		migrations, err := loadMigrations(migrationFiles, "migrations/"+dialect)
+		if err != nil {
+			t.Fatalf("loadMigrations: got error %s", err.Error())
+		}
+		var up strings.Builder
+		for _, migration := range migrations {
+			up.WriteString(migration.Up)
+		}
+		sql := up.String()
+
+		models := []interface{}{
+			&model.Merchant{}, &model.EmailVerification{}, &model.PasswordReset{}, &model.Membership{},
+			&model.Invitation{}, &model.RecoveryCode{}, &model.RefreshToken{}, &model.RateLimitBucket{},
+			&model.Wallet{}, &model.ApiKey{}, &model.Payment{}, &model.PaymentState{},
+		}
+		for _, m := range models {
+			s, err := schema.Parse(m, &sync.Map{}, schema.NamingStrategy{})
+			if err != nil {
+				t.Fatalf("schema.Parse: got error %s", err.Error())
+			}
+			table := regexp.MustCompile(`(?s)CREATE TABLE IF NOT EXISTS ` + s.Table + ` \((.*?)\n\);`).FindStringSubmatch(sql)
+			if table == nil {
+				t.Errorf("%s: no migration for table %s", dialect, s.Table)
+				continue
+			}
+			for _, field := range s.Fields {
+				if field.DBName == "" {
+					continue
+				}
+				created := regexp.MustCompile(`(?m)^\s+` + field.DBName + `\s`).MatchString(table[1])
+				added := regexp.MustCompile(`ALTER TABLE ` + s.Table + ` ADD COLUMN (IF NOT EXISTS )?` + field.DBName + `\s`).MatchString(sql)
+				if !created && !added {
+					t.Errorf("%s: no migration for column %s.%s", dialect, s.Table, field.DBName)
+				}
+			}
+		}
+	}
+}
+
+// TestMigrationsOfDialects fails if a migration was only added for one database
+func TestMigrationsOfDialects(t *testing.T) {
+	var versions []string
+	for _, dialect := range dialects {
+		migrations, err := loadMigrations(migrationFiles, "migrations/"+dialect)
+		if err != nil {
+			t.Fatalf("loadMigrations: got error %s", err.Error())
+		}
+		var names strings.Builder
+		for _, migration := range migrations {
+			names.WriteString(fmt.Sprintf("%d_%s ", migration.Version, migration.Name))
+		}
+		versions = append(versions, names.String())
+	}
+	for i := range versions {
+		if versions[i] != versions[0] {
+			t.Errorf("Expected migrations %s of %s, but got %s of %s", versions[0], dialects[0], versions[i], dialects[i])
+		}
+	}
+}
+
+// TestMigratorSqlite records the applied versions in the version table
+func TestMigratorSqlite(t *testing.T) {
+	db, err := OpenSqlite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("OpenSqlite: got error %s", err.Error())
+	}
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatalf("NewMigrator: got error %s", err.Error())
+	}
+
+	err = migrator.Up()
+	if err != nil {
+		t.Fatalf("Up: got error %s", err.Error())
+	}
+	err = migrator.CheckVersion()
+	if err != nil {
+		t.Errorf("Expected the latest version after Up, but got %s", err.Error())
+	}
+
+	err = migrator.To(0)
+	if err != nil {
+		t.Fatalf("To: got error %s", err.Error())
+	}
+	version, err := migrator.Version()
+	if err != nil || version != 0 {
+		t.Errorf("Expected version 0 after reverting all migrations, but got %d %v", version, err)
+	}
+}
+
+// the tables of the first release, created by AutoMigrate before the migrations were introduced
+type legacyMerchant struct {
+	model.Base
+	Email    string `gorm:"unique"`
+	IsActive bool
+}
+
+func (legacyMerchant) TableName() string { return "merchants" }
+
+type legacyEmailVerification struct {
+	model.Base
+	MerchantId       uuid.UUID `gorm:"type:uuid"`
+	VerificationCode uint64
+}
+
+func (legacyEmailVerification) TableName() string { return "email_verifications" }
+
+type legacyPayment struct {
+	model.Base
+	BlockchainPaymentId uuid.UUID `gorm:"type:uuid"`
+	MerchantId          uuid.UUID `gorm:"type:uuid"`
+	PayCurrency         int
+	PayAddress          string
+}
+
+func (legacyPayment) TableName() string { return "payments" }
+
+type legacyPaymentState struct {
+	model.Base
+	PaymentId    uuid.UUID `gorm:"type:uuid"`
+	PaymentState int
+}
+
+func (legacyPaymentState) TableName() string { return "payment_states" }
+
+// TestMigratorPostgresLegacySchema adopts a database created by the first release, all data in it is deleted
+func TestMigratorPostgresLegacySchema(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_DSN")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_DSN is not set")
+	}
+	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	if err != nil {
+		t.Fatalf("gorm.Open: got error %s", err.Error())
+	}
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatalf("NewMigrator: got error %s", err.Error())
+	}
+	if err = migrator.To(0); err != nil {
+		t.Fatalf("To: got error %s", err.Error())
+	}
+	// the tables of an earlier run which failed before the migrations
+	if err = db.Exec(migrator.migrations[0].Down).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Exec(`DROP TABLE schema_migrations; CREATE EXTENSION IF NOT EXISTS "uuid-ossp"`).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	err = db.AutoMigrate(&legacyMerchant{}, &legacyEmailVerification{}, &legacyPayment{}, &legacyPaymentState{})
+	if err != nil {
+		t.Fatalf("AutoMigrate: got error %s", err.Error())
+	}
+	merchant := legacyMerchant{Email: "momo@mail.com", IsActive: true}
+	payment := legacyPayment{BlockchainPaymentId: uuid.New(), PayCurrency: 1, PayAddress: "0x1"}
+	if err = db.Create(&merchant).Error; err != nil {
+		t.Fatal(err)
+	}
+	payment.MerchantId = merchant.ID
+	if err = db.Create(&payment).Error; err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Create(&legacyPaymentState{PaymentId: payment.ID, PaymentState: 1}).Error; err != nil {
+		t.Fatal(err)
+	}
+
+	if err = migrator.Up(); err != nil {
+		t.Fatalf("Up: got error %s", err.Error())
+	}
+	err = db.Model(&model.Merchant{}).Where("id = ?", merchant.ID).Update("failed_logins", gorm.Expr("failed_logins + 1")).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found model.Merchant
+	if err = db.First(&found, "id = ?", merchant.ID).Error; err != nil {
+		t.Fatalf("First: got error %s", err.Error())
+	}
+	if found.FailedLogins != 1 || found.TokenVersion != 0 || found.TwoFactor.Enabled {
+		t.Errorf("Expected the zero values in the added columns, but got %+v", found)
+	}
+	var state model.PaymentState
+	if err = db.First(&state, "payment_id = ?", payment.ID).Error; err != nil {
+		t.Fatalf("First: got error %s", err.Error())
+	}
+	if state.BlockchainPaymentId != payment.BlockchainPaymentId || state.PayAddress != "0x1" || int(state.PayCurrency) != 1 {
+		t.Errorf("Expected the state to belong to the blockchain payment of the payment, but got %+v", state)
+	}
+}
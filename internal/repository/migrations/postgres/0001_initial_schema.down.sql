@@ -0,0 +1,12 @@
+DROP TABLE IF EXISTS payment_states;
+DROP TABLE IF EXISTS payments;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS wallets;
+DROP TABLE IF EXISTS rate_limit_buckets;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS recovery_codes;
+DROP TABLE IF EXISTS invitations;
+DROP TABLE IF EXISTS memberships;
+DROP TABLE IF EXISTS password_resets;
+DROP TABLE IF EXISTS email_verifications;
+DROP TABLE IF EXISTS merchants;
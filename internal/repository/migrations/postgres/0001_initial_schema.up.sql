@@ -0,0 +1,202 @@
+-- Schema created by GORM AutoMigrate until migrations were introduced.
+-- IF NOT EXISTS lets databases created by AutoMigrate adopt this version without changes,
+-- the columns older versions of AutoMigrate did not create are added by 0002.
+CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
+
+CREATE TABLE IF NOT EXISTS merchants (
+    id                        uuid DEFAULT uuid_generate_v4(),
+    created_at                timestamptz,
+    updated_at                timestamptz,
+    deleted_at                timestamptz,
+    first_name                text,
+    last_name                 text,
+    email                     text UNIQUE,
+    password                  text,
+    salt                      bytea,
+    is_active                 boolean,
+    password_changed_at       timestamptz,
+    token_version             bigint,
+    failed_logins             bigint,
+    locked_until              timestamptz,
+    branding_logo_url         text,
+    branding_primary_color    text,
+    branding_accent_color     text,
+    two_factor_secret         text,
+    two_factor_enabled        boolean,
+    two_factor_last_used_step bigint,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_merchants_deleted_at ON merchants (deleted_at);
+
+CREATE TABLE IF NOT EXISTS email_verifications (
+    id          uuid DEFAULT uuid_generate_v4(),
+    created_at  timestamptz,
+    updated_at  timestamptz,
+    deleted_at  timestamptz,
+    merchant_id uuid,
+    token_hash  text,
+    expires_at  timestamptz,
+    sent_at     timestamptz,
+    attempts    bigint,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_merchants_email_verification FOREIGN KEY (merchant_id) REFERENCES merchants (id)
+);
+CREATE INDEX IF NOT EXISTS idx_email_verifications_deleted_at ON email_verifications (deleted_at);
+
+CREATE TABLE IF NOT EXISTS password_resets (
+    id          uuid DEFAULT uuid_generate_v4(),
+    created_at  timestamptz,
+    updated_at  timestamptz,
+    deleted_at  timestamptz,
+    merchant_id uuid,
+    token_hash  text,
+    expires_at  timestamptz,
+    used_at     timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_merchants_password_resets FOREIGN KEY (merchant_id) REFERENCES merchants (id)
+);
+CREATE INDEX IF NOT EXISTS idx_password_resets_deleted_at ON password_resets (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_password_resets_merchant_id ON password_resets (merchant_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_password_resets_token_hash ON password_resets (token_hash);
+
+CREATE TABLE IF NOT EXISTS memberships (
+    id              uuid DEFAULT uuid_generate_v4(),
+    created_at      timestamptz,
+    updated_at      timestamptz,
+    deleted_at      timestamptz,
+    organization_id uuid,
+    merchant_id     uuid,
+    role            bigint,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_merchants_membership FOREIGN KEY (merchant_id) REFERENCES merchants (id)
+);
+CREATE INDEX IF NOT EXISTS idx_memberships_deleted_at ON memberships (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_memberships_organization_id ON memberships (organization_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_memberships_merchant_id ON memberships (merchant_id);
+
+CREATE TABLE IF NOT EXISTS invitations (
+    id              uuid DEFAULT uuid_generate_v4(),
+    created_at      timestamptz,
+    updated_at      timestamptz,
+    deleted_at      timestamptz,
+    organization_id uuid,
+    email           text,
+    role            bigint,
+    token_hash      text,
+    expires_at      timestamptz,
+    accepted_at     timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_invitations_deleted_at ON invitations (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_invitations_organization_id ON invitations (organization_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invitations_token_hash ON invitations (token_hash);
+
+CREATE TABLE IF NOT EXISTS recovery_codes (
+    id          uuid DEFAULT uuid_generate_v4(),
+    created_at  timestamptz,
+    updated_at  timestamptz,
+    deleted_at  timestamptz,
+    merchant_id uuid,
+    code_hash   text,
+    used_at     timestamptz,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_merchants_recovery_codes FOREIGN KEY (merchant_id) REFERENCES merchants (id)
+);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_deleted_at ON recovery_codes (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_merchant_id ON recovery_codes (merchant_id);
+
+CREATE TABLE IF NOT EXISTS refresh_tokens (
+    id          uuid DEFAULT uuid_generate_v4(),
+    created_at  timestamptz,
+    updated_at  timestamptz,
+    deleted_at  timestamptz,
+    merchant_id uuid,
+    family_id   uuid,
+    token_hash  text,
+    expires_at  timestamptz,
+    revoked_at  timestamptz,
+    PRIMARY KEY (id)
+);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_deleted_at ON refresh_tokens (deleted_at);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_merchant_id ON refresh_tokens (merchant_id);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON refresh_tokens (family_id);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_token_hash ON refresh_tokens (token_hash);
+
+CREATE TABLE IF NOT EXISTS rate_limit_buckets (
+    key        text,
+    tokens     decimal,
+    updated_at timestamptz,
+    PRIMARY KEY (key)
+);
+CREATE INDEX IF NOT EXISTS idx_rate_limit_buckets_updated_at ON rate_limit_buckets (updated_at);
+
+CREATE TABLE IF NOT EXISTS wallets (
+    id          uuid DEFAULT uuid_generate_v4(),
+    created_at  timestamptz,
+    updated_at  timestamptz,
+    deleted_at  timestamptz,
+    merchant_id uuid,
+    currency    bigint,
+    mode        bigint,
+    address     text,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_merchants_wallets FOREIGN KEY (merchant_id) REFERENCES merchants (id)
+);
+CREATE INDEX IF NOT EXISTS idx_wallets_deleted_at ON wallets (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS wallet_index ON wallets (merchant_id, currency, mode) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS api_keys (
+    id          uuid DEFAULT uuid_generate_v4(),
+    created_at  timestamptz,
+    updated_at  timestamptz,
+    deleted_at  timestamptz,
+    merchant_id uuid,
+    mode        bigint,
+    api_key     text,
+    secret      text,
+    secret_salt bytea,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_merchants_api_keys FOREIGN KEY (merchant_id) REFERENCES merchants (id)
+);
+CREATE INDEX IF NOT EXISTS idx_api_keys_deleted_at ON api_keys (deleted_at);
+CREATE UNIQUE INDEX IF NOT EXISTS api_key_index ON api_keys (merchant_id, mode) WHERE deleted_at IS NULL;
+
+CREATE TABLE IF NOT EXISTS payments (
+    id                    uuid DEFAULT uuid_generate_v4(),
+    created_at            timestamptz,
+    updated_at            timestamptz,
+    deleted_at            timestamptz,
+    blockchain_payment_id uuid,
+    merchant_id           uuid,
+    wallet_id             uuid,
+    mode                  bigint,
+    price_amount          numeric,
+    price_currency        bigint,
+    pay_currency          bigint,
+    pay_address           text,
+    callback_url          text,
+    success_page_url      text,
+    failure_page_url      text,
+    tx_hash               text,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_merchants_payments FOREIGN KEY (merchant_id) REFERENCES merchants (id),
+    CONSTRAINT fk_payments_wallet FOREIGN KEY (wallet_id) REFERENCES wallets (id)
+);
+CREATE INDEX IF NOT EXISTS idx_payments_deleted_at ON payments (deleted_at);
+
+CREATE TABLE IF NOT EXISTS payment_states (
+    id                    uuid DEFAULT uuid_generate_v4(),
+    created_at            timestamptz,
+    updated_at            timestamptz,
+    deleted_at            timestamptz,
+    payment_id            uuid,
+    blockchain_payment_id uuid,
+    pay_currency          bigint,
+    pay_address           text,
+    pay_amount            numeric,
+    actually_paid         numeric,
+    payment_state         bigint,
+    PRIMARY KEY (id),
+    CONSTRAINT fk_payments_payment_states FOREIGN KEY (payment_id) REFERENCES payments (id)
+);
+CREATE INDEX IF NOT EXISTS idx_payment_states_deleted_at ON payment_states (deleted_at);
@@ -0,0 +1,2 @@
+-- The columns are part of the schema of 0001, only the index is created by this migration.
+DROP INDEX IF EXISTS idx_payment_states_blockchain_payment_id;
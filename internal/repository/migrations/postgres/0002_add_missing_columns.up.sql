@@ -0,0 +1,30 @@
+-- Tables created by an older AutoMigrate are kept by 0001 without the columns added to the models later.
+-- The defaults are the zero values of the fields, e.g. failed_logins + 1 would stay null otherwise.
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS password_changed_at timestamptz;
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS token_version bigint DEFAULT 0;
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS failed_logins bigint DEFAULT 0;
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS locked_until timestamptz;
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS branding_logo_url text DEFAULT '';
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS branding_primary_color text DEFAULT '';
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS branding_accent_color text DEFAULT '';
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS two_factor_secret text DEFAULT '';
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS two_factor_enabled boolean DEFAULT false;
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS two_factor_last_used_step bigint DEFAULT 0;
+
+-- the verification codes are not valid anymore, the merchants have to request a new email
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS token_hash text DEFAULT '';
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS expires_at timestamptz;
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS sent_at timestamptz;
+ALTER TABLE email_verifications ADD COLUMN IF NOT EXISTS attempts bigint DEFAULT 0;
+
+-- before the currency could be changed, all states belonged to the blockchain payment of the payment
+ALTER TABLE payment_states ADD COLUMN IF NOT EXISTS blockchain_payment_id uuid;
+ALTER TABLE payment_states ADD COLUMN IF NOT EXISTS pay_currency bigint;
+ALTER TABLE payment_states ADD COLUMN IF NOT EXISTS pay_address text;
+UPDATE payment_states
+SET blockchain_payment_id = payments.blockchain_payment_id,
+    pay_currency          = payments.pay_currency,
+    pay_address           = payments.pay_address
+FROM payments
+WHERE payment_states.payment_id = payments.id AND payment_states.pay_currency IS NULL;
+CREATE INDEX IF NOT EXISTS idx_payment_states_blockchain_payment_id ON payment_states (blockchain_payment_id);
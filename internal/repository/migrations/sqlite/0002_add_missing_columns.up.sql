@@ -0,0 +1,2 @@
+-- Sqlite databases were always created by the migrations, all columns are created by 0001.
+SELECT 1;
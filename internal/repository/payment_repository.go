@@ -1,10 +1,13 @@
 package repository
 
 import (
+	"context"
+
 	"github.com/CHainGate/backend/internal/model"
 	"github.com/CHainGate/backend/pkg/enum"
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type paymentRepository struct {
@@ -12,20 +15,23 @@ type paymentRepository struct {
 }
 
 type IPaymentRepository interface {
-	FindByPaymentId(paymentId uuid.UUID) (*model.Payment, error)
-	FindByMerchantIdAndMode(merchantId uuid.UUID, mode enum.Mode) ([]model.Payment, error)
-	FindByBlockchainIdAndCurrency(id string, currency enum.CryptoCurrency) (*model.Payment, error)
-	Update(payment *model.Payment) error
-	Create(payment *model.Payment) error
+	FindByPaymentId(ctx context.Context, paymentId uuid.UUID) (*model.Payment, error)
+	FindByPaymentIdForUpdate(ctx context.Context, paymentId uuid.UUID) (*model.Payment, error)
+	FindByMerchantIdAndMode(ctx context.Context, merchantId uuid.UUID, mode enum.Mode) ([]model.Payment, error)
+	FindByBlockchainIdAndCurrency(ctx context.Context, id string, currency enum.CryptoCurrency) (*model.Payment, error)
+	FindByBlockchainIdAndCurrencyForUpdate(ctx context.Context, id string, currency enum.CryptoCurrency) (*model.Payment, error)
+	FindByPreviousBlockchainId(ctx context.Context, id string) (*model.Payment, error)
+	Update(ctx context.Context, payment *model.Payment) error
+	Create(ctx context.Context, payment *model.Payment) error
 }
 
 func NewPaymentRepository(db *gorm.DB) (IPaymentRepository, error) {
 	return &paymentRepository{db}, nil
 }
 
-func (r *paymentRepository) FindByPaymentId(paymentId uuid.UUID) (*model.Payment, error) {
+func (r *paymentRepository) FindByPaymentId(ctx context.Context, paymentId uuid.UUID) (*model.Payment, error) {
 	var payment model.Payment
-	result := r.DB.Preload("PaymentStates", func(db *gorm.DB) *gorm.DB {
+	result := r.DB.WithContext(ctx).Preload("PaymentStates", func(db *gorm.DB) *gorm.DB {
 		return db.Order("payment_states.created_at DESC")
 	}).Where("id = ?", paymentId).Order("payments.updated_at DESC").First(&payment)
 	if result.Error != nil {
@@ -34,9 +40,24 @@ func (r *paymentRepository) FindByPaymentId(paymentId uuid.UUID) (*model.Payment
 	return &payment, nil
 }
 
-func (r *paymentRepository) FindByMerchantIdAndMode(merchantId uuid.UUID, mode enum.Mode) ([]model.Payment, error) {
+// FindByPaymentIdForUpdate locks the payment row until the transaction ends. Use it within WithTx.
+func (r *paymentRepository) FindByPaymentIdForUpdate(ctx context.Context, paymentId uuid.UUID) (*model.Payment, error) {
+	var payment model.Payment
+	result := r.DB.WithContext(ctx).Preload("PaymentStates", func(db *gorm.DB) *gorm.DB {
+		return db.Order("payment_states.created_at DESC")
+	}).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id = ?", paymentId).
+		First(&payment)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &payment, nil
+}
+
+func (r *paymentRepository) FindByMerchantIdAndMode(ctx context.Context, merchantId uuid.UUID, mode enum.Mode) ([]model.Payment, error) {
 	var payments []model.Payment
-	result := r.DB.Preload("PaymentStates", func(db *gorm.DB) *gorm.DB {
+	result := r.DB.WithContext(ctx).Preload("PaymentStates", func(db *gorm.DB) *gorm.DB {
 		return db.Order("payment_states.created_at DESC")
 	}).Where("merchant_id = ? and mode = ?", merchantId, mode).Order("payments.updated_at DESC").Find(&payments)
 	if result.Error != nil {
@@ -45,11 +66,27 @@ func (r *paymentRepository) FindByMerchantIdAndMode(merchantId uuid.UUID, mode e
 	return payments, nil
 }
 
-func (r *paymentRepository) FindByBlockchainIdAndCurrency(id string, currency enum.CryptoCurrency) (*model.Payment, error) {
+func (r *paymentRepository) FindByBlockchainIdAndCurrency(ctx context.Context, id string, currency enum.CryptoCurrency) (*model.Payment, error) {
+	var payment model.Payment
+	result := r.DB.WithContext(ctx).Preload("PaymentStates", func(db *gorm.DB) *gorm.DB {
+		return db.Order("payment_states.created_at DESC")
+	}).
+		Where("blockchain_payment_id = ? AND pay_currency = ?", id, currency).
+		First(&payment)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &payment, nil
+}
+
+// FindByBlockchainIdAndCurrencyForUpdate locks the payment row until the transaction ends,
+// so concurrent updates of the same payment are applied one after the other. Use it within WithTx.
+func (r *paymentRepository) FindByBlockchainIdAndCurrencyForUpdate(ctx context.Context, id string, currency enum.CryptoCurrency) (*model.Payment, error) {
 	var payment model.Payment
-	result := r.DB.Preload("PaymentStates", func(db *gorm.DB) *gorm.DB {
+	result := r.DB.WithContext(ctx).Preload("PaymentStates", func(db *gorm.DB) *gorm.DB {
 		return db.Order("payment_states.created_at DESC")
 	}).
+		Clauses(clause.Locking{Strength: "UPDATE"}).
 		Where("blockchain_payment_id = ? AND pay_currency = ?", id, currency).
 		First(&payment)
 	if result.Error != nil {
@@ -58,16 +95,29 @@ func (r *paymentRepository) FindByBlockchainIdAndCurrency(id string, currency en
 	return &payment, nil
 }
 
-func (r *paymentRepository) Update(payment *model.Payment) error {
-	result := r.DB.Save(&payment)
+// FindByPreviousBlockchainId finds a payment by a blockchain payment which was replaced after a currency change
+func (r *paymentRepository) FindByPreviousBlockchainId(ctx context.Context, id string) (*model.Payment, error) {
+	var payment model.Payment
+	result := r.DB.WithContext(ctx).
+		Where("id IN (?)", r.DB.WithContext(ctx).Model(&model.PaymentState{}).Select("payment_id").Where("blockchain_payment_id = ?", id)).
+		Where("blockchain_payment_id <> ?", id).
+		First(&payment)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &payment, nil
+}
+
+func (r *paymentRepository) Update(ctx context.Context, payment *model.Payment) error {
+	result := r.DB.WithContext(ctx).Save(&payment)
 	if result.Error != nil {
 		return result.Error
 	}
 	return nil
 }
 
-func (r *paymentRepository) Create(payment *model.Payment) error {
-	result := r.DB.Create(&payment)
+func (r *paymentRepository) Create(ctx context.Context, payment *model.Payment) error {
+	result := r.DB.WithContext(ctx).Create(&payment)
 	if result.Error != nil {
 		return result.Error
 	}
@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/ratelimit"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const rateLimitPruneInterval = 1000
+const rateLimitBucketLifetime = time.Hour * 1
+
+type rateLimitRepository struct {
+	DB    *gorm.DB
+	takes uint64
+}
+
+// IRateLimitRepository is the rate limit store for setups with several replicas
+type IRateLimitRepository interface {
+	ratelimit.Store
+}
+
+func NewRateLimitRepository(db *gorm.DB) (IRateLimitRepository, error) {
+	return &rateLimitRepository{DB: db}, nil
+}
+
+// Take locks the bucket row, so concurrent requests of all replicas are counted
+func (r *rateLimitRepository) Take(ctx context.Context, key string, limit ratelimit.Limit, now time.Time) (ratelimit.Result, error) {
+	if atomic.AddUint64(&r.takes, 1)%rateLimitPruneInterval == 0 {
+		err := r.prune(ctx, now)
+		if err != nil {
+			return ratelimit.Result{}, err
+		}
+	}
+
+	var result ratelimit.Result
+	err := r.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&model.RateLimitBucket{Key: key, Tokens: float64(limit.Burst), UpdatedAt: now}).Error
+		if err != nil {
+			return err
+		}
+
+		var bucket model.RateLimitBucket
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("key = ?", key).First(&bucket).Error
+		if err != nil {
+			return err
+		}
+
+		result = ratelimit.Take(&bucket.Tokens, &bucket.UpdatedAt, limit, now)
+		return tx.Model(&bucket).Updates(map[string]interface{}{"tokens": bucket.Tokens, "updated_at": bucket.UpdatedAt}).Error
+	})
+	if err != nil {
+		return ratelimit.Result{}, err
+	}
+	return result, nil
+}
+
+// prune deletes the buckets which were not used for a while, they are full again
+func (r *rateLimitRepository) prune(ctx context.Context, now time.Time) error {
+	result := r.DB.WithContext(ctx).Where("updated_at < ?", now.Add(-rateLimitBucketLifetime)).Delete(&model.RateLimitBucket{})
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
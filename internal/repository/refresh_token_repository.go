@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type refreshTokenRepository struct {
+	DB *gorm.DB
+}
+
+type IRefreshTokenRepository interface {
+	Create(ctx context.Context, refreshToken *model.RefreshToken) error
+	FindByTokenHash(ctx context.Context, tokenHash string) (*model.RefreshToken, error)
+	Revoke(ctx context.Context, id uuid.UUID) (bool, error)
+	RevokeFamily(ctx context.Context, familyId uuid.UUID) error
+	RevokeAllByMerchantId(ctx context.Context, merchantId uuid.UUID) error
+}
+
+func NewRefreshTokenRepository(db *gorm.DB) (IRefreshTokenRepository, error) {
+	return &refreshTokenRepository{db}, nil
+}
+
+func (r *refreshTokenRepository) Create(ctx context.Context, refreshToken *model.RefreshToken) error {
+	result := r.DB.WithContext(ctx).Create(&refreshToken)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (r *refreshTokenRepository) FindByTokenHash(ctx context.Context, tokenHash string) (*model.RefreshToken, error) {
+	var refreshToken model.RefreshToken
+	result := r.DB.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&refreshToken)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &refreshToken, nil
+}
+
+// Revoke returns false if the token was already revoked, e.g. by a concurrent refresh
+func (r *refreshTokenRepository) Revoke(ctx context.Context, id uuid.UUID) (bool, error) {
+	result := r.DB.WithContext(ctx).Model(&model.RefreshToken{}).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *refreshTokenRepository) RevokeFamily(ctx context.Context, familyId uuid.UUID) error {
+	result := r.DB.WithContext(ctx).Model(&model.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyId).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (r *refreshTokenRepository) RevokeAllByMerchantId(ctx context.Context, merchantId uuid.UUID) error {
+	result := r.DB.WithContext(ctx).Model(&model.RefreshToken{}).
+		Where("merchant_id = ? AND revoked_at IS NULL", merchantId).
+		Update("revoked_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
@@ -2,76 +2,140 @@ package repository
 
 import (
 	"fmt"
+	"strings"
 
-	"github.com/CHainGate/backend/internal/model"
 	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
+	"github.com/CHainGate/backend/internal/metrics"
+	"github.com/CHainGate/backend/internal/tracing"
 	"github.com/CHainGate/backend/internal/utils"
 )
 
-func SetupDatabase() (IMerchantRepository, IApiKeyRepository, IPaymentRepository, error) {
-	dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable", utils.Opts.DbHost, utils.Opts.DbUser, utils.Opts.DbPassword, utils.Opts.DbName, utils.Opts.DbPort)
-	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
+// Database has the repositories of the configured database
+type Database struct {
+	Repositories
+	RateLimit  IRateLimitRepository
+	UnitOfWork IUnitOfWork
+	Health     IHealthRepository
+}
+
+func SetupDatabase() (*Database, error) {
+	db, err := OpenDatabase()
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
 
-	err = autoMigrateDB(db)
+	migrator, err := NewMigrator(db)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
-
-	merchantRepo, apiKeyRepo, paymentRepo, err := createRepositories(db)
+	if utils.Opts.MigrateOnStartup {
+		err = migrator.Up()
+		if err != nil {
+			return nil, err
+		}
+	}
+	// the schema is only changed by migrations, never run against a schema this binary was not built for
+	err = migrator.CheckVersion()
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
 
-	return merchantRepo, apiKeyRepo, paymentRepo, nil
+	return createRepositories(db)
 }
 
-func autoMigrateDB(db *gorm.DB) error {
-	err := db.AutoMigrate(&model.Merchant{})
+// OpenDatabase opens the configured database, the duration of the queries is measured
+func OpenDatabase() (*gorm.DB, error) {
+	var db *gorm.DB
+	var err error
+	switch utils.Opts.DbDriver {
+	case "postgres":
+		dsn := fmt.Sprintf("host=%s user=%s password=%s dbname=%s port=%s sslmode=disable", utils.Opts.DbHost, utils.Opts.DbUser, utils.Opts.DbPassword, utils.Opts.DbName, utils.Opts.DbPort)
+		db, err = gorm.Open(postgres.Open(dsn), &gorm.Config{})
+	case "sqlite":
+		db, err = OpenSqlite(utils.Opts.DbPath)
+	default:
+		return nil, fmt.Errorf("unknown database driver %s", utils.Opts.DbDriver)
+	}
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = db.AutoMigrate(&model.EmailVerification{})
+
+	err = db.Use(metrics.NewGormPlugin())
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = db.AutoMigrate(&model.Wallet{})
+	err = db.Use(tracing.NewGormPlugin())
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = db.AutoMigrate(&model.ApiKey{})
+	return db, nil
+}
+
+// OpenSqlite opens or creates the database file. Sqlite has no row locks, so every transaction
+// locks the database for writing when it begins (_txlock=immediate) and waits for the other ones.
+func OpenSqlite(path string) (*gorm.DB, error) {
+	dsn := fmt.Sprintf("file:%s?_foreign_keys=1&_txlock=immediate&_busy_timeout=5000", path)
+	return gorm.Open(sqlite.Open(dsn), &gorm.Config{})
+}
+
+// IsDuplicateEmail reports whether err violates the unique email of the merchants
+func IsDuplicateEmail(err error) bool {
+	return err != nil && (strings.Contains(err.Error(), "merchants_email_key") || strings.Contains(err.Error(), "merchants.email"))
+}
+
+func createRepositories(db *gorm.DB) (*Database, error) {
+	merchantRepo, err := NewMerchantRepository(db)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = db.AutoMigrate(&model.Payment{})
+
+	paymentRepo, err := NewPaymentRepository(db)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	err = db.AutoMigrate(&model.PaymentState{})
+
+	apiKeyRepo, err := NewApiKeyRepository(db)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	return nil
-}
 
-func createRepositories(db *gorm.DB) (IMerchantRepository, IApiKeyRepository, IPaymentRepository, error) {
-	merchantRepo, err := NewMerchantRepository(db)
+	refreshTokenRepo, err := NewRefreshTokenRepository(db)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
 
-	paymentRepo, err := NewPaymentRepository(db)
+	teamRepo, err := NewTeamRepository(db)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
 
-	apiKeyRepo, err := NewApiKeyRepository(db)
+	rateLimitRepo, err := NewRateLimitRepository(db)
+	if err != nil {
+		return nil, err
+	}
+
+	unitOfWork, err := NewUnitOfWork(db)
+	if err != nil {
+		return nil, err
+	}
+
+	healthRepo, err := NewHealthRepository(db)
 	if err != nil {
-		return nil, nil, nil, err
+		return nil, err
 	}
-	return merchantRepo, apiKeyRepo, paymentRepo, nil
+	return &Database{
+		Repositories: Repositories{
+			Merchant:     merchantRepo,
+			ApiKey:       apiKeyRepo,
+			Payment:      paymentRepo,
+			Team:         teamRepo,
+			RefreshToken: refreshTokenRepo,
+		},
+		RateLimit:  rateLimitRepo,
+		UnitOfWork: unitOfWork,
+		Health:     healthRepo,
+	}, nil
 }
@@ -0,0 +1,866 @@
+// Package repositorytest is the contract of the repositories. The postgres and the in-memory
+// implementations have to pass the same tests, so they can be used interchangeably.
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// NewRepositories returns repositories sharing an empty database
+type NewRepositories func(t *testing.T) repository.Repositories
+
+// NewUnitOfWork returns a unit of work and the repositories outside of it, sharing an empty database
+type NewUnitOfWork func(t *testing.T) (repository.IUnitOfWork, repository.Repositories)
+
+// Run runs the contract tests of all repositories and of the unit of work
+func Run(t *testing.T, newRepositories NewRepositories, newUnitOfWork NewUnitOfWork) {
+	t.Run("MerchantRepository", func(t *testing.T) { TestMerchantRepository(t, newRepositories) })
+	t.Run("ApiKeyRepository", func(t *testing.T) { TestApiKeyRepository(t, newRepositories) })
+	t.Run("PaymentRepository", func(t *testing.T) { TestPaymentRepository(t, newRepositories) })
+	t.Run("TeamRepository", func(t *testing.T) { TestTeamRepository(t, newRepositories) })
+	t.Run("RefreshTokenRepository", func(t *testing.T) { TestRefreshTokenRepository(t, newRepositories) })
+	t.Run("UnitOfWork", func(t *testing.T) { TestUnitOfWork(t, newUnitOfWork) })
+}
+
+func TestMerchantRepository(t *testing.T, newRepositories NewRepositories) {
+	ctx := context.Background()
+
+	t.Run("CreateAndFind", func(t *testing.T) {
+		repo := newRepositories(t).Merchant
+		merchant := newMerchant("momo@mail.com")
+		merchant.EmailVerification = model.EmailVerification{TokenHash: "hash", ExpiresAt: time.Now().Add(time.Hour)}
+		merchant.Wallets = []model.Wallet{{Currency: enum.ETH, Mode: enum.Test, Address: "0x1"}}
+		mustNot(t, repo.Create(ctx, merchant))
+
+		if merchant.ID == uuid.Nil || merchant.CreatedAt.IsZero() {
+			t.Fatalf("Expected id and creation time to be set, but got %v", merchant.Base)
+		}
+		if merchant.EmailVerification.MerchantId != merchant.ID || merchant.Wallets[0].ID == uuid.Nil {
+			t.Errorf("Expected associations to be created")
+		}
+
+		found, err := repo.FindById(ctx, merchant.ID)
+		mustNot(t, err)
+		if found.Email != merchant.Email || string(found.Salt) != string(merchant.Salt) {
+			t.Errorf("Expected merchant %s, but got %s", merchant.Email, found.Email)
+		}
+		if found.EmailVerification.TokenHash != "hash" || len(found.Wallets) != 1 || found.Wallets[0].Address != "0x1" {
+			t.Errorf("Expected email verification and wallets to be loaded")
+		}
+
+		found, err = repo.FindByEmail(ctx, merchant.Email)
+		mustNot(t, err)
+		if found.ID != merchant.ID {
+			t.Errorf("Expected merchant %s, but got %s", merchant.ID, found.ID)
+		}
+
+		_, err = repo.FindById(ctx, uuid.New())
+		expectNotFound(t, err)
+		_, err = repo.FindByEmail(ctx, "unknown@mail.com")
+		expectNotFound(t, err)
+	})
+
+	t.Run("UniqueEmail", func(t *testing.T) {
+		repo := newRepositories(t).Merchant
+		mustNot(t, repo.Create(ctx, newMerchant("momo@mail.com")))
+
+		duplicate := newMerchant("momo@mail.com")
+		duplicate.FirstName = "Duplicate"
+		if err := repo.Create(ctx, duplicate); !repository.IsDuplicateEmail(err) {
+			t.Fatalf("Expected duplicate email error, but got %v", err)
+		}
+
+		found, err := repo.FindByEmail(ctx, "momo@mail.com")
+		mustNot(t, err)
+		if found.FirstName == "Duplicate" {
+			t.Errorf("Expected first merchant to be kept")
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		repo := newRepositories(t).Merchant
+		merchant := newMerchant("momo@mail.com")
+		mustNot(t, repo.Create(ctx, merchant))
+
+		found, err := repo.FindById(ctx, merchant.ID)
+		mustNot(t, err)
+		found.FirstName = "Changed"
+		found.TwoFactor.Enabled = true
+		found.Wallets = append(found.Wallets, model.Wallet{Currency: enum.BTC, Mode: enum.Main, Address: "bc1"})
+		mustNot(t, repo.Update(ctx, found))
+
+		found, err = repo.FindById(ctx, merchant.ID)
+		mustNot(t, err)
+		if found.FirstName != "Changed" || !found.TwoFactor.Enabled || len(found.Wallets) != 1 || found.Wallets[0].Currency != enum.BTC {
+			t.Errorf("Expected changes to be saved, but got %+v", found)
+		}
+	})
+
+	t.Run("SoftDeleteWallet", func(t *testing.T) {
+		repo := newRepositories(t).Merchant
+		merchant := newMerchant("momo@mail.com")
+		merchant.Wallets = []model.Wallet{{Currency: enum.ETH, Mode: enum.Test, Address: "0x1"}}
+		mustNot(t, repo.Create(ctx, merchant))
+
+		found, err := repo.FindById(ctx, merchant.ID)
+		mustNot(t, err)
+		found.Wallets = append(found.Wallets, model.Wallet{Currency: enum.ETH, Mode: enum.Test, Address: "0x2"})
+		if err := repo.Update(ctx, found); err == nil {
+			t.Fatalf("Expected second wallet with the same currency and mode to fail")
+		}
+
+		found, err = repo.FindById(ctx, merchant.ID)
+		mustNot(t, err)
+		if len(found.Wallets) != 1 {
+			t.Fatalf("Expected failed update to be rolled back, but got %d wallets", len(found.Wallets))
+		}
+
+		mustNot(t, repo.DeleteWalletById(ctx, merchant.ID, merchant.Wallets[0].ID.String()))
+		found, err = repo.FindById(ctx, merchant.ID)
+		mustNot(t, err)
+		if len(found.Wallets) != 0 {
+			t.Fatalf("Expected deleted wallet not to be loaded")
+		}
+
+		found.Wallets = []model.Wallet{{Currency: enum.ETH, Mode: enum.Test, Address: "0x2"}}
+		mustNot(t, repo.Update(ctx, found))
+	})
+
+	t.Run("FindAll", func(t *testing.T) {
+		repo := newRepositories(t).Merchant
+		first := newMerchant("momo@mail.com")
+		mustNot(t, repo.Create(ctx, first))
+		second := newMerchant("lulu@mail.com")
+		second.Wallets = []model.Wallet{{Currency: enum.ETH, Mode: enum.Test, Address: "0x1"}}
+		mustNot(t, repo.Create(ctx, second))
+
+		merchants, err := repo.FindAll(ctx)
+		mustNot(t, err)
+		if len(merchants) != 2 || merchants[0].ID != first.ID || merchants[1].ID != second.ID {
+			t.Fatalf("Expected the merchants in creation order, but got %+v", merchants)
+		}
+		if len(merchants[1].Wallets) != 0 {
+			t.Errorf("Expected the associations not to be loaded")
+		}
+	})
+
+	t.Run("UpdateEmailVerification", func(t *testing.T) {
+		repo := newRepositories(t).Merchant
+		merchant := newMerchant("momo@mail.com")
+		merchant.EmailVerification = model.EmailVerification{TokenHash: "hash"}
+		mustNot(t, repo.Create(ctx, merchant))
+
+		merchant.EmailVerification.Attempts = 3
+		mustNot(t, repo.UpdateEmailVerification(ctx, &merchant.EmailVerification))
+
+		found, err := repo.FindById(ctx, merchant.ID)
+		mustNot(t, err)
+		if found.EmailVerification.Attempts != 3 {
+			t.Errorf("Expected 3 attempts, but got %d", found.EmailVerification.Attempts)
+		}
+	})
+
+	t.Run("PasswordReset", func(t *testing.T) {
+		repo := newRepositories(t).Merchant
+		merchant := newMerchant("momo@mail.com")
+		merchant.PasswordResets = []model.PasswordReset{{TokenHash: "reset", ExpiresAt: time.Now().Add(time.Hour)}}
+		mustNot(t, repo.Create(ctx, merchant))
+
+		reset, err := repo.FindPasswordResetByTokenHash(ctx, "reset")
+		mustNot(t, err)
+		if reset.MerchantId != merchant.ID {
+			t.Errorf("Expected reset of merchant %s, but got %s", merchant.ID, reset.MerchantId)
+		}
+
+		used, err := repo.MarkPasswordResetUsed(ctx, reset.ID)
+		mustNot(t, err)
+		if !used {
+			t.Errorf("Expected reset to be marked as used")
+		}
+		used, err = repo.MarkPasswordResetUsed(ctx, reset.ID)
+		mustNot(t, err)
+		if used {
+			t.Errorf("Expected reset to be usable once")
+		}
+
+		_, err = repo.FindPasswordResetByTokenHash(ctx, "unknown")
+		expectNotFound(t, err)
+	})
+
+	t.Run("RecoveryCodes", func(t *testing.T) {
+		repo := newRepositories(t).Merchant
+		merchant := newMerchant("momo@mail.com")
+		merchant.RecoveryCodes = []model.RecoveryCode{{CodeHash: "a"}, {CodeHash: "b"}}
+		mustNot(t, repo.Create(ctx, merchant))
+
+		used, err := repo.MarkRecoveryCodeUsed(ctx, merchant.ID, "a")
+		mustNot(t, err)
+		if !used {
+			t.Errorf("Expected recovery code to be marked as used")
+		}
+		used, err = repo.MarkRecoveryCodeUsed(ctx, merchant.ID, "a")
+		mustNot(t, err)
+		if used {
+			t.Errorf("Expected recovery code to be usable once")
+		}
+		used, err = repo.MarkRecoveryCodeUsed(ctx, uuid.New(), "b")
+		mustNot(t, err)
+		if used {
+			t.Errorf("Expected recovery code of another merchant to be rejected")
+		}
+
+		mustNot(t, repo.DeleteRecoveryCodes(ctx, merchant.ID))
+		used, err = repo.MarkRecoveryCodeUsed(ctx, merchant.ID, "b")
+		mustNot(t, err)
+		if used {
+			t.Errorf("Expected deleted recovery code to be rejected")
+		}
+	})
+
+	t.Run("TotpStep", func(t *testing.T) {
+		repo := newRepositories(t).Merchant
+		merchant := newMerchant("momo@mail.com")
+		merchant.TwoFactor = model.TwoFactor{Secret: "secret", Enabled: true, LastUsedStep: 10}
+		mustNot(t, repo.Create(ctx, merchant))
+
+		for _, test := range []struct {
+			step int64
+			used bool
+		}{{11, true}, {11, false}, {10, false}, {12, true}} {
+			used, err := repo.MarkTotpStepUsed(ctx, merchant.ID, test.step)
+			mustNot(t, err)
+			if used != test.used {
+				t.Errorf("Expected step %d to be used %t, but got %t", test.step, test.used, used)
+			}
+		}
+		found, err := repo.FindById(ctx, merchant.ID)
+		mustNot(t, err)
+		if found.TwoFactor.LastUsedStep != 12 {
+			t.Errorf("Expected last used step 12, but got %d", found.TwoFactor.LastUsedStep)
+		}
+	})
+
+	t.Run("FailedLogins", func(t *testing.T) {
+		repo := newRepositories(t).Merchant
+		merchant := newMerchant("momo@mail.com")
+		mustNot(t, repo.Create(ctx, merchant))
+
+		for want := 1; want <= 2; want++ {
+			failedLogins, err := repo.RecordFailedLogin(ctx, merchant.ID)
+			mustNot(t, err)
+			if failedLogins != want {
+				t.Errorf("Expected %d failed logins, but got %d", want, failedLogins)
+			}
+		}
+
+		mustNot(t, repo.LockMerchant(ctx, merchant.ID, time.Now().Add(time.Hour)))
+		found, err := repo.FindById(ctx, merchant.ID)
+		mustNot(t, err)
+		if found.FailedLogins != 0 || found.LockedUntil == nil {
+			t.Errorf("Expected locked merchant without failed logins, but got %d %v", found.FailedLogins, found.LockedUntil)
+		}
+
+		mustNot(t, repo.ResetFailedLogins(ctx, merchant.ID))
+		found, err = repo.FindById(ctx, merchant.ID)
+		mustNot(t, err)
+		if found.LockedUntil != nil {
+			t.Errorf("Expected merchant to be unlocked")
+		}
+	})
+}
+
+func TestApiKeyRepository(t *testing.T, newRepositories NewRepositories) {
+	ctx := context.Background()
+
+	t.Run("FindAndDelete", func(t *testing.T) {
+		repos := newRepositories(t)
+		merchant := newMerchant("momo@mail.com")
+		merchant.ApiKeys = []model.ApiKey{newApiKey(enum.Test)}
+		mustNot(t, repos.Merchant.Create(ctx, merchant))
+		key := merchant.ApiKeys[0]
+
+		found, err := repos.ApiKey.FindByMerchantAndMode(ctx, merchant.ID, enum.Test)
+		mustNot(t, err)
+		if found.ID != key.ID || found.ApiKey != key.ApiKey || string(found.SecretSalt) != string(key.SecretSalt) {
+			t.Errorf("Expected api key %s, but got %s", key.ID, found.ID)
+		}
+
+		found, err = repos.ApiKey.FindById(ctx, key.ID.String())
+		mustNot(t, err)
+		if found.MerchantId != merchant.ID {
+			t.Errorf("Expected api key of merchant %s, but got %s", merchant.ID, found.MerchantId)
+		}
+
+		// the keys are found with Find, a missing key is empty instead of an error
+		found, err = repos.ApiKey.FindByMerchantAndMode(ctx, merchant.ID, enum.Main)
+		mustNot(t, err)
+		if found.ID != uuid.Nil {
+			t.Errorf("Expected empty api key, but got %s", found.ID)
+		}
+
+		mustNot(t, repos.ApiKey.Delete(ctx, uuid.New(), key.ID.String()))
+		found, err = repos.ApiKey.FindById(ctx, key.ID.String())
+		mustNot(t, err)
+		if found.ID != key.ID {
+			t.Errorf("Expected api key of another merchant not to be deleted")
+		}
+
+		mustNot(t, repos.ApiKey.Delete(ctx, merchant.ID, key.ID.String()))
+		found, err = repos.ApiKey.FindByMerchantAndMode(ctx, merchant.ID, enum.Test)
+		mustNot(t, err)
+		if found.ID != uuid.Nil {
+			t.Errorf("Expected deleted api key not to be found")
+		}
+	})
+
+	t.Run("FindAllAndUpdate", func(t *testing.T) {
+		repos := newRepositories(t)
+		merchant := newMerchant("momo@mail.com")
+		merchant.ApiKeys = []model.ApiKey{newApiKey(enum.Test), newApiKey(enum.Main)}
+		mustNot(t, repos.Merchant.Create(ctx, merchant))
+		mustNot(t, repos.ApiKey.Delete(ctx, merchant.ID, merchant.ApiKeys[1].ID.String()))
+
+		keys, err := repos.ApiKey.FindAll(ctx)
+		mustNot(t, err)
+		if len(keys) != 1 || keys[0].ID != merchant.ApiKeys[0].ID {
+			t.Fatalf("Expected only the api key which is not deleted, but got %+v", keys)
+		}
+
+		keys[0].ApiKey = "reencrypted"
+		mustNot(t, repos.ApiKey.Update(ctx, &keys[0]))
+		found, err := repos.ApiKey.FindById(ctx, keys[0].ID.String())
+		mustNot(t, err)
+		if found.ApiKey != "reencrypted" || found.Secret != "secret" || found.MerchantId != merchant.ID {
+			t.Errorf("Expected the api key to be updated, but got %+v", found)
+		}
+	})
+
+	t.Run("UniqueMode", func(t *testing.T) {
+		repos := newRepositories(t)
+		merchant := newMerchant("momo@mail.com")
+		merchant.ApiKeys = []model.ApiKey{newApiKey(enum.Test)}
+		mustNot(t, repos.Merchant.Create(ctx, merchant))
+
+		found, err := repos.Merchant.FindById(ctx, merchant.ID)
+		mustNot(t, err)
+		found.ApiKeys = []model.ApiKey{newApiKey(enum.Test)}
+		if err := repos.Merchant.Update(ctx, found); err == nil {
+			t.Fatalf("Expected second api key with the same mode to fail")
+		}
+
+		mustNot(t, repos.ApiKey.Delete(ctx, merchant.ID, merchant.ApiKeys[0].ID.String()))
+		found.ApiKeys = []model.ApiKey{newApiKey(enum.Test)}
+		mustNot(t, repos.Merchant.Update(ctx, found))
+
+		key, err := repos.ApiKey.FindByMerchantAndMode(ctx, merchant.ID, enum.Test)
+		mustNot(t, err)
+		if key.ID != found.ApiKeys[0].ID {
+			t.Errorf("Expected new api key %s, but got %s", found.ApiKeys[0].ID, key.ID)
+		}
+	})
+}
+
+func TestPaymentRepository(t *testing.T, newRepositories NewRepositories) {
+	ctx := context.Background()
+
+	t.Run("CreateAndFind", func(t *testing.T) {
+		repos := newRepositories(t)
+		merchant := newMerchantWithWallet(t, repos)
+		blockchainPaymentId := uuid.New()
+		payment := newPayment(merchant.ID, &merchant.Wallets[0], blockchainPaymentId, enum.Test)
+		mustNot(t, repos.Payment.Create(ctx, payment))
+
+		if payment.ID == uuid.Nil || payment.WalletId == nil || *payment.WalletId != merchant.Wallets[0].ID {
+			t.Fatalf("Expected id and wallet to be set")
+		}
+
+		found, err := repos.Payment.FindByPaymentId(ctx, payment.ID)
+		mustNot(t, err)
+		expectStates(t, found, enum.Waiting, enum.CurrencySelection)
+		if found.PaymentStates[0].PayAmount.String() != "123456789012345678901234567890" {
+			t.Errorf("Expected big pay amount to be stored exactly, but got %s", found.PaymentStates[0].PayAmount.String())
+		}
+		if found.PriceAmount != 10.5 {
+			t.Errorf("Expected price amount 10.5, but got %f", found.PriceAmount)
+		}
+
+		found, err = repos.Payment.FindByBlockchainIdAndCurrency(ctx, blockchainPaymentId.String(), enum.ETH)
+		mustNot(t, err)
+		if found.ID != payment.ID {
+			t.Errorf("Expected payment %s, but got %s", payment.ID, found.ID)
+		}
+		expectStates(t, found, enum.Waiting, enum.CurrencySelection)
+
+		found, err = repos.Payment.FindByBlockchainIdAndCurrencyForUpdate(ctx, blockchainPaymentId.String(), enum.ETH)
+		mustNot(t, err)
+		if found.ID != payment.ID {
+			t.Errorf("Expected payment %s, but got %s", payment.ID, found.ID)
+		}
+
+		_, err = repos.Payment.FindByBlockchainIdAndCurrency(ctx, blockchainPaymentId.String(), enum.BTC)
+		expectNotFound(t, err)
+		_, err = repos.Payment.FindByPaymentId(ctx, uuid.New())
+		expectNotFound(t, err)
+	})
+
+	t.Run("UnknownMerchant", func(t *testing.T) {
+		repos := newRepositories(t)
+		payment := newPayment(uuid.New(), nil, uuid.New(), enum.Test)
+		if err := repos.Payment.Create(ctx, payment); err == nil {
+			t.Errorf("Expected payment of an unknown merchant to fail")
+		}
+	})
+
+	t.Run("UpdateAddsStates", func(t *testing.T) {
+		repos := newRepositories(t)
+		merchant := newMerchantWithWallet(t, repos)
+		payment := newPayment(merchant.ID, &merchant.Wallets[0], uuid.New(), enum.Test)
+		mustNot(t, repos.Payment.Create(ctx, payment))
+
+		found, err := repos.Payment.FindByPaymentId(ctx, payment.ID)
+		mustNot(t, err)
+		found.TxHash = "0xabc"
+		found.PaymentStates = append(found.PaymentStates, model.PaymentState{
+			BlockchainPaymentId: found.BlockchainPaymentId,
+			PayCurrency:         enum.ETH,
+			PaymentState:        enum.Paid,
+			PayAmount:           model.NewBigIntFromInt(1),
+			ActuallyPaid:        model.NewBigIntFromInt(1),
+		})
+		mustNot(t, repos.Payment.Update(ctx, found))
+
+		found, err = repos.Payment.FindByPaymentId(ctx, payment.ID)
+		mustNot(t, err)
+		if found.TxHash != "0xabc" {
+			t.Errorf("Expected tx hash to be saved")
+		}
+		expectStates(t, found, enum.Paid, enum.Waiting, enum.CurrencySelection)
+	})
+
+	t.Run("FindByMerchantIdAndMode", func(t *testing.T) {
+		repos := newRepositories(t)
+		merchant := newMerchantWithWallet(t, repos)
+		first := newPayment(merchant.ID, &merchant.Wallets[0], uuid.New(), enum.Test)
+		mustNot(t, repos.Payment.Create(ctx, first))
+		second := newPayment(merchant.ID, &merchant.Wallets[0], uuid.New(), enum.Test)
+		mustNot(t, repos.Payment.Create(ctx, second))
+		main := newPayment(merchant.ID, nil, uuid.New(), enum.Main)
+		mustNot(t, repos.Payment.Create(ctx, main))
+
+		// the updated payment is the latest one
+		found, err := repos.Payment.FindByPaymentId(ctx, first.ID)
+		mustNot(t, err)
+		mustNot(t, repos.Payment.Update(ctx, found))
+
+		payments, err := repos.Payment.FindByMerchantIdAndMode(ctx, merchant.ID, enum.Test)
+		mustNot(t, err)
+		if len(payments) != 2 || payments[0].ID != first.ID || payments[1].ID != second.ID {
+			t.Fatalf("Expected test payments ordered by update, but got %d payments", len(payments))
+		}
+		expectStates(t, &payments[0], enum.Waiting, enum.CurrencySelection)
+
+		payments, err = repos.Payment.FindByMerchantIdAndMode(ctx, uuid.New(), enum.Test)
+		mustNot(t, err)
+		if len(payments) != 0 {
+			t.Errorf("Expected no payments of an unknown merchant")
+		}
+	})
+
+	t.Run("FindByPreviousBlockchainId", func(t *testing.T) {
+		repos := newRepositories(t)
+		merchant := newMerchantWithWallet(t, repos)
+		previousId := uuid.New()
+		payment := newPayment(merchant.ID, &merchant.Wallets[0], previousId, enum.Test)
+		mustNot(t, repos.Payment.Create(ctx, payment))
+
+		found, err := repos.Payment.FindByPaymentId(ctx, payment.ID)
+		mustNot(t, err)
+		currentId := uuid.New()
+		found.BlockchainPaymentId = currentId
+		found.PaymentStates = append(found.PaymentStates, model.PaymentState{
+			BlockchainPaymentId: currentId,
+			PayCurrency:         enum.ETH,
+			PaymentState:        enum.Waiting,
+			PayAmount:           model.NewBigIntFromInt(2),
+			ActuallyPaid:        model.NewBigIntFromInt(0),
+		})
+		mustNot(t, repos.Payment.Update(ctx, found))
+
+		found, err = repos.Payment.FindByPreviousBlockchainId(ctx, previousId.String())
+		mustNot(t, err)
+		if found.ID != payment.ID {
+			t.Errorf("Expected payment %s, but got %s", payment.ID, found.ID)
+		}
+		_, err = repos.Payment.FindByPreviousBlockchainId(ctx, currentId.String())
+		expectNotFound(t, err)
+	})
+}
+
+func TestTeamRepository(t *testing.T, newRepositories NewRepositories) {
+	ctx := context.Background()
+
+	t.Run("Memberships", func(t *testing.T) {
+		repos := newRepositories(t)
+		owner := newMerchant("owner@mail.com")
+		mustNot(t, repos.Merchant.Create(ctx, owner))
+		first := newMerchant("first@mail.com")
+		first.Membership = &model.Membership{OrganizationId: owner.ID, Role: enum.Admin}
+		mustNot(t, repos.Merchant.Create(ctx, first))
+		second := newMerchant("second@mail.com")
+		second.Membership = &model.Membership{OrganizationId: owner.ID, Role: enum.Developer}
+		mustNot(t, repos.Merchant.Create(ctx, second))
+
+		membership, err := repos.Team.FindMembershipByMerchantId(ctx, first.ID)
+		mustNot(t, err)
+		if membership.OrganizationId != owner.ID || membership.Role != enum.Admin {
+			t.Errorf("Expected admin membership of organization %s", owner.ID)
+		}
+		_, err = repos.Team.FindMembershipByMerchantId(ctx, owner.ID)
+		expectNotFound(t, err)
+
+		memberships, err := repos.Team.FindMembershipsByOrganizationId(ctx, owner.ID)
+		mustNot(t, err)
+		if len(memberships) != 2 || memberships[0].MerchantId != first.ID || memberships[1].MerchantId != second.ID {
+			t.Fatalf("Expected memberships ordered by creation, but got %d memberships", len(memberships))
+		}
+
+		updated, err := repos.Team.UpdateMembershipRole(ctx, owner.ID, second.ID, enum.Finance)
+		mustNot(t, err)
+		if !updated {
+			t.Errorf("Expected role to be updated")
+		}
+		updated, err = repos.Team.UpdateMembershipRole(ctx, uuid.New(), second.ID, enum.Admin)
+		mustNot(t, err)
+		if updated {
+			t.Errorf("Expected no update of another organization")
+		}
+		membership, err = repos.Team.FindMembershipByMerchantId(ctx, second.ID)
+		mustNot(t, err)
+		if membership.Role != enum.Finance {
+			t.Errorf("Expected role %s, but got %s", enum.Finance, membership.Role)
+		}
+	})
+
+	t.Run("DeleteMembership", func(t *testing.T) {
+		repos := newRepositories(t)
+		owner := newMerchant("owner@mail.com")
+		mustNot(t, repos.Merchant.Create(ctx, owner))
+		member := newMerchant("member@mail.com")
+		member.Membership = &model.Membership{OrganizationId: owner.ID, Role: enum.Admin}
+		mustNot(t, repos.Merchant.Create(ctx, member))
+
+		deleted, err := repos.Team.DeleteMembership(ctx, owner.ID, member.ID)
+		mustNot(t, err)
+		if !deleted {
+			t.Errorf("Expected membership to be deleted")
+		}
+		deleted, err = repos.Team.DeleteMembership(ctx, owner.ID, member.ID)
+		mustNot(t, err)
+		if deleted {
+			t.Errorf("Expected membership to be deleted only once")
+		}
+
+		// the membership is deleted permanently, so the unique index allows a new one
+		found, err := repos.Merchant.FindById(ctx, member.ID)
+		mustNot(t, err)
+		found.Membership = &model.Membership{OrganizationId: owner.ID, Role: enum.Developer}
+		mustNot(t, repos.Merchant.Update(ctx, found))
+		membership, err := repos.Team.FindMembershipByMerchantId(ctx, member.ID)
+		mustNot(t, err)
+		if membership.Role != enum.Developer {
+			t.Errorf("Expected role %s, but got %s", enum.Developer, membership.Role)
+		}
+	})
+
+	t.Run("Invitations", func(t *testing.T) {
+		repos := newRepositories(t)
+		invitation := &model.Invitation{OrganizationId: uuid.New(), Email: "new@mail.com", Role: enum.Admin, TokenHash: "hash", ExpiresAt: time.Now().Add(time.Hour)}
+		mustNot(t, repos.Team.CreateInvitation(ctx, invitation))
+		if invitation.ID == uuid.Nil {
+			t.Fatalf("Expected id to be set")
+		}
+		duplicate := &model.Invitation{OrganizationId: uuid.New(), Email: "other@mail.com", TokenHash: "hash"}
+		if err := repos.Team.CreateInvitation(ctx, duplicate); err == nil {
+			t.Errorf("Expected duplicate token hash to fail")
+		}
+
+		found, err := repos.Team.FindInvitationByTokenHash(ctx, "hash")
+		mustNot(t, err)
+		if found.ID != invitation.ID || found.Email != invitation.Email || found.AcceptedAt != nil {
+			t.Errorf("Expected invitation %s, but got %s", invitation.ID, found.ID)
+		}
+		_, err = repos.Team.FindInvitationByTokenHash(ctx, "unknown")
+		expectNotFound(t, err)
+
+		accepted, err := repos.Team.MarkInvitationAccepted(ctx, invitation.ID)
+		mustNot(t, err)
+		if !accepted {
+			t.Errorf("Expected invitation to be accepted")
+		}
+		accepted, err = repos.Team.MarkInvitationAccepted(ctx, invitation.ID)
+		mustNot(t, err)
+		if accepted {
+			t.Errorf("Expected invitation to be accepted only once")
+		}
+		found, err = repos.Team.FindInvitationByTokenHash(ctx, "hash")
+		mustNot(t, err)
+		if found.AcceptedAt == nil {
+			t.Errorf("Expected accepted_at to be set")
+		}
+	})
+}
+
+func TestRefreshTokenRepository(t *testing.T, newRepositories NewRepositories) {
+	ctx := context.Background()
+
+	t.Run("CreateAndRevoke", func(t *testing.T) {
+		repos := newRepositories(t)
+		token := newRefreshToken(uuid.New(), uuid.New(), "hash")
+		mustNot(t, repos.RefreshToken.Create(ctx, token))
+		if token.ID == uuid.Nil {
+			t.Fatalf("Expected id to be set")
+		}
+		if err := repos.RefreshToken.Create(ctx, newRefreshToken(uuid.New(), uuid.New(), "hash")); err == nil {
+			t.Errorf("Expected duplicate token hash to fail")
+		}
+
+		found, err := repos.RefreshToken.FindByTokenHash(ctx, "hash")
+		mustNot(t, err)
+		if found.ID != token.ID || found.FamilyId != token.FamilyId || found.RevokedAt != nil {
+			t.Errorf("Expected refresh token %s, but got %s", token.ID, found.ID)
+		}
+		_, err = repos.RefreshToken.FindByTokenHash(ctx, "unknown")
+		expectNotFound(t, err)
+
+		revoked, err := repos.RefreshToken.Revoke(ctx, token.ID)
+		mustNot(t, err)
+		if !revoked {
+			t.Errorf("Expected refresh token to be revoked")
+		}
+		revoked, err = repos.RefreshToken.Revoke(ctx, token.ID)
+		mustNot(t, err)
+		if revoked {
+			t.Errorf("Expected refresh token to be revoked only once")
+		}
+		found, err = repos.RefreshToken.FindByTokenHash(ctx, "hash")
+		mustNot(t, err)
+		if found.RevokedAt == nil {
+			t.Errorf("Expected revoked_at to be set")
+		}
+	})
+
+	t.Run("RevokeFamilyAndMerchant", func(t *testing.T) {
+		repos := newRepositories(t)
+		merchantId := uuid.New()
+		familyId := uuid.New()
+		first := newRefreshToken(merchantId, familyId, "first")
+		second := newRefreshToken(merchantId, familyId, "second")
+		other := newRefreshToken(merchantId, uuid.New(), "other")
+		stranger := newRefreshToken(uuid.New(), uuid.New(), "stranger")
+		for _, token := range []*model.RefreshToken{first, second, other, stranger} {
+			mustNot(t, repos.RefreshToken.Create(ctx, token))
+		}
+
+		mustNot(t, repos.RefreshToken.RevokeFamily(ctx, familyId))
+		expectRevoked(t, repos, map[string]bool{"first": true, "second": true, "other": false, "stranger": false})
+
+		mustNot(t, repos.RefreshToken.RevokeAllByMerchantId(ctx, merchantId))
+		expectRevoked(t, repos, map[string]bool{"first": true, "second": true, "other": true, "stranger": false})
+	})
+}
+
+func TestUnitOfWork(t *testing.T, newUnitOfWork NewUnitOfWork) {
+	ctx := context.Background()
+
+	t.Run("Commit", func(t *testing.T) {
+		unitOfWork, repos := newUnitOfWork(t)
+		merchant := newMerchant("momo@mail.com")
+		err := unitOfWork.WithTx(ctx, func(tx repository.Repositories) error {
+			mustNot(t, tx.Merchant.Create(ctx, merchant))
+			// the transaction sees its own writes
+			_, err := tx.Merchant.FindById(ctx, merchant.ID)
+			return err
+		})
+		mustNot(t, err)
+
+		_, err = repos.Merchant.FindById(ctx, merchant.ID)
+		mustNot(t, err)
+	})
+
+	t.Run("Rollback", func(t *testing.T) {
+		unitOfWork, repos := newUnitOfWork(t)
+		merchant := newMerchant("momo@mail.com")
+		rollback := errors.New("rollback")
+		err := unitOfWork.WithTx(ctx, func(tx repository.Repositories) error {
+			mustNot(t, tx.Merchant.Create(ctx, merchant))
+			mustNot(t, tx.RefreshToken.Create(ctx, newRefreshToken(merchant.ID, uuid.New(), "hash")))
+			return rollback
+		})
+		if !errors.Is(err, rollback) {
+			t.Fatalf("Expected error %v, but got %v", rollback, err)
+		}
+
+		_, err = repos.Merchant.FindById(ctx, merchant.ID)
+		expectNotFound(t, err)
+		_, err = repos.RefreshToken.FindByTokenHash(ctx, "hash")
+		expectNotFound(t, err)
+	})
+
+	t.Run("ForUpdate", func(t *testing.T) {
+		unitOfWork, repos := newUnitOfWork(t)
+		merchant := newMerchantWithWallet(t, repos)
+		blockchainPaymentId := uuid.New()
+		payment := newPayment(merchant.ID, &merchant.Wallets[0], blockchainPaymentId, enum.Test)
+		mustNot(t, repos.Payment.Create(ctx, payment))
+
+		err := unitOfWork.WithTx(ctx, func(tx repository.Repositories) error {
+			found, err := tx.Payment.FindByBlockchainIdAndCurrencyForUpdate(ctx, blockchainPaymentId.String(), enum.ETH)
+			if err != nil {
+				return err
+			}
+			found.TxHash = "0xabc"
+			return tx.Payment.Update(ctx, found)
+		})
+		mustNot(t, err)
+
+		err = unitOfWork.WithTx(ctx, func(tx repository.Repositories) error {
+			found, err := tx.Payment.FindByPaymentIdForUpdate(ctx, payment.ID)
+			if err != nil {
+				return err
+			}
+			expectStates(t, found, enum.Waiting, enum.CurrencySelection)
+			found.PayAddress = "0x2"
+			return tx.Payment.Update(ctx, found)
+		})
+		mustNot(t, err)
+
+		found, err := repos.Payment.FindByPaymentId(ctx, payment.ID)
+		mustNot(t, err)
+		if found.TxHash != "0xabc" || found.PayAddress != "0x2" {
+			t.Errorf("Expected tx hash and pay address to be saved")
+		}
+
+		err = unitOfWork.WithTx(ctx, func(tx repository.Repositories) error {
+			_, err := tx.Payment.FindByPaymentIdForUpdate(ctx, uuid.New())
+			return err
+		})
+		expectNotFound(t, err)
+	})
+}
+
+func newMerchant(email string) *model.Merchant {
+	return &model.Merchant{
+		FirstName: "Momo",
+		LastName:  "Test",
+		Email:     email,
+		Password:  "password",
+		Salt:      []byte("salt"),
+		IsActive:  true,
+	}
+}
+
+func newMerchantWithWallet(t *testing.T, repos repository.Repositories) *model.Merchant {
+	merchant := newMerchant("momo@mail.com")
+	merchant.Wallets = []model.Wallet{{Currency: enum.ETH, Mode: enum.Test, Address: "0x1"}}
+	mustNot(t, repos.Merchant.Create(context.Background(), merchant))
+	return merchant
+}
+
+func newApiKey(mode enum.Mode) model.ApiKey {
+	return model.ApiKey{
+		Base:       model.Base{ID: uuid.New()},
+		Mode:       mode,
+		ApiKey:     "encrypted",
+		Secret:     "secret",
+		SecretSalt: []byte("salt"),
+	}
+}
+
+// newPayment has a currency selection and a waiting state, created in the past
+func newPayment(merchantId uuid.UUID, wallet *model.Wallet, blockchainPaymentId uuid.UUID, mode enum.Mode) *model.Payment {
+	now := time.Now()
+	return &model.Payment{
+		MerchantId:          merchantId,
+		Mode:                mode,
+		PriceAmount:         10.5,
+		PriceCurrency:       enum.USD,
+		PayCurrency:         enum.ETH,
+		PayAddress:          "0x1",
+		BlockchainPaymentId: blockchainPaymentId,
+		Wallet:              wallet,
+		PaymentStates: []model.PaymentState{
+			{
+				Base:         model.Base{CreatedAt: now.Add(-2 * time.Hour)},
+				PaymentState: enum.CurrencySelection,
+				PayAmount:    model.NewBigIntFromInt(0),
+				ActuallyPaid: model.NewBigIntFromInt(0),
+			},
+			{
+				Base:                model.Base{CreatedAt: now.Add(-time.Hour)},
+				BlockchainPaymentId: blockchainPaymentId,
+				PayCurrency:         enum.ETH,
+				PayAddress:          "0x1",
+				PaymentState:        enum.Waiting,
+				PayAmount:           model.NewBigIntFromString("123456789012345678901234567890"),
+				ActuallyPaid:        model.NewBigIntFromInt(0),
+			},
+		},
+	}
+}
+
+func newRefreshToken(merchantId uuid.UUID, familyId uuid.UUID, tokenHash string) *model.RefreshToken {
+	return &model.RefreshToken{
+		MerchantId: merchantId,
+		FamilyId:   familyId,
+		TokenHash:  tokenHash,
+		ExpiresAt:  time.Now().Add(time.Hour),
+	}
+}
+
+// expectRevoked checks which of the refresh tokens, by their hash, are revoked
+func expectRevoked(t *testing.T, repos repository.Repositories, revoked map[string]bool) {
+	t.Helper()
+	for tokenHash, want := range revoked {
+		token, err := repos.RefreshToken.FindByTokenHash(context.Background(), tokenHash)
+		mustNot(t, err)
+		if (token.RevokedAt != nil) != want {
+			t.Errorf("Expected refresh token %s revoked to be %v", tokenHash, want)
+		}
+	}
+}
+
+// expectStates checks the states are ordered by created_at DESC
+func expectStates(t *testing.T, payment *model.Payment, states ...enum.State) {
+	t.Helper()
+	if len(payment.PaymentStates) != len(states) {
+		t.Fatalf("Expected %d states, but got %d", len(states), len(payment.PaymentStates))
+	}
+	for i, state := range states {
+		if payment.PaymentStates[i].PaymentState != state {
+			t.Errorf("Expected state %s at %d, but got %s", state, i, payment.PaymentStates[i].PaymentState)
+		}
+	}
+}
+
+func expectNotFound(t *testing.T, err error) {
+	t.Helper()
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("Expected error %v, but got %v", gorm.ErrRecordNotFound, err)
+	}
+}
+
+func mustNot(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,96 @@
+package repository
+
+import (
+	"context"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type teamRepository struct {
+	DB *gorm.DB
+}
+
+type ITeamRepository interface {
+	FindMembershipByMerchantId(ctx context.Context, merchantId uuid.UUID) (*model.Membership, error)
+	FindMembershipsByOrganizationId(ctx context.Context, organizationId uuid.UUID) ([]model.Membership, error)
+	UpdateMembershipRole(ctx context.Context, organizationId uuid.UUID, merchantId uuid.UUID, role enum.Role) (bool, error)
+	DeleteMembership(ctx context.Context, organizationId uuid.UUID, merchantId uuid.UUID) (bool, error)
+	CreateInvitation(ctx context.Context, invitation *model.Invitation) error
+	FindInvitationByTokenHash(ctx context.Context, tokenHash string) (*model.Invitation, error)
+	MarkInvitationAccepted(ctx context.Context, id uuid.UUID) (bool, error)
+}
+
+func NewTeamRepository(db *gorm.DB) (ITeamRepository, error) {
+	return &teamRepository{db}, nil
+}
+
+func (r *teamRepository) FindMembershipByMerchantId(ctx context.Context, merchantId uuid.UUID) (*model.Membership, error) {
+	var membership model.Membership
+	result := r.DB.WithContext(ctx).Where("merchant_id = ?", merchantId).First(&membership)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &membership, nil
+}
+
+func (r *teamRepository) FindMembershipsByOrganizationId(ctx context.Context, organizationId uuid.UUID) ([]model.Membership, error) {
+	var memberships []model.Membership
+	result := r.DB.WithContext(ctx).Where("organization_id = ?", organizationId).Order("created_at").Find(&memberships)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return memberships, nil
+}
+
+// UpdateMembershipRole returns false if the merchant is not a member of the organization
+func (r *teamRepository) UpdateMembershipRole(ctx context.Context, organizationId uuid.UUID, merchantId uuid.UUID, role enum.Role) (bool, error) {
+	result := r.DB.WithContext(ctx).Model(&model.Membership{}).
+		Where("organization_id = ? AND merchant_id = ?", organizationId, merchantId).
+		Update("role", role)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// DeleteMembership returns false if the merchant is not a member of the organization.
+// The membership is deleted permanently, so the merchant can be added to a team again.
+func (r *teamRepository) DeleteMembership(ctx context.Context, organizationId uuid.UUID, merchantId uuid.UUID) (bool, error) {
+	result := r.DB.WithContext(ctx).Unscoped().Where("organization_id = ? AND merchant_id = ?", organizationId, merchantId).Delete(&model.Membership{})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+func (r *teamRepository) CreateInvitation(ctx context.Context, invitation *model.Invitation) error {
+	result := r.DB.WithContext(ctx).Create(&invitation)
+	if result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
+
+func (r *teamRepository) FindInvitationByTokenHash(ctx context.Context, tokenHash string) (*model.Invitation, error) {
+	var invitation model.Invitation
+	result := r.DB.WithContext(ctx).Where("token_hash = ?", tokenHash).First(&invitation)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &invitation, nil
+}
+
+// MarkInvitationAccepted returns false if the invitation was already accepted
+func (r *teamRepository) MarkInvitationAccepted(ctx context.Context, id uuid.UUID) (bool, error) {
+	result := r.DB.WithContext(ctx).Model(&model.Invitation{}).
+		Where("id = ? AND accepted_at IS NULL", id).
+		Update("accepted_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
@@ -0,0 +1,42 @@
+package repository
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// Repositories share the transaction of a unit of work
+type Repositories struct {
+	Merchant     IMerchantRepository
+	ApiKey       IApiKeyRepository
+	Payment      IPaymentRepository
+	Team         ITeamRepository
+	RefreshToken IRefreshTokenRepository
+}
+
+type IUnitOfWork interface {
+	// WithTx commits if fn returns nil and rolls back otherwise. Side effects like emails,
+	// webhooks or broadcasts belong after WithTx returned, never into fn.
+	WithTx(ctx context.Context, fn func(repos Repositories) error) error
+}
+
+type unitOfWork struct {
+	DB *gorm.DB
+}
+
+func NewUnitOfWork(db *gorm.DB) (IUnitOfWork, error) {
+	return &unitOfWork{db}, nil
+}
+
+func (u *unitOfWork) WithTx(ctx context.Context, fn func(repos Repositories) error) error {
+	return u.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return fn(Repositories{
+			Merchant:     &merchantRepository{tx},
+			ApiKey:       &apiKeyRepository{tx},
+			Payment:      &paymentRepository{tx},
+			Team:         &teamRepository{tx},
+			RefreshToken: &refreshTokenRepository{tx},
+		})
+	})
+}
@@ -0,0 +1,246 @@
+package service
+
+import (
+	"context"
+	"crypto/aes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/CHainGate/backend/internal/config"
+	"github.com/CHainGate/backend/internal/metrics"
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+)
+
+var (
+	ErrPaymentNotExpirable = errors.New("Payment already received funds or is final ")
+	ErrWrongEncryptionKey  = errors.New("Encryption key does not match the stored secrets ")
+)
+
+// KeyRotation counts the secrets which were encrypted with the new key
+type KeyRotation struct {
+	ApiKeys          int
+	TwoFactorSecrets int
+}
+
+// IAdminService is used by operators, there is no authorization, the caller has access to the database anyway
+type IAdminService interface {
+	ListMerchants(ctx context.Context) ([]model.Merchant, error)
+	FindMerchant(ctx context.Context, idOrEmail string) (*model.Merchant, error)
+	SetMerchantActive(ctx context.Context, idOrEmail string, active bool) (*model.Merchant, error)
+	FindPayment(ctx context.Context, id uuid.UUID) (*model.Payment, error)
+	ReplayWebhook(ctx context.Context, id uuid.UUID) (*model.Payment, error)
+	ExpirePayment(ctx context.Context, id uuid.UUID) (*model.Payment, error)
+	RotateEncryptionKey(ctx context.Context, oldKey string, newKey string) (*KeyRotation, error)
+}
+
+type adminService struct {
+	merchantRepository     repository.IMerchantRepository
+	paymentRepository      repository.IPaymentRepository
+	refreshTokenRepository repository.IRefreshTokenRepository
+	unitOfWork             repository.IUnitOfWork
+	internalPaymentService IInternalPaymentService
+}
+
+func NewAdminService(
+	merchantRepository repository.IMerchantRepository,
+	paymentRepository repository.IPaymentRepository,
+	refreshTokenRepository repository.IRefreshTokenRepository,
+	unitOfWork repository.IUnitOfWork,
+	internalPaymentService IInternalPaymentService,
+) IAdminService {
+	return &adminService{merchantRepository, paymentRepository, refreshTokenRepository, unitOfWork, internalPaymentService}
+}
+
+func (s *adminService) ListMerchants(ctx context.Context) ([]model.Merchant, error) {
+	return s.merchantRepository.FindAll(ctx)
+}
+
+// FindMerchant accepts the id or the email of the merchant
+func (s *adminService) FindMerchant(ctx context.Context, idOrEmail string) (*model.Merchant, error) {
+	id, err := uuid.Parse(idOrEmail)
+	if err == nil {
+		return s.merchantRepository.FindById(ctx, id)
+	}
+	return s.merchantRepository.FindByEmail(ctx, idOrEmail)
+}
+
+// SetMerchantActive deactivates the merchant or activates it without email verification.
+// A deactivated merchant cannot login or use its api keys, the sessions are revoked.
+func (s *adminService) SetMerchantActive(ctx context.Context, idOrEmail string, active bool) (*model.Merchant, error) {
+	merchant, err := s.FindMerchant(ctx, idOrEmail)
+	if err != nil {
+		return nil, err
+	}
+	if merchant.IsActive == active {
+		return merchant, nil
+	}
+
+	merchant.IsActive = active
+	if !active {
+		merchant.TokenVersion++
+	}
+	err = s.merchantRepository.Update(ctx, merchant)
+	if err != nil {
+		return nil, err
+	}
+	if !active {
+		err = s.refreshTokenRepository.RevokeAllByMerchantId(ctx, merchant.ID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	slog.InfoContext(ctx, "Merchant activation changed", "merchant_id", merchant.ID, "active", active)
+	return merchant, nil
+}
+
+// FindPayment returns the payment with its states, the latest first
+func (s *adminService) FindPayment(ctx context.Context, id uuid.UUID) (*model.Payment, error) {
+	return s.paymentRepository.FindByPaymentId(ctx, id)
+}
+
+func (s *adminService) ReplayWebhook(ctx context.Context, id uuid.UUID) (*model.Payment, error) {
+	payment, err := s.paymentRepository.FindByPaymentId(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return payment, s.internalPaymentService.SendWebhook(ctx, payment)
+}
+
+// ExpirePayment adds an expired state to a payment which did not receive funds yet or only a part of them.
+// The payment is locked, so an update of the blockchain service waits and is applied afterwards. The blockchain
+// service is not notified, if the buyer pays anyway its updates are still applied, e.g. the payment is paid after it expired.
+func (s *adminService) ExpirePayment(ctx context.Context, id uuid.UUID) (*model.Payment, error) {
+	var payment *model.Payment
+	err := s.unitOfWork.WithTx(ctx, func(repos repository.Repositories) error {
+		current, err := repos.Payment.FindByPaymentIdForUpdate(ctx, id)
+		if err != nil {
+			return err
+		}
+		currentState := current.PaymentStates[0]
+		if currentState.PaymentState != enum.CurrencySelection && currentState.PaymentState != enum.Waiting && currentState.PaymentState != enum.PartiallyPaid {
+			return ErrPaymentNotExpirable
+		}
+
+		expired := model.PaymentState{
+			BlockchainPaymentId: currentState.BlockchainPaymentId,
+			PayCurrency:         currentState.PayCurrency,
+			PayAddress:          currentState.PayAddress,
+			PaymentState:        enum.Expired,
+			PayAmount:           currentState.PayAmount,
+			ActuallyPaid:        currentState.ActuallyPaid,
+		}
+		if expired.PayAmount == nil {
+			expired.PayAmount = model.NewBigIntFromInt(0)
+		}
+		if expired.ActuallyPaid == nil {
+			expired.ActuallyPaid = model.NewBigIntFromInt(0)
+		}
+		current.PaymentStates = append(current.PaymentStates, expired)
+		err = repos.Payment.Update(ctx, current)
+		if err != nil {
+			return err
+		}
+
+		payment, err = repos.Payment.FindByPaymentId(ctx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	ctx = paymentLogContext(ctx, payment)
+	slog.InfoContext(ctx, "Payment expired by an operator")
+	metrics.PaymentStateTransitions.WithLabelValues(enum.Expired.String()).Inc()
+
+	if pool, ok := config.GetPool(payment.ID); ok {
+		pool.Publish(model.NewStateMessage(enum.Expired, model.NewSocketBody(payment, false)))
+	}
+	// the state is committed before the webhook is sent, if only the webhook failed the payment is expired anyway
+	return payment, s.internalPaymentService.SendWebhook(ctx, payment)
+}
+
+// RotateEncryptionKey encrypts the api keys and the two factor secrets with the new key, within one transaction.
+// The api keys given to the merchants are encrypted with the key as well, so they change. The old ones are
+// accepted as long as OLD_API_KEY_SECRET is set to the old key, see decryptApiKey.
+func (s *adminService) RotateEncryptionKey(ctx context.Context, oldKey string, newKey string) (*KeyRotation, error) {
+	if _, err := aes.NewCipher([]byte(newKey)); err != nil {
+		return nil, err
+	}
+
+	rotation := &KeyRotation{}
+	err := s.unitOfWork.WithTx(ctx, func(repos repository.Repositories) error {
+		keys, err := repos.ApiKey.FindAll(ctx)
+		if err != nil {
+			return err
+		}
+		for i := range keys {
+			keys[i].ApiKey, err = reencryptApiKey(&keys[i], oldKey, newKey)
+			if err != nil {
+				return fmt.Errorf("api key %s: %w", keys[i].ID, err)
+			}
+			err = repos.ApiKey.Update(ctx, &keys[i])
+			if err != nil {
+				return err
+			}
+			rotation.ApiKeys++
+		}
+
+		merchants, err := repos.Merchant.FindAll(ctx)
+		if err != nil {
+			return err
+		}
+		for i := range merchants {
+			if merchants[i].TwoFactor.Secret == "" {
+				continue
+			}
+			merchants[i].TwoFactor.Secret, err = reencryptTotpSecret(merchants[i].TwoFactor.Secret, oldKey, newKey)
+			if err != nil {
+				return fmt.Errorf("two factor secret of merchant %s: %w", merchants[i].ID, err)
+			}
+			err = repos.Merchant.Update(ctx, &merchants[i])
+			if err != nil {
+				return err
+			}
+			rotation.TwoFactorSecrets++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(ctx, "Encryption key rotated", "api_keys", rotation.ApiKeys, "two_factor_secrets", rotation.TwoFactorSecrets)
+	return rotation, nil
+}
+
+// reencryptApiKey decrypts both layers of the stored api key, see CreateApiKey. Decrypting with a wrong key
+// does not fail, the id in the combined key tells whether the old key is right.
+func reencryptApiKey(key *model.ApiKey, oldKey string, newKey string) (string, error) {
+	merchantKey, err := Decrypt([]byte(oldKey), key.ApiKey)
+	if err != nil {
+		return "", err
+	}
+	combinedKey, err := Decrypt([]byte(oldKey), merchantKey)
+	if err != nil || !strings.HasPrefix(combinedKey, key.ID.String()+"_") {
+		return "", ErrWrongEncryptionKey
+	}
+	merchantKey, err = encrypt([]byte(newKey), combinedKey)
+	if err != nil {
+		return "", err
+	}
+	return encrypt([]byte(newKey), merchantKey)
+}
+
+func reencryptTotpSecret(encryptedSecret string, oldKey string, newKey string) (string, error) {
+	secret, err := Decrypt([]byte(oldKey), encryptedSecret)
+	if err != nil {
+		return "", err
+	}
+	if _, err = totpEncoding.DecodeString(secret); err != nil {
+		return "", ErrWrongEncryptionKey
+	}
+	return encrypt([]byte(newKey), secret)
+}
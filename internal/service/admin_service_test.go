@@ -0,0 +1,247 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"gopkg.in/h2non/gock.v1"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/internal/utils"
+	"github.com/CHainGate/backend/internalApi"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+)
+
+// newAdminService uses a sqlite database, the key rotation needs a real transaction
+func newAdminService(t *testing.T) (IAdminService, repository.Repositories) {
+	db, err := repository.OpenSqlite(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("OpenSqlite: got error %s", err.Error())
+	}
+	t.Cleanup(func() {
+		conn, _ := db.DB()
+		_ = conn.Close()
+	})
+	migrator, err := repository.NewMigrator(db)
+	if err != nil {
+		t.Fatalf("NewMigrator: got error %s", err.Error())
+	}
+	if err = migrator.Up(); err != nil {
+		t.Fatalf("Up: got error %s", err.Error())
+	}
+
+	merchantRepo, _ := repository.NewMerchantRepository(db)
+	apiKeyRepo, _ := repository.NewApiKeyRepository(db)
+	paymentRepo, _ := repository.NewPaymentRepository(db)
+	refreshTokenRepo, _ := repository.NewRefreshTokenRepository(db)
+	unitOfWork, _ := repository.NewUnitOfWork(db)
+	internalPaymentService := NewInternalPaymentService(paymentRepo, apiKeyRepo, unitOfWork)
+	repos := repository.Repositories{Merchant: merchantRepo, ApiKey: apiKeyRepo, Payment: paymentRepo, RefreshToken: refreshTokenRepo}
+	return NewAdminService(merchantRepo, paymentRepo, refreshTokenRepo, unitOfWork, internalPaymentService), repos
+}
+
+func TestSetMerchantActive(t *testing.T) {
+	ctx := context.Background()
+	adminService, repos := newAdminService(t)
+	merchant := &model.Merchant{Email: "momo@mail.com", IsActive: true}
+	if err := repos.Merchant.Create(ctx, merchant); err != nil {
+		t.Fatal(err)
+	}
+
+	deactivated, err := adminService.SetMerchantActive(ctx, "momo@mail.com", false)
+	if err != nil {
+		t.Fatalf("SetMerchantActive: got error %s", err.Error())
+	}
+	if deactivated.IsActive || deactivated.TokenVersion != 1 {
+		t.Errorf("Expected an inactive merchant with revoked tokens, but got active %t and version %d", deactivated.IsActive, deactivated.TokenVersion)
+	}
+
+	activated, err := adminService.SetMerchantActive(ctx, merchant.ID.String(), true)
+	if err != nil {
+		t.Fatalf("SetMerchantActive: got error %s", err.Error())
+	}
+	found, err := repos.Merchant.FindById(ctx, merchant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !activated.IsActive || !found.IsActive || found.TokenVersion != 1 {
+		t.Errorf("Expected an active merchant with token version 1, but got active %t and version %d", found.IsActive, found.TokenVersion)
+	}
+
+	_, err = adminService.FindMerchant(ctx, uuid.NewString())
+	if err == nil {
+		t.Error("Expected an unknown merchant not to be found")
+	}
+}
+
+func TestRotateEncryptionKey(t *testing.T) {
+	ctx := context.Background()
+	adminService, repos := newAdminService(t)
+	oldKey := utils.Opts.ApiKeySecret
+	newKey := "newApiSecretKey1"
+
+	authenticationService, _ := newAuthenticationService()
+	key, err := authenticationService.CreateApiKey(ctx, enum.Test)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merchantKey, err := Decrypt([]byte(oldKey), key.ApiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	combinedKey, err := Decrypt([]byte(oldKey), merchantKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	totpSecret, err := generateTotpSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedSecret, err := encrypt([]byte(oldKey), totpSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merchant := &model.Merchant{
+		Email:     "momo@mail.com",
+		IsActive:  true,
+		TwoFactor: model.TwoFactor{Secret: encryptedSecret, Enabled: true},
+		ApiKeys:   []model.ApiKey{*key},
+	}
+	if err = repos.Merchant.Create(ctx, merchant); err != nil {
+		t.Fatal(err)
+	}
+	if err = repos.Merchant.Create(ctx, &model.Merchant{Email: "lulu@mail.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = adminService.RotateEncryptionKey(ctx, "wrongApiSecretKy", newKey)
+	if !errors.Is(err, ErrWrongEncryptionKey) {
+		t.Fatalf("Expected wrong encryption key error, but got %v", err)
+	}
+
+	rotation, err := adminService.RotateEncryptionKey(ctx, oldKey, newKey)
+	if err != nil {
+		t.Fatalf("RotateEncryptionKey: got error %s", err.Error())
+	}
+	if rotation.ApiKeys != 1 || rotation.TwoFactorSecrets != 1 {
+		t.Errorf("Expected 1 api key and 1 two factor secret, but got %+v", rotation)
+	}
+
+	found, err := repos.Merchant.FindById(ctx, merchant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decryptedSecret, _ := Decrypt([]byte(newKey), found.TwoFactor.Secret)
+	if decryptedSecret != totpSecret {
+		t.Errorf("Expected the two factor secret to be encrypted with the new key")
+	}
+	rotatedKey, err := repos.ApiKey.FindById(ctx, key.ID.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	newMerchantKey, _ := Decrypt([]byte(newKey), rotatedKey.ApiKey)
+	rotatedCombinedKey, _ := Decrypt([]byte(newKey), newMerchantKey)
+	if rotatedCombinedKey != combinedKey || newMerchantKey == merchantKey {
+		t.Errorf("Expected the api key to be encrypted with the new key")
+	}
+
+	defer func() {
+		utils.Opts.ApiKeySecret = oldKey
+		utils.Opts.OldApiKeySecret = ""
+	}()
+	utils.Opts.ApiKeySecret = newKey
+	authenticationService = NewAuthenticationService(repos.Merchant, repos.ApiKey, repos.RefreshToken, nil)
+	if _, _, err = authenticationService.HandleApiAuthentication(ctx, merchantKey); err == nil {
+		t.Error("Expected the old api key to be rejected without OLD_API_KEY_SECRET")
+	}
+	utils.Opts.OldApiKeySecret = oldKey
+	for name, apiKey := range map[string]string{"old": merchantKey, "new": newMerchantKey} {
+		if _, _, err = authenticationService.HandleApiAuthentication(ctx, apiKey); err != nil {
+			t.Errorf("Expected the %s api key to be accepted, but got %s", name, err.Error())
+		}
+	}
+}
+
+func TestExpirePaymentRejected(t *testing.T) {
+	ctx := context.Background()
+	adminService, repos := newAdminService(t)
+	merchant := &model.Merchant{Email: "momo@mail.com", Wallets: []model.Wallet{{Currency: enum.ETH, Mode: enum.Test, Address: "0x1"}}}
+	if err := repos.Merchant.Create(ctx, merchant); err != nil {
+		t.Fatal(err)
+	}
+	payment := &model.Payment{
+		MerchantId:  merchant.ID,
+		Mode:        enum.Test,
+		PayCurrency: enum.ETH,
+		Wallet:      &merchant.Wallets[0],
+		PaymentStates: []model.PaymentState{
+			{PaymentState: enum.Paid, PayAmount: model.NewBigIntFromInt(10), ActuallyPaid: model.NewBigIntFromInt(10)},
+		},
+	}
+	if err := repos.Payment.Create(ctx, payment); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := adminService.ExpirePayment(ctx, payment.ID)
+	if !errors.Is(err, ErrPaymentNotExpirable) {
+		t.Fatalf("Expected payment not expirable error, but got %v", err)
+	}
+	found, err := adminService.FindPayment(ctx, payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found.PaymentStates) != 1 {
+		t.Errorf("Expected no state to be added, but got %d states", len(found.PaymentStates))
+	}
+}
+
+func TestExpirePaymentConcurrentlyWithPaidUpdate(t *testing.T) {
+	defer gock.Off()
+	mockWebhooks()
+	for i := 0; i < 10; i++ {
+		publicService, internalService, _, repos := newPaymentServices()
+		adminService := NewAdminService(repos.Merchant, repos.Payment, repos.RefreshToken, publicService.unitOfWork, internalService)
+		payment := createEthInvoice(t, repos)
+
+		var wg sync.WaitGroup
+		var expireErr error
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, expireErr = adminService.ExpirePayment(context.Background(), payment.ID)
+		}()
+		go func() {
+			defer wg.Done()
+			_ = internalService.HandlePaymentUpdate(context.Background(), internalApi.PaymentUpdateDto{
+				PaymentId:    payment.BlockchainPaymentId.String(),
+				PayAmount:    "1000",
+				PayCurrency:  enum.ETH.String(),
+				ActuallyPaid: "1000",
+				PaymentState: enum.Paid.String(),
+				TxHash:       "0xabc",
+			})
+		}()
+		wg.Wait()
+
+		found, err := repos.Payment.FindByPaymentId(context.Background(), payment.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found.PaymentStates[0].PaymentState != enum.Paid {
+			t.Fatalf("Expected the paid update to be applied, but got %s", found.PaymentStates[0].PaymentState)
+		}
+		// either the payment expired before it was paid or it could not be expired anymore
+		expired := found.PaymentStates[1].PaymentState == enum.Expired
+		if expired && len(found.PaymentStates) != 4 {
+			t.Errorf("Expected the expired state before the paid state, but got %d states", len(found.PaymentStates))
+		}
+		if !expired && !errors.Is(expireErr, ErrPaymentNotExpirable) {
+			t.Errorf("Expected payment not expirable error, but got %v", expireErr)
+		}
+	}
+}
@@ -3,10 +3,13 @@ package service
 import (
 	"context"
 	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"math/big"
+	"log/slog"
 	"net/url"
-	"strconv"
 	"strings"
 	"time"
 
@@ -21,30 +24,108 @@ import (
 	"gorm.io/gorm"
 )
 
-const jwtDuration = time.Hour * 24
+const jwtDuration = time.Minute * 15
+const refreshTokenDuration = time.Hour * 24 * 30
+const passwordResetDuration = time.Hour * 1
+const minPasswordLength = 8
+const emailVerificationDuration = time.Hour * 24
+const verificationResendInterval = time.Minute * 1
+const maxVerificationAttempts = 5
+const maxFailedLogins = 10
+const loginLockDuration = time.Minute * 15
+
+var (
+	ErrInvalidResetToken = errors.New("Password reset link is invalid or expired ")
+	ErrWrongPassword     = errors.New("Wrong password ")
+	ErrPasswordTooShort  = errors.New("Password must be at least 8 characters long ")
+	ErrTokenRevoked      = errors.New("token revoked")
+
+	ErrInvalidCredentials   = errors.New("Email or password wrong ")
+	ErrMerchantNotActive    = errors.New("Please verify your E-Mail first ")
+	ErrTooManyLoginAttempts = errors.New("Too many failed logins, please try again later ")
+
+	ErrInvalidRefreshToken = errors.New("Session expired, please login again ")
+
+	ErrInvalidVerification = errors.New("Wrong verification link ")
+	ErrVerificationExpired = errors.New("Verification link expired, please request a new one ")
+	ErrVerificationLocked  = errors.New("Too many wrong attempts, please request a new verification link ")
+	ErrResendTooSoon       = errors.New("Verification E-Mail was just sent, please try again later ")
+)
 
 type IAuthenticationService interface {
-	HandleJwtAuthentication(bearer string) (*model.Merchant, error)
-	HandleLogin(email string, password string) (string, error)
-	HandleApiAuthentication(apiKey string) (*model.Merchant, *model.ApiKey, error)
-	CreateApiKey(mode enum.Mode) (*model.ApiKey, error)
-	CreateMerchant(registerRequestDto configApi.RegisterRequestDto) error
-	HandleVerification(email string, verificationCode int64) error
+	HandleJwtAuthentication(ctx context.Context, bearer string) (*model.Merchant, error)
+	HandleLogin(ctx context.Context, email string, password string, clientIp string) (*LoginResult, error)
+	CompleteLogin(ctx context.Context, mfaToken string, code string) (*AuthTokens, error)
+	RefreshSession(ctx context.Context, refreshToken string) (*AuthTokens, error)
+	Logout(ctx context.Context, refreshToken string) error
+	LogoutAll(ctx context.Context, merchant *model.Merchant) error
+	HandleApiAuthentication(ctx context.Context, apiKey string) (*model.Merchant, *model.ApiKey, error)
+	CreateApiKey(ctx context.Context, mode enum.Mode) (*model.ApiKey, error)
+	CreateMerchant(ctx context.Context, registerRequestDto configApi.RegisterRequestDto) error
+	HandleVerification(ctx context.Context, email string, token string) error
+	ResendVerification(ctx context.Context, email string) error
+	RequestPasswordReset(ctx context.Context, email string) error
+	ResetPassword(ctx context.Context, token string, newPassword string) error
+	ChangePassword(ctx context.Context, merchant *model.Merchant, oldPassword string, newPassword string, clientIp string) error
+	EnrolTwoFactor(ctx context.Context, merchant *model.Merchant) (*TwoFactorEnrolment, error)
+	ConfirmTwoFactor(ctx context.Context, merchant *model.Merchant, code string) ([]string, error)
+	DisableTwoFactor(ctx context.Context, merchant *model.Merchant, code string) error
+	VerifySecondFactor(ctx context.Context, merchant *model.Merchant, code string) error
+}
+
+// AuthTokens is a short-lived access token and the refresh token to get a new one
+type AuthTokens struct {
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    time.Duration
+}
+
+// LoginResult either contains the tokens or, if two-factor authentication is enabled,
+// the token for the second login step
+type LoginResult struct {
+	Tokens   *AuthTokens
+	MfaToken string
+}
+
+// LoginThrottledError is returned while logins are delayed or the account is locked
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+}
+
+func (e *LoginThrottledError) Error() string {
+	return ErrTooManyLoginAttempts.Error()
+}
+
+func (e *LoginThrottledError) Unwrap() error {
+	return ErrTooManyLoginAttempts
+}
+
+type jwtClaims struct {
+	FirstName string `json:"firstName"`
+	Version   int    `json:"ver"`
+	Purpose   string `json:"purpose,omitempty"`
+	jwt.RegisteredClaims
 }
 
 type authenticationService struct {
-	merchantRepository repository.IMerchantRepository
-	apiKeyRepository   repository.IApiKeyRepository
+	merchantRepository     repository.IMerchantRepository
+	apiKeyRepository       repository.IApiKeyRepository
+	refreshTokenRepository repository.IRefreshTokenRepository
+	unitOfWork             repository.IUnitOfWork
+	loginThrottle          *loginThrottle
+	verificationResends    *resendThrottle
 }
 
 func NewAuthenticationService(
 	merchantRepository repository.IMerchantRepository,
 	apiKeyRepository repository.IApiKeyRepository,
+	refreshTokenRepository repository.IRefreshTokenRepository,
+	unitOfWork repository.IUnitOfWork,
 ) IAuthenticationService {
-	return &authenticationService{merchantRepository, apiKeyRepository}
+	return &authenticationService{merchantRepository, apiKeyRepository, refreshTokenRepository, unitOfWork, newLoginThrottle(), newResendThrottle()}
 }
 
-func (s *authenticationService) HandleJwtAuthentication(bearer string) (*model.Merchant, error) {
+func (s *authenticationService) HandleJwtAuthentication(ctx context.Context, bearer string) (*model.Merchant, error) {
 	bearerToken := strings.Split(bearer, " ")
 	claims, err := decodeJwtToken(bearerToken[1])
 	if err != nil {
@@ -54,44 +135,204 @@ func (s *authenticationService) HandleJwtAuthentication(bearer string) (*model.M
 	if claims.ExpiresAt.Time.Before(time.Now()) {
 		return nil, errors.New("token expired")
 	}
-	merchant, err := s.merchantRepository.FindByEmail(claims.Issuer)
+	merchant, err := s.merchantRepository.FindByEmail(ctx, claims.Issuer)
 	if err != nil {
 		return nil, err
 	}
 
+	if isTokenRevoked(claims, merchant) {
+		return nil, ErrTokenRevoked
+	}
+	if claims.Purpose != "" {
+		return nil, errors.New("not an access token")
+	}
+
 	return merchant, nil
 }
 
-func (s *authenticationService) HandleLogin(email string, password string) (string, error) {
-	merchant, err := s.merchantRepository.FindByEmail(email)
+// HandleLogin checks the credentials. Failed logins are delayed per client ip and email and
+// the account is locked after maxFailedLogins. Unknown emails and wrong passwords return the same error.
+func (s *authenticationService) HandleLogin(ctx context.Context, email string, password string, clientIp string) (*LoginResult, error) {
+	ipKey := "ip:" + clientIp
+	emailKey := "email:" + strings.ToLower(email)
+	if wait := s.loginThrottle.retryAfter(ipKey, emailKey); wait > 0 {
+		return nil, &LoginThrottledError{RetryAfter: wait}
+	}
+
+	merchant, err := s.merchantRepository.FindByEmail(ctx, email)
 	if errors.Is(err, gorm.ErrRecordNotFound) {
-		return "", errors.New("Email or password wrong ")
+		// hash anyway, so the response time does not reveal whether the email exists
+		_, _ = scryptPassword(password, make([]byte, PwSaltBytes))
+		s.loginThrottle.fail(ipKey, emailKey)
+		return nil, ErrInvalidCredentials
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if merchant.LockedUntil != nil && merchant.LockedUntil.After(time.Now()) {
+		return nil, &LoginThrottledError{RetryAfter: time.Until(*merchant.LockedUntil)}
 	}
 
 	err = canMerchantLogin(merchant, password)
+	if errors.Is(err, ErrInvalidCredentials) {
+		s.loginThrottle.fail(ipKey, emailKey)
+		return nil, s.recordFailedLogin(ctx, merchant, ErrInvalidCredentials)
+	}
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+
+	s.loginThrottle.reset(emailKey)
+	if merchant.FailedLogins > 0 || merchant.LockedUntil != nil {
+		err = s.merchantRepository.ResetFailedLogins(ctx, merchant.ID)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	token, err := createJwtToken(merchant.Email, merchant.FirstName, jwtDuration)
+	if merchant.TwoFactor.Enabled {
+		mfaToken, err := createMfaToken(merchant)
+		if err != nil {
+			return nil, err
+		}
+		return &LoginResult{MfaToken: mfaToken}, nil
+	}
+
+	tokens, err := s.createSession(ctx, merchant, uuid.New())
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return &LoginResult{Tokens: tokens}, nil
+}
 
-	return token, nil
+// recordFailedLogin locks the account after too many failed logins and notifies the merchant,
+// it returns the error of the failure, e.g. wrong credentials or a wrong two-factor code
+func (s *authenticationService) recordFailedLogin(ctx context.Context, merchant *model.Merchant, failure error) error {
+	failedLogins, err := s.merchantRepository.RecordFailedLogin(ctx, merchant.ID)
+	if err != nil {
+		return err
+	}
+	if failedLogins < maxFailedLogins {
+		return failure
+	}
+
+	lockedUntil := time.Now().Add(loginLockDuration)
+	err = s.merchantRepository.LockMerchant(ctx, merchant.ID, lockedUntil)
+	if err != nil {
+		return err
+	}
+
+	err = sendLockoutEmail(ctx, merchant, lockedUntil)
+	if err != nil {
+		slog.ErrorContext(ctx, "Could not send lockout email", "merchant_id", merchant.ID, "error", err)
+	}
+	return failure
 }
 
-func (s *authenticationService) HandleApiAuthentication(apiKey string) (*model.Merchant, *model.ApiKey, error) {
-	decryptedApiKey, err := Decrypt([]byte(utils.Opts.ApiKeySecret), apiKey)
+// RefreshSession replaces the refresh token with a new one. If an already replaced
+// token is used again, it was probably stolen and the whole session is revoked.
+func (s *authenticationService) RefreshSession(ctx context.Context, refreshToken string) (*AuthTokens, error) {
+	current, err := s.refreshTokenRepository.FindByTokenHash(ctx, hashToken(refreshToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidRefreshToken
+	}
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+
+	if current.RevokedAt != nil {
+		err := s.refreshTokenRepository.RevokeFamily(ctx, current.FamilyId)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+	if current.ExpiresAt.Before(time.Now()) {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	revoked, err := s.refreshTokenRepository.Revoke(ctx, current.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !revoked {
+		// a concurrent refresh used the same token
+		err := s.refreshTokenRepository.RevokeFamily(ctx, current.FamilyId)
+		if err != nil {
+			return nil, err
+		}
+		return nil, ErrInvalidRefreshToken
+	}
+
+	merchant, err := s.merchantRepository.FindById(ctx, current.MerchantId)
+	if err != nil {
+		return nil, err
+	}
+	if !merchant.IsActive {
+		return nil, ErrInvalidRefreshToken
+	}
+
+	return s.createSession(ctx, merchant, current.FamilyId)
+}
+
+// Logout revokes the session of the refresh token, unknown tokens are ignored
+func (s *authenticationService) Logout(ctx context.Context, refreshToken string) error {
+	current, err := s.refreshTokenRepository.FindByTokenHash(ctx, hashToken(refreshToken))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return s.refreshTokenRepository.RevokeFamily(ctx, current.FamilyId)
+}
+
+// LogoutAll revokes all sessions and access tokens of the merchant
+func (s *authenticationService) LogoutAll(ctx context.Context, merchant *model.Merchant) error {
+	merchant.TokenVersion++
+	err := s.merchantRepository.Update(ctx, merchant)
+	if err != nil {
+		return err
+	}
+	return s.refreshTokenRepository.RevokeAllByMerchantId(ctx, merchant.ID)
+}
+
+func (s *authenticationService) createSession(ctx context.Context, merchant *model.Merchant, familyId uuid.UUID) (*AuthTokens, error) {
+	accessToken, err := createJwtToken(merchant.Email, merchant.FirstName, merchant.TokenVersion, jwtDuration)
+	if err != nil {
+		return nil, err
 	}
 
-	apiKeyDetails := strings.Split(decryptedApiKey, "_")
-	apiKeyId := apiKeyDetails[0]
-	apiKeySecret := apiKeyDetails[1]
+	refreshToken, err := createToken()
+	if err != nil {
+		return nil, err
+	}
 
-	currentApiKey, err := s.apiKeyRepository.FindById(apiKeyId)
+	err = s.refreshTokenRepository.Create(ctx, &model.RefreshToken{
+		MerchantId: merchant.ID,
+		FamilyId:   familyId,
+		TokenHash:  hashToken(refreshToken),
+		ExpiresAt:  time.Now().Add(refreshTokenDuration),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &AuthTokens{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		ExpiresIn:    jwtDuration,
+	}, nil
+}
+
+func (s *authenticationService) HandleApiAuthentication(ctx context.Context, apiKey string) (*model.Merchant, *model.ApiKey, error) {
+	apiKeyId, apiKeySecret, err := decryptApiKey(apiKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	currentApiKey, err := s.apiKeyRepository.FindById(ctx, apiKeyId)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -105,15 +346,39 @@ func (s *authenticationService) HandleApiAuthentication(apiKey string) (*model.M
 		return nil, nil, errors.New("not authorized")
 	}
 
-	merchant, err := s.merchantRepository.FindById(currentApiKey.MerchantId)
+	merchant, err := s.merchantRepository.FindById(ctx, currentApiKey.MerchantId)
 	if err != nil {
 		return nil, nil, err
 	}
+	// deactivated by an operator
+	if !merchant.IsActive {
+		return nil, nil, errors.New("not authorized")
+	}
 
 	return merchant, currentApiKey, nil
 }
 
-func (s *authenticationService) CreateApiKey(mode enum.Mode) (*model.ApiKey, error) {
+// decryptApiKey returns the id and the secret of the api key of a merchant. After a key rotation the merchants
+// keep using their old api keys until they copied the new ones, they are decrypted with OLD_API_KEY_SECRET.
+// Decrypting with a wrong key does not fail, the id tells which key is right.
+func decryptApiKey(apiKey string) (string, string, error) {
+	for _, key := range []string{utils.Opts.ApiKeySecret, utils.Opts.OldApiKeySecret} {
+		if key == "" {
+			continue
+		}
+		decryptedApiKey, err := Decrypt([]byte(key), apiKey)
+		if err != nil {
+			return "", "", err
+		}
+		apiKeyId, apiKeySecret, ok := strings.Cut(decryptedApiKey, "_")
+		if _, err = uuid.Parse(apiKeyId); ok && err == nil {
+			return apiKeyId, apiKeySecret, nil
+		}
+	}
+	return "", "", errors.New("not authorized")
+}
+
+func (s *authenticationService) CreateApiKey(ctx context.Context, mode enum.Mode) (*model.ApiKey, error) {
 	apiKeySecret, err := generateApiKeySecret()
 	if err != nil {
 		return nil, err
@@ -152,24 +417,79 @@ func (s *authenticationService) CreateApiKey(mode enum.Mode) (*model.ApiKey, err
 	return &key, nil
 }
 
-func (s *authenticationService) HandleVerification(email string, verificationCode int64) error {
-	merchant, err := s.merchantRepository.FindByEmail(email)
+func (s *authenticationService) HandleVerification(ctx context.Context, email string, token string) error {
+	merchant, err := s.merchantRepository.FindByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidVerification
+	}
 	if err != nil {
 		return err
 	}
+	if merchant.IsActive {
+		return nil
+	}
 
-	if merchant.EmailVerification.VerificationCode == uint64(verificationCode) {
-		merchant.IsActive = true
-		err := s.merchantRepository.Update(merchant)
+	verification := &merchant.EmailVerification
+	if verification.Attempts >= maxVerificationAttempts {
+		return ErrVerificationLocked
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashToken(token)), []byte(verification.TokenHash)) != 1 {
+		verification.Attempts++
+		err := s.merchantRepository.UpdateEmailVerification(ctx, verification)
 		if err != nil {
 			return err
 		}
+		return ErrInvalidVerification
+	}
+
+	if verification.ExpiresAt.Before(time.Now()) {
+		return ErrVerificationExpired
+	}
+
+	merchant.IsActive = true
+	return s.merchantRepository.Update(ctx, merchant)
+}
+
+// ResendVerification sends a new verification link and resets the attempt counter.
+// The interval applies to every email, unknown and already verified accounts are ignored silently,
+// so the answer does not reveal whether an account exists.
+func (s *authenticationService) ResendVerification(ctx context.Context, email string) error {
+	if !s.verificationResends.allow(strings.ToLower(email), verificationResendInterval) {
+		return ErrResendTooSoon
+	}
+
+	merchant, err := s.merchantRepository.FindByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if merchant.IsActive {
 		return nil
 	}
-	return errors.New("Wrong verification code ")
+
+	verification := &merchant.EmailVerification
+	// e.g. the email of the registration, another replica answers the same way for an unknown account
+	if time.Since(verification.SentAt) < verificationResendInterval {
+		return nil
+	}
+
+	token, err := newEmailVerification(verification)
+	if err != nil {
+		return err
+	}
+
+	err = s.merchantRepository.UpdateEmailVerification(ctx, verification)
+	if err != nil {
+		return err
+	}
+
+	return sendVerificationEmail(ctx, merchant, token)
 }
 
-func (s *authenticationService) CreateMerchant(registerRequestDto configApi.RegisterRequestDto) error {
+func (s *authenticationService) CreateMerchant(ctx context.Context, registerRequestDto configApi.RegisterRequestDto) error {
 	//TODO: maybe use password validator https://github.com/wagslane/go-password-validator
 	salt, err := createSalt()
 	if err != nil {
@@ -181,99 +501,287 @@ func (s *authenticationService) CreateMerchant(registerRequestDto configApi.Regi
 		return err
 	}
 
-	verificationCode, err := createVerificationCode()
+	merchant := model.Merchant{
+		FirstName: registerRequestDto.FirstName,
+		LastName:  registerRequestDto.LastName,
+		Email:     registerRequestDto.Email,
+		Password:  encryptedPassword,
+		Salt:      salt,
+		IsActive:  false,
+	}
+
+	token, err := newEmailVerification(&merchant.EmailVerification)
 	if err != nil {
 		return err
 	}
 
-	emailVerification := model.EmailVerification{
-		VerificationCode: verificationCode.Uint64(),
+	err = s.merchantRepository.Create(ctx, &merchant)
+	if err != nil {
+		return err
 	}
 
-	merchant := model.Merchant{
-		FirstName:         registerRequestDto.FirstName,
-		LastName:          registerRequestDto.LastName,
-		Email:             registerRequestDto.Email,
-		Password:          encryptedPassword,
-		Salt:              salt,
-		EmailVerification: emailVerification,
-		IsActive:          false,
+	// if the email cannot be sent, the merchant can request a new one with ResendVerification
+	return sendVerificationEmail(ctx, &merchant, token)
+}
+
+// RequestPasswordReset sends a reset link to the merchant. Unknown or inactive
+// accounts are silently ignored, so the endpoint cannot be used to probe for emails.
+func (s *authenticationService) RequestPasswordReset(ctx context.Context, email string) error {
+	merchant, err := s.merchantRepository.FindByEmail(ctx, email)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !merchant.IsActive {
+		return nil
 	}
 
-	err = sendVerificationEmail(&merchant)
+	token, err := createToken()
 	if err != nil {
 		return err
 	}
 
-	err = s.merchantRepository.Create(&merchant)
+	merchant.PasswordResets = append(merchant.PasswordResets, model.PasswordReset{
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(passwordResetDuration),
+	})
+	err = s.merchantRepository.Update(ctx, merchant)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return sendPasswordResetEmail(ctx, merchant, token)
+}
+
+func (s *authenticationService) ResetPassword(ctx context.Context, token string, newPassword string) error {
+	err := validatePassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	reset, err := s.merchantRepository.FindPasswordResetByTokenHash(ctx, hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidResetToken
+	}
+	if err != nil {
+		return err
+	}
+	if reset.UsedAt != nil || reset.ExpiresAt.Before(time.Now()) {
+		return ErrInvalidResetToken
+	}
+
+	salt, encryptedPassword, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	// the token is only used up together with the password change
+	return s.unitOfWork.WithTx(ctx, func(repos repository.Repositories) error {
+		// marking the token as used is atomic, so two concurrent requests cannot both reset the password
+		marked, err := repos.Merchant.MarkPasswordResetUsed(ctx, reset.ID)
+		if err != nil {
+			return err
+		}
+		if !marked {
+			return ErrInvalidResetToken
+		}
+
+		merchant, err := repos.Merchant.FindById(ctx, reset.MerchantId)
+		if err != nil {
+			return err
+		}
+		return savePassword(ctx, repos, merchant, salt, encryptedPassword)
+	})
+}
+
+func (s *authenticationService) ChangePassword(ctx context.Context, merchant *model.Merchant, oldPassword string, newPassword string, clientIp string) error {
+	// a stolen session must not allow to guess the password, wrong passwords count like failed logins
+	ipKey := "ip:" + clientIp
+	emailKey := "email:" + strings.ToLower(merchant.Email)
+	if wait := s.loginThrottle.retryAfter(ipKey, emailKey); wait > 0 {
+		return &LoginThrottledError{RetryAfter: wait}
+	}
+	if merchant.LockedUntil != nil && merchant.LockedUntil.After(time.Now()) {
+		return &LoginThrottledError{RetryAfter: time.Until(*merchant.LockedUntil)}
+	}
+
+	encryptedPassword, err := scryptPassword(oldPassword, merchant.Salt)
+	if err != nil {
+		return err
+	}
+	if subtle.ConstantTimeCompare([]byte(encryptedPassword), []byte(merchant.Password)) != 1 {
+		s.loginThrottle.fail(ipKey, emailKey)
+		return s.recordFailedLogin(ctx, merchant, ErrWrongPassword)
+	}
+	s.loginThrottle.reset(emailKey)
+
+	err = validatePassword(newPassword)
+	if err != nil {
+		return err
+	}
+
+	salt, encryptedPassword, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
+	return s.unitOfWork.WithTx(ctx, func(repos repository.Repositories) error {
+		return savePassword(ctx, repos, merchant, salt, encryptedPassword)
+	})
+}
+
+// hashPassword returns a new salt and the password hashed with it
+func hashPassword(password string) ([]byte, string, error) {
+	salt, err := createSalt()
+	if err != nil {
+		return nil, "", err
+	}
+
+	encryptedPassword, err := scryptPassword(password, salt)
+	if err != nil {
+		return nil, "", err
+	}
+	return salt, encryptedPassword, nil
+}
+
+// savePassword rotates the salt and password. All sessions and tokens issued before the change are revoked.
+func savePassword(ctx context.Context, repos repository.Repositories, merchant *model.Merchant, salt []byte, encryptedPassword string) error {
+	merchant.Salt = salt
+	merchant.Password = encryptedPassword
+	merchant.TokenVersion++
+	merchant.FailedLogins = 0
+	merchant.LockedUntil = nil
+	err := repos.Merchant.Update(ctx, merchant)
+	if err != nil {
+		return err
+	}
+	return repos.RefreshToken.RevokeAllByMerchantId(ctx, merchant.ID)
 }
 
-func createJwtToken(issuer string, firstName string, duration time.Duration) (string, error) {
-	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"iss":       issuer,
-		"firstName": firstName,
-		"exp":       jwt.NewNumericDate(time.Now().Add(duration)),
+func createJwtToken(issuer string, firstName string, version int, duration time.Duration) (string, error) {
+	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwtClaims{
+		FirstName: firstName,
+		Version:   version,
+		RegisteredClaims: jwt.RegisteredClaims{
+			Issuer:    issuer,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(duration)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
 	})
 
 	return claims.SignedString([]byte(utils.Opts.JwtSecret))
 }
 
-func sendVerificationEmail(merchant *model.Merchant) error {
+func sendVerificationEmail(ctx context.Context, merchant *model.Merchant, token string) error {
 	baseUrl, err := url.Parse(utils.Opts.EmailVerificationUrl)
 	if err != nil {
 		return err
 	}
 	params := url.Values{}
 	params.Add("email", merchant.Email)
-	params.Add("code", strconv.FormatUint(merchant.EmailVerification.VerificationCode, 10))
+	params.Add("token", token)
 
 	baseUrl.RawQuery = params.Encode()
 
 	content := "Please Verify your E-Mail: " + baseUrl.String()
-	email := *proxyClientApi.NewEmailRequestDto(merchant.FirstName, merchant.Email, "Verify your E-Mail", content)
+	return sendEmail(ctx, merchant.FirstName, merchant.Email, "Verify your E-Mail", content)
+}
+
+func sendPasswordResetEmail(ctx context.Context, merchant *model.Merchant, token string) error {
+	baseUrl, err := url.Parse(utils.Opts.PasswordResetUrl)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Add("token", token)
+
+	baseUrl.RawQuery = params.Encode()
+
+	content := "Reset your password within the next hour: " + baseUrl.String() +
+		"\nIf you did not request a password reset, you can ignore this E-Mail."
+	return sendEmail(ctx, merchant.FirstName, merchant.Email, "Reset your password", content)
+}
+
+func sendLockoutEmail(ctx context.Context, merchant *model.Merchant, lockedUntil time.Time) error {
+	content := "Your account was locked until " + lockedUntil.UTC().Format(time.RFC1123) + " after too many failed logins." +
+		"\nIf this was not you, please reset your password: " + utils.Opts.PasswordResetUrl
+	return sendEmail(ctx, merchant.FirstName, merchant.Email, "Your account was locked", content)
+}
+
+func sendEmail(ctx context.Context, name string, emailTo string, subject string, content string) error {
+	email := *proxyClientApi.NewEmailRequestDto(name, emailTo, subject, content)
 	configuration := proxyClientApi.NewConfiguration()
 	configuration.Servers[0].URL = utils.Opts.ProxyBaseUrl
+	configuration.HTTPClient = proxyHttpClient()
 	apiClient := proxyClientApi.NewAPIClient(configuration)
-	_, err = apiClient.EmailApi.SendEmail(context.Background()).EmailRequestDto(email).Execute()
+	ctx, cancel := proxyContext(ctx)
+	defer cancel()
+	_, err := apiClient.EmailApi.SendEmail(ctx).EmailRequestDto(email).Execute()
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
+// canMerchantLogin only reveals that the account is not active to someone knowing the password
 func canMerchantLogin(merchant *model.Merchant, password string) error {
-	if !merchant.IsActive {
-		return errors.New("Merchant not active ")
-	}
 	encryptedPassword, err := scryptPassword(password, merchant.Salt)
 	if err != nil {
 		return err
 	}
-	if encryptedPassword != merchant.Password {
-		return errors.New("Wrong username or password ")
+	if subtle.ConstantTimeCompare([]byte(encryptedPassword), []byte(merchant.Password)) != 1 {
+		return ErrInvalidCredentials
+	}
+	if !merchant.IsActive {
+		return ErrMerchantNotActive
 	}
 	return nil
 }
 
-func createVerificationCode() (*big.Int, error) {
-	// 6 digits
-	max := big.NewInt(1000000)
-	min := big.NewInt(100000)
-	verificationCode, err := rand.Int(rand.Reader, max.Sub(max, min))
+// newEmailVerification resets the verification with a new token and returns the token
+func newEmailVerification(verification *model.EmailVerification) (string, error) {
+	token, err := createToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	verification.TokenHash = hashToken(token)
+	verification.ExpiresAt = now.Add(emailVerificationDuration)
+	verification.SentAt = now
+	verification.Attempts = 0
+	return token, nil
+}
+
+// createToken returns a random url safe token, only its hash is stored
+func createToken() (string, error) {
+	b := make([]byte, 32)
+	_, err := rand.Read(b)
 	if err != nil {
-		return nil, errors.New("Cannot generate verification code ")
+		return "", errors.New("Cannot generate token ")
 	}
-	verificationCode.Add(verificationCode, min)
-	return verificationCode, nil
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
+
+func validatePassword(password string) error {
+	if len(password) < minPasswordLength {
+		return ErrPasswordTooShort
+	}
+	return nil
+}
+
+// isTokenRevoked reports whether the token was issued before the last password change or logout of all sessions
+func isTokenRevoked(claims *jwtClaims, merchant *model.Merchant) bool {
+	return claims.Version != merchant.TokenVersion
 }
 
-func decodeJwtToken(jwtToken string) (*jwt.RegisteredClaims, error) {
-	token, err := jwt.ParseWithClaims(jwtToken, &jwt.RegisteredClaims{}, func(token *jwt.Token) (interface{}, error) {
+func decodeJwtToken(jwtToken string) (*jwtClaims, error) {
+	token, err := jwt.ParseWithClaims(jwtToken, &jwtClaims{}, func(token *jwt.Token) (interface{}, error) {
 		return []byte(utils.Opts.JwtSecret), nil
 	})
 
@@ -281,7 +789,7 @@ func decodeJwtToken(jwtToken string) (*jwt.RegisteredClaims, error) {
 		return nil, err
 	}
 
-	claims := token.Claims.(*jwt.RegisteredClaims)
+	claims := token.Claims.(*jwtClaims)
 	return claims, nil
 }
 
@@ -1,6 +1,8 @@
 package service
 
 import (
+	"context"
+	"errors"
 	"log"
 	"os"
 	"testing"
@@ -8,18 +10,15 @@ import (
 
 	"gopkg.in/h2non/gock.v1"
 
+	"github.com/CHainGate/backend/configApi"
 	"github.com/CHainGate/backend/internal/model"
 	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/internal/repository/memory"
 	"github.com/CHainGate/backend/internal/utils"
 	"github.com/CHainGate/backend/pkg/enum"
-	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/google/uuid"
-	"gorm.io/driver/postgres"
-	"gorm.io/gorm"
 )
 
-var service IAuthenticationService
-var mock sqlmock.Sqlmock
 var jwtTest JwtTest
 var testMerchant = &model.Merchant{
 	FirstName: "Momo",
@@ -27,9 +26,6 @@ var testMerchant = &model.Merchant{
 	Email:     "momo@mail.com",
 	Password:  "test",
 	IsActive:  true,
-	EmailVerification: model.EmailVerification{
-		VerificationCode: 123456,
-	},
 }
 
 type JwtTest struct {
@@ -39,11 +35,7 @@ type JwtTest struct {
 }
 
 func setup() {
-	newMock, merchantRepo := NewMerchantRepositoryMock()
-	mock = newMock
-	_, apiKeyRepo := NewApiKeyRepositoryMock()
-	service = NewAuthenticationService(merchantRepo, apiKeyRepo)
-	utils.NewOpts()
+	utils.NewOpts(nil)
 	utils.Opts.JwtSecret = "secret"
 	utils.Opts.ApiKeySecret = "apiSecretKey1234"
 	merchantId, err := uuid.Parse("b39310ec-59f9-454e-b1dd-2bcc18e9994f")
@@ -51,17 +43,8 @@ func setup() {
 		log.Fatal(err)
 	}
 	testMerchant.ID = merchantId
-	testMerchant.EmailVerification.MerchantId = merchantId
 }
 
-/*func findMerchantByEmailMock() repository.IMerchantRepository {
-	row := sqlmock.NewRows([]string{"id", "first_name", "last_name", "email", "password", "is_active", "created_at"}).
-		AddRow(testMerchant.ID, testMerchant.FirstName, testMerchant.LastName, testMerchant.Email, testMerchant.Password, testMerchant.IsActive, testMerchant.CreatedAt)
-
-	merchantMock.ExpectQuery("SELECT").WithArgs(testMerchant.Email).WillReturnRows(row)
-	return repo
-}*/
-
 func shutdown() {}
 
 func TestMain(m *testing.M) {
@@ -71,45 +54,37 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
-func NewMerchantRepositoryMock() (sqlmock.Sqlmock, repository.IMerchantRepository) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		log.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
-	}
-	dialector := postgres.New(postgres.Config{
-		Conn:       db,
-		DriverName: "postgres",
-	})
+// newRepositories returns the repositories and the unit of work of an empty in-memory database
+func newRepositories() (repository.Repositories, repository.IUnitOfWork) {
+	db := memory.NewDB()
+	unitOfWork, _ := memory.NewUnitOfWork(db)
+	return memory.NewRepositories(db), unitOfWork
+}
 
-	gormDb, err := gorm.Open(dialector, &gorm.Config{})
-	merchantRepository, err := repository.NewMerchantRepository(gormDb)
-	if err != nil {
-		return nil, nil
-	}
-	return mock, merchantRepository
+func newAuthenticationService() (IAuthenticationService, repository.Repositories) {
+	repos, unitOfWork := newRepositories()
+	return NewAuthenticationService(repos.Merchant, repos.ApiKey, repos.RefreshToken, unitOfWork), repos
 }
 
-func NewApiKeyRepositoryMock() (sqlmock.Sqlmock, repository.IApiKeyRepository) {
-	db, mock, err := sqlmock.New()
-	if err != nil {
-		log.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+func createMerchant(t *testing.T, repos repository.Repositories, merchant *model.Merchant) {
+	t.Helper()
+	if err := repos.Merchant.Create(context.Background(), merchant); err != nil {
+		t.Fatalf("Create: got error %s", err.Error())
 	}
-	dialector := postgres.New(postgres.Config{
-		Conn:       db,
-		DriverName: "postgres",
-	})
+}
 
-	gormDb, err := gorm.Open(dialector, &gorm.Config{})
-	apiKeyRepository, err := repository.NewApiKeyRepository(gormDb)
+func findMerchant(t *testing.T, repos repository.Repositories, id uuid.UUID) *model.Merchant {
+	t.Helper()
+	merchant, err := repos.Merchant.FindById(context.Background(), id)
 	if err != nil {
-		return nil, nil
+		t.Fatalf("FindById: got error %s", err.Error())
 	}
-	return mock, apiKeyRepository
+	return merchant
 }
 
 func TestCreateJwtToken(t *testing.T) {
 	jwtDuration := time.Hour * 24
-	token, err := createJwtToken("test@email.com", "test", jwtDuration)
+	token, err := createJwtToken("test@email.com", "test", 0, jwtDuration)
 	if err != nil {
 		t.Errorf("Cannot create JWT Token, got error %s", err.Error())
 	}
@@ -135,20 +110,15 @@ func TestDecodeJwtToken(t *testing.T) {
 }
 
 func TestHandleAuthorization(t *testing.T) {
-	merchantRow := sqlmock.NewRows([]string{"id", "first_name", "last_name", "email", "password", "is_active", "created_at"}).
-		AddRow(testMerchant.ID, testMerchant.FirstName, testMerchant.LastName, testMerchant.Email, testMerchant.Password, testMerchant.IsActive, testMerchant.CreatedAt)
-	verificationRow := sqlmock.NewRows([]string{"id", "merchant_id", "verification_code"}).AddRow(uuid.New(), testMerchant.ID, 123456)
-
-	mock.ExpectQuery("SELECT (.+) FROM \"merchants\"").WithArgs(testMerchant.Email).WillReturnRows(merchantRow)
-	mock.ExpectQuery("SELECT (.+) FROM \"email_verifications\"").WithArgs(testMerchant.ID).WillReturnRows(verificationRow)
-	mock.ExpectQuery("SELECT (.+) FROM \"wallets\"").WithArgs(testMerchant.ID).WillReturnRows(sqlmock.NewRows([]string{""}))
+	service, repos := newAuthenticationService()
+	createMerchant(t, repos, &model.Merchant{FirstName: testMerchant.FirstName, Email: testMerchant.Email, IsActive: true})
 
-	token, err := createJwtToken(testMerchant.Email, testMerchant.FirstName, time.Hour*1)
+	token, err := createJwtToken(testMerchant.Email, testMerchant.FirstName, 0, time.Hour*1)
 	if err != nil {
-		t.Errorf("")
+		t.Fatalf("createJwtToken: got error %s", err.Error())
 	}
 	bearer := "bearer " + token
-	merchant, err := service.HandleJwtAuthentication(bearer)
+	merchant, err := service.HandleJwtAuthentication(context.Background(), bearer)
 	if err != nil {
 		t.Fatalf("handleAuthorization: got error %s", err.Error())
 	}
@@ -158,102 +128,130 @@ func TestHandleAuthorization(t *testing.T) {
 	}
 }
 
-func TestCreateVerificationCode(t *testing.T) {
-	const verificationCodeLength = 6
-	code, err := createVerificationCode()
-	if err != nil {
-		t.Fatalf("createVerificationCode: got error %s", err.Error())
-	}
-	if len(code.String()) != verificationCodeLength {
-		t.Errorf("Expected verification code with length of %d, but got %d", verificationCodeLength, len(code.String()))
-	}
-}
-
-func TestHandleVerification(t *testing.T) {
-	merchantId, _ := uuid.Parse("b39310ec-59f9-454e-b1dd-2bcc18e9994f")
-	codeId, _ := uuid.Parse("b39310ec-59f9-454e-b1dd-000000000000")
-	merchant := model.Merchant{
-		Base:      model.Base{ID: merchantId},
+func newUnverifiedMerchant(token string, attempts int) *model.Merchant {
+	return &model.Merchant{
 		FirstName: "hans",
 		LastName:  "meier",
 		Password:  "pw",
 		Salt:      []byte("salt"),
 		Email:     "test@mail.com",
-		IsActive:  true,
+		IsActive:  false,
 		EmailVerification: model.EmailVerification{
-			Base:             model.Base{ID: codeId},
-			MerchantId:       merchantId,
-			VerificationCode: 123456,
+			TokenHash: hashToken(token),
+			ExpiresAt: time.Now().Add(time.Hour),
+			SentAt:    time.Now(),
+			Attempts:  attempts,
 		},
 	}
+}
 
-	merchantRow := sqlmock.NewRows([]string{"id", "first_name", "last_name", "email", "password", "salt", "is_active", "created_at"}).
-		AddRow(merchant.ID, merchant.FirstName, merchant.LastName, merchant.Email, merchant.Password, merchant.Salt, merchant.IsActive, merchant.CreatedAt)
-	verificationRow := sqlmock.NewRows([]string{"id", "merchant_id", "verification_code"}).AddRow(merchant.EmailVerification.ID, testMerchant.ID, merchant.EmailVerification.VerificationCode)
+func TestHandleVerification(t *testing.T) {
+	service, repos := newAuthenticationService()
+	merchant := newUnverifiedMerchant("token", 0)
+	createMerchant(t, repos, merchant)
 
-	mock.ExpectQuery("SELECT (.+) FROM \"merchants\"").WithArgs(merchant.Email).WillReturnRows(merchantRow)
-	mock.ExpectQuery("SELECT (.+) FROM \"email_verifications\"").WithArgs(merchant.ID).WillReturnRows(verificationRow)
-	mock.ExpectQuery("SELECT (.+) FROM \"wallets\"").WithArgs(testMerchant.ID).WillReturnRows(sqlmock.NewRows([]string{""}))
+	err := service.HandleVerification(context.Background(), merchant.Email, "token")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !findMerchant(t, repos, merchant.ID).IsActive {
+		t.Errorf("Expected merchant to be active")
+	}
+}
 
-	mock.ExpectBegin()
-	mock.ExpectExec("UPDATE \"merchants\"").
-		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
-		WillReturnResult(sqlmock.NewResult(0, 1))
+func TestHandleVerificationWrongToken(t *testing.T) {
+	service, repos := newAuthenticationService()
+	merchant := newUnverifiedMerchant("token", 0)
+	createMerchant(t, repos, merchant)
 
-	mock.ExpectQuery("INSERT INTO \"email_verifications\"").
-		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), merchantId, 123456, codeId).
-		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+	err := service.HandleVerification(context.Background(), merchant.Email, "wrong")
+	if !errors.Is(err, ErrInvalidVerification) {
+		t.Errorf("Expected error %v, but got %v", ErrInvalidVerification, err)
+	}
+	found := findMerchant(t, repos, merchant.ID)
+	if found.IsActive || found.EmailVerification.Attempts != 1 {
+		t.Errorf("Expected an inactive merchant with 1 attempt, but got %d attempts", found.EmailVerification.Attempts)
+	}
+}
 
-	mock.ExpectCommit()
+func TestHandleVerificationLocked(t *testing.T) {
+	service, repos := newAuthenticationService()
+	merchant := newUnverifiedMerchant("token", maxVerificationAttempts)
+	createMerchant(t, repos, merchant)
 
-	err := service.HandleVerification(merchant.Email, 123456)
-	if err != nil {
-		t.Error(err)
+	// even the correct token is rejected once the verification is locked
+	err := service.HandleVerification(context.Background(), merchant.Email, "token")
+	if !errors.Is(err, ErrVerificationLocked) {
+		t.Errorf("Expected error %v, but got %v", ErrVerificationLocked, err)
 	}
-
-	err = mock.ExpectationsWereMet()
-	if err != nil {
-		t.Errorf("Expectations were not met: %s", err.Error())
+	if findMerchant(t, repos, merchant.ID).IsActive {
+		t.Errorf("Expected merchant to stay inactive")
 	}
-
 }
 
-//TODO: make test work
-/*func TestCreateMerchant(t *testing.T) {
-	request := configApi.RegisterRequestDto{
-		FirstName: "",
-		LastName: "",
-		Email: "",
-		Password: "",
+func TestResendVerificationTooSoon(t *testing.T) {
+	service, repos := newAuthenticationService()
+	// the verification email was just sent
+	unverified := newUnverifiedMerchant("token", 0)
+	createMerchant(t, repos, unverified)
+	verified := newLoginMerchant("verified@mail.com", "password1234", 0, nil)
+	createMerchant(t, repos, verified)
+
+	// the answers do not reveal whether an account exists
+	for _, email := range []string{unverified.Email, verified.Email, "unknown@mail.com"} {
+		err := service.ResendVerification(context.Background(), email)
+		if err != nil {
+			t.Errorf("Expected the first request for %s to be accepted, but got %v", email, err)
+		}
+		err = service.ResendVerification(context.Background(), email)
+		if !errors.Is(err, ErrResendTooSoon) {
+			t.Errorf("Expected error %v for %s, but got %v", ErrResendTooSoon, email, err)
+		}
 	}
-	merchantId := uuid.New()
-	verificationCode := big.NewInt(123456)
-	encryptedPassword := []byte("password")
+}
+
+func TestCreateMerchant(t *testing.T) {
+	defer gock.Off()
+	gock.New("localhost:8001").
+		Post("/api/email").
+		Reply(200)
+
+	service, repos := newAuthenticationService()
 	registerRequest := configApi.RegisterRequestDto{
 		Email:     "hans@mail.ch",
 		Password:  "password",
 		FirstName: "hans",
 		LastName:  "meier",
 	}
+	err := service.CreateMerchant(context.Background(), registerRequest)
+	if err != nil {
+		t.Fatalf("Error occured during createMerchant: %s", err.Error())
+	}
 
+	merchant, err := repos.Merchant.FindByEmail(context.Background(), registerRequest.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merchant.IsActive || merchant.Password == registerRequest.Password || merchant.EmailVerification.TokenHash == "" {
+		t.Errorf("Expected an inactive merchant with a hashed password and an email verification")
+	}
+}
 
-	merchantMock.ExpectBegin()
-
-	merchantMock.ExpectQuery("INSERT INTO \"merchants\"").
-		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), registerRequest.FirstName, registerRequest.LastName, registerRequest.Email, sqlmock.AnyArg(), false).
-		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(merchantId))
-
-	merchantMock.ExpectQuery("INSERT INTO \"email_verifications\"").
-		WithArgs(sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), merchantId, verificationCode.Int64()).
-		WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(uuid.New()))
+func TestCreateMerchantEmailFailed(t *testing.T) {
+	defer gock.Off()
+	gock.New("localhost:8001").
+		Post("/api/email").
+		Reply(500)
 
-	merchantMock.ExpectCommit()
+	service, repos := newAuthenticationService()
+	registerRequest := configApi.RegisterRequestDto{Email: "fail@mail.ch", Password: "password", FirstName: "hans", LastName: "meier"}
+	_ = service.CreateMerchant(context.Background(), registerRequest)
 
-	err := service.CreateMerchant(request)
-	if err != nil {
-		t.Fatalf("Error occured during createMerchant: %s", err.Error())
+	// the email is sent after the merchant was saved, the merchant can request a new one
+	if _, err := repos.Merchant.FindByEmail(context.Background(), registerRequest.Email); err != nil {
+		t.Errorf("Expected the merchant to be saved, but got %v", err)
 	}
-}*/
+}
 
 func TestSendVerificationEmail(t *testing.T) {
 	defer gock.Off() // Flush pending mocks after test execution
@@ -265,10 +263,134 @@ func TestSendVerificationEmail(t *testing.T) {
 			"name":     "Momo",
 			"email_to": "momo@mail.com",
 			"subject":  "Verify your E-Mail",
-			"content":  "Please Verify your E-Mail: ?code=123456&email=momo%40mail.com"}).
+			"content":  "Please Verify your E-Mail: ?email=momo%40mail.com&token=abc"}).
 		Reply(200)
 
-	err := sendVerificationEmail(testMerchant)
+	err := sendVerificationEmail(context.Background(), testMerchant, "abc")
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCreateToken(t *testing.T) {
+	token, err := createToken()
+	if err != nil {
+		t.Fatalf("createToken: got error %s", err.Error())
+	}
+	other, err := createToken()
+	if err != nil {
+		t.Fatalf("createToken: got error %s", err.Error())
+	}
+	if token == other {
+		t.Errorf("Expected different reset tokens, but got %s twice", token)
+	}
+	if hashToken(token) != hashToken(token) {
+		t.Errorf("Expected the same hash for the same token")
+	}
+	if hashToken(token) == token {
+		t.Errorf("Expected the token to be hashed")
+	}
+}
+
+func TestIsTokenRevoked(t *testing.T) {
+	tests := []struct {
+		name         string
+		version      int
+		tokenVersion int
+		want         bool
+	}{
+		{"same version", 0, 0, false},
+		{"password changed", 0, 1, true},
+		{"logged out of all sessions", 1, 2, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := &jwtClaims{Version: tt.version}
+			merchant := &model.Merchant{TokenVersion: tt.tokenVersion}
+			if got := isTokenRevoked(claims, merchant); got != tt.want {
+				t.Errorf("Expected %v, but got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func createRefreshToken(t *testing.T, repos repository.Repositories, merchantId uuid.UUID, familyId uuid.UUID, token string, revokedAt *time.Time) {
+	t.Helper()
+	refreshToken := &model.RefreshToken{
+		MerchantId: merchantId,
+		FamilyId:   familyId,
+		TokenHash:  hashToken(token),
+		ExpiresAt:  time.Now().Add(time.Hour),
+		RevokedAt:  revokedAt,
+	}
+	if err := repos.RefreshToken.Create(context.Background(), refreshToken); err != nil {
+		t.Fatalf("Create: got error %s", err.Error())
+	}
+}
+
+func TestRefreshSession(t *testing.T) {
+	service, repos := newAuthenticationService()
+	merchant := &model.Merchant{FirstName: testMerchant.FirstName, Email: testMerchant.Email, IsActive: true, TokenVersion: 3}
+	createMerchant(t, repos, merchant)
+	createRefreshToken(t, repos, merchant.ID, uuid.New(), "refresh", nil)
+
+	tokens, err := service.RefreshSession(context.Background(), "refresh")
+	if err != nil {
+		t.Fatalf("RefreshSession: got error %s", err.Error())
+	}
+	if tokens.RefreshToken == "refresh" {
+		t.Errorf("Expected a new refresh token")
+	}
+	claims, err := decodeJwtToken(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("Cannot decode JWT Token, got error %s", err.Error())
+	}
+	if claims.Version != 3 {
+		t.Errorf("Expected token version %d, but got %d", 3, claims.Version)
+	}
+
+	_, err = service.RefreshSession(context.Background(), "refresh")
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Expected the old refresh token to be revoked, but got %v", err)
+	}
+}
+
+func TestRefreshSessionReuseRevokesSession(t *testing.T) {
+	service, repos := newAuthenticationService()
+	merchant := &model.Merchant{FirstName: testMerchant.FirstName, Email: testMerchant.Email, IsActive: true}
+	createMerchant(t, repos, merchant)
+	familyId := uuid.New()
+	revokedAt := time.Now().Add(-time.Minute)
+	createRefreshToken(t, repos, merchant.ID, familyId, "stolen", &revokedAt)
+	createRefreshToken(t, repos, merchant.ID, familyId, "current", nil)
+
+	_, err := service.RefreshSession(context.Background(), "stolen")
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("Expected error %v, but got %v", ErrInvalidRefreshToken, err)
+	}
+	current, err := repos.RefreshToken.FindByTokenHash(context.Background(), hashToken("current"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.RevokedAt == nil {
+		t.Errorf("Expected the whole session to be revoked")
+	}
+}
+
+func TestSendPasswordResetEmail(t *testing.T) {
+	defer gock.Off()
+
+	gock.New("localhost:8001").
+		Post("/api/email").
+		MatchType("json").
+		JSON(map[string]string{
+			"name":     "Momo",
+			"email_to": "momo@mail.com",
+			"subject":  "Reset your password",
+			"content":  "Reset your password within the next hour: http://localhost:3000/password/reset?token=abc\nIf you did not request a password reset, you can ignore this E-Mail."}).
+		Reply(200)
+
+	err := sendPasswordResetEmail(context.Background(), testMerchant, "abc")
 	if err != nil {
 		t.Error(err)
 	}
@@ -276,7 +398,8 @@ func TestSendVerificationEmail(t *testing.T) {
 
 // TODO: improve test
 func TestHandleSecretApiKey(t *testing.T) {
-	key, err := service.CreateApiKey(enum.Test)
+	service, _ := newAuthenticationService()
+	key, err := service.CreateApiKey(context.Background(), enum.Test)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -303,3 +426,136 @@ func TestGetCombinedApiKey(t *testing.T) {
 		t.Errorf("expected combined key %s, but got %s", expected, decrypt)
 	}
 }
+
+func newLoginMerchant(email string, password string, failedLogins int, lockedUntil *time.Time) *model.Merchant {
+	salt := []byte("salt")
+	encryptedPassword, _ := scryptPassword(password, salt)
+	return &model.Merchant{
+		FirstName:    testMerchant.FirstName,
+		Email:        email,
+		Password:     encryptedPassword,
+		Salt:         salt,
+		IsActive:     true,
+		FailedLogins: failedLogins,
+		LockedUntil:  lockedUntil,
+	}
+}
+
+func TestHandleLoginUnknownEmail(t *testing.T) {
+	service, _ := newAuthenticationService()
+
+	_, err := service.HandleLogin(context.Background(), "unknown@mail.com", "password1234", "10.0.0.1")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected error %v, but got %v", ErrInvalidCredentials, err)
+	}
+}
+
+func TestHandleLoginLocksAccount(t *testing.T) {
+	defer gock.Off()
+	gock.New("localhost:8001").
+		Post("/api/email").
+		Reply(200)
+
+	service, repos := newAuthenticationService()
+	merchant := newLoginMerchant("lock@mail.com", "password1234", maxFailedLogins-1, nil)
+	createMerchant(t, repos, merchant)
+
+	_, err := service.HandleLogin(context.Background(), "lock@mail.com", "wrong-password", "10.0.0.2")
+	if !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("Expected error %v, but got %v", ErrInvalidCredentials, err)
+	}
+	found := findMerchant(t, repos, merchant.ID)
+	if found.FailedLogins != 0 || found.LockedUntil == nil || found.LockedUntil.Before(time.Now()) {
+		t.Errorf("Expected the account to be locked")
+	}
+}
+
+func TestHandleLoginLocked(t *testing.T) {
+	service, repos := newAuthenticationService()
+	lockedUntil := time.Now().Add(loginLockDuration)
+	createMerchant(t, repos, newLoginMerchant("locked@mail.com", "password1234", 0, &lockedUntil))
+
+	_, err := service.HandleLogin(context.Background(), "locked@mail.com", "password1234", "10.0.0.3")
+	var throttledErr *LoginThrottledError
+	if !errors.As(err, &throttledErr) {
+		t.Fatalf("Expected error %v, but got %v", ErrTooManyLoginAttempts, err)
+	}
+	if throttledErr.RetryAfter <= 0 || throttledErr.RetryAfter > loginLockDuration {
+		t.Errorf("Unexpected retry after %s", throttledErr.RetryAfter)
+	}
+}
+
+func TestChangePasswordLocksAccount(t *testing.T) {
+	defer gock.Off()
+	gock.New("localhost:8001").
+		Post("/api/email").
+		Reply(200)
+
+	service, repos := newAuthenticationService()
+	merchant := newLoginMerchant("change@mail.com", "password1234", maxFailedLogins-1, nil)
+	createMerchant(t, repos, merchant)
+
+	err := service.ChangePassword(context.Background(), merchant, "wrong-password", "newPassword1234", "10.0.0.4")
+	if !errors.Is(err, ErrWrongPassword) {
+		t.Errorf("Expected error %v, but got %v", ErrWrongPassword, err)
+	}
+	found := findMerchant(t, repos, merchant.ID)
+	if found.LockedUntil == nil || found.LockedUntil.Before(time.Now()) {
+		t.Fatalf("Expected the account to be locked")
+	}
+
+	err = service.ChangePassword(context.Background(), found, "password1234", "newPassword1234", "10.0.0.4")
+	var throttledErr *LoginThrottledError
+	if !errors.As(err, &throttledErr) {
+		t.Errorf("Expected error %v, but got %v", ErrTooManyLoginAttempts, err)
+	}
+	if found = findMerchant(t, repos, merchant.ID); found.Password != merchant.Password {
+		t.Errorf("Expected the password not to be changed")
+	}
+}
+
+func TestChangePasswordThrottled(t *testing.T) {
+	service, repos := newAuthenticationService()
+	merchant := newLoginMerchant("throttle@mail.com", "password1234", 0, nil)
+	createMerchant(t, repos, merchant)
+
+	var err error
+	for i := 0; i <= freeLoginFailures; i++ {
+		err = service.ChangePassword(context.Background(), merchant, "wrong-password", "newPassword1234", "10.0.0.5")
+	}
+	if !errors.Is(err, ErrWrongPassword) {
+		t.Fatalf("Expected error %v, but got %v", ErrWrongPassword, err)
+	}
+	// the same throttle as the logins
+	_, err = service.HandleLogin(context.Background(), "throttle@mail.com", "password1234", "10.0.0.6")
+	var throttledErr *LoginThrottledError
+	if !errors.As(err, &throttledErr) {
+		t.Errorf("Expected error %v, but got %v", ErrTooManyLoginAttempts, err)
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	service, repos := newAuthenticationService()
+	merchant := newLoginMerchant("reset@mail.com", "password1234", 0, nil)
+	merchant.PasswordResets = []model.PasswordReset{{TokenHash: hashToken("reset-token"), ExpiresAt: time.Now().Add(passwordResetDuration)}}
+	createMerchant(t, repos, merchant)
+
+	err := service.ResetPassword(context.Background(), "reset-token", "short")
+	if !errors.Is(err, ErrPasswordTooShort) {
+		t.Fatalf("Expected error %v, but got %v", ErrPasswordTooShort, err)
+	}
+
+	err = service.ResetPassword(context.Background(), "reset-token", "newPassword1234")
+	if err != nil {
+		t.Fatalf("ResetPassword: got error %s", err.Error())
+	}
+	found := findMerchant(t, repos, merchant.ID)
+	if canMerchantLogin(found, "newPassword1234") != nil || found.TokenVersion != merchant.TokenVersion+1 {
+		t.Errorf("Expected the new password and a new token version")
+	}
+
+	err = service.ResetPassword(context.Background(), "reset-token", "otherPassword1234")
+	if !errors.Is(err, ErrInvalidResetToken) {
+		t.Errorf("Expected error %v, but got %v", ErrInvalidResetToken, err)
+	}
+}
@@ -43,13 +43,10 @@ func NewApiKeyApiService(
 }
 
 // DeleteApiKey - delete api key
-func (s *ApiKeyApiService) DeleteApiKey(_ context.Context, apiKeyId string, authorization string) (configApi.ImplResponse, error) {
-	merchant, err := s.authenticationService.HandleJwtAuthentication(authorization)
-	if err != nil {
-		return configApi.Response(http.StatusForbidden, nil), errors.New("not authorized")
-	}
+func (s *ApiKeyApiService) DeleteApiKey(ctx context.Context, apiKeyId string, authorization string) (configApi.ImplResponse, error) {
+	merchant := principalFromContext(ctx).Organization
 
-	err = s.apiKeyRepository.Delete(merchant.ID, apiKeyId)
+	err := s.apiKeyRepository.Delete(ctx, merchant.ID, apiKeyId)
 	if err != nil {
 		return configApi.Response(http.StatusBadRequest, nil), err
 	}
@@ -57,10 +54,13 @@ func (s *ApiKeyApiService) DeleteApiKey(_ context.Context, apiKeyId string, auth
 }
 
 // GenerateApiKey - create new secret api key
-func (s *ApiKeyApiService) GenerateApiKey(_ context.Context, authorization string, apiKeyRequestDto configApi.ApiKeyRequestDto) (configApi.ImplResponse, error) {
-	merchant, err := s.authenticationService.HandleJwtAuthentication(authorization)
+func (s *ApiKeyApiService) GenerateApiKey(ctx context.Context, authorization string, xOTP string, apiKeyRequestDto configApi.ApiKeyRequestDto) (configApi.ImplResponse, error) {
+	principal := principalFromContext(ctx)
+	merchant := principal.Organization
+
+	err := s.authenticationService.VerifySecondFactor(ctx, principal.User, xOTP)
 	if err != nil {
-		return configApi.Response(http.StatusForbidden, nil), errors.New("not authorized")
+		return secondFactorErrorResponse(err)
 	}
 
 	mode, ok := enum.ParseStringToModeEnum(apiKeyRequestDto.Mode)
@@ -68,13 +68,13 @@ func (s *ApiKeyApiService) GenerateApiKey(_ context.Context, authorization strin
 		return configApi.Response(http.StatusBadRequest, nil), errors.New("mode does not exist")
 	}
 
-	key, err := s.authenticationService.CreateApiKey(mode)
+	key, err := s.authenticationService.CreateApiKey(ctx, mode)
 	if err != nil {
 		return configApi.Response(http.StatusInternalServerError, nil), err
 	}
 
 	merchant.ApiKeys = append(merchant.ApiKeys, *key)
-	err = s.merchantRepository.Update(merchant)
+	err = s.merchantRepository.Update(ctx, merchant)
 	if err != nil {
 		return configApi.Response(http.StatusInternalServerError, nil), errors.New("Merchant could not be updated ")
 	}
@@ -94,18 +94,15 @@ func (s *ApiKeyApiService) GenerateApiKey(_ context.Context, authorization strin
 }
 
 // GetApiKey - gets the api key
-func (s *ApiKeyApiService) GetApiKey(_ context.Context, mode string, authorization string) (configApi.ImplResponse, error) {
-	merchant, err := s.authenticationService.HandleJwtAuthentication(authorization)
-	if err != nil {
-		return configApi.Response(http.StatusForbidden, nil), errors.New("not authorized")
-	}
+func (s *ApiKeyApiService) GetApiKey(ctx context.Context, mode string, authorization string) (configApi.ImplResponse, error) {
+	merchant := principalFromContext(ctx).Organization
 
 	enumMode, ok := enum.ParseStringToModeEnum(mode)
 	if !ok {
 		return configApi.Response(http.StatusBadRequest, nil), errors.New("mode does not exist")
 	}
 
-	key, err := s.apiKeyRepository.FindByMerchantAndMode(merchant.ID, enumMode)
+	key, err := s.apiKeyRepository.FindByMerchantAndMode(ctx, merchant.ID, enumMode)
 	if err != nil {
 		return configApi.Response(http.StatusInternalServerError, nil), err
 	}
@@ -12,8 +12,10 @@ package configService
 import (
 	"context"
 	"errors"
+	"math"
 	"net/http"
 
+	"github.com/CHainGate/backend/internal/repository"
 	"github.com/CHainGate/backend/internal/service"
 
 	"github.com/CHainGate/backend/configApi"
@@ -32,22 +34,84 @@ func NewAuthenticationApiService(authenticationService service.IAuthenticationSe
 }
 
 // Login - Authenticate to chaingate
-func (s *AuthenticationApiService) Login(_ context.Context, loginRequestDto configApi.LoginRequestDto) (configApi.ImplResponse, error) {
-	token, err := s.authenticationService.HandleLogin(loginRequestDto.Email, loginRequestDto.Password)
+func (s *AuthenticationApiService) Login(ctx context.Context, loginRequestDto configApi.LoginRequestDto) (configApi.ImplResponse, error) {
+	result, err := s.authenticationService.HandleLogin(ctx, loginRequestDto.Email, loginRequestDto.Password, clientIpFromContext(ctx))
+	if response, ok := throttledResponse(err); ok {
+		return response, err
+	}
+	if errors.Is(err, service.ErrInvalidCredentials) {
+		return configApi.Response(http.StatusUnauthorized, nil), err
+	}
+	if errors.Is(err, service.ErrMerchantNotActive) {
+		return configApi.Response(http.StatusForbidden, nil), err
+	}
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	if result.MfaToken != "" {
+		return configApi.Response(http.StatusAccepted, configApi.MfaRequiredResponseDto{MfaToken: result.MfaToken}), nil
+	}
+
+	return configApi.Response(http.StatusCreated, toTokenResponseDto(result.Tokens)), nil
+}
+
+// LoginSecondFactor - Second login step with a two-factor code
+func (s *AuthenticationApiService) LoginSecondFactor(ctx context.Context, loginSecondFactorRequestDto configApi.LoginSecondFactorRequestDto) (configApi.ImplResponse, error) {
+	tokens, err := s.authenticationService.CompleteLogin(ctx, loginSecondFactorRequestDto.MfaToken, loginSecondFactorRequestDto.Code)
+	if response, ok := throttledResponse(err); ok {
+		return response, err
+	}
+	if errors.Is(err, service.ErrInvalidMfaToken) || errors.Is(err, service.ErrInvalidSecondFactor) {
+		return configApi.Response(http.StatusUnauthorized, nil), err
+	}
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	return configApi.Response(http.StatusCreated, toTokenResponseDto(tokens)), nil
+}
+
+// RefreshToken - Get a new access token
+func (s *AuthenticationApiService) RefreshToken(ctx context.Context, refreshTokenRequestDto configApi.RefreshTokenRequestDto) (configApi.ImplResponse, error) {
+	tokens, err := s.authenticationService.RefreshSession(ctx, refreshTokenRequestDto.RefreshToken)
+	if errors.Is(err, service.ErrInvalidRefreshToken) {
+		return configApi.Response(http.StatusUnauthorized, nil), err
+	}
 	if err != nil {
 		return configApi.Response(http.StatusInternalServerError, nil), err
 	}
 
-	tokenDto := configApi.TokenResponseDto{Token: token}
+	return configApi.Response(http.StatusOK, toTokenResponseDto(tokens)), nil
+}
+
+// Logout - Revoke the current session
+func (s *AuthenticationApiService) Logout(ctx context.Context, refreshTokenRequestDto configApi.RefreshTokenRequestDto) (configApi.ImplResponse, error) {
+	err := s.authenticationService.Logout(ctx, refreshTokenRequestDto.RefreshToken)
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
 
-	return configApi.Response(http.StatusCreated, tokenDto), nil
+	return configApi.Response(http.StatusNoContent, nil), nil
+}
+
+// LogoutAll - Revoke all sessions
+func (s *AuthenticationApiService) LogoutAll(ctx context.Context, authorization string) (configApi.ImplResponse, error) {
+	merchant := principalFromContext(ctx).User
+
+	err := s.authenticationService.LogoutAll(ctx, merchant)
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	return configApi.Response(http.StatusNoContent, nil), nil
 }
 
 // RegisterMerchant - Merchant registration
-func (s *AuthenticationApiService) RegisterMerchant(_ context.Context, registerRequestDto configApi.RegisterRequestDto) (configApi.ImplResponse, error) {
-	err := s.authenticationService.CreateMerchant(registerRequestDto)
+func (s *AuthenticationApiService) RegisterMerchant(ctx context.Context, registerRequestDto configApi.RegisterRequestDto) (configApi.ImplResponse, error) {
+	err := s.authenticationService.CreateMerchant(ctx, registerRequestDto)
 	if err != nil {
-		if err.Error() == "ERROR: duplicate key value violates unique constraint \"merchants_email_key\" (SQLSTATE 23505)" {
+		if repository.IsDuplicateEmail(err) {
 			return configApi.Response(http.StatusBadRequest, nil), errors.New("E-Mail already exists")
 		}
 		return configApi.Response(http.StatusInternalServerError, nil), err
@@ -57,11 +121,89 @@ func (s *AuthenticationApiService) RegisterMerchant(_ context.Context, registerR
 }
 
 // VerifyEmail - Verify merchant E-Mail
-func (s *AuthenticationApiService) VerifyEmail(_ context.Context, email string, verificationCode int64) (configApi.ImplResponse, error) {
-	err := s.authenticationService.HandleVerification(email, verificationCode)
+func (s *AuthenticationApiService) VerifyEmail(ctx context.Context, email string, token string) (configApi.ImplResponse, error) {
+	err := s.authenticationService.HandleVerification(ctx, email, token)
+	if errors.Is(err, service.ErrInvalidVerification) || errors.Is(err, service.ErrVerificationExpired) {
+		return configApi.Response(http.StatusBadRequest, nil), err
+	}
+	if errors.Is(err, service.ErrVerificationLocked) {
+		return configApi.Response(http.StatusForbidden, nil), err
+	}
 	if err != nil {
 		return configApi.Response(http.StatusInternalServerError, nil), err
 	}
 
 	return configApi.Response(http.StatusOK, nil), nil
 }
+
+// ResendVerificationEmail - Send a new verification link
+func (s *AuthenticationApiService) ResendVerificationEmail(ctx context.Context, resendVerificationRequestDto configApi.ResendVerificationRequestDto) (configApi.ImplResponse, error) {
+	err := s.authenticationService.ResendVerification(ctx, resendVerificationRequestDto.Email)
+	if errors.Is(err, service.ErrResendTooSoon) {
+		return configApi.Response(http.StatusTooManyRequests, nil), err
+	}
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	return configApi.Response(http.StatusNoContent, nil), nil
+}
+
+// RequestPasswordReset - Request a password reset link
+func (s *AuthenticationApiService) RequestPasswordReset(ctx context.Context, passwordForgotRequestDto configApi.PasswordForgotRequestDto) (configApi.ImplResponse, error) {
+	err := s.authenticationService.RequestPasswordReset(ctx, passwordForgotRequestDto.Email)
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	return configApi.Response(http.StatusNoContent, nil), nil
+}
+
+// ResetPassword - Set a new password with a reset token
+func (s *AuthenticationApiService) ResetPassword(ctx context.Context, passwordResetRequestDto configApi.PasswordResetRequestDto) (configApi.ImplResponse, error) {
+	err := s.authenticationService.ResetPassword(ctx, passwordResetRequestDto.Token, passwordResetRequestDto.Password)
+	if errors.Is(err, service.ErrInvalidResetToken) || errors.Is(err, service.ErrPasswordTooShort) {
+		return configApi.Response(http.StatusBadRequest, nil), err
+	}
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	return configApi.Response(http.StatusNoContent, nil), nil
+}
+
+// ChangePassword - Change the password
+func (s *AuthenticationApiService) ChangePassword(ctx context.Context, authorization string, passwordChangeRequestDto configApi.PasswordChangeRequestDto) (configApi.ImplResponse, error) {
+	merchant := principalFromContext(ctx).User
+
+	err := s.authenticationService.ChangePassword(ctx, merchant, passwordChangeRequestDto.OldPassword, passwordChangeRequestDto.NewPassword, clientIpFromContext(ctx))
+	if response, ok := throttledResponse(err); ok {
+		return response, err
+	}
+	if errors.Is(err, service.ErrWrongPassword) || errors.Is(err, service.ErrPasswordTooShort) {
+		return configApi.Response(http.StatusBadRequest, nil), err
+	}
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	return configApi.Response(http.StatusNoContent, nil), nil
+}
+
+// throttledResponse tells the client how long to wait while logins are delayed or the account is locked
+func throttledResponse(err error) (configApi.ImplResponse, bool) {
+	var throttledErr *service.LoginThrottledError
+	if !errors.As(err, &throttledErr) {
+		return configApi.ImplResponse{}, false
+	}
+	retryAfter := int32(math.Ceil(throttledErr.RetryAfter.Seconds()))
+	return configApi.Response(http.StatusTooManyRequests, configApi.LoginThrottledResponseDto{RetryAfter: retryAfter}), true
+}
+
+func toTokenResponseDto(tokens *service.AuthTokens) configApi.TokenResponseDto {
+	return configApi.TokenResponseDto{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    int64(tokens.ExpiresIn.Seconds()),
+	}
+}
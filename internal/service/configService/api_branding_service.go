@@ -0,0 +1,86 @@
+/*
+ * Config OpenAPI
+ *
+ * This is the config OpenAPI definition.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package configService
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/internal/service"
+
+	"github.com/CHainGate/backend/configApi"
+)
+
+var colorRegex = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+// BrandingApiService is a service that implements the logic for the BrandingApiServicer
+// This service should implement the business logic for every endpoint for the BrandingApi API.
+// Include any external packages or services that will be required by this service.
+type BrandingApiService struct {
+	authenticationService service.IAuthenticationService
+	merchantRepository    repository.IMerchantRepository
+}
+
+// NewBrandingApiService creates a default api service
+func NewBrandingApiService(
+	authenticationService service.IAuthenticationService,
+	merchantRepository repository.IMerchantRepository,
+) configApi.BrandingApiServicer {
+	return &BrandingApiService{authenticationService, merchantRepository}
+}
+
+// GetBranding - get the branding of the hosted checkout page
+func (s *BrandingApiService) GetBranding(ctx context.Context, authorization string) (configApi.ImplResponse, error) {
+	merchant := principalFromContext(ctx).Organization
+
+	return configApi.Response(http.StatusOK, toBrandingDto(merchant.Branding)), nil
+}
+
+// UpdateBranding - update the branding of the hosted checkout page
+func (s *BrandingApiService) UpdateBranding(ctx context.Context, authorization string, brandingDto configApi.BrandingDto) (configApi.ImplResponse, error) {
+	merchant := principalFromContext(ctx).Organization
+
+	if brandingDto.LogoUrl != "" {
+		logoUrl, err := url.Parse(brandingDto.LogoUrl)
+		if err != nil || (logoUrl.Scheme != "https" && logoUrl.Scheme != "http") || logoUrl.Host == "" {
+			return configApi.Response(http.StatusBadRequest, nil), errors.New("invalid logo url")
+		}
+	}
+	for _, color := range []string{brandingDto.PrimaryColor, brandingDto.AccentColor} {
+		if color != "" && !colorRegex.MatchString(color) {
+			return configApi.Response(http.StatusBadRequest, nil), errors.New("colors must be hex values like #1a73e8")
+		}
+	}
+
+	merchant.Branding = model.Branding{
+		LogoUrl:      brandingDto.LogoUrl,
+		PrimaryColor: brandingDto.PrimaryColor,
+		AccentColor:  brandingDto.AccentColor,
+	}
+	err := s.merchantRepository.Update(ctx, merchant)
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	return configApi.Response(http.StatusOK, toBrandingDto(merchant.Branding)), nil
+}
+
+func toBrandingDto(branding model.Branding) configApi.BrandingDto {
+	return configApi.BrandingDto{
+		LogoUrl:      branding.LogoUrl,
+		PrimaryColor: branding.PrimaryColor,
+		AccentColor:  branding.AccentColor,
+	}
+}
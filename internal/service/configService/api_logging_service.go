@@ -38,17 +38,14 @@ func NewLoggingApiService(
 }
 
 // GetLoggingInformation - get logging information
-func (s *LoggingApiService) GetLoggingInformation(_ context.Context, mode string, authorization string) (configApi.ImplResponse, error) {
-	merchant, err := s.authenticationService.HandleJwtAuthentication(authorization)
-	if err != nil {
-		return configApi.Response(http.StatusForbidden, nil), errors.New("not authorized")
-	}
+func (s *LoggingApiService) GetLoggingInformation(ctx context.Context, mode string, authorization string) (configApi.ImplResponse, error) {
+	merchant := principalFromContext(ctx).Organization
 
 	parsedMode, ok := enum.ParseStringToModeEnum(mode)
 	if !ok {
 		return configApi.Response(http.StatusInternalServerError, nil), errors.New("wrong mode")
 	}
-	payments, err := s.paymentRepository.FindByMerchantIdAndMode(merchant.ID, parsedMode)
+	payments, err := s.paymentRepository.FindByMerchantIdAndMode(ctx, merchant.ID, parsedMode)
 	if err != nil {
 		return configApi.Response(http.StatusInternalServerError, nil), err
 	}
@@ -58,16 +55,23 @@ func (s *LoggingApiService) GetLoggingInformation(_ context.Context, mode string
 		var history []configApi.PaymentHistory
 		for _, state := range payment.PaymentStates {
 			actuallyPaid := state.ActuallyPaid
+			// states before the currency change was possible have no currency and address
+			payCurrency := payment.PayCurrency
+			payAddress := payment.PayAddress
+			if state.PayCurrency != 0 {
+				payCurrency = state.PayCurrency
+				payAddress = state.PayAddress
+			}
 			h := configApi.PaymentHistory{
 				Id:            state.ID.String(),
 				CreatedAt:     state.CreatedAt,
-				PayCurrency:   payment.PayCurrency.String(),
+				PayCurrency:   payCurrency.String(),
 				PaymentState:  state.PaymentState.String(),
 				PayAmount:     state.PayAmount.String(),
 				ActuallyPaid:  actuallyPaid.String(),
 				PriceCurrency: payment.PriceCurrency.String(),
 				PriceAmount:   payment.PriceAmount,
-				PayAddress:    payment.PayAddress,
+				PayAddress:    payAddress,
 			}
 			history = append(history, h)
 		}
@@ -0,0 +1,141 @@
+/*
+ * Config OpenAPI
+ *
+ * This is the config OpenAPI definition.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package configService
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/internal/service"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+
+	"github.com/CHainGate/backend/configApi"
+)
+
+// TeamApiService is a service that implements the logic for the TeamApiServicer
+// This service should implement the business logic for every endpoint for the TeamApi API.
+// Include any external packages or services that will be required by this service.
+type TeamApiService struct {
+	teamService service.ITeamService
+}
+
+// NewTeamApiService creates a default api service
+func NewTeamApiService(teamService service.ITeamService) configApi.TeamApiServicer {
+	return &TeamApiService{teamService}
+}
+
+// GetTeam - get the members of the organization
+func (s *TeamApiService) GetTeam(ctx context.Context, authorization string) (configApi.ImplResponse, error) {
+	members, err := s.teamService.GetMembers(ctx, principalFromContext(ctx).Organization)
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	result := make([]configApi.TeamMemberDto, 0, len(members))
+	for _, member := range members {
+		result = append(result, configApi.TeamMemberDto{
+			Id:        member.Merchant.ID.String(),
+			Email:     member.Merchant.Email,
+			FirstName: member.Merchant.FirstName,
+			LastName:  member.Merchant.LastName,
+			Role:      member.Role.String(),
+		})
+	}
+	return configApi.Response(http.StatusOK, result), nil
+}
+
+// InviteMember - invite a new member by email
+func (s *TeamApiService) InviteMember(ctx context.Context, authorization string, invitationRequestDto configApi.InvitationRequestDto) (configApi.ImplResponse, error) {
+	role, ok := enum.ParseStringToRoleEnum(invitationRequestDto.Role)
+	if !ok {
+		return configApi.Response(http.StatusBadRequest, nil), errors.New("role does not exist")
+	}
+
+	err := s.teamService.InviteMember(ctx, principalFromContext(ctx).Organization, invitationRequestDto.Email, role)
+	if errors.Is(err, service.ErrRoleNotAssignable) {
+		return configApi.Response(http.StatusBadRequest, nil), err
+	}
+	if errors.Is(err, service.ErrAlreadyRegistered) {
+		return configApi.Response(http.StatusConflict, nil), err
+	}
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	return configApi.Response(http.StatusNoContent, nil), nil
+}
+
+// AcceptInvitation - create the account of an invited member
+func (s *TeamApiService) AcceptInvitation(ctx context.Context, acceptInvitationRequestDto configApi.AcceptInvitationRequestDto) (configApi.ImplResponse, error) {
+	err := s.teamService.AcceptInvitation(
+		ctx,
+		acceptInvitationRequestDto.Token,
+		acceptInvitationRequestDto.FirstName,
+		acceptInvitationRequestDto.LastName,
+		acceptInvitationRequestDto.Password,
+	)
+	if errors.Is(err, service.ErrInvalidInvitation) || errors.Is(err, service.ErrPasswordTooShort) {
+		return configApi.Response(http.StatusBadRequest, nil), err
+	}
+	if err != nil {
+		if repository.IsDuplicateEmail(err) {
+			return configApi.Response(http.StatusConflict, nil), errors.New("E-Mail already exists")
+		}
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	return configApi.Response(http.StatusCreated, nil), nil
+}
+
+// UpdateMemberRole - change the role of a member
+func (s *TeamApiService) UpdateMemberRole(ctx context.Context, memberId string, authorization string, memberRoleDto configApi.MemberRoleDto) (configApi.ImplResponse, error) {
+	id, err := uuid.Parse(memberId)
+	if err != nil {
+		return configApi.Response(http.StatusBadRequest, nil), err
+	}
+	role, ok := enum.ParseStringToRoleEnum(memberRoleDto.Role)
+	if !ok {
+		return configApi.Response(http.StatusBadRequest, nil), errors.New("role does not exist")
+	}
+
+	err = s.teamService.UpdateMemberRole(ctx, principalFromContext(ctx).Organization, id, role)
+	if errors.Is(err, service.ErrRoleNotAssignable) {
+		return configApi.Response(http.StatusBadRequest, nil), err
+	}
+	if errors.Is(err, service.ErrMemberNotFound) {
+		return configApi.Response(http.StatusNotFound, nil), err
+	}
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	return configApi.Response(http.StatusNoContent, nil), nil
+}
+
+// RemoveMember - remove a member from the organization
+func (s *TeamApiService) RemoveMember(ctx context.Context, memberId string, authorization string) (configApi.ImplResponse, error) {
+	id, err := uuid.Parse(memberId)
+	if err != nil {
+		return configApi.Response(http.StatusBadRequest, nil), err
+	}
+
+	err = s.teamService.RemoveMember(ctx, principalFromContext(ctx).Organization, id)
+	if errors.Is(err, service.ErrMemberNotFound) {
+		return configApi.Response(http.StatusNotFound, nil), err
+	}
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	return configApi.Response(http.StatusNoContent, nil), nil
+}
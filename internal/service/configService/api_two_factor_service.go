@@ -0,0 +1,100 @@
+/*
+ * Config OpenAPI
+ *
+ * This is the config OpenAPI definition.
+ *
+ * API version: 1.0.0
+ * Generated by: OpenAPI Generator (https://openapi-generator.tech)
+ */
+
+package configService
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+
+	"github.com/CHainGate/backend/internal/service"
+
+	"github.com/CHainGate/backend/configApi"
+)
+
+// TwoFactorApiService is a service that implements the logic for the TwoFactorApiServicer
+// This service should implement the business logic for every endpoint for the TwoFactorApi API.
+// Include any external packages or services that will be required by this service.
+type TwoFactorApiService struct {
+	authenticationService service.IAuthenticationService
+}
+
+// NewTwoFactorApiService creates a default api service
+func NewTwoFactorApiService(authenticationService service.IAuthenticationService) configApi.TwoFactorApiServicer {
+	return &TwoFactorApiService{authenticationService}
+}
+
+// EnrolTwoFactor - start the two-factor enrolment
+func (s *TwoFactorApiService) EnrolTwoFactor(ctx context.Context, authorization string) (configApi.ImplResponse, error) {
+	merchant := principalFromContext(ctx).User
+
+	enrolment, err := s.authenticationService.EnrolTwoFactor(ctx, merchant)
+	if errors.Is(err, service.ErrTwoFactorAlreadyEnabled) {
+		return configApi.Response(http.StatusConflict, nil), err
+	}
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	enrolmentDto := configApi.TwoFactorEnrolmentDto{
+		Secret:     enrolment.Secret,
+		OtpauthUri: enrolment.Uri,
+		QrCode:     "data:image/png;base64," + base64.StdEncoding.EncodeToString(enrolment.QrCode),
+	}
+	return configApi.Response(http.StatusOK, enrolmentDto), nil
+}
+
+// ConfirmTwoFactor - enable two-factor authentication
+func (s *TwoFactorApiService) ConfirmTwoFactor(ctx context.Context, authorization string, twoFactorCodeDto configApi.TwoFactorCodeDto) (configApi.ImplResponse, error) {
+	merchant := principalFromContext(ctx).User
+
+	codes, err := s.authenticationService.ConfirmTwoFactor(ctx, merchant, twoFactorCodeDto.Code)
+	if errors.Is(err, service.ErrTwoFactorAlreadyEnabled) {
+		return configApi.Response(http.StatusConflict, nil), err
+	}
+	if errors.Is(err, service.ErrTwoFactorNotEnrolled) || errors.Is(err, service.ErrInvalidSecondFactor) {
+		return configApi.Response(http.StatusBadRequest, nil), err
+	}
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	return configApi.Response(http.StatusOK, configApi.RecoveryCodesDto{RecoveryCodes: codes}), nil
+}
+
+// DisableTwoFactor - disable two-factor authentication
+func (s *TwoFactorApiService) DisableTwoFactor(ctx context.Context, authorization string, twoFactorCodeDto configApi.TwoFactorCodeDto) (configApi.ImplResponse, error) {
+	merchant := principalFromContext(ctx).User
+
+	err := s.authenticationService.DisableTwoFactor(ctx, merchant, twoFactorCodeDto.Code)
+	if response, ok := throttledResponse(err); ok {
+		return response, err
+	}
+	if errors.Is(err, service.ErrTwoFactorNotEnabled) || errors.Is(err, service.ErrInvalidSecondFactor) {
+		return configApi.Response(http.StatusBadRequest, nil), err
+	}
+	if err != nil {
+		return configApi.Response(http.StatusInternalServerError, nil), err
+	}
+
+	return configApi.Response(http.StatusNoContent, nil), nil
+}
+
+// secondFactorErrorResponse is the response if the re-confirmation of a sensitive operation failed
+func secondFactorErrorResponse(err error) (configApi.ImplResponse, error) {
+	if response, ok := throttledResponse(err); ok {
+		return response, err
+	}
+	if errors.Is(err, service.ErrSecondFactorRequired) || errors.Is(err, service.ErrInvalidSecondFactor) {
+		return configApi.Response(http.StatusForbidden, nil), err
+	}
+	return configApi.Response(http.StatusInternalServerError, nil), err
+}
@@ -40,10 +40,13 @@ func NewWalletApiService(
 }
 
 // AddWallet - add new wallet address
-func (s *WalletApiService) AddWallet(_ context.Context, authorization string, walletRequestDto configApi.WalletRequestDto) (configApi.ImplResponse, error) {
-	merchant, err := s.authenticationService.HandleJwtAuthentication(authorization)
+func (s *WalletApiService) AddWallet(ctx context.Context, authorization string, xOTP string, walletRequestDto configApi.WalletRequestDto) (configApi.ImplResponse, error) {
+	principal := principalFromContext(ctx)
+	merchant := principal.Organization
+
+	err := s.authenticationService.VerifySecondFactor(ctx, principal.User, xOTP)
 	if err != nil {
-		return configApi.Response(http.StatusForbidden, nil), errors.New("not authorized")
+		return secondFactorErrorResponse(err)
 	}
 
 	mode, ok := enum.ParseStringToModeEnum(walletRequestDto.Mode)
@@ -62,7 +65,7 @@ func (s *WalletApiService) AddWallet(_ context.Context, authorization string, wa
 	}
 
 	merchant.Wallets = append(merchant.Wallets, newWallet)
-	err = s.merchantRepository.Update(merchant)
+	err = s.merchantRepository.Update(ctx, merchant)
 	if err != nil {
 		return configApi.Response(http.StatusNotImplemented, nil), err
 	}
@@ -77,13 +80,16 @@ func (s *WalletApiService) AddWallet(_ context.Context, authorization string, wa
 }
 
 // DeleteWallet - delete wallet
-func (s *WalletApiService) DeleteWallet(ctx context.Context, walletId string, authorization string) (configApi.ImplResponse, error) {
-	_, err := s.authenticationService.HandleJwtAuthentication(authorization)
+func (s *WalletApiService) DeleteWallet(ctx context.Context, walletId string, authorization string, xOTP string) (configApi.ImplResponse, error) {
+	principal := principalFromContext(ctx)
+	merchant := principal.Organization
+
+	err := s.authenticationService.VerifySecondFactor(ctx, principal.User, xOTP)
 	if err != nil {
-		return configApi.Response(http.StatusForbidden, nil), errors.New("not authorized")
+		return secondFactorErrorResponse(err)
 	}
 
-	err = s.merchantRepository.DeleteWalletById(walletId)
+	err = s.merchantRepository.DeleteWalletById(ctx, merchant.ID, walletId)
 	if err != nil {
 		return configApi.Response(http.StatusInternalServerError, nil), err
 	}
@@ -93,10 +99,7 @@ func (s *WalletApiService) DeleteWallet(ctx context.Context, walletId string, au
 
 // GetWallets - get wallets
 func (s *WalletApiService) GetWallets(ctx context.Context, mode string, authorization string) (configApi.ImplResponse, error) {
-	merchant, err := s.authenticationService.HandleJwtAuthentication(authorization)
-	if err != nil {
-		return configApi.Response(http.StatusForbidden, nil), errors.New("not authorized")
-	}
+	merchant := principalFromContext(ctx).Organization
 
 	parsedMode, ok := enum.ParseStringToModeEnum(mode)
 	if !ok {
@@ -0,0 +1,104 @@
+package configService
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/CHainGate/backend/internal/service"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/gorilla/mux"
+)
+
+type principalKey struct{}
+
+var allRoles = []enum.Role{enum.Owner, enum.Admin, enum.Developer, enum.Finance}
+
+// publicOperations do not need an access token
+var publicOperations = map[string]bool{
+	"Login":                   true,
+	"LoginSecondFactor":       true,
+	"RegisterMerchant":        true,
+	"VerifyEmail":             true,
+	"ResendVerificationEmail": true,
+	"RefreshToken":            true,
+	"Logout":                  true,
+	"RequestPasswordReset":    true,
+	"ResetPassword":           true,
+	"AcceptInvitation":        true,
+}
+
+// operationRoles are the roles allowed to call an operation, operations which are not listed are denied.
+// The route names are the operation ids of the openapi definition.
+var operationRoles = map[string][]enum.Role{
+	"GetConfig":             allRoles,
+	"ChangePassword":        allRoles,
+	"LogoutAll":             allRoles,
+	"EnrolTwoFactor":        allRoles,
+	"ConfirmTwoFactor":      allRoles,
+	"DisableTwoFactor":      allRoles,
+	"GetWallets":            allRoles,
+	"AddWallet":             {enum.Owner, enum.Admin},
+	"DeleteWallet":          {enum.Owner, enum.Admin},
+	"GetApiKey":             {enum.Owner, enum.Admin, enum.Developer},
+	"GenerateApiKey":        {enum.Owner, enum.Admin, enum.Developer},
+	"DeleteApiKey":          {enum.Owner, enum.Admin, enum.Developer},
+	"GetLoggingInformation": allRoles,
+	"GetBranding":           allRoles,
+	"UpdateBranding":        {enum.Owner, enum.Admin},
+	"GetTeam":               allRoles,
+	"InviteMember":          {enum.Owner, enum.Admin},
+	"UpdateMemberRole":      {enum.Owner, enum.Admin},
+	"RemoveMember":          {enum.Owner, enum.Admin},
+}
+
+// NewAuthorizationMiddleware authenticates the access token and checks the role of the user
+// for the called operation. The principal is passed to the handlers in the request context.
+func NewAuthorizationMiddleware(authenticationService service.IAuthenticationService, teamService service.ITeamService) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			route := mux.CurrentRoute(r)
+			if route == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			operation := route.GetName()
+			if publicOperations[operation] {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			authorization := r.Header.Get("Authorization")
+			if !strings.HasPrefix(strings.ToLower(authorization), "bearer ") {
+				http.Error(w, "not authorized", http.StatusUnauthorized)
+				return
+			}
+
+			user, err := authenticationService.HandleJwtAuthentication(r.Context(), authorization)
+			if err != nil {
+				http.Error(w, "not authorized", http.StatusUnauthorized)
+				return
+			}
+
+			principal, err := teamService.GetPrincipal(r.Context(), user)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+
+			if !principal.HasRole(operationRoles[operation]...) {
+				http.Error(w, "role "+principal.Role.String()+" is not allowed to "+operation, http.StatusForbidden)
+				return
+			}
+
+			ctx := context.WithValue(r.Context(), principalKey{}, principal)
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// principalFromContext returns the principal set by the authorization middleware
+func principalFromContext(ctx context.Context) *service.Principal {
+	principal, _ := ctx.Value(principalKey{}).(*service.Principal)
+	return principal
+}
@@ -0,0 +1,198 @@
+package configService
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CHainGate/backend/configApi"
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/service"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// fakeAuthentication accepts the bearer token "valid"
+type fakeAuthentication struct {
+	service.IAuthenticationService
+}
+
+func (fakeAuthentication) HandleJwtAuthentication(_ context.Context, bearer string) (*model.Merchant, error) {
+	if bearer != "Bearer valid" {
+		return nil, errors.New("not authorized")
+	}
+	return &model.Merchant{Base: model.Base{ID: uuid.New()}}, nil
+}
+
+// fakeTeam gives every user the same role in its own organization
+type fakeTeam struct {
+	service.ITeamService
+	role enum.Role
+}
+
+func (f fakeTeam) GetPrincipal(_ context.Context, user *model.Merchant) (*service.Principal, error) {
+	return &service.Principal{User: user, Organization: user, Role: f.role}, nil
+}
+
+type configRoute struct {
+	name   string
+	method string
+	path   string
+}
+
+// newConfigRouter builds the generated routes, the handlers are replaced so only the middleware decides
+func newConfigRouter(t *testing.T) (*mux.Router, []configRoute) {
+	t.Helper()
+	router := configApi.NewRouter(
+		configApi.NewApiKeyApiController(nil),
+		configApi.NewAuthenticationApiController(nil),
+		configApi.NewLoggingApiController(nil),
+		configApi.NewWalletApiController(nil),
+		configApi.NewConfigApiController(nil),
+		configApi.NewBrandingApiController(nil),
+		configApi.NewTwoFactorApiController(nil),
+		configApi.NewTeamApiController(nil),
+	)
+	var routes []configRoute
+	err := router.Walk(func(route *mux.Route, _ *mux.Router, _ []*mux.Route) error {
+		path, err := route.GetPathTemplate()
+		if err != nil {
+			return err
+		}
+		methods, err := route.GetMethods()
+		if err != nil {
+			return err
+		}
+		path = strings.NewReplacer("{id}", uuid.New().String()).Replace(path)
+		routes = append(routes, configRoute{route.GetName(), methods[0], path})
+		route.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk: got error %s", err.Error())
+	}
+	if len(routes) == 0 {
+		t.Fatal("Expected the generated routes")
+	}
+	return router, routes
+}
+
+func TestOperationsAreAuthorized(t *testing.T) {
+	_, routes := newConfigRouter(t)
+	names := map[string]bool{}
+	for _, route := range routes {
+		names[route.name] = true
+		_, hasRoles := operationRoles[route.name]
+		if publicOperations[route.name] == hasRoles {
+			t.Errorf("Expected operation %s to be either public or to have roles", route.name)
+		}
+	}
+	for name := range publicOperations {
+		if !names[name] {
+			t.Errorf("Expected public operation %s to be a route", name)
+		}
+	}
+	for name := range operationRoles {
+		if !names[name] {
+			t.Errorf("Expected operation %s with roles to be a route", name)
+		}
+	}
+}
+
+func TestAuthorizationMiddleware(t *testing.T) {
+	owner := []enum.Role{enum.Owner, enum.Admin}
+	developer := []enum.Role{enum.Owner, enum.Admin, enum.Developer}
+	// nil roles are public operations
+	tests := map[string][]enum.Role{
+		"Login":                   nil,
+		"LoginSecondFactor":       nil,
+		"RegisterMerchant":        nil,
+		"VerifyEmail":             nil,
+		"ResendVerificationEmail": nil,
+		"RefreshToken":            nil,
+		"Logout":                  nil,
+		"RequestPasswordReset":    nil,
+		"ResetPassword":           nil,
+		"AcceptInvitation":        nil,
+		"GetConfig":               allRoles,
+		"ChangePassword":          allRoles,
+		"LogoutAll":               allRoles,
+		"EnrolTwoFactor":          allRoles,
+		"ConfirmTwoFactor":        allRoles,
+		"DisableTwoFactor":        allRoles,
+		"GetWallets":              allRoles,
+		"AddWallet":               owner,
+		"DeleteWallet":            owner,
+		"GetApiKey":               developer,
+		"GenerateApiKey":          developer,
+		"DeleteApiKey":            developer,
+		"GetLoggingInformation":   allRoles,
+		"GetBranding":             allRoles,
+		"UpdateBranding":          owner,
+		"GetTeam":                 allRoles,
+		"InviteMember":            owner,
+		"UpdateMemberRole":        owner,
+		"RemoveMember":            owner,
+	}
+
+	_, routes := newConfigRouter(t)
+	if len(routes) != len(tests) {
+		t.Errorf("Expected %d routes, but got %d", len(tests), len(routes))
+	}
+	for _, route := range routes {
+		roles, ok := tests[route.name]
+		if !ok {
+			t.Errorf("Expected operation %s to be tested", route.name)
+			continue
+		}
+		t.Run(route.name, func(t *testing.T) {
+			public := roles == nil
+			for _, bearer := range []string{"", "Bearer invalid"} {
+				expected := http.StatusUnauthorized
+				if public {
+					expected = http.StatusNoContent
+				}
+				if code := authorize(t, enum.Owner, route, bearer); code != expected {
+					t.Errorf("Expected status %d with token %q, but got %d", expected, bearer, code)
+				}
+			}
+			for _, role := range allRoles {
+				expected := http.StatusForbidden
+				if public || hasRole(roles, role) {
+					expected = http.StatusNoContent
+				}
+				if code := authorize(t, role, route, "Bearer valid"); code != expected {
+					t.Errorf("Expected status %d for role %s, but got %d", expected, role.String(), code)
+				}
+			}
+		})
+	}
+}
+
+func authorize(t *testing.T, role enum.Role, route configRoute, bearer string) int {
+	t.Helper()
+	router, _ := newConfigRouter(t)
+	router.Use(NewAuthorizationMiddleware(fakeAuthentication{}, fakeTeam{role: role}))
+	r := httptest.NewRequest(route.method, route.path, nil)
+	if bearer != "" {
+		r.Header.Set("Authorization", bearer)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w.Code
+}
+
+func hasRole(roles []enum.Role, role enum.Role) bool {
+	for _, r := range roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
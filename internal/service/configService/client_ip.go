@@ -0,0 +1,26 @@
+package configService
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/CHainGate/backend/internal/utils"
+	"github.com/gorilla/mux"
+)
+
+type clientIpKey struct{}
+
+// NewClientIpMiddleware passes the ip of the client to the handlers in the request context
+func NewClientIpMiddleware() mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), clientIpKey{}, utils.ClientIp(r))
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func clientIpFromContext(ctx context.Context) string {
+	ip, _ := ctx.Value(clientIpKey{}).(string)
+	return ip
+}
@@ -0,0 +1,31 @@
+package configService
+
+import (
+	"net/http"
+
+	"github.com/CHainGate/backend/internal/ratelimit"
+	"github.com/CHainGate/backend/internal/utils"
+	"github.com/gorilla/mux"
+)
+
+// NewIpRateLimitMiddleware limits the operations without access token per client ip, it has to run before the authorization
+func NewIpRateLimitMiddleware(store ratelimit.Store) mux.MiddlewareFunc {
+	return ratelimit.NewMiddleware(store, func(r *http.Request) (string, ratelimit.Limit) {
+		route := mux.CurrentRoute(r)
+		if route == nil || !publicOperations[route.GetName()] {
+			return "", ratelimit.Limit{}
+		}
+		return "ip:" + clientIpFromContext(r.Context()), ratelimit.PerMinute(utils.Opts.RateLimitAnonymous)
+	})
+}
+
+// NewMerchantRateLimitMiddleware limits the operations of an organization, it has to run after the authorization
+func NewMerchantRateLimitMiddleware(store ratelimit.Store) mux.MiddlewareFunc {
+	return ratelimit.NewMiddleware(store, func(r *http.Request) (string, ratelimit.Limit) {
+		principal := principalFromContext(r.Context())
+		if principal == nil {
+			return "", ratelimit.Limit{}
+		}
+		return "merchant:" + principal.Organization.ID.String(), ratelimit.PerMinute(utils.Opts.RateLimitConfig)
+	})
+}
@@ -0,0 +1,38 @@
+package service
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/CHainGate/backend/internal/logging"
+	"github.com/CHainGate/backend/internal/metrics"
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/tracing"
+	"github.com/CHainGate/backend/internal/utils"
+)
+
+// paymentLogContext tags the log lines with the ids of the payment, so a payment can be traced
+// across the backend and the blockchain services
+func paymentLogContext(ctx context.Context, payment *model.Payment) context.Context {
+	return logging.With(ctx,
+		slog.String("payment_id", payment.ID.String()),
+		slog.String("blockchain_payment_id", payment.BlockchainPaymentId.String()),
+		slog.String("merchant_id", payment.MerchantId.String()),
+		slog.String("mode", payment.Mode.String()),
+	)
+}
+
+func blockchainContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, time.Duration(utils.Opts.BlockchainTimeout)*time.Second)
+}
+
+func proxyContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), time.Duration(utils.Opts.ProxyTimeout)*time.Second)
+}
+
+// proxyHttpClient measures and traces the requests to the proxy sending emails and webhooks
+func proxyHttpClient() *http.Client {
+	return &http.Client{Transport: tracing.NewTransport("proxy", &metrics.Transport{Service: "proxy", Base: &logging.Transport{}})}
+}
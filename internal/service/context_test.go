@@ -0,0 +1,25 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CHainGate/backend/internal/utils"
+)
+
+func TestProxyContext(t *testing.T) {
+	utils.Opts.ProxyTimeout = 1
+	parent, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	ctx, cancelProxy := proxyContext(parent)
+	defer cancelProxy()
+	if ctx.Err() != nil {
+		t.Errorf("Expected proxy call not to be canceled with the request, but got %v", ctx.Err())
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok || time.Until(deadline) > time.Second {
+		t.Errorf("Expected proxy call to time out after 1s")
+	}
+}
@@ -30,8 +30,8 @@ func NewPaymentUpdateApiService(internalPaymentService service.IInternalPaymentS
 }
 
 // UpdatePayment - update payment
-func (s *PaymentUpdateApiService) UpdatePayment(_ context.Context, payment internalApi.PaymentUpdateDto) (internalApi.ImplResponse, error) {
-	err := s.internalPaymentService.HandlePaymentUpdate(payment)
+func (s *PaymentUpdateApiService) UpdatePayment(ctx context.Context, payment internalApi.PaymentUpdateDto) (internalApi.ImplResponse, error) {
+	err := s.internalPaymentService.HandlePaymentUpdate(ctx, payment)
 	if err != nil {
 		return internalApi.Response(http.StatusInternalServerError, nil), err
 	}
@@ -0,0 +1,24 @@
+package internalService
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/CHainGate/backend/pkg/hmacauth"
+	"github.com/gorilla/mux"
+)
+
+// NewAuthenticationMiddleware rejects requests which are not signed by a blockchain service
+func NewAuthenticationMiddleware(verifier *hmacauth.Verifier) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			err := verifier.Verify(r)
+			if err != nil {
+				slog.WarnContext(r.Context(), "Rejected internal request", "method", r.Method, "path", r.URL.Path, "error", err)
+				http.Error(w, "not authorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
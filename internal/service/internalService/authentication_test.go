@@ -0,0 +1,81 @@
+package internalService
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CHainGate/backend/pkg/hmacauth"
+	"github.com/gorilla/mux"
+)
+
+const webhookBody = `{"paymentId":"b39310ec-59f9-454e-b1dd-2bcc18e9994f","paymentState":"finished"}`
+
+// newInternalServer serves a fake webhook behind the authentication middleware and records the received bodies
+func newInternalServer(secret string) (*httptest.Server, *[]string) {
+	var received []string
+	router := mux.NewRouter()
+	router.HandleFunc("/api/internal/payment/webhook", func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, string(body))
+	}).Methods(http.MethodPut)
+	router.Use(NewAuthenticationMiddleware(hmacauth.NewVerifier([]byte(secret))))
+	return httptest.NewServer(router), &received
+}
+
+func putWebhook(t *testing.T, client *http.Client, url string) int {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPut, url+"/api/internal/payment/webhook", strings.NewReader(webhookBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	return resp.StatusCode
+}
+
+func TestSignedPaymentUpdate(t *testing.T) {
+	server, received := newInternalServer("secret")
+	defer server.Close()
+
+	caller := &http.Client{Transport: &hmacauth.Transport{Secret: []byte("secret")}}
+	if status := putWebhook(t, caller, server.URL); status != http.StatusOK {
+		t.Errorf("Expected status %d, but got %d", http.StatusOK, status)
+	}
+	if len(*received) != 1 || (*received)[0] != webhookBody {
+		t.Errorf("Expected the handler to receive the body, but got %v", *received)
+	}
+}
+
+func TestUnsignedPaymentUpdateRejected(t *testing.T) {
+	server, received := newInternalServer("secret")
+	defer server.Close()
+
+	callers := map[string]*http.Client{
+		"unsigned":     http.DefaultClient,
+		"wrong secret": {Transport: &hmacauth.Transport{Secret: []byte("guessed")}},
+	}
+	for name, caller := range callers {
+		if status := putWebhook(t, caller, server.URL); status != http.StatusUnauthorized {
+			t.Errorf("%s: expected status %d, but got %d", name, http.StatusUnauthorized, status)
+		}
+	}
+	if len(*received) != 0 {
+		t.Errorf("Expected no update to reach the handler, but got %v", *received)
+	}
+}
+
+func TestPaymentUpdateRejectedWithoutSecret(t *testing.T) {
+	server, _ := newInternalServer("")
+	defer server.Close()
+
+	caller := &http.Client{Transport: &hmacauth.Transport{Secret: []byte("secret")}}
+	if status := putWebhook(t, caller, server.URL); status != http.StatusUnauthorized {
+		t.Errorf("Expected status %d, but got %d", http.StatusUnauthorized, status)
+	}
+}
@@ -0,0 +1,50 @@
+package internalService
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/CHainGate/backend/internal/utils"
+)
+
+// NewServer creates the listener of the internal api on its own port. If a client CA is configured,
+// the blockchain services have to present a client certificate signed by it (mutual TLS).
+func NewServer(handler http.Handler) (*http.Server, error) {
+	server := &http.Server{
+		Addr:    ":" + strconv.Itoa(utils.Opts.InternalServerPort),
+		Handler: handler,
+	}
+	if utils.Opts.InternalTlsClientCa == "" {
+		return server, nil
+	}
+	if utils.Opts.InternalTlsCert == "" || utils.Opts.InternalTlsKey == "" {
+		return nil, errors.New("INTERNAL_TLS_CLIENT_CA requires INTERNAL_TLS_CERT and INTERNAL_TLS_KEY")
+	}
+
+	ca, err := os.ReadFile(utils.Opts.InternalTlsClientCa)
+	if err != nil {
+		return nil, err
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(ca) {
+		return nil, errors.New("no certificate found in INTERNAL_TLS_CLIENT_CA")
+	}
+	server.TLSConfig = &tls.Config{
+		ClientCAs:  clientCAs,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+		MinVersion: tls.VersionTLS12,
+	}
+	return server, nil
+}
+
+// ListenAndServe serves with TLS if a certificate is configured
+func ListenAndServe(server *http.Server) error {
+	if utils.Opts.InternalTlsCert != "" {
+		return server.ListenAndServeTLS(utils.Opts.InternalTlsCert, utils.Opts.InternalTlsKey)
+	}
+	return server.ListenAndServe()
+}
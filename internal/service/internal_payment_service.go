@@ -7,12 +7,16 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"errors"
-	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"time"
 
 	"github.com/CHainGate/backend/internal/config"
+	"github.com/CHainGate/backend/internal/logging"
+	"github.com/CHainGate/backend/internal/metrics"
+	"github.com/CHainGate/backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"gorm.io/gorm"
 
 	"github.com/CHainGate/backend/internal/utils"
@@ -22,119 +26,238 @@ import (
 	"github.com/CHainGate/backend/internalApi"
 	"github.com/CHainGate/backend/pkg/enum"
 	"github.com/CHainGate/backend/proxyClientApi"
+	"github.com/google/uuid"
 )
 
 type IInternalPaymentService interface {
-	HandlePaymentUpdate(payment internalApi.PaymentUpdateDto) error
-	AddNewPaymentState(payment *model.Payment, paymentState model.PaymentState) error
+	HandlePaymentUpdate(ctx context.Context, payment internalApi.PaymentUpdateDto) error
+	AddNewPaymentState(ctx context.Context, payment *model.Payment, paymentState model.PaymentState) error
+	SendWebhook(ctx context.Context, payment *model.Payment) error
 }
 
 type internalPaymentService struct {
 	paymentRepository repository.IPaymentRepository
 	apiKeyRepository  repository.IApiKeyRepository
+	unitOfWork        repository.IUnitOfWork
 }
 
-func NewInternalPaymentService(paymentRepository repository.IPaymentRepository, apiKeyRepository repository.IApiKeyRepository) IInternalPaymentService {
-	return &internalPaymentService{paymentRepository, apiKeyRepository}
+func NewInternalPaymentService(
+	paymentRepository repository.IPaymentRepository,
+	apiKeyRepository repository.IApiKeyRepository,
+	unitOfWork repository.IUnitOfWork,
+) IInternalPaymentService {
+	return &internalPaymentService{paymentRepository, apiKeyRepository, unitOfWork}
 }
 
-func (s *internalPaymentService) AddNewPaymentState(payment *model.Payment, paymentState model.PaymentState) error {
+func (s *internalPaymentService) AddNewPaymentState(ctx context.Context, payment *model.Payment, paymentState model.PaymentState) error {
+	ctx = paymentLogContext(ctx, payment)
 	payment.PaymentStates = append(payment.PaymentStates, paymentState)
 
-	err := s.paymentRepository.Update(payment)
+	err := s.paymentRepository.Update(ctx, payment)
 	if err != nil {
 		return err
 	}
 
-	payment, err = s.paymentRepository.FindByPaymentId(payment.ID)
+	payment, err = s.paymentRepository.FindByPaymentId(ctx, payment.ID)
 	if err != nil {
 		return err
 	}
 
-	err = s.callWebhook(payment)
+	slog.InfoContext(ctx, "Payment state added", "state", paymentState.PaymentState.String())
+	metrics.PaymentStateTransitions.WithLabelValues(paymentState.PaymentState.String()).Inc()
+	err = s.callWebhook(ctx, payment)
 	if err != nil {
+		slog.ErrorContext(ctx, "Could not send webhook", "error", err)
 		return err
 	}
 
 	return nil
 }
 
-func (s *internalPaymentService) HandlePaymentUpdate(payment internalApi.PaymentUpdateDto) error {
+// HandlePaymentUpdate applies the update within a transaction, the payment row is locked so concurrent
+// updates of the blockchain services are not lost. The buyer and the merchant are notified after the commit.
+func (s *internalPaymentService) HandlePaymentUpdate(ctx context.Context, payment internalApi.PaymentUpdateDto) error {
+	ctx = logging.With(ctx, slog.String("blockchain_payment_id", payment.PaymentId))
 	payCurrency, ok := enum.ParseStringToCryptoCurrencyEnum(payment.PayCurrency)
 	if !ok {
 
 	}
-	currentPayment, err := s.paymentRepository.FindByBlockchainIdAndCurrency(payment.PaymentId, payCurrency)
-	if err != nil {
-		// if the blockchain service creates a new payment but the backend cannot save it to the database
-		// we will get an expired update after 15min which is fine and can be ignored, because the buyer
-		// never sees the pay address
-		if errors.Is(err, gorm.ErrRecordNotFound) && payment.PaymentState == enum.Expired.String() {
-			return nil
+
+	var updatedPayment *model.Payment
+	var paymentState enum.State
+	err := s.unitOfWork.WithTx(ctx, func(repos repository.Repositories) error {
+		currentPayment, err := repos.Payment.FindByBlockchainIdAndCurrencyForUpdate(ctx, payment.PaymentId, payCurrency)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			currentPayment, err = switchToReplacedPayment(ctx, repos, payment, payCurrency)
+			if currentPayment == nil {
+				return err
+			}
+		}
+		if err != nil {
+			return err
 		}
-		return err
-	}
 
-	paymentState, ok := enum.ParseStringToStateEnum(payment.PaymentState)
+		paymentState, ok = enum.ParseStringToStateEnum(payment.PaymentState)
 
-	if paymentState != enum.PartiallyPaid {
-		for _, state := range currentPayment.PaymentStates {
-			if state.PaymentState.String() == payment.PaymentState {
-				log.Println(fmt.Sprintf("Payment %s with state %s already updated", payment.PaymentId, payment.PaymentState))
-				return nil
+		if paymentState != enum.PartiallyPaid {
+			for _, state := range currentPayment.PaymentStates {
+				// states of a replaced blockchain payment do not count, states before the currency change have no id
+				isCurrentBlockchainPayment := state.BlockchainPaymentId == currentPayment.BlockchainPaymentId || state.BlockchainPaymentId == uuid.Nil
+				if isCurrentBlockchainPayment && state.PaymentState.String() == payment.PaymentState {
+					slog.InfoContext(paymentLogContext(ctx, currentPayment), "Payment state already updated", "state", payment.PaymentState)
+					return nil
+				}
 			}
 		}
-	}
 
-	if !ok {
-		return err
-	}
-	newPaymentState := model.PaymentState{
-		PaymentState: paymentState,
-		ActuallyPaid: model.NewBigIntFromString(payment.ActuallyPaid),
-		PayAmount:    model.NewBigIntFromString(payment.PayAmount),
-	}
+		if !ok {
+			return err
+		}
+		newPaymentState := model.PaymentState{
+			BlockchainPaymentId: currentPayment.BlockchainPaymentId,
+			PayCurrency:         currentPayment.PayCurrency,
+			PayAddress:          currentPayment.PayAddress,
+			PaymentState:        paymentState,
+			ActuallyPaid:        model.NewBigIntFromString(payment.ActuallyPaid),
+			PayAmount:           model.NewBigIntFromString(payment.PayAmount),
+		}
+
+		currentPayment.PaymentStates = append(currentPayment.PaymentStates, newPaymentState)
 
-	currentPayment.PaymentStates = append(currentPayment.PaymentStates, newPaymentState)
+		currentPayment.TxHash = payment.TxHash
 
-	currentPayment.TxHash = payment.TxHash
+		err = repos.Payment.Update(ctx, currentPayment)
+		if err != nil {
+			return err
+		}
 
-	err = s.paymentRepository.Update(currentPayment)
+		updatedPayment, err = repos.Payment.FindByBlockchainIdAndCurrency(ctx, payment.PaymentId, payCurrency)
+		return err
+	})
 	if err != nil {
+		slog.ErrorContext(ctx, "Could not update payment", "state", payment.PaymentState, "error", err)
 		return err
 	}
+	if updatedPayment == nil {
+		return nil
+	}
+	ctx = paymentLogContext(ctx, updatedPayment)
+	slog.InfoContext(ctx, "Payment state updated", "state", paymentState.String(), "tx_hash", updatedPayment.TxHash)
+	metrics.PaymentStateTransitions.WithLabelValues(paymentState.String()).Inc()
+	body := model.NewSocketBody(updatedPayment, false)
+	message := model.NewStateMessage(paymentState, body)
+	if pool, ok := config.GetPool(updatedPayment.ID); ok {
+		pool.Publish(message)
+	}
 
-	currentPayment, err = s.paymentRepository.FindByBlockchainIdAndCurrency(payment.PaymentId, payCurrency)
+	err = s.callWebhook(ctx, updatedPayment)
 	if err != nil {
+		slog.ErrorContext(ctx, "Could not send webhook", "error", err)
 		return err
 	}
 
-	body := model.SocketBody{
-		InitialState:   false,
-		Currency:       currentPayment.PayCurrency.String(),
-		PayAddress:     currentPayment.PayAddress,
-		PayAmount:      currentPayment.PaymentStates[0].PayAmount.String(),
-		ActuallyPaid:   currentPayment.PaymentStates[0].ActuallyPaid.String(),
-		ExpireTime:     model.GetWaitingCreateDate(currentPayment).Add(15 * time.Minute),
-		Mode:           currentPayment.Mode.String(),
-		SuccessPageURL: currentPayment.SuccessPageUrl,
-		FailurePageURL: currentPayment.FailurePageUrl,
+	return nil
+}
+
+// switchToReplacedPayment handles an update of a blockchain payment which was replaced by a currency change.
+// Updates without funds are ignored. If the buyer paid to the replaced address anyway, the payment switches back
+// to the replaced blockchain payment. The abandoned blockchain payment keeps running until it expires, its updates are ignored the same way.
+// If the current blockchain payment received funds as well, nothing is changed and the funds have to be handled manually.
+func switchToReplacedPayment(ctx context.Context, repos repository.Repositories, update internalApi.PaymentUpdateDto, payCurrency enum.CryptoCurrency) (*model.Payment, error) {
+	switchedPayment, err := repos.Payment.FindByPreviousBlockchainId(ctx, update.PaymentId)
+	if errors.Is(err, gorm.ErrRecordNotFound) && update.PaymentState == enum.Expired.String() {
+		// if the blockchain service creates a new payment but the backend cannot save it to the database
+		// we will get an expired update after 15min which is fine and can be ignored, because the buyer
+		// never sees the pay address
+		slog.InfoContext(ctx, "Ignoring expired update of unknown blockchain payment")
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ctx = paymentLogContext(ctx, switchedPayment)
+	hasFunds := model.NewBigIntFromString(update.ActuallyPaid).Sign() > 0
+	if !hasFunds && (update.PaymentState == enum.Waiting.String() || update.PaymentState == enum.Expired.String()) {
+		slog.InfoContext(ctx, "Ignoring update of replaced blockchain payment", "state", update.PaymentState, "replaced_blockchain_payment_id", update.PaymentId)
+		return nil, nil
+	}
+
+	payment, err := repos.Payment.FindByPaymentIdForUpdate(ctx, switchedPayment.ID)
+	if err != nil {
+		return nil, err
+	}
+	currentState := payment.PaymentStates[0] //states are sorted
+	if currentState.PaymentState != enum.Waiting || currentState.ActuallyPaid.Sign() != 0 {
+		slog.ErrorContext(ctx, "Funds received on replaced blockchain payment, the current blockchain payment received funds as well",
+			"state", update.PaymentState, "actually_paid", update.ActuallyPaid, "replaced_blockchain_payment_id", update.PaymentId, "tx_hash", update.TxHash)
+		metrics.ReplacedPaymentFunds.WithLabelValues(payCurrency.String(), "manual").Inc()
+		return nil, nil
 	}
 
-	message := model.Message{MessageType: paymentState.String(), Body: body}
-	if pool, ok := config.Pools[currentPayment.ID]; ok {
-		pool.Broadcast <- message
+	var replacedState *model.PaymentState
+	for i, state := range payment.PaymentStates {
+		if state.BlockchainPaymentId.String() == update.PaymentId && state.PayCurrency == payCurrency {
+			replacedState = &payment.PaymentStates[i]
+			break
+		}
 	}
+	if replacedState == nil {
+		return nil, gorm.ErrRecordNotFound
+	}
+	merchant, err := repos.Merchant.FindById(ctx, payment.MerchantId)
+	if err != nil {
+		return nil, err
+	}
+	for i, wallet := range merchant.Wallets {
+		if wallet.Mode == payment.Mode && wallet.Currency == payCurrency {
+			payment.Wallet = &merchant.Wallets[i]
+			payment.WalletId = &merchant.Wallets[i].ID
+		}
+	}
+
+	slog.ErrorContext(ctx, "Funds received on replaced blockchain payment, switching back to it",
+		"state", update.PaymentState, "actually_paid", update.ActuallyPaid, "replaced_blockchain_payment_id", update.PaymentId,
+		"abandoned_blockchain_payment_id", currentState.BlockchainPaymentId)
+	metrics.ReplacedPaymentFunds.WithLabelValues(payCurrency.String(), "switched").Inc()
+	payment.BlockchainPaymentId = replacedState.BlockchainPaymentId
+	payment.PayCurrency = replacedState.PayCurrency
+	payment.PayAddress = replacedState.PayAddress
+	return payment, nil
+}
 
-	err = s.callWebhook(currentPayment)
+// SendWebhook sends the current state of the payment, the merchant has to handle duplicates anyway
+func (s *internalPaymentService) SendWebhook(ctx context.Context, payment *model.Payment) error {
+	ctx = paymentLogContext(ctx, payment)
+	err := s.callWebhook(ctx, payment)
 	if err != nil {
+		slog.ErrorContext(ctx, "Could not send webhook", "error", err)
 		return err
 	}
+	slog.InfoContext(ctx, "Webhook sent", "state", payment.PaymentStates[0].PaymentState.String())
+	return nil
+}
 
+// callWebhook sends the current state to the merchant, the outcome and the duration are measured and traced
+func (s *internalPaymentService) callWebhook(ctx context.Context, payment *model.Payment) error {
+	ctx, span := tracing.Start(ctx, "webhook", trace.WithAttributes(
+		attribute.String("payment_id", payment.ID.String()),
+		attribute.String("merchant_id", payment.MerchantId.String()),
+	))
+	start := time.Now()
+	err := s.sendWebhook(ctx, payment)
+	metrics.WebhookDuration.Observe(metrics.Since(start))
+	tracing.End(span, err)
+	if err != nil {
+		metrics.WebhookDeliveries.WithLabelValues("failure").Inc()
+		return err
+	}
+	metrics.WebhookDeliveries.WithLabelValues("success").Inc()
 	return nil
 }
 
-func (s *internalPaymentService) callWebhook(payment *model.Payment) error {
+func (s *internalPaymentService) sendWebhook(ctx context.Context, payment *model.Payment) error {
+	// the payment is already committed, the webhook is sent even if the blockchain service disconnected
+	ctx = context.WithoutCancel(ctx)
 	currentState := payment.PaymentStates[0] //states are sorted
 	payAmount, err := utils.ConvertAmountToBaseString(payment.PayCurrency, currentState.PayAmount.Int)
 	if err != nil {
@@ -159,7 +282,7 @@ func (s *internalPaymentService) callWebhook(payment *model.Payment) error {
 		},
 	}
 
-	apiKey, err := s.apiKeyRepository.FindByMerchantAndMode(payment.MerchantId, payment.Mode)
+	apiKey, err := s.apiKeyRepository.FindByMerchantAndMode(ctx, payment.MerchantId, payment.Mode)
 	if err != nil {
 		return err
 	}
@@ -175,8 +298,11 @@ func (s *internalPaymentService) callWebhook(payment *model.Payment) error {
 	webhook := *proxyClientApi.NewWebHookRequestDto(payment.CallbackUrl, body)
 	configuration := proxyClientApi.NewConfiguration()
 	configuration.Servers[0].URL = utils.Opts.ProxyBaseUrl
+	configuration.HTTPClient = proxyHttpClient()
 	apiClient := proxyClientApi.NewAPIClient(configuration)
-	_, err = apiClient.WebhookApi.SendWebhook(context.Background()).WebHookRequestDto(webhook).Execute()
+	ctx, cancel := proxyContext(ctx)
+	defer cancel()
+	_, err = apiClient.WebhookApi.SendWebhook(ctx).WebHookRequestDto(webhook).Execute()
 	if err != nil {
 		return err
 	}
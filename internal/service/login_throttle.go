@@ -0,0 +1,167 @@
+package service
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const freeLoginFailures = 3
+const maxLoginDelay = time.Minute * 5
+const loginFailureWindow = time.Hour * 1
+const maxTrackedLoginKeys = 100000
+
+// loginThrottle counts failed logins per client ip and per email in memory.
+// After a few failures every further attempt has to wait twice as long as the previous one.
+type loginThrottle struct {
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+	now      func() time.Time
+}
+
+type loginFailures struct {
+	count int
+	last  time.Time
+}
+
+func newLoginThrottle() *loginThrottle {
+	return &loginThrottle{failures: map[string]*loginFailures{}, now: time.Now}
+}
+
+// retryAfter returns how long the longest throttled key has to wait, 0 if a login is allowed
+func (t *loginThrottle) retryAfter(keys ...string) time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	var wait time.Duration
+	for _, key := range keys {
+		f, ok := t.failures[key]
+		if !ok {
+			continue
+		}
+		if now.Sub(f.last) > loginFailureWindow {
+			delete(t.failures, key)
+			continue
+		}
+		if remaining := f.last.Add(loginDelay(f.count)).Sub(now); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait
+}
+
+// count returns the failures of the key within loginFailureWindow
+func (t *loginThrottle) count(key string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	f, ok := t.failures[key]
+	if !ok || t.now().Sub(f.last) > loginFailureWindow {
+		return 0
+	}
+	return f.count
+}
+
+func (t *loginThrottle) fail(keys ...string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	for _, key := range keys {
+		f, ok := t.failures[key]
+		if !ok && len(t.failures) >= maxTrackedLoginKeys {
+			t.prune(now)
+			if len(t.failures) >= maxTrackedLoginKeys {
+				t.evictOldest(maxTrackedLoginKeys / 10)
+			}
+		}
+		if !ok || now.Sub(f.last) > loginFailureWindow {
+			f = &loginFailures{}
+			t.failures[key] = f
+		}
+		f.count++
+		f.last = now
+	}
+}
+
+func (t *loginThrottle) reset(key string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.failures, key)
+}
+
+func (t *loginThrottle) prune(now time.Time) {
+	for key, f := range t.failures {
+		if now.Sub(f.last) > loginFailureWindow {
+			delete(t.failures, key)
+		}
+	}
+}
+
+// evictOldest removes the n keys with the oldest failures, so the map stays bounded if all keys failed within loginFailureWindow
+func (t *loginThrottle) evictOldest(n int) {
+	keys := make([]string, 0, len(t.failures))
+	for key := range t.failures {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return t.failures[keys[i]].last.Before(t.failures[keys[j]].last) })
+	if n > len(keys) {
+		n = len(keys)
+	}
+	for _, key := range keys[:n] {
+		delete(t.failures, key)
+	}
+}
+
+// loginDelay is 1s after the first failures which are free, then 2s, 4s, ... up to maxLoginDelay
+func loginDelay(failures int) time.Duration {
+	if failures <= freeLoginFailures {
+		return 0
+	}
+	exponent := failures - freeLoginFailures - 1
+	if exponent >= 16 {
+		return maxLoginDelay
+	}
+	delay := time.Second << exponent
+	if delay > maxLoginDelay {
+		return maxLoginDelay
+	}
+	return delay
+}
+
+// resendThrottle allows one request per key and interval in memory, the keys are not checked against the database,
+// so the answer is the same for unknown and existing accounts
+type resendThrottle struct {
+	mu   sync.Mutex
+	last map[string]time.Time
+	now  func() time.Time
+}
+
+func newResendThrottle() *resendThrottle {
+	return &resendThrottle{last: map[string]time.Time{}, now: time.Now}
+}
+
+// allow records the request and reports whether the previous one of the key is longer than interval ago
+func (t *resendThrottle) allow(key string, interval time.Duration) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if last, ok := t.last[key]; ok && now.Sub(last) < interval {
+		return false
+	}
+	if len(t.last) >= maxTrackedLoginKeys {
+		for k, last := range t.last {
+			if now.Sub(last) >= interval {
+				delete(t.last, k)
+			}
+		}
+		// every key is within the interval, the request is allowed without tracking it
+		if len(t.last) >= maxTrackedLoginKeys {
+			return true
+		}
+	}
+	t.last[key] = now
+	return true
+}
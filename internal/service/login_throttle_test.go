@@ -0,0 +1,81 @@
+package service
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestLoginDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{freeLoginFailures, 0},
+		{freeLoginFailures + 1, time.Second},
+		{freeLoginFailures + 2, 2 * time.Second},
+		{freeLoginFailures + 4, 8 * time.Second},
+		{freeLoginFailures + 20, maxLoginDelay},
+		{1000, maxLoginDelay},
+	}
+	for _, tt := range tests {
+		if got := loginDelay(tt.failures); got != tt.want {
+			t.Errorf("Expected delay %s after %d failures, but got %s", tt.want, tt.failures, got)
+		}
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	now := time.Unix(1650000000, 0)
+	throttle := newLoginThrottle()
+	throttle.now = func() time.Time { return now }
+
+	for i := 0; i < freeLoginFailures; i++ {
+		throttle.fail("ip:1.2.3.4", "email:momo@mail.com")
+	}
+	if wait := throttle.retryAfter("ip:1.2.3.4", "email:momo@mail.com"); wait != 0 {
+		t.Errorf("Expected first failures to be free, but got wait %s", wait)
+	}
+
+	throttle.fail("ip:1.2.3.4", "email:momo@mail.com")
+	throttle.fail("ip:1.2.3.4")
+	if wait := throttle.retryAfter("ip:5.6.7.8", "email:momo@mail.com"); wait != time.Second {
+		t.Errorf("Expected wait of 1s for the email, but got %s", wait)
+	}
+	if wait := throttle.retryAfter("ip:1.2.3.4", "email:other@mail.com"); wait != 2*time.Second {
+		t.Errorf("Expected wait of 2s for the ip, but got %s", wait)
+	}
+
+	throttle.reset("email:momo@mail.com")
+	if wait := throttle.retryAfter("ip:5.6.7.8", "email:momo@mail.com"); wait != 0 {
+		t.Errorf("Expected no wait after reset, but got %s", wait)
+	}
+
+	now = now.Add(loginFailureWindow + time.Second)
+	if wait := throttle.retryAfter("ip:1.2.3.4"); wait != 0 {
+		t.Errorf("Expected failures to expire, but got wait %s", wait)
+	}
+}
+
+func TestLoginThrottleBounded(t *testing.T) {
+	now := time.Unix(1650000000, 0)
+	throttle := newLoginThrottle()
+	throttle.now = func() time.Time { return now }
+
+	for i := 0; i < maxTrackedLoginKeys; i++ {
+		throttle.fail("ip:" + strconv.Itoa(i))
+		now = now.Add(time.Millisecond)
+	}
+	throttle.fail("email:momo@mail.com")
+
+	if len(throttle.failures) > maxTrackedLoginKeys {
+		t.Errorf("Expected at most %d keys, but got %d", maxTrackedLoginKeys, len(throttle.failures))
+	}
+	if throttle.count("ip:0") != 0 {
+		t.Error("Expected the oldest key to be evicted")
+	}
+	if throttle.count("ip:"+strconv.Itoa(maxTrackedLoginKeys-1)) != 1 || throttle.count("email:momo@mail.com") != 1 {
+		t.Error("Expected the latest keys to be kept")
+	}
+}
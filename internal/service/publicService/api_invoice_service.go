@@ -14,6 +14,7 @@ import (
 	"errors"
 	"net/http"
 
+	"github.com/CHainGate/backend/internal/metrics"
 	"github.com/CHainGate/backend/internal/model"
 	"github.com/CHainGate/backend/internal/repository"
 	"github.com/CHainGate/backend/internal/service"
@@ -43,8 +44,8 @@ func NewInvoiceApiService(
 }
 
 // NewInvoice - Create a new invoice
-func (s *InvoiceApiService) NewInvoice(_ context.Context, xAPIKEY string, invoiceRequestDto publicApi.InvoiceRequestDto) (publicApi.ImplResponse, error) {
-	merchant, apiKey, err := s.authenticationService.HandleApiAuthentication(xAPIKEY)
+func (s *InvoiceApiService) NewInvoice(ctx context.Context, xAPIKEY string, invoiceRequestDto publicApi.InvoiceRequestDto) (publicApi.ImplResponse, error) {
+	merchant, apiKey, err := authenticate(ctx, s.authenticationService, xAPIKEY)
 	if err != nil {
 		if err.Error() == "not authorized" {
 			return publicApi.Response(http.StatusForbidden, nil), err
@@ -79,10 +80,12 @@ func (s *InvoiceApiService) NewInvoice(_ context.Context, xAPIKEY string, invoic
 		FailurePageUrl: invoiceRequestDto.FailurePageUrl,
 	}
 
-	err = s.paymentRepository.Create(&payment)
+	err = s.paymentRepository.Create(ctx, &payment)
 	if err != nil {
 		return publicApi.Response(http.StatusInternalServerError, nil), err
 	}
+	metrics.PaymentsCreated.WithLabelValues(payment.PayCurrency.String(), payment.Mode.String()).Inc()
+	metrics.PaymentStateTransitions.WithLabelValues(initialState.PaymentState.String()).Inc()
 
 	paymentResponseDto := publicApi.InvoiceResponseDto{
 		Id:             payment.ID.String(),
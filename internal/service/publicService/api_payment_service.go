@@ -16,6 +16,7 @@ import (
 	"github.com/CHainGate/backend/internal/utils"
 	"net/http"
 
+	"github.com/CHainGate/backend/internal/model"
 	"github.com/CHainGate/backend/internal/service"
 	"github.com/CHainGate/backend/pkg/enum"
 	"github.com/CHainGate/backend/publicApi"
@@ -38,8 +39,8 @@ func NewPaymentApiService(
 }
 
 // NewPayment - Create a new payment
-func (s *PaymentApiService) NewPayment(_ context.Context, xAPIKEY string, paymentRequestDto publicApi.PaymentRequestDto) (publicApi.ImplResponse, error) {
-	merchant, apiKey, err := s.authenticationService.HandleApiAuthentication(xAPIKEY)
+func (s *PaymentApiService) NewPayment(ctx context.Context, xAPIKEY string, paymentRequestDto publicApi.PaymentRequestDto) (publicApi.ImplResponse, error) {
+	merchant, apiKey, err := authenticate(ctx, s.authenticationService, xAPIKEY)
 	if err != nil {
 		if err.Error() == "not authorized" {
 			return publicApi.Response(http.StatusForbidden, nil), err
@@ -69,7 +70,7 @@ func (s *PaymentApiService) NewPayment(_ context.Context, xAPIKEY string, paymen
 		return publicApi.Response(http.StatusBadRequest, nil), errors.New(errorMessage)
 	}
 
-	payment, err := s.publicApiService.HandleNewPayment(priceCurrency, paymentRequestDto.PriceAmount, payCurrency, wallet, apiKey.Mode, paymentRequestDto.CallbackUrl, merchant)
+	payment, err := s.publicApiService.HandleNewPayment(ctx, priceCurrency, paymentRequestDto.PriceAmount, payCurrency, wallet, apiKey.Mode, paymentRequestDto.CallbackUrl, merchant)
 	if err != nil {
 		if err.Error() == "Pay amount is too low " {
 			return publicApi.Response(http.StatusBadRequest, nil), err
@@ -85,6 +86,10 @@ func (s *PaymentApiService) NewPayment(_ context.Context, xAPIKEY string, paymen
 	if err != nil {
 		return publicApi.Response(http.StatusInternalServerError, nil), err
 	}
+	paymentUri, err := model.GetPaymentUri(payment)
+	if err != nil {
+		return publicApi.Response(http.StatusInternalServerError, nil), err
+	}
 
 	paymentResponseDto := publicApi.PaymentResponseDto{
 		Id:            payment.ID.String(),
@@ -93,6 +98,7 @@ func (s *PaymentApiService) NewPayment(_ context.Context, xAPIKEY string, paymen
 		PriceCurrency: payment.PriceCurrency.String(),
 		PayAmount:     payAmount,
 		PayCurrency:   payment.PayCurrency.String(),
+		PaymentUri:    paymentUri,
 		ActuallyPaid:  actuallyPaid,
 		CallbackUrl:   payment.CallbackUrl,
 		PaymentState:  payment.PaymentStates[0].PaymentState.String(),
@@ -0,0 +1,52 @@
+package publicService
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/service"
+	"github.com/gorilla/mux"
+)
+
+type apiAuthenticationKey struct{}
+
+type apiAuthentication struct {
+	key      string
+	merchant *model.Merchant
+	apiKey   *model.ApiKey
+}
+
+// NewAuthenticationMiddleware validates the api key of the request and passes the merchant to the handlers in the request context.
+// Requests without a valid api key are not rejected here, the handlers answer them.
+func NewAuthenticationMiddleware(authenticationService service.IAuthenticationService) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := r.Header.Get("X-API-KEY")
+			if key == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			merchant, apiKey, err := authenticationService.HandleApiAuthentication(r.Context(), key)
+			if err != nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+			ctx := context.WithValue(r.Context(), apiAuthenticationKey{}, &apiAuthentication{key, merchant, apiKey})
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func apiAuthenticationFromContext(ctx context.Context) *apiAuthentication {
+	authentication, _ := ctx.Value(apiAuthenticationKey{}).(*apiAuthentication)
+	return authentication
+}
+
+// authenticate returns the merchant of the api key, it was already validated by the middleware for requests of the router
+func authenticate(ctx context.Context, authenticationService service.IAuthenticationService, key string) (*model.Merchant, *model.ApiKey, error) {
+	if authentication := apiAuthenticationFromContext(ctx); authentication != nil && authentication.key == key {
+		return authentication.merchant, authentication.apiKey, nil
+	}
+	return authenticationService.HandleApiAuthentication(ctx, key)
+}
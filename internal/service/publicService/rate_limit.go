@@ -0,0 +1,32 @@
+package publicService
+
+import (
+	"net/http"
+
+	"github.com/CHainGate/backend/internal/ratelimit"
+	"github.com/CHainGate/backend/internal/utils"
+	"github.com/gorilla/mux"
+)
+
+// NewIpRateLimitMiddleware limits the requests per client ip, it has to run before the authentication.
+// Requests with an api key get the public limit, so sending a new api key with every request does not get around it.
+func NewIpRateLimitMiddleware(store ratelimit.Store) mux.MiddlewareFunc {
+	return ratelimit.NewMiddleware(store, func(r *http.Request) (string, ratelimit.Limit) {
+		if r.Header.Get("X-API-KEY") == "" {
+			return "ip:" + utils.ClientIp(r), ratelimit.PerMinute(utils.Opts.RateLimitAnonymous)
+		}
+		return "apikey-ip:" + utils.ClientIp(r), ratelimit.PerMinute(utils.Opts.RateLimitPublic)
+	})
+}
+
+// NewApiKeyRateLimitMiddleware limits the requests per api key in addition to the client ip, it has to run after the authentication.
+// Only valid api keys have a bucket, so unknown keys do not fill the store.
+func NewApiKeyRateLimitMiddleware(store ratelimit.Store) mux.MiddlewareFunc {
+	return ratelimit.NewMiddleware(store, func(r *http.Request) (string, ratelimit.Limit) {
+		authentication := apiAuthenticationFromContext(r.Context())
+		if authentication == nil {
+			return "", ratelimit.Limit{}
+		}
+		return "apikey:" + authentication.apiKey.ID.String(), ratelimit.PerMinute(utils.Opts.RateLimitPublic)
+	})
+}
@@ -0,0 +1,96 @@
+package publicService
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/ratelimit"
+	"github.com/CHainGate/backend/internal/service"
+	"github.com/CHainGate/backend/internal/utils"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// fakeAuthentication accepts the api keys of the map
+type fakeAuthentication struct {
+	service.IAuthenticationService
+	apiKeys map[string]*model.ApiKey
+}
+
+func (f *fakeAuthentication) HandleApiAuthentication(_ context.Context, key string) (*model.Merchant, *model.ApiKey, error) {
+	apiKey, ok := f.apiKeys[key]
+	if !ok {
+		return nil, nil, errors.New("not authorized")
+	}
+	return &model.Merchant{}, apiKey, nil
+}
+
+func newRateLimitedRouter(apiKeys map[string]*model.ApiKey) *mux.Router {
+	store := ratelimit.NewMemoryStore()
+	router := mux.NewRouter()
+	router.HandleFunc("/api/public/payment", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	router.Use(
+		NewIpRateLimitMiddleware(store),
+		NewAuthenticationMiddleware(&fakeAuthentication{apiKeys: apiKeys}),
+		NewApiKeyRateLimitMiddleware(store),
+	)
+	return router
+}
+
+func setRateLimits(public int, anonymous int) {
+	utils.NewOpts(nil)
+	utils.Opts.RateLimitPublic = public
+	utils.Opts.RateLimitAnonymous = anonymous
+}
+
+func request(router *mux.Router, apiKey string, remoteAddr string) int {
+	r := httptest.NewRequest(http.MethodPost, "/api/public/payment", nil)
+	r.RemoteAddr = remoteAddr
+	if apiKey != "" {
+		r.Header.Set("X-API-KEY", apiKey)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	return w.Code
+}
+
+func TestRateLimitRotatingApiKeys(t *testing.T) {
+	setRateLimits(3, 1)
+	router := newRateLimitedRouter(nil)
+
+	for i := 0; i < 3; i++ {
+		if code := request(router, "key"+strconv.Itoa(i), "192.0.2.1:1234"); code != http.StatusCreated {
+			t.Fatalf("Expected request %d to be allowed, but got %d", i+1, code)
+		}
+	}
+	if code := request(router, "key3", "192.0.2.1:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected a new api key from the same ip to be limited, but got %d", code)
+	}
+	if code := request(router, "", "192.0.2.1:1234"); code != http.StatusCreated {
+		t.Errorf("Expected the request without api key to have its own limit, but got %d", code)
+	}
+	if code := request(router, "", "192.0.2.1:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the second request without api key to be limited, but got %d", code)
+	}
+}
+
+func TestRateLimitApiKey(t *testing.T) {
+	setRateLimits(2, 1)
+	router := newRateLimitedRouter(map[string]*model.ApiKey{"valid": {Base: model.Base{ID: uuid.New()}}})
+
+	for i, remoteAddr := range []string{"192.0.2.1:1234", "192.0.2.2:1234"} {
+		if code := request(router, "valid", remoteAddr); code != http.StatusCreated {
+			t.Fatalf("Expected request %d to be allowed, but got %d", i+1, code)
+		}
+	}
+	if code := request(router, "valid", "192.0.2.3:1234"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the api key to be limited from another ip, but got %d", code)
+	}
+}
@@ -4,9 +4,14 @@ import (
 	"context"
 	"errors"
 	"io/ioutil"
+	"log/slog"
+	"net/http"
 	"time"
 
 	"github.com/CHainGate/backend/internal/config"
+	"github.com/CHainGate/backend/internal/logging"
+	"github.com/CHainGate/backend/internal/metrics"
+	"github.com/CHainGate/backend/internal/tracing"
 
 	"github.com/CHainGate/backend/internal/utils"
 
@@ -15,18 +20,28 @@ import (
 	"github.com/CHainGate/backend/internal/model"
 	"github.com/CHainGate/backend/internal/repository"
 	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/CHainGate/backend/pkg/hmacauth"
 	"github.com/google/uuid"
 )
 
 type IPublicPaymentService interface {
-	HandleNewPayment(priceCurrency enum.FiatCurrency, priceAmount float64, payCurrency enum.CryptoCurrency, wallet string, mode enum.Mode, callback string, merchant *model.Merchant) (*model.Payment, error)
-	HandleNewInvoice(payment *model.Payment, currency enum.CryptoCurrency) (*model.Payment, error)
+	HandleNewPayment(ctx context.Context, priceCurrency enum.FiatCurrency, priceAmount float64, payCurrency enum.CryptoCurrency, wallet string, mode enum.Mode, callback string, merchant *model.Merchant) (*model.Payment, error)
+	HandleNewInvoice(ctx context.Context, payment *model.Payment, currency enum.CryptoCurrency) (*model.Payment, error)
+	HandleCurrencyChange(ctx context.Context, payment *model.Payment, currency enum.CryptoCurrency) (*model.Payment, error)
 }
 
+var (
+	ErrCurrencyChangeNotAllowed = errors.New("currency can only be changed while waiting for the payment ")
+	ErrSameCurrency             = errors.New("currency is already selected ")
+	ErrNoWalletForCurrency      = errors.New("merchant has no wallet for this currency ")
+)
+
 type publicPaymentService struct {
 	merchantRepository     repository.IMerchantRepository
 	paymentRepository      repository.IPaymentRepository
+	unitOfWork             repository.IUnitOfWork
 	internalPaymentService IInternalPaymentService
+	blockchain             blockchainClient
 }
 
 type PaymentResponse struct {
@@ -39,55 +54,34 @@ type PaymentResponse struct {
 	PayAddress    string
 }
 
-func NewPublicPaymentService(merchantRepository repository.IMerchantRepository, paymentRepository repository.IPaymentRepository, internalPaymentService IInternalPaymentService) IPublicPaymentService {
-	return &publicPaymentService{merchantRepository, paymentRepository, internalPaymentService}
+func NewPublicPaymentService(
+	merchantRepository repository.IMerchantRepository,
+	paymentRepository repository.IPaymentRepository,
+	unitOfWork repository.IUnitOfWork,
+	internalPaymentService IInternalPaymentService,
+) IPublicPaymentService {
+	return &publicPaymentService{merchantRepository, paymentRepository, unitOfWork, internalPaymentService, blockchainServices{}}
 }
 
-func (s *publicPaymentService) HandleNewPayment(priceCurrency enum.FiatCurrency, priceAmount float64, payCurrency enum.CryptoCurrency, wallet string, mode enum.Mode, callback string, merchant *model.Merchant) (*model.Payment, error) {
-	var paymentReponse PaymentResponse
-	if payCurrency == enum.ETH {
-		response, err := createEthPayment(priceCurrency, priceAmount, wallet, mode)
-		if err != nil {
-			return nil, err
-		}
-
-		paymentReponse = PaymentResponse{
-			PaymentId:     response.PaymentId,
-			PaymentState:  response.PaymentState,
-			PayCurrency:   response.PayCurrency,
-			PayAmount:     response.PayAmount,
-			PriceCurrency: response.PriceCurrency,
-			PriceAmount:   response.PriceAmount,
-			PayAddress:    response.PayAddress,
-		}
-	}
-
-	if payCurrency == enum.BTC {
-		response, err := createBtcPayment(priceCurrency, priceAmount, wallet, mode)
-		if err != nil {
-			return nil, err
-		}
-
-		paymentReponse = PaymentResponse{
-			PaymentId:     response.PaymentId,
-			PaymentState:  &response.PaymentState,
-			PayCurrency:   response.PayCurrency,
-			PayAmount:     response.PayAmount,
-			PriceCurrency: response.PriceCurrency,
-			PriceAmount:   response.PriceAmount,
-			PayAddress:    response.PayAddress,
-		}
+func (s *publicPaymentService) HandleNewPayment(ctx context.Context, priceCurrency enum.FiatCurrency, priceAmount float64, payCurrency enum.CryptoCurrency, wallet string, mode enum.Mode, callback string, merchant *model.Merchant) (*model.Payment, error) {
+	paymentReponse, err := s.blockchain.createPayment(ctx, payCurrency, priceCurrency, priceAmount, wallet, mode)
+	if err != nil {
+		return nil, err
 	}
 
-	payment, err := s.handleBlockchainResponsePayment(&paymentReponse, mode, callback, merchant)
+	payment, err := s.handleBlockchainResponsePayment(ctx, paymentReponse, mode, callback, merchant)
 	if err != nil {
 		return nil, err
 	}
+	slog.InfoContext(paymentLogContext(ctx, payment), "Payment created", "currency", payment.PayCurrency.String())
+	metrics.PaymentsCreated.WithLabelValues(payment.PayCurrency.String(), payment.Mode.String()).Inc()
+	metrics.PaymentStateTransitions.WithLabelValues(payment.PaymentStates[0].PaymentState.String()).Inc()
 	return payment, nil
 }
 
-func (s *publicPaymentService) HandleNewInvoice(initialPayment *model.Payment, currency enum.CryptoCurrency) (*model.Payment, error) {
-	m, err := s.merchantRepository.FindById(initialPayment.MerchantId)
+func (s *publicPaymentService) HandleNewInvoice(ctx context.Context, initialPayment *model.Payment, currency enum.CryptoCurrency) (*model.Payment, error) {
+	ctx = paymentLogContext(ctx, initialPayment)
+	m, err := s.merchantRepository.FindById(ctx, initialPayment.MerchantId)
 	if err != nil {
 		return nil, err
 	}
@@ -100,49 +94,119 @@ func (s *publicPaymentService) HandleNewInvoice(initialPayment *model.Payment, c
 
 	initialPayment.Wallet = &wallet
 
-	var paymentResponse PaymentResponse
-	if currency == enum.ETH {
-		response, err := createEthPayment(initialPayment.PriceCurrency, initialPayment.PriceAmount, initialPayment.Wallet.Address, initialPayment.Mode)
-		if err != nil {
-			return nil, err
-		}
+	paymentResponse, err := s.blockchain.createPayment(ctx, currency, initialPayment.PriceCurrency, initialPayment.PriceAmount, initialPayment.Wallet.Address, initialPayment.Mode)
+	if err != nil {
+		return nil, err
+	}
 
-		paymentResponse = PaymentResponse{
-			PaymentId:     response.PaymentId,
-			PaymentState:  response.PaymentState,
-			PayCurrency:   response.PayCurrency,
-			PayAmount:     response.PayAmount,
-			PriceCurrency: response.PriceCurrency,
-			PriceAmount:   response.PriceAmount,
-			PayAddress:    response.PayAddress,
+	payment, err := s.handleBlockchainResponseInvoice(ctx, paymentResponse, initialPayment)
+	if err != nil {
+		return nil, err
+	}
+	slog.InfoContext(paymentLogContext(ctx, payment), "Invoice currency selected", "currency", currency.String())
+	return payment, nil
+}
+
+// HandleCurrencyChange switches the pay currency of an invoice as long as the buyer has not sent anything.
+// The payment is checked again with the row locked, an update of the blockchain service may have come in meanwhile.
+// The new waiting state records the new currency and address in the history. The blockchain services cannot cancel a payment,
+// the previous one keeps running until it expires and its updates without funds are ignored.
+func (s *publicPaymentService) HandleCurrencyChange(ctx context.Context, payment *model.Payment, currency enum.CryptoCurrency) (*model.Payment, error) {
+	ctx = paymentLogContext(ctx, payment)
+	// checked before the new blockchain payment is created, most changes which are not allowed end here
+	err := checkCurrencyChange(payment, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := s.merchantRepository.FindById(ctx, payment.MerchantId)
+	if err != nil {
+		return nil, err
+	}
+	var wallet *model.Wallet
+	for i, w := range m.Wallets {
+		if payment.Mode == w.Mode && w.Currency == currency {
+			wallet = &m.Wallets[i]
 		}
 	}
+	if wallet == nil {
+		return nil, ErrNoWalletForCurrency
+	}
 
-	if currency == enum.BTC {
-		response, err := createBtcPayment(initialPayment.PriceCurrency, initialPayment.PriceAmount, initialPayment.Wallet.Address, initialPayment.Mode)
+	paymentResponse, err := s.blockchain.createPayment(ctx, currency, payment.PriceCurrency, payment.PriceAmount, wallet.Address, payment.Mode)
+	if err != nil {
+		return nil, err
+	}
+	newState, err := newBlockchainPaymentState(paymentResponse)
+	if err != nil {
+		return nil, err
+	}
+
+	var previousState model.PaymentState
+	var updatedPayment *model.Payment
+	err = s.unitOfWork.WithTx(ctx, func(repos repository.Repositories) error {
+		currentPayment, err := repos.Payment.FindByPaymentIdForUpdate(ctx, payment.ID)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		err = checkCurrencyChange(currentPayment, currency)
+		if err != nil {
+			return err
 		}
 
-		paymentResponse = PaymentResponse{
-			PaymentId:     response.PaymentId,
-			PaymentState:  &response.PaymentState,
-			PayCurrency:   response.PayCurrency,
-			PayAmount:     response.PayAmount,
-			PriceCurrency: response.PriceCurrency,
-			PriceAmount:   response.PriceAmount,
-			PayAddress:    response.PayAddress,
+		previousState = currentPayment.PaymentStates[0]
+		currentPayment.PayCurrency = newState.PayCurrency
+		currentPayment.BlockchainPaymentId = newState.BlockchainPaymentId
+		currentPayment.PayAddress = newState.PayAddress
+		currentPayment.Wallet = wallet
+		currentPayment.WalletId = &wallet.ID
+		currentPayment.PaymentStates = append(currentPayment.PaymentStates, *newState)
+		err = repos.Payment.Update(ctx, currentPayment)
+		if err != nil {
+			return err
 		}
-	}
 
-	payment, err := s.handleBlockchainResponseInvoice(&paymentResponse, initialPayment)
+		// reload to get the states sorted with the new state first
+		updatedPayment, err = repos.Payment.FindByPaymentId(ctx, payment.ID)
+		return err
+	})
 	if err != nil {
+		// the new blockchain payment expires unused, its expired update is ignored
 		return nil, err
 	}
-	return payment, nil
+
+	ctx = paymentLogContext(ctx, updatedPayment)
+	slog.InfoContext(ctx, "Payment switched currency", "from", previousState.PayCurrency.String(), "to", currency.String(),
+		"previous_blockchain_payment_id", previousState.BlockchainPaymentId)
+	metrics.PaymentStateTransitions.WithLabelValues(newState.PaymentState.String()).Inc()
+
+	body := model.NewSocketBody(updatedPayment, false)
+	message := model.NewStateMessage(newState.PaymentState, body)
+	if pool, ok := config.GetPool(updatedPayment.ID); ok {
+		pool.Publish(message)
+	}
+
+	// the currency is changed anyway, the merchant gets the new state with the next webhook
+	_ = s.internalPaymentService.SendWebhook(ctx, updatedPayment)
+	return updatedPayment, nil
+}
+
+// checkCurrencyChange allows changes while the payment is waiting for the first funds, at most 15 minutes
+func checkCurrencyChange(payment *model.Payment, currency enum.CryptoCurrency) error {
+	currentState := payment.PaymentStates[0] //states are sorted
+	if currentState.PaymentState != enum.Waiting || currentState.ActuallyPaid.Sign() != 0 {
+		return ErrCurrencyChangeNotAllowed
+	}
+	if time.Now().After(model.GetWaitingCreateDate(payment).Add(15 * time.Minute)) {
+		return ErrCurrencyChangeNotAllowed
+	}
+	if payment.PayCurrency == currency {
+		return ErrSameCurrency
+	}
+	return nil
 }
 
-func (s *publicPaymentService) handleBlockchainResponsePayment(resp *PaymentResponse, mode enum.Mode, callbackUrl string, merchant *model.Merchant) (*model.Payment, error) {
+func (s *publicPaymentService) handleBlockchainResponsePayment(ctx context.Context, resp *PaymentResponse, mode enum.Mode, callbackUrl string, merchant *model.Merchant) (*model.Payment, error) {
 	blockChainPaymentId, err := uuid.Parse(resp.PaymentId)
 	if err != nil {
 		return nil, err
@@ -152,12 +216,6 @@ func (s *publicPaymentService) handleBlockchainResponsePayment(resp *PaymentResp
 	if !ok {
 		return nil, err
 	}
-	initialState := model.PaymentState{
-		PaymentState: paymentState,
-		PayAmount:    model.NewBigIntFromString(resp.PayAmount),
-		ActuallyPaid: model.NewBigIntFromInt(0),
-	}
-
 	priceCurrency, ok := enum.ParseStringToFiatCurrencyEnum(resp.PriceCurrency)
 	if !ok {
 		return nil, err
@@ -166,6 +224,14 @@ func (s *publicPaymentService) handleBlockchainResponsePayment(resp *PaymentResp
 	if !ok {
 		return nil, err
 	}
+	initialState := model.PaymentState{
+		BlockchainPaymentId: blockChainPaymentId,
+		PayCurrency:         payCurrency,
+		PayAddress:          resp.PayAddress,
+		PaymentState:        paymentState,
+		PayAmount:           model.NewBigIntFromString(resp.PayAmount),
+		ActuallyPaid:        model.NewBigIntFromInt(0),
+	}
 	payment := model.Payment{
 		Base:                model.Base{ID: uuid.New()},
 		MerchantId:          merchant.ID,
@@ -180,7 +246,7 @@ func (s *publicPaymentService) handleBlockchainResponsePayment(resp *PaymentResp
 		Wallet:              &merchant.Wallets[0],
 	}
 
-	err = s.paymentRepository.Create(&payment)
+	err = s.paymentRepository.Create(ctx, &payment)
 	if err != nil {
 		return nil, err
 	}
@@ -188,79 +254,140 @@ func (s *publicPaymentService) handleBlockchainResponsePayment(resp *PaymentResp
 	return &payment, nil
 }
 
-func (s *publicPaymentService) handleBlockchainResponseInvoice(resp *PaymentResponse, payment *model.Payment) (*model.Payment, error) {
-	blockChainPaymentId, err := uuid.Parse(resp.PaymentId)
+func (s *publicPaymentService) handleBlockchainResponseInvoice(ctx context.Context, resp *PaymentResponse, payment *model.Payment) (*model.Payment, error) {
+	initialState, err := newBlockchainPaymentState(resp)
 	if err != nil {
 		return nil, err
 	}
-
-	paymentState, ok := enum.ParseStringToStateEnum(*resp.PaymentState)
-	if !ok {
-		return nil, err
-	}
-	initialState := model.PaymentState{
-		PaymentState: paymentState,
-		PayAmount:    model.NewBigIntFromString(resp.PayAmount),
-		ActuallyPaid: model.NewBigIntFromInt(0),
-	}
-
 	priceCurrency, ok := enum.ParseStringToFiatCurrencyEnum(resp.PriceCurrency)
 	if !ok {
-		return nil, err
-	}
-	payCurrency, ok := enum.ParseStringToCryptoCurrencyEnum(resp.PayCurrency)
-	if !ok {
-		return nil, err
+		return nil, errors.New("price currency not supported ")
 	}
 	payment.PriceAmount = resp.PriceAmount
 	payment.PriceCurrency = priceCurrency
-	payment.PayCurrency = payCurrency
-	payment.BlockchainPaymentId = blockChainPaymentId
+	payment.PayCurrency = initialState.PayCurrency
+	payment.BlockchainPaymentId = initialState.BlockchainPaymentId
 	payment.PayAddress = resp.PayAddress
 
-	err = s.internalPaymentService.AddNewPaymentState(payment, initialState)
+	ctx = paymentLogContext(ctx, payment)
+	err = s.internalPaymentService.AddNewPaymentState(ctx, payment, *initialState)
 	if err != nil {
 		return nil, err
 	}
 
-	body := model.SocketBody{
-		InitialState:   false,
-		Currency:       payment.PayCurrency.String(),
-		PayAddress:     payment.PayAddress,
-		PayAmount:      resp.PayAmount,
-		ActuallyPaid:   payment.PaymentStates[0].ActuallyPaid.String(),
-		ExpireTime:     model.GetWaitingCreateDate(payment).Add(15 * time.Minute),
-		Mode:           payment.Mode.String(),
-		SuccessPageURL: payment.SuccessPageUrl,
-		FailurePageURL: payment.FailurePageUrl,
+	// reload to get the states sorted with the new state first
+	payment, err = s.paymentRepository.FindByPaymentId(ctx, payment.ID)
+	if err != nil {
+		return nil, err
 	}
-	message := model.Message{MessageType: paymentState.String(), Body: body}
-	if pool, ok := config.Pools[payment.ID]; ok {
-		pool.Broadcast <- message
+
+	body := model.NewSocketBody(payment, false)
+	message := model.NewStateMessage(initialState.PaymentState, body)
+	if pool, ok := config.GetPool(payment.ID); ok {
+		pool.Publish(message)
 	}
 
 	return payment, nil
 }
 
-func createEthPayment(priceCurrency enum.FiatCurrency, priceAmount float64, wallet string, mode enum.Mode) (*ethClientApi.PaymentResponse, error) {
+// newBlockchainPaymentState is the first state of a new blockchain payment
+func newBlockchainPaymentState(resp *PaymentResponse) (*model.PaymentState, error) {
+	blockchainPaymentId, err := uuid.Parse(resp.PaymentId)
+	if err != nil {
+		return nil, err
+	}
+	paymentState, ok := enum.ParseStringToStateEnum(*resp.PaymentState)
+	if !ok {
+		return nil, errors.New("payment state not supported ")
+	}
+	payCurrency, ok := enum.ParseStringToCryptoCurrencyEnum(resp.PayCurrency)
+	if !ok {
+		return nil, errors.New("currency not supported ")
+	}
+	return &model.PaymentState{
+		BlockchainPaymentId: blockchainPaymentId,
+		PayCurrency:         payCurrency,
+		PayAddress:          resp.PayAddress,
+		PaymentState:        paymentState,
+		PayAmount:           model.NewBigIntFromString(resp.PayAmount),
+		ActuallyPaid:        model.NewBigIntFromInt(0),
+	}, nil
+}
+
+// blockchainClient creates the payments of the ethereum and the bitcoin service
+type blockchainClient interface {
+	createPayment(ctx context.Context, currency enum.CryptoCurrency, priceCurrency enum.FiatCurrency, priceAmount float64, wallet string, mode enum.Mode) (*PaymentResponse, error)
+}
+
+type blockchainServices struct{}
+
+func (blockchainServices) createPayment(ctx context.Context, currency enum.CryptoCurrency, priceCurrency enum.FiatCurrency, priceAmount float64, wallet string, mode enum.Mode) (*PaymentResponse, error) {
+	return createBlockchainPayment(ctx, currency, priceCurrency, priceAmount, wallet, mode)
+}
+
+func createBlockchainPayment(ctx context.Context, currency enum.CryptoCurrency, priceCurrency enum.FiatCurrency, priceAmount float64, wallet string, mode enum.Mode) (*PaymentResponse, error) {
+	switch currency {
+	case enum.ETH:
+		response, err := createEthPayment(ctx, priceCurrency, priceAmount, wallet, mode)
+		if err != nil {
+			return nil, err
+		}
+
+		return &PaymentResponse{
+			PaymentId:     response.PaymentId,
+			PaymentState:  response.PaymentState,
+			PayCurrency:   response.PayCurrency,
+			PayAmount:     response.PayAmount,
+			PriceCurrency: response.PriceCurrency,
+			PriceAmount:   response.PriceAmount,
+			PayAddress:    response.PayAddress,
+		}, nil
+	case enum.BTC:
+		response, err := createBtcPayment(ctx, priceCurrency, priceAmount, wallet, mode)
+		if err != nil {
+			return nil, err
+		}
+
+		return &PaymentResponse{
+			PaymentId:     response.PaymentId,
+			PaymentState:  &response.PaymentState,
+			PayCurrency:   response.PayCurrency,
+			PayAmount:     response.PayAmount,
+			PriceCurrency: response.PriceCurrency,
+			PriceAmount:   response.PriceAmount,
+			PayAddress:    response.PayAddress,
+		}, nil
+	}
+	return nil, errors.New("currency not supported ")
+}
+
+func createEthPayment(ctx context.Context, priceCurrency enum.FiatCurrency, priceAmount float64, wallet string, mode enum.Mode) (*ethClientApi.PaymentResponse, error) {
 	paymentRequest := *ethClientApi.NewPaymentRequest(priceCurrency.String(), priceAmount, wallet, mode.String())
 	configuration := ethClientApi.NewConfiguration()
 	configuration.Servers[0].URL = utils.Opts.EthereumBaseUrl
+	configuration.HTTPClient = blockchainHttpClient("ethereum")
 	apiClient := ethClientApi.NewAPIClient(configuration)
-	resp, _, err := apiClient.PaymentApi.CreatePayment(context.Background()).PaymentRequest(paymentRequest).Execute()
+	ctx, cancel := blockchainContext(ctx)
+	defer cancel()
+	resp, _, err := apiClient.PaymentApi.CreatePayment(ctx).PaymentRequest(paymentRequest).Execute()
 	if err != nil {
+		slog.ErrorContext(ctx, "Could not create ethereum payment", "error", err)
 		return nil, err
 	}
 	return resp, nil
 }
 
-func createBtcPayment(priceCurrency enum.FiatCurrency, priceAmount float64, wallet string, mode enum.Mode) (*btcClientApi.PaymentResponseDto, error) {
+func createBtcPayment(ctx context.Context, priceCurrency enum.FiatCurrency, priceAmount float64, wallet string, mode enum.Mode) (*btcClientApi.PaymentResponseDto, error) {
 	paymentRequest := *btcClientApi.NewPaymentRequestDto(priceCurrency.String(), priceAmount, wallet, mode.String())
 	configuration := btcClientApi.NewConfiguration()
 	configuration.Servers[0].URL = utils.Opts.BitcoinBaseUrl
+	configuration.HTTPClient = blockchainHttpClient("bitcoin")
 	apiClient := btcClientApi.NewAPIClient(configuration)
-	resp, h, err := apiClient.PaymentApi.CreatePayment(context.Background()).PaymentRequestDto(paymentRequest).Execute()
+	ctx, cancel := blockchainContext(ctx)
+	defer cancel()
+	resp, h, err := apiClient.PaymentApi.CreatePayment(ctx).PaymentRequestDto(paymentRequest).Execute()
 	if err != nil {
+		slog.ErrorContext(ctx, "Could not create bitcoin payment", "error", err)
 		body, _ := ioutil.ReadAll(h.Body)
 		if string(body) == "\"Pay amount is too low \"\n" {
 			return nil, errors.New("Pay amount is too low ")
@@ -269,3 +396,13 @@ func createBtcPayment(priceCurrency enum.FiatCurrency, priceAmount float64, wall
 	}
 	return resp, nil
 }
+
+// blockchainHttpClient signs the requests, so the blockchain services can verify they come from the backend.
+// The request id and the trace context are sent along to find the log lines and spans of a request in all services.
+func blockchainHttpClient(service string) *http.Client {
+	transport := &logging.Transport{}
+	if utils.Opts.InternalApiSecret != "" {
+		transport.Base = &hmacauth.Transport{Secret: []byte(utils.Opts.InternalApiSecret)}
+	}
+	return &http.Client{Transport: tracing.NewTransport(service, &metrics.Transport{Service: service, Base: transport})}
+}
@@ -0,0 +1,327 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/h2non/gock.v1"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/internalApi"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+)
+
+func newWaitingPayment(actuallyPaid int64, waitingSince time.Time) *model.Payment {
+	waiting := model.PaymentState{
+		PaymentState: enum.Waiting,
+		PayAmount:    model.NewBigIntFromInt(1000),
+		ActuallyPaid: model.NewBigIntFromInt(actuallyPaid),
+	}
+	waiting.CreatedAt = waitingSince
+	return &model.Payment{
+		PayCurrency:   enum.ETH,
+		Mode:          enum.Test,
+		PaymentStates: []model.PaymentState{waiting},
+	}
+}
+
+func TestHandleCurrencyChangeRejected(t *testing.T) {
+	publicService := &publicPaymentService{}
+
+	partiallyPaid := newWaitingPayment(0, time.Now())
+	partiallyPaid.PaymentStates[0].PaymentState = enum.PartiallyPaid
+
+	tests := []struct {
+		name     string
+		payment  *model.Payment
+		currency enum.CryptoCurrency
+		err      error
+	}{
+		{"already received funds", newWaitingPayment(10, time.Now()), enum.BTC, ErrCurrencyChangeNotAllowed},
+		{"partially paid", partiallyPaid, enum.BTC, ErrCurrencyChangeNotAllowed},
+		{"expired", newWaitingPayment(0, time.Now().Add(-20*time.Minute)), enum.BTC, ErrCurrencyChangeNotAllowed},
+		{"same currency", newWaitingPayment(0, time.Now()), enum.ETH, ErrSameCurrency},
+	}
+
+	for _, test := range tests {
+		_, err := publicService.HandleCurrencyChange(context.Background(), test.payment, test.currency)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%s: expected error %v, but got %v", test.name, test.err, err)
+		}
+	}
+}
+
+// fakeBlockchain answers with the next payment id
+type fakeBlockchain struct {
+	nextId uuid.UUID
+}
+
+func (b *fakeBlockchain) createPayment(_ context.Context, currency enum.CryptoCurrency, priceCurrency enum.FiatCurrency, priceAmount float64, wallet string, _ enum.Mode) (*PaymentResponse, error) {
+	state := enum.Waiting.String()
+	return &PaymentResponse{
+		PaymentId:     b.nextId.String(),
+		PaymentState:  &state,
+		PayCurrency:   currency.String(),
+		PayAmount:     "2000",
+		PriceCurrency: priceCurrency.String(),
+		PriceAmount:   priceAmount,
+		PayAddress:    wallet + "-pay",
+	}, nil
+}
+
+func newPaymentServices() (*publicPaymentService, *internalPaymentService, *fakeBlockchain, repository.Repositories) {
+	repos, unitOfWork := newRepositories()
+	blockchain := &fakeBlockchain{nextId: uuid.New()}
+	internalService := &internalPaymentService{repos.Payment, repos.ApiKey, unitOfWork}
+	publicService := &publicPaymentService{repos.Merchant, repos.Payment, unitOfWork, internalService, blockchain}
+	return publicService, internalService, blockchain, repos
+}
+
+// createEthInvoice creates an invoice of a merchant with an eth and a btc wallet, waiting for eth
+func createEthInvoice(t *testing.T, repos repository.Repositories) *model.Payment {
+	t.Helper()
+	merchant := &model.Merchant{
+		Email: "momo@mail.com",
+		Wallets: []model.Wallet{
+			{Currency: enum.ETH, Mode: enum.Test, Address: "0xeth"},
+			{Currency: enum.BTC, Mode: enum.Test, Address: "btc"},
+		},
+	}
+	createMerchant(t, repos, merchant)
+
+	now := time.Now()
+	blockchainPaymentId := uuid.New()
+	payment := &model.Payment{
+		MerchantId:          merchant.ID,
+		Mode:                enum.Test,
+		PriceAmount:         10,
+		PriceCurrency:       enum.USD,
+		PayCurrency:         enum.ETH,
+		PayAddress:          "0xeth-pay",
+		BlockchainPaymentId: blockchainPaymentId,
+		CallbackUrl:         "https://merchant.example/webhook",
+		Wallet:              &merchant.Wallets[0],
+		PaymentStates: []model.PaymentState{
+			{
+				Base:         model.Base{CreatedAt: now.Add(-2 * time.Minute)},
+				PaymentState: enum.CurrencySelection,
+				PayAmount:    model.NewBigIntFromInt(0),
+				ActuallyPaid: model.NewBigIntFromInt(0),
+			},
+			{
+				Base:                model.Base{CreatedAt: now.Add(-time.Minute)},
+				BlockchainPaymentId: blockchainPaymentId,
+				PayCurrency:         enum.ETH,
+				PayAddress:          "0xeth-pay",
+				PaymentState:        enum.Waiting,
+				PayAmount:           model.NewBigIntFromInt(1000),
+				ActuallyPaid:        model.NewBigIntFromInt(0),
+			},
+		},
+	}
+	if err := repos.Payment.Create(context.Background(), payment); err != nil {
+		t.Fatalf("Create: got error %s", err.Error())
+	}
+	found, err := repos.Payment.FindByPaymentId(context.Background(), payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return found
+}
+
+func mockWebhooks() {
+	gock.New("localhost:8001").
+		Post("/api").
+		Persist().
+		Reply(200)
+}
+
+func TestHandleCurrencyChange(t *testing.T) {
+	defer gock.Off()
+	mockWebhooks()
+	publicService, _, blockchain, repos := newPaymentServices()
+	payment := createEthInvoice(t, repos)
+
+	changed, err := publicService.HandleCurrencyChange(context.Background(), payment, enum.BTC)
+	if err != nil {
+		t.Fatalf("HandleCurrencyChange: got error %s", err.Error())
+	}
+
+	found, err := repos.Payment.FindByPaymentId(context.Background(), payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed.BlockchainPaymentId != blockchain.nextId || found.BlockchainPaymentId != blockchain.nextId {
+		t.Errorf("Expected blockchain payment %s, but got %s", blockchain.nextId, found.BlockchainPaymentId)
+	}
+	if found.PayCurrency != enum.BTC || found.PayAddress != "btc-pay" {
+		t.Errorf("Expected btc pay address, but got %s %s", found.PayAddress, found.PayCurrency)
+	}
+	current := found.PaymentStates[0]
+	if len(found.PaymentStates) != 3 || current.PaymentState != enum.Waiting || current.PayCurrency != enum.BTC || current.BlockchainPaymentId != blockchain.nextId {
+		t.Errorf("Expected a new waiting state of the btc payment")
+	}
+}
+
+func TestHandleCurrencyChangeAfterUpdate(t *testing.T) {
+	defer gock.Off()
+	mockWebhooks()
+	publicService, internalService, _, repos := newPaymentServices()
+	// the payment is loaded before the update arrives
+	payment := createEthInvoice(t, repos)
+
+	err := internalService.HandlePaymentUpdate(context.Background(), internalApi.PaymentUpdateDto{
+		PaymentId:    payment.BlockchainPaymentId.String(),
+		PayAmount:    "1000",
+		PayCurrency:  enum.ETH.String(),
+		ActuallyPaid: "400",
+		PaymentState: enum.PartiallyPaid.String(),
+	})
+	if err != nil {
+		t.Fatalf("HandlePaymentUpdate: got error %s", err.Error())
+	}
+
+	_, err = publicService.HandleCurrencyChange(context.Background(), payment, enum.BTC)
+	if !errors.Is(err, ErrCurrencyChangeNotAllowed) {
+		t.Fatalf("Expected error %v, but got %v", ErrCurrencyChangeNotAllowed, err)
+	}
+	found, err := repos.Payment.FindByPaymentId(context.Background(), payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.PayCurrency != enum.ETH || found.PaymentStates[0].PaymentState != enum.PartiallyPaid {
+		t.Errorf("Expected the partially paid eth payment to be kept")
+	}
+}
+
+func TestHandlePaymentUpdateOfReplacedPayment(t *testing.T) {
+	defer gock.Off()
+	mockWebhooks()
+	publicService, internalService, blockchain, repos := newPaymentServices()
+	payment := createEthInvoice(t, repos)
+	ethPaymentId := payment.BlockchainPaymentId
+	btcPaymentId := blockchain.nextId
+	_, err := publicService.HandleCurrencyChange(context.Background(), payment, enum.BTC)
+	if err != nil {
+		t.Fatalf("HandleCurrencyChange: got error %s", err.Error())
+	}
+
+	update := internalApi.PaymentUpdateDto{
+		PaymentId:    ethPaymentId.String(),
+		PayAmount:    "1000",
+		PayCurrency:  enum.ETH.String(),
+		ActuallyPaid: "0",
+		PaymentState: enum.Waiting.String(),
+	}
+	err = internalService.HandlePaymentUpdate(context.Background(), update)
+	if err != nil {
+		t.Fatalf("HandlePaymentUpdate: got error %s", err.Error())
+	}
+	found, err := repos.Payment.FindByPaymentId(context.Background(), payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.BlockchainPaymentId != btcPaymentId || len(found.PaymentStates) != 3 {
+		t.Fatalf("Expected the update without funds to be ignored")
+	}
+
+	// the buyer paid to the eth address shown before the change
+	update.ActuallyPaid = "1000"
+	update.PaymentState = enum.Paid.String()
+	update.TxHash = "0xabc"
+	err = internalService.HandlePaymentUpdate(context.Background(), update)
+	if err != nil {
+		t.Fatalf("HandlePaymentUpdate: got error %s", err.Error())
+	}
+	found, err = repos.Payment.FindByPaymentId(context.Background(), payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.BlockchainPaymentId != ethPaymentId || found.PayCurrency != enum.ETH || found.PayAddress != "0xeth-pay" || found.TxHash != "0xabc" {
+		t.Errorf("Expected the payment to switch back to the eth payment")
+	}
+	current := found.PaymentStates[0]
+	if current.PaymentState != enum.Paid || current.BlockchainPaymentId != ethPaymentId || current.ActuallyPaid.String() != "1000" {
+		t.Errorf("Expected a paid state of the eth payment, but got %s", current.PaymentState)
+	}
+	if found.WalletId == nil || *found.WalletId != findWallet(t, repos, found.MerchantId, enum.ETH).ID {
+		t.Errorf("Expected the eth wallet")
+	}
+
+	// the abandoned btc payment expires without funds
+	err = internalService.HandlePaymentUpdate(context.Background(), internalApi.PaymentUpdateDto{
+		PaymentId:    btcPaymentId.String(),
+		PayAmount:    "2000",
+		PayCurrency:  enum.BTC.String(),
+		ActuallyPaid: "0",
+		PaymentState: enum.Expired.String(),
+	})
+	if err != nil {
+		t.Fatalf("HandlePaymentUpdate: got error %s", err.Error())
+	}
+	found, err = repos.Payment.FindByPaymentId(context.Background(), payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.BlockchainPaymentId != ethPaymentId || found.PaymentStates[0].PaymentState != enum.Paid {
+		t.Errorf("Expected the expired update of the abandoned btc payment to be ignored")
+	}
+}
+
+func TestHandlePaymentUpdateOfReplacedPaymentAfterFunds(t *testing.T) {
+	defer gock.Off()
+	mockWebhooks()
+	publicService, internalService, blockchain, repos := newPaymentServices()
+	payment := createEthInvoice(t, repos)
+	ethPaymentId := payment.BlockchainPaymentId
+	btcPaymentId := blockchain.nextId
+	_, err := publicService.HandleCurrencyChange(context.Background(), payment, enum.BTC)
+	if err != nil {
+		t.Fatalf("HandleCurrencyChange: got error %s", err.Error())
+	}
+	err = internalService.HandlePaymentUpdate(context.Background(), internalApi.PaymentUpdateDto{
+		PaymentId:    btcPaymentId.String(),
+		PayAmount:    "2000",
+		PayCurrency:  enum.BTC.String(),
+		ActuallyPaid: "2000",
+		PaymentState: enum.Paid.String(),
+	})
+	if err != nil {
+		t.Fatalf("HandlePaymentUpdate: got error %s", err.Error())
+	}
+
+	// both were paid, the payment keeps the btc payment and the eth funds are handled manually
+	err = internalService.HandlePaymentUpdate(context.Background(), internalApi.PaymentUpdateDto{
+		PaymentId:    ethPaymentId.String(),
+		PayAmount:    "1000",
+		PayCurrency:  enum.ETH.String(),
+		ActuallyPaid: "1000",
+		PaymentState: enum.Paid.String(),
+	})
+	if err != nil {
+		t.Fatalf("HandlePaymentUpdate: got error %s", err.Error())
+	}
+	found, err := repos.Payment.FindByPaymentId(context.Background(), payment.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.BlockchainPaymentId != btcPaymentId || found.PaymentStates[0].PayCurrency != enum.BTC {
+		t.Errorf("Expected the paid btc payment to be kept")
+	}
+}
+
+func findWallet(t *testing.T, repos repository.Repositories, merchantId uuid.UUID, currency enum.CryptoCurrency) model.Wallet {
+	t.Helper()
+	merchant := findMerchant(t, repos, merchantId)
+	for _, wallet := range merchant.Wallets {
+		if wallet.Currency == currency {
+			return wallet
+		}
+	}
+	t.Fatalf("Expected a %s wallet", currency)
+	return model.Wallet{}
+}
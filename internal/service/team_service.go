@@ -0,0 +1,233 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/internal/utils"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const invitationDuration = time.Hour * 24 * 7
+
+var (
+	ErrInvalidInvitation = errors.New("Invitation is invalid or expired ")
+	ErrRoleNotAssignable = errors.New("Role cannot be assigned ")
+	ErrAlreadyRegistered = errors.New("E-Mail already exists ")
+	ErrMemberNotFound    = errors.New("Member not found ")
+)
+
+// Principal is the authenticated user acting for an organization
+type Principal struct {
+	User         *model.Merchant
+	Organization *model.Merchant
+	Role         enum.Role
+}
+
+// HasRole reports whether the principal has one of the roles
+func (p *Principal) HasRole(roles ...enum.Role) bool {
+	for _, role := range roles {
+		if p.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+type Member struct {
+	Merchant *model.Merchant
+	Role     enum.Role
+}
+
+type ITeamService interface {
+	GetPrincipal(ctx context.Context, user *model.Merchant) (*Principal, error)
+	GetMembers(ctx context.Context, organization *model.Merchant) ([]Member, error)
+	InviteMember(ctx context.Context, organization *model.Merchant, email string, role enum.Role) error
+	AcceptInvitation(ctx context.Context, token string, firstName string, lastName string, password string) error
+	UpdateMemberRole(ctx context.Context, organization *model.Merchant, memberId uuid.UUID, role enum.Role) error
+	RemoveMember(ctx context.Context, organization *model.Merchant, memberId uuid.UUID) error
+}
+
+type teamService struct {
+	merchantRepository repository.IMerchantRepository
+	teamRepository     repository.ITeamRepository
+	unitOfWork         repository.IUnitOfWork
+}
+
+func NewTeamService(
+	merchantRepository repository.IMerchantRepository,
+	teamRepository repository.ITeamRepository,
+	unitOfWork repository.IUnitOfWork,
+) ITeamService {
+	return &teamService{merchantRepository, teamRepository, unitOfWork}
+}
+
+// GetPrincipal resolves the organization of the user. Users without membership are the owner of their own organization.
+func (s *teamService) GetPrincipal(ctx context.Context, user *model.Merchant) (*Principal, error) {
+	membership, err := s.teamRepository.FindMembershipByMerchantId(ctx, user.ID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return &Principal{User: user, Organization: user, Role: enum.Owner}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	organization, err := s.merchantRepository.FindById(ctx, membership.OrganizationId)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Principal{User: user, Organization: organization, Role: membership.Role}, nil
+}
+
+// GetMembers returns the owner followed by all members
+func (s *teamService) GetMembers(ctx context.Context, organization *model.Merchant) ([]Member, error) {
+	memberships, err := s.teamRepository.FindMembershipsByOrganizationId(ctx, organization.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	members := []Member{{Merchant: organization, Role: enum.Owner}}
+	for _, membership := range memberships {
+		merchant, err := s.merchantRepository.FindById(ctx, membership.MerchantId)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, Member{Merchant: merchant, Role: membership.Role})
+	}
+	return members, nil
+}
+
+// InviteMember sends an invitation link. Invited users get a new account, existing accounts cannot be invited.
+func (s *teamService) InviteMember(ctx context.Context, organization *model.Merchant, email string, role enum.Role) error {
+	if role == enum.Owner {
+		return ErrRoleNotAssignable
+	}
+
+	_, err := s.merchantRepository.FindByEmail(ctx, email)
+	if err == nil {
+		return ErrAlreadyRegistered
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return err
+	}
+
+	token, err := createToken()
+	if err != nil {
+		return err
+	}
+
+	err = s.teamRepository.CreateInvitation(ctx, &model.Invitation{
+		OrganizationId: organization.ID,
+		Email:          email,
+		Role:           role,
+		TokenHash:      hashToken(token),
+		ExpiresAt:      time.Now().Add(invitationDuration),
+	})
+	if err != nil {
+		return err
+	}
+
+	return sendInvitationEmail(ctx, organization, email, token)
+}
+
+// AcceptInvitation creates the account of the invited user. The email is verified by the invitation.
+func (s *teamService) AcceptInvitation(ctx context.Context, token string, firstName string, lastName string, password string) error {
+	err := validatePassword(password)
+	if err != nil {
+		return err
+	}
+
+	invitation, err := s.teamRepository.FindInvitationByTokenHash(ctx, hashToken(token))
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrInvalidInvitation
+	}
+	if err != nil {
+		return err
+	}
+	if invitation.AcceptedAt != nil || invitation.ExpiresAt.Before(time.Now()) {
+		return ErrInvalidInvitation
+	}
+
+	salt, err := createSalt()
+	if err != nil {
+		return err
+	}
+
+	encryptedPassword, err := scryptPassword(password, salt)
+	if err != nil {
+		return err
+	}
+
+	merchant := model.Merchant{
+		FirstName: firstName,
+		LastName:  lastName,
+		Email:     invitation.Email,
+		Password:  encryptedPassword,
+		Salt:      salt,
+		IsActive:  true,
+		Membership: &model.Membership{
+			OrganizationId: invitation.OrganizationId,
+			Role:           invitation.Role,
+		},
+	}
+
+	// the account is only created if the invitation was not accepted concurrently
+	return s.unitOfWork.WithTx(ctx, func(repos repository.Repositories) error {
+		accepted, err := repos.Team.MarkInvitationAccepted(ctx, invitation.ID)
+		if err != nil {
+			return err
+		}
+		if !accepted {
+			return ErrInvalidInvitation
+		}
+		return repos.Merchant.Create(ctx, &merchant)
+	})
+}
+
+func (s *teamService) UpdateMemberRole(ctx context.Context, organization *model.Merchant, memberId uuid.UUID, role enum.Role) error {
+	if role == enum.Owner {
+		return ErrRoleNotAssignable
+	}
+
+	updated, err := s.teamRepository.UpdateMembershipRole(ctx, organization.ID, memberId, role)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+// RemoveMember turns the member back into a standalone account
+func (s *teamService) RemoveMember(ctx context.Context, organization *model.Merchant, memberId uuid.UUID) error {
+	deleted, err := s.teamRepository.DeleteMembership(ctx, organization.ID, memberId)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrMemberNotFound
+	}
+	return nil
+}
+
+func sendInvitationEmail(ctx context.Context, organization *model.Merchant, email string, token string) error {
+	baseUrl, err := url.Parse(utils.Opts.InvitationUrl)
+	if err != nil {
+		return err
+	}
+	params := url.Values{}
+	params.Add("token", token)
+
+	baseUrl.RawQuery = params.Encode()
+
+	content := organization.FirstName + " " + organization.LastName + " invited you to their CHainGate account: " + baseUrl.String()
+	return sendEmail(ctx, email, email, "Invitation to CHainGate", content)
+}
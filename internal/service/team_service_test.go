@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/google/uuid"
+)
+
+func newTeamService() (ITeamService, repository.Repositories) {
+	repos, unitOfWork := newRepositories()
+	return NewTeamService(repos.Merchant, repos.Team, unitOfWork), repos
+}
+
+func createInvitation(t *testing.T, repos repository.Repositories, organizationId uuid.UUID, token string, expiresAt time.Time) {
+	t.Helper()
+	invitation := &model.Invitation{
+		OrganizationId: organizationId,
+		Email:          "new@mail.com",
+		Role:           enum.Developer,
+		TokenHash:      hashToken(token),
+		ExpiresAt:      expiresAt,
+	}
+	if err := repos.Team.CreateInvitation(context.Background(), invitation); err != nil {
+		t.Fatalf("CreateInvitation: got error %s", err.Error())
+	}
+}
+
+func TestGetPrincipalOwner(t *testing.T) {
+	teamService, repos := newTeamService()
+	owner := &model.Merchant{Email: "owner@mail.com", IsActive: true}
+	createMerchant(t, repos, owner)
+
+	principal, err := teamService.GetPrincipal(context.Background(), owner)
+	if err != nil {
+		t.Fatalf("GetPrincipal: got error %s", err.Error())
+	}
+	if principal.Organization != owner || principal.Role != enum.Owner {
+		t.Errorf("Expected merchant without membership to be the owner of its organization")
+	}
+}
+
+func TestGetPrincipalMember(t *testing.T) {
+	teamService, repos := newTeamService()
+	owner := &model.Merchant{Email: "owner@mail.com", IsActive: true}
+	createMerchant(t, repos, owner)
+	member := &model.Merchant{Email: "member@mail.com", IsActive: true, Membership: &model.Membership{OrganizationId: owner.ID, Role: enum.Finance}}
+	createMerchant(t, repos, member)
+
+	principal, err := teamService.GetPrincipal(context.Background(), member)
+	if err != nil {
+		t.Fatalf("GetPrincipal: got error %s", err.Error())
+	}
+	if principal.Organization.ID != owner.ID {
+		t.Errorf("Expected organization %s, but got %s", owner.ID, principal.Organization.ID)
+	}
+	if !principal.HasRole(enum.Owner, enum.Finance) || principal.HasRole(enum.Owner, enum.Admin) {
+		t.Errorf("Expected principal to have role %s", enum.Finance)
+	}
+}
+
+func TestOwnerRoleNotAssignable(t *testing.T) {
+	teamService, _ := newTeamService()
+
+	err := teamService.InviteMember(context.Background(), testMerchant, "new@mail.com", enum.Owner)
+	if !errors.Is(err, ErrRoleNotAssignable) {
+		t.Errorf("Expected error %v, but got %v", ErrRoleNotAssignable, err)
+	}
+	err = teamService.UpdateMemberRole(context.Background(), testMerchant, uuid.New(), enum.Owner)
+	if !errors.Is(err, ErrRoleNotAssignable) {
+		t.Errorf("Expected error %v, but got %v", ErrRoleNotAssignable, err)
+	}
+}
+
+func TestAcceptInvitationExpired(t *testing.T) {
+	teamService, repos := newTeamService()
+	token := "invitation-token"
+	createInvitation(t, repos, uuid.New(), token, time.Now().Add(-time.Minute))
+
+	err := teamService.AcceptInvitation(context.Background(), token, "New", "Member", "password1234")
+	if !errors.Is(err, ErrInvalidInvitation) {
+		t.Errorf("Expected error %v, but got %v", ErrInvalidInvitation, err)
+	}
+}
+
+func TestAcceptInvitationConcurrently(t *testing.T) {
+	teamService, repos := newTeamService()
+	token := "invitation-token"
+	organizationId := uuid.New()
+	createInvitation(t, repos, organizationId, token, time.Now().Add(time.Hour))
+
+	errs := make([]error, 5)
+	var wg sync.WaitGroup
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = teamService.AcceptInvitation(context.Background(), token, "New", "Member", "password1234")
+		}(i)
+	}
+	wg.Wait()
+
+	accepted := 0
+	for _, err := range errs {
+		if err == nil {
+			accepted++
+		} else if !errors.Is(err, ErrInvalidInvitation) {
+			t.Errorf("Expected error %v, but got %v", ErrInvalidInvitation, err)
+		}
+	}
+	if accepted != 1 {
+		t.Fatalf("Expected the invitation to be accepted once, but got %d", accepted)
+	}
+
+	merchant, err := repos.Merchant.FindByEmail(context.Background(), "new@mail.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	membership, err := repos.Team.FindMembershipByMerchantId(context.Background(), merchant.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !merchant.IsActive || membership.OrganizationId != organizationId || membership.Role != enum.Developer {
+		t.Errorf("Expected an active developer of organization %s", organizationId)
+	}
+}
+
+func TestRemoveMember(t *testing.T) {
+	teamService, repos := newTeamService()
+	owner := &model.Merchant{Email: "owner@mail.com", IsActive: true}
+	createMerchant(t, repos, owner)
+	member := &model.Merchant{Email: "member@mail.com", IsActive: true, Membership: &model.Membership{OrganizationId: owner.ID, Role: enum.Admin}}
+	createMerchant(t, repos, member)
+
+	err := teamService.RemoveMember(context.Background(), owner, member.ID)
+	if err != nil {
+		t.Fatalf("RemoveMember: got error %s", err.Error())
+	}
+	err = teamService.RemoveMember(context.Background(), owner, member.ID)
+	if !errors.Is(err, ErrMemberNotFound) {
+		t.Errorf("Expected error %v, but got %v", ErrMemberNotFound, err)
+	}
+}
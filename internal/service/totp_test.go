@@ -0,0 +1,65 @@
+package service
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// test vectors of RFC 6238 appendix B, truncated to 6 digits
+func TestTotpCode(t *testing.T) {
+	secret := []byte("12345678901234567890")
+	tests := []struct {
+		time int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, tt := range tests {
+		got := totpCode(secret, totpStep(time.Unix(tt.time, 0)))
+		if got != tt.want {
+			t.Errorf("Expected code %s at %d, but got %s", tt.want, tt.time, got)
+		}
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	step := totpStep(now)
+
+	if _, ok := validateTotp(secret, "050471", now, 0); !ok {
+		t.Errorf("Expected current code to be valid")
+	}
+	if _, ok := validateTotp(secret, "081804", now, 0); !ok {
+		t.Errorf("Expected code of the previous step to be valid")
+	}
+	if _, ok := validateTotp(secret, "050471", now.Add(5*totpPeriod*time.Second), 0); ok {
+		t.Errorf("Expected old code to be invalid")
+	}
+	if _, ok := validateTotp(secret, "050471", now, step); ok {
+		t.Errorf("Expected used code to be invalid")
+	}
+	if _, ok := validateTotp(secret, "123", now, 0); ok {
+		t.Errorf("Expected short code to be invalid")
+	}
+}
+
+func TestTotpUri(t *testing.T) {
+	secret, err := generateTotpSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	uri := totpUri("momo@mail.com", secret)
+	if !strings.HasPrefix(uri, "otpauth://totp/CHainGate:momo@mail.com?") {
+		t.Errorf("Unexpected uri %s", uri)
+	}
+	if !strings.Contains(uri, "secret="+secret) {
+		t.Errorf("Expected secret in uri %s", uri)
+	}
+}
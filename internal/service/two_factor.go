@@ -0,0 +1,254 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/utils"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+const mfaTokenDuration = time.Minute * 5
+const mfaPurpose = "mfa"
+const recoveryCodeCount = 10
+const maxMfaAttempts = 5
+
+var (
+	ErrTwoFactorAlreadyEnabled = errors.New("Two-factor authentication is already enabled ")
+	ErrTwoFactorNotEnrolled    = errors.New("Two-factor authentication has to be enrolled first ")
+	ErrTwoFactorNotEnabled     = errors.New("Two-factor authentication is not enabled ")
+	ErrSecondFactorRequired    = errors.New("Two-factor code required ")
+	ErrInvalidSecondFactor     = errors.New("Wrong two-factor code ")
+	ErrInvalidMfaToken         = errors.New("Login expired, please login again ")
+)
+
+var recoveryCodeEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// TwoFactorEnrolment is shown once to the merchant to set up the authenticator app
+type TwoFactorEnrolment struct {
+	Secret string
+	Uri    string
+	QrCode []byte
+}
+
+// EnrolTwoFactor creates a new secret. It is only enforced after ConfirmTwoFactor.
+func (s *authenticationService) EnrolTwoFactor(ctx context.Context, merchant *model.Merchant) (*TwoFactorEnrolment, error) {
+	if merchant.TwoFactor.Enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	secret, err := generateTotpSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedSecret, err := encrypt([]byte(utils.Opts.ApiKeySecret), secret)
+	if err != nil {
+		return nil, err
+	}
+
+	merchant.TwoFactor = model.TwoFactor{Secret: encryptedSecret}
+	err = s.merchantRepository.Update(ctx, merchant)
+	if err != nil {
+		return nil, err
+	}
+
+	uri := totpUri(merchant.Email, secret)
+	qrCode, err := utils.QrCodePng(uri, 256)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TwoFactorEnrolment{Secret: secret, Uri: uri, QrCode: qrCode}, nil
+}
+
+// ConfirmTwoFactor enables two-factor authentication and returns the recovery codes
+func (s *authenticationService) ConfirmTwoFactor(ctx context.Context, merchant *model.Merchant, code string) ([]string, error) {
+	if merchant.TwoFactor.Enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+	if merchant.TwoFactor.Secret == "" {
+		return nil, ErrTwoFactorNotEnrolled
+	}
+
+	step, ok, err := checkTotp(merchant, code)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrInvalidSecondFactor
+	}
+
+	err = s.merchantRepository.DeleteRecoveryCodes(ctx, merchant.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	merchant.RecoveryCodes = nil
+	for i := 0; i < recoveryCodeCount; i++ {
+		recoveryCode, err := createRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, recoveryCode)
+		merchant.RecoveryCodes = append(merchant.RecoveryCodes, model.RecoveryCode{
+			CodeHash: hashToken(normalizeRecoveryCode(recoveryCode)),
+		})
+	}
+
+	merchant.TwoFactor.Enabled = true
+	merchant.TwoFactor.LastUsedStep = step
+	err = s.merchantRepository.Update(ctx, merchant)
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+func (s *authenticationService) DisableTwoFactor(ctx context.Context, merchant *model.Merchant, code string) error {
+	if !merchant.TwoFactor.Enabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	err := s.verifyCode(ctx, merchant, code)
+	if err != nil {
+		return err
+	}
+
+	err = s.merchantRepository.DeleteRecoveryCodes(ctx, merchant.ID)
+	if err != nil {
+		return err
+	}
+
+	merchant.TwoFactor = model.TwoFactor{}
+	return s.merchantRepository.Update(ctx, merchant)
+}
+
+// VerifySecondFactor re-confirms sensitive operations, it passes if two-factor authentication is disabled
+func (s *authenticationService) VerifySecondFactor(ctx context.Context, merchant *model.Merchant, code string) error {
+	if !merchant.TwoFactor.Enabled {
+		return nil
+	}
+	if code == "" {
+		return ErrSecondFactorRequired
+	}
+	return s.verifyCode(ctx, merchant, code)
+}
+
+// CompleteLogin is the second login step with the token returned by HandleLogin
+func (s *authenticationService) CompleteLogin(ctx context.Context, mfaToken string, code string) (*AuthTokens, error) {
+	claims, err := decodeJwtToken(mfaToken)
+	if err != nil || claims.Purpose != mfaPurpose {
+		return nil, ErrInvalidMfaToken
+	}
+
+	merchant, err := s.merchantRepository.FindByEmail(ctx, claims.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	if isTokenRevoked(claims, merchant) || !merchant.IsActive || !merchant.TwoFactor.Enabled {
+		return nil, ErrInvalidMfaToken
+	}
+
+	// every mfa token allows a few attempts, wrong codes of all tokens lock the account like wrong passwords
+	attemptsKey := "mfa:" + claims.ID
+	if claims.ID == "" || s.loginThrottle.count(attemptsKey) >= maxMfaAttempts {
+		return nil, ErrInvalidMfaToken
+	}
+	err = s.verifyCode(ctx, merchant, code)
+	if errors.Is(err, ErrInvalidSecondFactor) {
+		s.loginThrottle.fail(attemptsKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return s.createSession(ctx, merchant, uuid.New())
+}
+
+// verifyCode accepts a TOTP code or an unused recovery code, each can be used once even by concurrent requests.
+// Wrong codes count as failed logins, so the account is locked after maxFailedLogins.
+func (s *authenticationService) verifyCode(ctx context.Context, merchant *model.Merchant, code string) error {
+	if merchant.LockedUntil != nil && merchant.LockedUntil.After(time.Now()) {
+		return &LoginThrottledError{RetryAfter: time.Until(*merchant.LockedUntil)}
+	}
+
+	step, ok, err := checkTotp(merchant, code)
+	if err != nil {
+		return err
+	}
+	if ok {
+		ok, err = s.merchantRepository.MarkTotpStepUsed(ctx, merchant.ID, step)
+		if err != nil {
+			return err
+		}
+		if ok {
+			merchant.TwoFactor.LastUsedStep = step
+		}
+	} else {
+		ok, err = s.merchantRepository.MarkRecoveryCodeUsed(ctx, merchant.ID, hashToken(normalizeRecoveryCode(code)))
+		if err != nil {
+			return err
+		}
+	}
+	if !ok {
+		return s.recordFailedLogin(ctx, merchant, ErrInvalidSecondFactor)
+	}
+
+	if merchant.FailedLogins > 0 {
+		err = s.merchantRepository.ResetFailedLogins(ctx, merchant.ID)
+		if err != nil {
+			return err
+		}
+		merchant.FailedLogins = 0
+		merchant.LockedUntil = nil
+	}
+	return nil
+}
+
+func checkTotp(merchant *model.Merchant, code string) (int64, bool, error) {
+	secret, err := Decrypt([]byte(utils.Opts.ApiKeySecret), merchant.TwoFactor.Secret)
+	if err != nil {
+		return 0, false, err
+	}
+	step, ok := validateTotp(secret, code, time.Now(), merchant.TwoFactor.LastUsedStep)
+	return step, ok, nil
+}
+
+func createMfaToken(merchant *model.Merchant) (string, error) {
+	claims := jwt.NewWithClaims(jwt.SigningMethodHS256, jwtClaims{
+		Version: merchant.TokenVersion,
+		Purpose: mfaPurpose,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(),
+			Issuer:    merchant.Email,
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(mfaTokenDuration)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	})
+
+	return claims.SignedString([]byte(utils.Opts.JwtSecret))
+}
+
+// createRecoveryCode returns a code like abcde-fghij
+func createRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", errors.New("Cannot generate recovery code ")
+	}
+	code := strings.ToLower(recoveryCodeEncoding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+func normalizeRecoveryCode(code string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+}
@@ -0,0 +1,168 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+	"time"
+
+	"gopkg.in/h2non/gock.v1"
+
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/CHainGate/backend/internal/utils"
+)
+
+func TestCreateRecoveryCode(t *testing.T) {
+	code, err := createRecoveryCode()
+	if err != nil {
+		t.Fatalf("createRecoveryCode: got error %s", err.Error())
+	}
+	if !regexp.MustCompile(`^[a-z2-7]{5}-[a-z2-7]{5}$`).MatchString(code) {
+		t.Errorf("Unexpected recovery code format %s", code)
+	}
+	if normalizeRecoveryCode(" ABCDE-fghij ") != "abcdefghij" {
+		t.Errorf("Expected recovery code to be normalized")
+	}
+}
+
+func TestVerifySecondFactor(t *testing.T) {
+	service, _ := newAuthenticationService()
+	err := service.VerifySecondFactor(context.Background(), &model.Merchant{}, "")
+	if err != nil {
+		t.Errorf("Expected no error without two-factor authentication, but got %s", err.Error())
+	}
+
+	merchant := &model.Merchant{TwoFactor: model.TwoFactor{Enabled: true}}
+	err = service.VerifySecondFactor(context.Background(), merchant, "")
+	if !errors.Is(err, ErrSecondFactorRequired) {
+		t.Errorf("Expected error %v, but got %v", ErrSecondFactorRequired, err)
+	}
+}
+
+func TestMfaTokenIsNoAccessToken(t *testing.T) {
+	service, repos := newAuthenticationService()
+	merchant := &model.Merchant{FirstName: testMerchant.FirstName, Email: testMerchant.Email, IsActive: true}
+	createMerchant(t, repos, merchant)
+
+	mfaToken, err := createMfaToken(merchant)
+	if err != nil {
+		t.Fatalf("createMfaToken: got error %s", err.Error())
+	}
+
+	_, err = service.HandleJwtAuthentication(context.Background(), "bearer "+mfaToken)
+	if err == nil {
+		t.Errorf("Expected mfa token to be rejected")
+	}
+}
+
+// newTwoFactorMerchant creates a merchant with two-factor authentication and returns the current code and a recovery code
+func newTwoFactorMerchant(t *testing.T, repos repository.Repositories, failedLogins int) (*model.Merchant, string, string) {
+	t.Helper()
+	secret, err := generateTotpSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encryptedSecret, err := encrypt([]byte(utils.Opts.ApiKeySecret), secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	recoveryCode, err := createRecoveryCode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	merchant := &model.Merchant{
+		FirstName:     testMerchant.FirstName,
+		Email:         testMerchant.Email,
+		IsActive:      true,
+		FailedLogins:  failedLogins,
+		TwoFactor:     model.TwoFactor{Secret: encryptedSecret, Enabled: true},
+		RecoveryCodes: []model.RecoveryCode{{CodeHash: hashToken(normalizeRecoveryCode(recoveryCode))}},
+	}
+	createMerchant(t, repos, merchant)
+
+	key, _ := totpEncoding.DecodeString(secret)
+	return findMerchant(t, repos, merchant.ID), totpCode(key, totpStep(time.Now())), recoveryCode
+}
+
+func TestVerifySecondFactorOnce(t *testing.T) {
+	service, repos := newAuthenticationService()
+	merchant, code, recoveryCode := newTwoFactorMerchant(t, repos, 0)
+
+	for _, c := range []string{code, recoveryCode} {
+		// both requests loaded the merchant before the code was used
+		first := findMerchant(t, repos, merchant.ID)
+		second := findMerchant(t, repos, merchant.ID)
+		if err := service.VerifySecondFactor(context.Background(), first, c); err != nil {
+			t.Fatalf("VerifySecondFactor: got error %s", err.Error())
+		}
+		err := service.VerifySecondFactor(context.Background(), second, c)
+		if !errors.Is(err, ErrInvalidSecondFactor) {
+			t.Errorf("Expected a used code to be rejected, but got %v", err)
+		}
+	}
+}
+
+func TestVerifySecondFactorLocksAccount(t *testing.T) {
+	defer gock.Off()
+	gock.New("localhost:8001").
+		Post("/api/email").
+		Reply(200)
+
+	service, repos := newAuthenticationService()
+	merchant, code, _ := newTwoFactorMerchant(t, repos, maxFailedLogins-1)
+
+	err := service.VerifySecondFactor(context.Background(), merchant, "000000")
+	if !errors.Is(err, ErrInvalidSecondFactor) {
+		t.Errorf("Expected error %v, but got %v", ErrInvalidSecondFactor, err)
+	}
+	found := findMerchant(t, repos, merchant.ID)
+	if found.LockedUntil == nil || found.LockedUntil.Before(time.Now()) {
+		t.Fatalf("Expected the account to be locked")
+	}
+
+	err = service.VerifySecondFactor(context.Background(), found, code)
+	var throttledErr *LoginThrottledError
+	if !errors.As(err, &throttledErr) {
+		t.Errorf("Expected error %v, but got %v", ErrTooManyLoginAttempts, err)
+	}
+}
+
+func TestCompleteLoginAttempts(t *testing.T) {
+	service, repos := newAuthenticationService()
+	merchant, code, _ := newTwoFactorMerchant(t, repos, 0)
+	mfaToken, err := createMfaToken(merchant)
+	if err != nil {
+		t.Fatalf("createMfaToken: got error %s", err.Error())
+	}
+
+	for i := 0; i < maxMfaAttempts; i++ {
+		_, err = service.CompleteLogin(context.Background(), mfaToken, "000000")
+		if !errors.Is(err, ErrInvalidSecondFactor) {
+			t.Fatalf("Expected error %v, but got %v", ErrInvalidSecondFactor, err)
+		}
+	}
+	_, err = service.CompleteLogin(context.Background(), mfaToken, code)
+	if !errors.Is(err, ErrInvalidMfaToken) {
+		t.Errorf("Expected the mfa token to be used up, but got %v", err)
+	}
+	if found := findMerchant(t, repos, merchant.ID); found.FailedLogins != maxMfaAttempts {
+		t.Errorf("Expected %d failed logins, but got %d", maxMfaAttempts, found.FailedLogins)
+	}
+
+	mfaToken, err = createMfaToken(merchant)
+	if err != nil {
+		t.Fatalf("createMfaToken: got error %s", err.Error())
+	}
+	tokens, err := service.CompleteLogin(context.Background(), mfaToken, code)
+	if err != nil {
+		t.Fatalf("CompleteLogin: got error %s", err.Error())
+	}
+	if tokens.AccessToken == "" {
+		t.Errorf("Expected an access token")
+	}
+	if found := findMerchant(t, repos, merchant.ID); found.FailedLogins != 0 {
+		t.Errorf("Expected the failed logins to be reset, but got %d", found.FailedLogins)
+	}
+}
@@ -0,0 +1,32 @@
+package tracing
+
+import (
+	"net/http"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+// NewMiddleware starts a span for every request of a router, named by the route template so the ids
+// in the path are attributes and not part of the name. A trace context of the caller is continued.
+func NewMiddleware(server string) mux.MiddlewareFunc {
+	return func(next http.Handler) http.Handler {
+		return otelhttp.NewHandler(next, server, otelhttp.WithSpanNameFormatter(routeName))
+	}
+}
+
+func routeName(_ string, r *http.Request) string {
+	if current := mux.CurrentRoute(r); current != nil {
+		if template, err := current.GetPathTemplate(); err == nil {
+			return r.Method + " " + template
+		}
+	}
+	return r.Method + " " + r.URL.Path
+}
+
+// NewTransport starts a client span for every request to the service and sends the trace context along
+func NewTransport(service string, base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base, otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+		return service + " " + r.Method + " " + r.URL.Path
+	}))
+}
@@ -0,0 +1,87 @@
+// Package tracing creates OpenTelemetry spans for the routers, the database and the requests to other services.
+// The W3C trace context is read from incoming requests and sent to the blockchain services and the proxy.
+package tracing
+
+import (
+	"context"
+	"errors"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const ServiceName = "chaingate-backend"
+
+const tracerName = "github.com/CHainGate/backend"
+
+var provider *sdktrace.TracerProvider
+
+// Setup sets the global tracer provider and propagator. The exporter is none, stdout or otlp,
+// with none the trace context is still propagated but no spans are recorded.
+func Setup(exporter string, endpoint string, sampleRatio float64) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var processor sdktrace.SpanProcessor
+	switch exporter {
+	case "", "none":
+		return nil
+	case "stdout":
+		// spans are written immediately, for local use only
+		e, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return err
+		}
+		processor = sdktrace.NewSimpleSpanProcessor(e)
+	case "otlp":
+		e, err := otlptracehttp.New(context.Background(), otlptracehttp.WithEndpointURL(endpoint))
+		if err != nil {
+			return err
+		}
+		processor = sdktrace.NewBatchSpanProcessor(e)
+	default:
+		return errors.New("Unknown tracing exporter " + exporter)
+	}
+
+	provider = newProvider(processor, sampleRatio)
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+// newProvider samples the given ratio of new traces, requests of other services keep the decision of the caller
+func newProvider(processor sdktrace.SpanProcessor, sampleRatio float64) *sdktrace.TracerProvider {
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithSpanProcessor(processor),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(sampleRatio))),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceName(ServiceName))),
+	)
+}
+
+// Shutdown exports the remaining spans
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+// Start starts a span with the tracer of the backend, the span has to be ended by the caller
+func Start(ctx context.Context, name string, opts ...trace.SpanStartOption) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, opts...)
+}
+
+// End records the error on the span and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,114 @@
+package tracing
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+const traceId = "4bf92f3577b34da6a3ce929d0e0e4736"
+const traceParent = "00-" + traceId + "-00f067aa0ba902b7-01"
+
+func setupTest(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(newProvider(sdktrace.NewSimpleSpanProcessor(exporter), 1))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(trace.NewNoopTracerProvider()) })
+	return exporter
+}
+
+func TestMiddlewareContinuesTrace(t *testing.T) {
+	exporter := setupTest(t)
+	router := mux.NewRouter()
+	router.HandleFunc("/api/payment/{id}", func(w http.ResponseWriter, r *http.Request) {}).Methods(http.MethodGet)
+	router.Use(NewMiddleware("public"))
+
+	r := httptest.NewRequest(http.MethodGet, "/api/payment/123", nil)
+	r.Header.Set("traceparent", traceParent)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("Expected one span, but got %d", len(spans))
+	}
+	if spans[0].Name != "GET /api/payment/{id}" {
+		t.Errorf("Expected the route template as name, but got %s", spans[0].Name)
+	}
+	if spans[0].SpanContext.TraceID().String() != traceId {
+		t.Errorf("Expected the trace of the caller, but got %s", spans[0].SpanContext.TraceID())
+	}
+}
+
+func TestTransportSendsTraceContext(t *testing.T) {
+	exporter := setupTest(t)
+	var received string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header.Get("traceparent")
+	}))
+	defer server.Close()
+
+	ctx, span := Start(context.Background(), "parent")
+	r, _ := http.NewRequestWithContext(ctx, http.MethodPost, server.URL+"/api/payment", nil)
+	client := &http.Client{Transport: NewTransport("ethereum", nil)}
+	resp, err := client.Do(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = resp.Body.Close()
+	span.End()
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[0].Name != "ethereum POST /api/payment" {
+		t.Fatalf("Expected a client span and its parent, but got %v", spans)
+	}
+	expected := "00-" + spans[0].SpanContext.TraceID().String() + "-" + spans[0].SpanContext.SpanID().String() + "-01"
+	if received != expected {
+		t.Errorf("Expected traceparent %s, but got %s", expected, received)
+	}
+	if spans[0].Parent.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("Expected the client span to be a child of the context span")
+	}
+}
+
+func TestGormPlugin(t *testing.T) {
+	exporter := setupTest(t)
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Use(NewGormPlugin())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, span := Start(context.Background(), "parent")
+	var count int64
+	err = db.WithContext(ctx).Table("sqlite_master").Count(&count).Error
+	span.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[0].Name != "db query" {
+		t.Fatalf("Expected a query span and its parent, but got %v", spans)
+	}
+	if spans[0].Parent.SpanID() != span.SpanContext().SpanID() {
+		t.Errorf("Expected the query span to be a child of the context span")
+	}
+	for _, attr := range spans[0].Attributes {
+		if attr.Key == "db.sql.table" && attr.Value.AsString() != "sqlite_master" {
+			t.Errorf("Expected table sqlite_master, but got %s", attr.Value.AsString())
+		}
+	}
+}
@@ -0,0 +1,28 @@
+package utils
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ClientIp returns the ip of the client. The ip is only read from the CLIENT_IP_HEADER
+// if the backend runs behind a reverse proxy.
+func ClientIp(r *http.Request) string {
+	return clientIp(r, Opts.ClientIpHeader)
+}
+
+func clientIp(r *http.Request, header string) string {
+	if header != "" {
+		// the last entry of X-Forwarded-For is the one added by our proxy
+		values := strings.Split(r.Header.Get(header), ",")
+		if ip := strings.TrimSpace(values[len(values)-1]); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
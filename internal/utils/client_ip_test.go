@@ -0,0 +1,22 @@
+package utils
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIp(t *testing.T) {
+	r := httptest.NewRequest("POST", "/login", nil)
+	r.RemoteAddr = "10.0.0.2:51234"
+	r.Header.Set("X-Forwarded-For", "1.1.1.1, 2.2.2.2")
+
+	if ip := clientIp(r, ""); ip != "10.0.0.2" {
+		t.Errorf("Expected remote address 10.0.0.2, but got %s", ip)
+	}
+	if ip := clientIp(r, "X-Forwarded-For"); ip != "2.2.2.2" {
+		t.Errorf("Expected ip added by the proxy 2.2.2.2, but got %s", ip)
+	}
+	if ip := clientIp(r, "X-Real-Ip"); ip != "10.0.0.2" {
+		t.Errorf("Expected fallback to remote address, but got %s", ip)
+	}
+}
@@ -3,10 +3,12 @@ package utils
 import (
 	"errors"
 	"flag"
+	"fmt"
 	"github.com/CHainGate/backend/pkg/enum"
 	"log"
 	"math/big"
 	"os"
+	"sort"
 	"strconv"
 
 	"github.com/joho/godotenv"
@@ -14,76 +16,200 @@ import (
 
 type OptsType struct {
 	ServerPort           int
+	ServerReadTimeout    int
+	ServerWriteTimeout   int
+	ServerIdleTimeout    int
+	ShutdownTimeout      int
+	InternalServerPort   int
+	InternalApiSecret    string
+	InternalTlsCert      string
+	InternalTlsKey       string
+	InternalTlsClientCa  string
+	DbDriver             string
+	DbPath               string
 	DbHost               string
 	DbUser               string
 	DbPassword           string
 	DbName               string
 	DbPort               string
+	MigrateOnStartup     bool
 	JwtSecret            string
 	ApiKeySecret         string
+	OldApiKeySecret      string
 	EmailVerificationUrl string
+	PasswordResetUrl     string
+	InvitationUrl        string
+	ClientIpHeader       string
+	RateLimitStore       string
+	RateLimitPublic      int
+	RateLimitConfig      int
+	RateLimitAnonymous   int
 	ProxyBaseUrl         string
 	EthereumBaseUrl      string
 	BitcoinBaseUrl       string
+	BlockchainTimeout    int
+	ProxyTimeout         int
 	PaymentBaseUrl       string
+	LogLevel             string
+	TracingExporter      string
+	TracingEndpoint      string
+	TracingSampleRatio   float64
+	HealthCache          int
+	HealthTimeout        int
+	EthereumTestChainId  int
 }
 
 var (
 	Opts *OptsType
 )
 
-func NewOpts() {
+// Setting is the effective value of an option and where it came from, secrets are redacted
+type Setting struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Source string `json:"source"`
+}
+
+const redacted = "[REDACTED]"
+
+// secretOpts are never logged or printed
+var secretOpts = map[string]bool{
+	"INTERNAL_API_SECRET": true,
+	"DB_PASSWORD":         true,
+	"JWT_SECRET":          true,
+	"API_KEY_SECRET":      true,
+	"OLD_API_KEY_SECRET":  true,
+}
+
+var settings []Setting
+
+// NewOpts creates Opts and returns the remaining arguments, e.g. the command. Every option is a flag
+// and an environment variable of the same name and can be set in the file of CONFIG_FILE (yaml or toml).
+// The flag takes precedence over the environment variable (or .env), which takes precedence over the
+// config file. Empty environment variables are ignored.
+func NewOpts(args []string) ([]string, error) {
 	err := godotenv.Load()
 	if err != nil {
 		log.Printf("Could not find env file [%v], using defaults", err)
 	}
 
 	o := &OptsType{}
-	//TODO: add default values
-	flag.IntVar(&o.ServerPort, "SERVER_PORT", lookupEnvInt("SERVER_PORT", 8000), "Server PORT")
-	flag.StringVar(&o.DbHost, "DB_HOST", lookupEnv("DB_HOST"), "Database Host")
-	flag.StringVar(&o.DbUser, "DB_USER", lookupEnv("DB_USER"), "Database User")
-	flag.StringVar(&o.DbPassword, "DB_PASSWORD", lookupEnv("DB_PASSWORD"), "Database Password")
-	flag.StringVar(&o.DbName, "DB_NAME", lookupEnv("DB_NAME"), "Database Name")
-	flag.StringVar(&o.DbPort, "DB_PORT", lookupEnv("DB_PORT"), "Database Port")
-	flag.StringVar(&o.JwtSecret, "JWT_SECRET", lookupEnv("JWT_SECRET"), "JWT Secret")
-	flag.StringVar(&o.ApiKeySecret, "API_KEY_SECRET", lookupEnv("API_KEY_SECRET"), "API Key Secret")
-	flag.StringVar(&o.EmailVerificationUrl, "EMAIL_VERIFICATION_URL", lookupEnv("EMAIL_VERIFICATION_URL"), "Email Verification URL")
-	flag.StringVar(&o.ProxyBaseUrl, "PROXY_BASE_URL", lookupEnv("PROXY_BASE_URL", "http://localhost:8001/api"), "Proxy base url")
-	flag.StringVar(&o.EthereumBaseUrl, "ETHEREUM_BASE_URL", lookupEnv("ETHEREUM_BASE_URL", "http://localhost:9000/api"), "Ethereum base url")
-	flag.StringVar(&o.BitcoinBaseUrl, "BITCOIN_BASE_URL", lookupEnv("BITCOIN_BASE_URL", "http://localhost:9001/api"), "Bitcoin base url")
-	flag.StringVar(&o.PaymentBaseUrl, "PAYMENT_URL", lookupEnv("PAYMENT_URL", "http://localhost:3000/payment/"), "Payment base URL")
-
-	Opts = o
-}
+	fs := flag.NewFlagSet("backend-service", flag.ContinueOnError)
+	configFile := fs.String("CONFIG_FILE", os.Getenv("CONFIG_FILE"), "Yaml or toml file with options, the keys are the names of the flags")
+	fs.IntVar(&o.ServerPort, "SERVER_PORT", 8000, "Server PORT")
+	fs.IntVar(&o.ServerReadTimeout, "SERVER_READ_TIMEOUT", 15, "Timeout in seconds to read a request")
+	fs.IntVar(&o.ServerWriteTimeout, "SERVER_WRITE_TIMEOUT", 30, "Timeout in seconds to handle a request and write the response, streams and long polls extend it")
+	fs.IntVar(&o.ServerIdleTimeout, "SERVER_IDLE_TIMEOUT", 120, "Seconds an idle keep-alive connection is kept open")
+	fs.IntVar(&o.ShutdownTimeout, "SHUTDOWN_TIMEOUT", 25, "Seconds to finish the requests in flight after SIGTERM, less than the grace period of kubernetes")
+	fs.IntVar(&o.InternalServerPort, "INTERNAL_SERVER_PORT", 0, "Port of the internal api, 0 serves it on SERVER_PORT")
+	fs.StringVar(&o.InternalApiSecret, "INTERNAL_API_SECRET", "", "Shared secret to sign the requests between backend and blockchain services")
+	fs.StringVar(&o.InternalTlsCert, "INTERNAL_TLS_CERT", "", "Certificate file of the internal api")
+	fs.StringVar(&o.InternalTlsKey, "INTERNAL_TLS_KEY", "", "Key file of the internal api")
+	fs.StringVar(&o.InternalTlsClientCa, "INTERNAL_TLS_CLIENT_CA", "", "CA file of the client certificates of the blockchain services")
+	fs.StringVar(&o.DbDriver, "DB_DRIVER", "postgres", "Database driver, postgres or sqlite for a single node")
+	fs.StringVar(&o.DbPath, "DB_PATH", "chaingate.db", "Database file if the driver is sqlite")
+	fs.StringVar(&o.DbHost, "DB_HOST", "", "Database Host")
+	fs.StringVar(&o.DbUser, "DB_USER", "", "Database User")
+	fs.StringVar(&o.DbPassword, "DB_PASSWORD", "", "Database Password")
+	fs.StringVar(&o.DbName, "DB_NAME", "", "Database Name")
+	fs.StringVar(&o.DbPort, "DB_PORT", "", "Database Port")
+	fs.BoolVar(&o.MigrateOnStartup, "MIGRATE_ON_STARTUP", false, "Apply all migrations at startup instead of running the migrate command")
+	fs.StringVar(&o.JwtSecret, "JWT_SECRET", "", "JWT Secret")
+	fs.StringVar(&o.ApiKeySecret, "API_KEY_SECRET", "", "API Key Secret")
+	fs.StringVar(&o.OldApiKeySecret, "OLD_API_KEY_SECRET", "", "Previous API Key Secret, the api keys of the merchants encrypted with it are accepted until it is removed")
+	fs.StringVar(&o.EmailVerificationUrl, "EMAIL_VERIFICATION_URL", "", "Email Verification URL")
+	fs.StringVar(&o.PasswordResetUrl, "PASSWORD_RESET_URL", "http://localhost:3000/password/reset", "Password reset URL")
+	fs.StringVar(&o.InvitationUrl, "INVITATION_URL", "http://localhost:3000/invitation", "Team invitation URL")
+	fs.StringVar(&o.ClientIpHeader, "CLIENT_IP_HEADER", "", "Header with the client ip set by a reverse proxy, e.g. X-Forwarded-For")
+	fs.StringVar(&o.RateLimitStore, "RATE_LIMIT_STORE", "memory", "Rate limit store, memory or postgres if several replicas are running")
+	fs.IntVar(&o.RateLimitPublic, "RATE_LIMIT_PUBLIC", 120, "Requests per minute and api key, and per ip for requests with an api key, 0 disables the limit")
+	fs.IntVar(&o.RateLimitConfig, "RATE_LIMIT_CONFIG", 300, "Requests per minute and merchant, 0 disables the limit")
+	fs.IntVar(&o.RateLimitAnonymous, "RATE_LIMIT_ANONYMOUS", 60, "Requests per minute and ip without authentication, 0 disables the limit")
+	fs.StringVar(&o.ProxyBaseUrl, "PROXY_BASE_URL", "http://localhost:8001/api", "Proxy base url")
+	fs.StringVar(&o.EthereumBaseUrl, "ETHEREUM_BASE_URL", "http://localhost:9000/api", "Ethereum base url")
+	fs.StringVar(&o.BitcoinBaseUrl, "BITCOIN_BASE_URL", "http://localhost:9001/api", "Bitcoin base url")
+	fs.IntVar(&o.BlockchainTimeout, "BLOCKCHAIN_TIMEOUT", 10, "Timeout in seconds of the requests to the ethereum and bitcoin services")
+	fs.IntVar(&o.ProxyTimeout, "PROXY_TIMEOUT", 10, "Timeout in seconds of the requests to the proxy sending emails and webhooks")
+	fs.StringVar(&o.PaymentBaseUrl, "PAYMENT_URL", "http://localhost:3000/payment/", "Payment base URL")
+	fs.StringVar(&o.LogLevel, "LOG_LEVEL", "info", "Log level, debug, info, warn or error")
+	fs.StringVar(&o.TracingExporter, "TRACING_EXPORTER", "none", "Exporter of the OpenTelemetry spans, none, stdout or otlp")
+	fs.StringVar(&o.TracingEndpoint, "TRACING_ENDPOINT", "http://localhost:4318", "OTLP/HTTP endpoint if the exporter is otlp")
+	fs.Float64Var(&o.TracingSampleRatio, "TRACING_SAMPLE_RATIO", 1, "Ratio of the traces started by the backend which are recorded")
+	fs.IntVar(&o.HealthCache, "HEALTH_CACHE", 10, "Seconds the results of the readiness checks are cached")
+	fs.IntVar(&o.HealthTimeout, "HEALTH_TIMEOUT", 3, "Timeout in seconds of each readiness check")
+	fs.IntVar(&o.EthereumTestChainId, "ETHEREUM_TEST_CHAIN_ID", 5, "Chain id of the ethereum testnet used in payment uris")
 
-func lookupEnv(key string, defaultValues ...string) string {
-	if val, ok := os.LookupEnv(key); ok {
-		return val
+	err = fs.Parse(args)
+	if err != nil {
+		return nil, err
 	}
-	for _, v := range defaultValues {
-		if v != "" {
-			return v
+	sources := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		sources[f.Name] = "flag"
+	})
+
+	var errs []error
+	fileValues := map[string]string{}
+	if *configFile != "" {
+		fileValues, err = readConfigFile(*configFile)
+		if err != nil {
+			return nil, err
+		}
+		names := make([]string, 0, len(fileValues))
+		for name := range fileValues {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if fs.Lookup(name) == nil || name == "CONFIG_FILE" {
+				errs = append(errs, fmt.Errorf("%s: unknown option %s", *configFile, name))
+				delete(fileValues, name)
+			}
 		}
 	}
-	return ""
-}
 
-func lookupEnvInt(key string, defaultValues ...int) int {
-	if val, ok := os.LookupEnv(key); ok {
-		v, err := strconv.Atoi(val)
-		if err != nil {
-			log.Printf("LookupEnvInt[%s]: %v", key, err)
-			return 0
+	fs.VisitAll(func(f *flag.Flag) {
+		if sources[f.Name] != "" {
+			return
+		}
+		sources[f.Name] = "default"
+		if value := os.Getenv(f.Name); value != "" {
+			sources[f.Name] = "env"
+			errs = append(errs, setOpt(fs, f.Name, value, "environment variable"))
+		} else if value, ok := fileValues[f.Name]; ok {
+			sources[f.Name] = "file"
+			errs = append(errs, setOpt(fs, f.Name, value, *configFile))
 		}
-		return v
+	})
+	err = errors.Join(errs...)
+	if err != nil {
+		return nil, err
 	}
-	for _, v := range defaultValues {
-		if v != 0 {
-			return v
+
+	settings = nil
+	fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if secretOpts[f.Name] && value != "" {
+			value = redacted
 		}
+		settings = append(settings, Setting{Name: f.Name, Value: value, Source: sources[f.Name]})
+	})
+
+	Opts = o
+	return fs.Args(), nil
+}
+
+func setOpt(fs *flag.FlagSet, name string, value string, source string) error {
+	err := fs.Set(name, value)
+	if err != nil {
+		return fmt.Errorf("invalid value for %s in %s: %w", name, source, err)
 	}
-	return 0
+	return nil
+}
+
+// Settings returns the options loaded by NewOpts sorted by name
+func Settings() []Setting {
+	return settings
 }
 
 func ConvertAmountToBaseString(currency enum.CryptoCurrency, amount big.Int) (string, error) {
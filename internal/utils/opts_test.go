@@ -2,12 +2,20 @@ package utils
 
 import (
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 )
 
 func TestNewOpts(t *testing.T) {
 	expected := OptsType{
 		ServerPort:           8000,
+		ServerReadTimeout:    15,
+		ServerWriteTimeout:   30,
+		ServerIdleTimeout:    120,
+		ShutdownTimeout:      25,
+		DbDriver:             "postgres",
+		DbPath:               "chaingate.db",
 		DbHost:               "mydbhost",
 		DbUser:               "postgres_usr",
 		DbPassword:           "postgres_pw",
@@ -16,10 +24,25 @@ func TestNewOpts(t *testing.T) {
 		JwtSecret:            "jwt_secret_token",
 		ApiKeySecret:         "api_secret_key",
 		EmailVerificationUrl: "https://send.email.ch/mail",
+		PasswordResetUrl:     "http://localhost:3000/password/reset",
+		InvitationUrl:        "http://localhost:3000/invitation",
+		RateLimitStore:       "memory",
+		RateLimitPublic:      120,
+		RateLimitConfig:      300,
+		RateLimitAnonymous:   60,
 		ProxyBaseUrl:         "http://localhost:8001/api",
 		EthereumBaseUrl:      "http://localhost:9000/api",
 		BitcoinBaseUrl:       "http://localhost:9001/api",
+		BlockchainTimeout:    10,
+		ProxyTimeout:         10,
 		PaymentBaseUrl:       "http://localhost:3000/payment/",
+		LogLevel:             "info",
+		TracingExporter:      "none",
+		TracingEndpoint:      "http://localhost:4318",
+		TracingSampleRatio:   1,
+		HealthCache:          10,
+		HealthTimeout:        3,
+		EthereumTestChainId:  5,
 	}
 
 	_ = os.Setenv("SERVER_PORT", "8000")
@@ -33,10 +56,136 @@ func TestNewOpts(t *testing.T) {
 	_ = os.Setenv("EMAIL_VERIFICATION_URL", "https://send.email.ch/mail")
 	_ = os.Setenv("PROXY_BASE_URL", "http://localhost:8001/api")
 
-	NewOpts()
+	args, err := NewOpts([]string{"migrate", "up"})
+	if err != nil {
+		t.Fatalf("NewOpts: got error %s", err.Error())
+	}
 
+	if len(args) != 2 || args[0] != "migrate" {
+		t.Errorf("expected the command to remain, but got %v", args)
+	}
 	if expected != *Opts {
 		t.Errorf("expected %v, but got %v", expected, Opts)
 	}
 
 }
+
+func TestNewOptsPrecedence(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(file, []byte("server_port: 9000\nLOG_LEVEL: debug\nPROXY_TIMEOUT: 20\nJWT_SECRET: file_secret\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", file)
+	t.Setenv("LOG_LEVEL", "warn")
+	t.Setenv("PROXY_TIMEOUT", "")
+	t.Setenv("SERVER_PORT", "8500")
+
+	_, err = NewOpts([]string{"-SERVER_PORT=9500"})
+	if err != nil {
+		t.Fatalf("NewOpts: got error %s", err.Error())
+	}
+
+	if Opts.ServerPort != 9500 || Opts.LogLevel != "warn" || Opts.ProxyTimeout != 20 || Opts.BlockchainTimeout != 10 {
+		t.Errorf("expected flag, env, file and default values, but got %d %s %d %d", Opts.ServerPort, Opts.LogLevel, Opts.ProxyTimeout, Opts.BlockchainTimeout)
+	}
+	sources := map[string]Setting{}
+	for _, setting := range Settings() {
+		sources[setting.Name] = setting
+	}
+	if sources["SERVER_PORT"].Source != "flag" || sources["LOG_LEVEL"].Source != "env" || sources["PROXY_TIMEOUT"].Source != "file" || sources["BLOCKCHAIN_TIMEOUT"].Source != "default" {
+		t.Errorf("unexpected sources %v", sources)
+	}
+	if sources["JWT_SECRET"].Value != redacted {
+		t.Errorf("expected the secret to be redacted, but got %s", sources["JWT_SECRET"].Value)
+	}
+}
+
+func TestNewOptsInvalid(t *testing.T) {
+	tests := map[string]struct {
+		file    string
+		content string
+		env     string
+	}{
+		"unknown option":  {"config.yaml", "SERVER_PROT: 8000\n", ""},
+		"invalid value":   {"config.toml", "SERVER_PORT = \"abc\"\n", ""},
+		"nested value":    {"config.yaml", "SERVER_PORT:\n  value: 8000\n", ""},
+		"unknown format":  {"config.json", "{}", ""},
+		"invalid env var": {"config.yaml", "", "abc"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), test.file)
+			err := os.WriteFile(file, []byte(test.content), 0600)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Setenv("SERVER_PORT", test.env)
+
+			_, err = NewOpts([]string{"-CONFIG_FILE", file})
+			if err == nil {
+				t.Errorf("expected an error")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	valid := OptsType{
+		ServerPort:           8000,
+		ServerReadTimeout:    15,
+		ServerWriteTimeout:   30,
+		ServerIdleTimeout:    120,
+		ShutdownTimeout:      25,
+		DbDriver:             "sqlite",
+		DbPath:               "chaingate.db",
+		JwtSecret:            "0123456789abcdef0123456789abcdef",
+		ApiKeySecret:         "0123456789abcdef",
+		EmailVerificationUrl: "https://chaingate.ch/verify",
+		PasswordResetUrl:     "https://chaingate.ch/password/reset",
+		InvitationUrl:        "https://chaingate.ch/invitation",
+		RateLimitStore:       "memory",
+		ProxyBaseUrl:         "http://proxy:8001/api",
+		EthereumBaseUrl:      "http://ethereum:9000/api",
+		BitcoinBaseUrl:       "http://bitcoin:9001/api",
+		BlockchainTimeout:    10,
+		ProxyTimeout:         10,
+		PaymentBaseUrl:       "https://chaingate.ch/payment/",
+		LogLevel:             "info",
+		TracingExporter:      "none",
+		TracingSampleRatio:   1,
+		HealthCache:          10,
+		HealthTimeout:        3,
+		EthereumTestChainId:  5,
+	}
+	if err := valid.Validate(); err != nil {
+		t.Fatalf("expected valid options, but got %s", err.Error())
+	}
+
+	tests := map[string]struct {
+		change   func(o *OptsType)
+		expected string
+	}{
+		"missing jwt secret":     {func(o *OptsType) { o.JwtSecret = "" }, "JWT_SECRET"},
+		"short internal secret":  {func(o *OptsType) { o.InternalApiSecret = "secret" }, "INTERNAL_API_SECRET"},
+		"invalid aes key length": {func(o *OptsType) { o.ApiKeySecret = "apiSecretKey123" }, "API_KEY_SECRET"},
+		"old aes key length":     {func(o *OptsType) { o.OldApiKeySecret = "apiSecretKey123" }, "OLD_API_KEY_SECRET"},
+		"same old aes key":       {func(o *OptsType) { o.OldApiKeySecret = o.ApiKeySecret }, "OLD_API_KEY_SECRET"},
+		"relative url":           {func(o *OptsType) { o.PaymentBaseUrl = "/payment/" }, "PAYMENT_URL"},
+		"missing postgres host":  {func(o *OptsType) { o.DbDriver = "postgres" }, "DB_HOST"},
+		"unknown driver":         {func(o *OptsType) { o.DbDriver = "mysql" }, "DB_DRIVER"},
+		"same ports":             {func(o *OptsType) { o.InternalServerPort = 8000 }, "INTERNAL_SERVER_PORT"},
+		"tls key without cert":   {func(o *OptsType) { o.InternalTlsKey = "key.pem" }, "INTERNAL_TLS_CERT"},
+		"sample ratio":           {func(o *OptsType) { o.TracingSampleRatio = 2 }, "TRACING_SAMPLE_RATIO"},
+	}
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			o := valid
+			test.change(&o)
+			err := o.Validate()
+			if err == nil || !strings.Contains(err.Error(), test.expected) {
+				t.Errorf("expected an error about %s, but got %v", test.expected, err)
+			}
+		})
+	}
+}
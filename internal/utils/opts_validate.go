@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Validate returns all invalid options at once, the server does not start with any of them
+func (o *OptsType) Validate() error {
+	v := &validator{}
+
+	v.port("SERVER_PORT", o.ServerPort)
+	if o.InternalServerPort != 0 {
+		v.port("INTERNAL_SERVER_PORT", o.InternalServerPort)
+		v.check(o.InternalServerPort != o.ServerPort, "INTERNAL_SERVER_PORT has to differ from SERVER_PORT")
+	}
+	v.check((o.InternalTlsCert == "") == (o.InternalTlsKey == ""), "INTERNAL_TLS_CERT and INTERNAL_TLS_KEY have to be set together")
+	v.check(o.InternalTlsClientCa == "" || o.InternalTlsCert != "", "INTERNAL_TLS_CLIENT_CA requires INTERNAL_TLS_CERT and INTERNAL_TLS_KEY")
+	v.check(o.InternalTlsClientCa == "" || o.InternalServerPort != 0, "INTERNAL_TLS_CLIENT_CA requires INTERNAL_SERVER_PORT")
+
+	// HS256 and the HMAC of the internal api need at least 256 bits, the api keys are encrypted with AES
+	v.check(len(o.JwtSecret) >= 32, "JWT_SECRET has to be at least 32 bytes")
+	v.check(isAesKey(o.ApiKeySecret), "API_KEY_SECRET has to be 16, 24 or 32 bytes (AES-128, AES-192 or AES-256)")
+	if o.OldApiKeySecret != "" {
+		v.check(isAesKey(o.OldApiKeySecret), "OLD_API_KEY_SECRET has to be 16, 24 or 32 bytes (AES-128, AES-192 or AES-256)")
+		v.check(o.OldApiKeySecret != o.ApiKeySecret, "OLD_API_KEY_SECRET has to differ from API_KEY_SECRET")
+	}
+	v.check(o.InternalApiSecret == "" || len(o.InternalApiSecret) >= 32, "INTERNAL_API_SECRET has to be at least 32 bytes")
+
+	switch o.DbDriver {
+	case "postgres":
+		v.required("DB_HOST", o.DbHost)
+		v.required("DB_USER", o.DbUser)
+		v.required("DB_NAME", o.DbName)
+		v.required("DB_PORT", o.DbPort)
+	case "sqlite":
+		v.required("DB_PATH", o.DbPath)
+	default:
+		v.fail("DB_DRIVER has to be postgres or sqlite")
+	}
+	v.oneOf("RATE_LIMIT_STORE", o.RateLimitStore, "memory", "postgres")
+	v.oneOf("LOG_LEVEL", strings.ToLower(o.LogLevel), "debug", "info", "warn", "error")
+	v.oneOf("TRACING_EXPORTER", o.TracingExporter, "none", "stdout", "otlp")
+	if o.TracingExporter == "otlp" {
+		v.url("TRACING_ENDPOINT", o.TracingEndpoint)
+	}
+	v.check(o.TracingSampleRatio >= 0 && o.TracingSampleRatio <= 1, "TRACING_SAMPLE_RATIO has to be between 0 and 1")
+
+	v.url("EMAIL_VERIFICATION_URL", o.EmailVerificationUrl)
+	v.url("PASSWORD_RESET_URL", o.PasswordResetUrl)
+	v.url("INVITATION_URL", o.InvitationUrl)
+	v.url("PAYMENT_URL", o.PaymentBaseUrl)
+	v.url("PROXY_BASE_URL", o.ProxyBaseUrl)
+	v.url("ETHEREUM_BASE_URL", o.EthereumBaseUrl)
+	v.url("BITCOIN_BASE_URL", o.BitcoinBaseUrl)
+
+	v.positive("SERVER_READ_TIMEOUT", o.ServerReadTimeout)
+	v.positive("SERVER_WRITE_TIMEOUT", o.ServerWriteTimeout)
+	v.positive("SERVER_IDLE_TIMEOUT", o.ServerIdleTimeout)
+	v.positive("SHUTDOWN_TIMEOUT", o.ShutdownTimeout)
+	v.positive("BLOCKCHAIN_TIMEOUT", o.BlockchainTimeout)
+	v.positive("PROXY_TIMEOUT", o.ProxyTimeout)
+	v.positive("HEALTH_TIMEOUT", o.HealthTimeout)
+	v.check(o.HealthCache >= 0, "HEALTH_CACHE must not be negative")
+	v.check(o.RateLimitPublic >= 0, "RATE_LIMIT_PUBLIC must not be negative")
+	v.check(o.RateLimitConfig >= 0, "RATE_LIMIT_CONFIG must not be negative")
+	v.check(o.RateLimitAnonymous >= 0, "RATE_LIMIT_ANONYMOUS must not be negative")
+	v.positive("ETHEREUM_TEST_CHAIN_ID", o.EthereumTestChainId)
+
+	return errors.Join(v.errs...)
+}
+
+func isAesKey(key string) bool {
+	return len(key) == 16 || len(key) == 24 || len(key) == 32
+}
+
+type validator struct {
+	errs []error
+}
+
+func (v *validator) fail(msg string) {
+	v.errs = append(v.errs, errors.New(msg))
+}
+
+func (v *validator) check(ok bool, msg string) {
+	if !ok {
+		v.fail(msg)
+	}
+}
+
+func (v *validator) required(name string, value string) {
+	v.check(value != "", name+" is required")
+}
+
+func (v *validator) positive(name string, value int) {
+	v.check(value > 0, name+" has to be positive")
+}
+
+func (v *validator) port(name string, value int) {
+	v.check(value > 0 && value <= 65535, name+" has to be a port between 1 and 65535")
+}
+
+func (v *validator) oneOf(name string, value string, allowed ...string) {
+	for _, a := range allowed {
+		if value == a {
+			return
+		}
+	}
+	v.fail(fmt.Sprintf("%s has to be one of %s", name, strings.Join(allowed, ", ")))
+}
+
+// url accepts absolute http and https urls
+func (v *validator) url(name string, value string) {
+	u, err := url.Parse(value)
+	v.check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", name+" has to be an absolute http or https url")
+}
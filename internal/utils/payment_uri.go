@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"errors"
+	"math/big"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/CHainGate/backend/pkg/enum"
+)
+
+// ethereumMainChainId is the chain id of the ethereum mainnet (EIP-155)
+const ethereumMainChainId = 1
+
+// PaymentUri creates the wallet deep link for a payment.
+// Bitcoin uses BIP21 with the amount in BTC, ethereum uses EIP-681 with the value in wei.
+func PaymentUri(currency enum.CryptoCurrency, mode enum.Mode, address string, amount big.Int) (string, error) {
+	switch currency {
+	case enum.BTC:
+		btcAmount, err := ConvertAmountToBaseString(currency, amount)
+		if err != nil {
+			return "", err
+		}
+		uri := "bitcoin:" + address
+		if amount.Sign() > 0 {
+			uri += "?" + url.Values{"amount": {TrimAmount(btcAmount)}}.Encode()
+		}
+		return uri, nil
+	case enum.ETH:
+		chainId := ethereumMainChainId
+		if mode == enum.Test {
+			chainId = Opts.EthereumTestChainId
+		}
+		uri := "ethereum:" + address + "@" + strconv.Itoa(chainId)
+		if amount.Sign() > 0 {
+			uri += "?value=" + amount.String()
+		}
+		return uri, nil
+	}
+	return "", errors.New("no payment uri for currency " + currency.String())
+}
+
+// TrimAmount removes the trailing zeros of a decimal amount
+func TrimAmount(amount string) string {
+	if !strings.Contains(amount, ".") {
+		return amount
+	}
+	return strings.TrimRight(strings.TrimRight(amount, "0"), ".")
+}
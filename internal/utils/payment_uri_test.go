@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/CHainGate/backend/pkg/enum"
+)
+
+func TestPaymentUri(t *testing.T) {
+	Opts = &OptsType{EthereumTestChainId: 5}
+	tests := []struct {
+		currency enum.CryptoCurrency
+		mode     enum.Mode
+		address  string
+		amount   string
+		expected string
+	}{
+		{enum.BTC, enum.Main, "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq", "150000", "bitcoin:bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq?amount=0.0015"},
+		{enum.BTC, enum.Test, "tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx", "100000000", "bitcoin:tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx?amount=1"},
+		{enum.BTC, enum.Main, "bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq", "0", "bitcoin:bc1qar0srrr7xfkvy5l643lydnw9re59gtzzwf5mdq"},
+		{enum.ETH, enum.Main, "0x1dB3439a222C519ab44bb1144fC28167b4Fa6EE6", "12300000000000000", "ethereum:0x1dB3439a222C519ab44bb1144fC28167b4Fa6EE6@1?value=12300000000000000"},
+		{enum.ETH, enum.Test, "0x1dB3439a222C519ab44bb1144fC28167b4Fa6EE6", "1", "ethereum:0x1dB3439a222C519ab44bb1144fC28167b4Fa6EE6@5?value=1"},
+	}
+
+	for _, test := range tests {
+		amount, _ := new(big.Int).SetString(test.amount, 10)
+		uri, err := PaymentUri(test.currency, test.mode, test.address, *amount)
+		if err != nil {
+			t.Fatalf("PaymentUri: got error %s", err.Error())
+		}
+		if uri != test.expected {
+			t.Errorf("Expected uri %s, but got %s", test.expected, uri)
+		}
+	}
+
+	_, err := PaymentUri(enum.NOT_SELECTED, enum.Main, "", *big.NewInt(1))
+	if err == nil {
+		t.Errorf("Expected an error for a payment without currency")
+	}
+}
+
+func TestTrimAmount(t *testing.T) {
+	tests := map[string]string{"0.012300000000000000": "0.0123", "1.00000000": "1", "0": "0", "10": "10"}
+	for amount, expected := range tests {
+		if TrimAmount(amount) != expected {
+			t.Errorf("Expected %s to be trimmed to %s, but got %s", amount, expected, TrimAmount(amount))
+		}
+	}
+}
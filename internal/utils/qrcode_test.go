@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const qrTestContent = "bitcoin:tb1qw508d6qejxtdg4y5r3zarvary0c5xw7kxpjzsx?amount=0.001"
+
+func TestQrCodePng(t *testing.T) {
+	png, err := QrCodePng(qrTestContent, 256)
+	if err != nil {
+		t.Fatalf("QrCodePng: got error %s", err.Error())
+	}
+	if !bytes.HasPrefix(png, []byte("\x89PNG")) {
+		t.Errorf("Expected a PNG image")
+	}
+}
+
+func TestQrCodeSvg(t *testing.T) {
+	svg, err := QrCodeSvg(qrTestContent)
+	if err != nil {
+		t.Fatalf("QrCodeSvg: got error %s", err.Error())
+	}
+	if !strings.HasPrefix(svg, "<svg") || !strings.HasSuffix(svg, "</svg>") {
+		t.Errorf("Expected a svg element, but got %s", svg)
+	}
+	if !strings.Contains(svg, "h1v1h-1z") {
+		t.Errorf("Expected the svg to contain modules")
+	}
+}
@@ -0,0 +1,27 @@
+package enum
+
+import "strings"
+
+type Role int
+
+const (
+	Owner Role = iota + 1
+	Admin
+	Developer
+	Finance
+)
+
+func (r Role) String() string {
+	return [...]string{"owner", "admin", "developer", "finance"}[r-1]
+}
+
+func ParseStringToRoleEnum(str string) (Role, bool) {
+	capabilitiesMap := map[string]Role{
+		"owner":     Owner,
+		"admin":     Admin,
+		"developer": Developer,
+		"finance":   Finance,
+	}
+	c, ok := capabilitiesMap[strings.ToLower(str)]
+	return c, ok
+}
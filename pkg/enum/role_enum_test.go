@@ -0,0 +1,53 @@
+package enum
+
+import "testing"
+
+type RoleEnumString struct {
+	enum   Role
+	name   string
+	string string
+}
+
+var roleEnumTests = []RoleEnumString{
+	{
+		enum:   Owner,
+		name:   "Owner",
+		string: "owner",
+	},
+	{
+		enum:   Admin,
+		name:   "Admin",
+		string: "admin",
+	},
+	{
+		enum:   Developer,
+		name:   "Developer",
+		string: "developer",
+	},
+	{
+		enum:   Finance,
+		name:   "Finance",
+		string: "finance",
+	},
+}
+
+func TestRole_String(t *testing.T) {
+	for _, test := range roleEnumTests {
+		output := test.enum.String()
+		if output != test.string {
+			t.Errorf("Expected string of enum %s, to be %s, but got %s", test.name, test.string, output)
+		}
+	}
+}
+
+func TestParseStringToRoleEnum(t *testing.T) {
+	for _, test := range roleEnumTests {
+		output, ok := ParseStringToRoleEnum(test.string)
+		if output != test.enum {
+			t.Errorf("Expected string %s, to be parsed as enum %s, but got %s", test.string, test.name, output)
+		}
+		if !ok {
+			t.Errorf("An error happend in ParseStringToRoleEnum!")
+		}
+	}
+}
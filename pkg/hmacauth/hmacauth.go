@@ -0,0 +1,149 @@
+// Package hmacauth signs and verifies requests between the backend and the blockchain services
+// with a shared secret. The signature covers the timestamp, method, path and body of the request.
+package hmacauth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const TimestampHeader = "X-Chaingate-Timestamp"
+const SignatureHeader = "X-Chaingate-Signature"
+
+// MaxClockSkew is how old or early a request may be, replays are rejected within this window
+const MaxClockSkew = time.Minute * 5
+const maxBodyBytes = 1 << 20
+
+var (
+	ErrNoSecret         = errors.New("no secret configured")
+	ErrMissingSignature = errors.New("request is not signed")
+	ErrInvalidSignature = errors.New("invalid signature")
+	ErrExpiredTimestamp = errors.New("timestamp expired")
+	ErrReplayed         = errors.New("request was already received")
+	ErrBodyTooLarge     = errors.New("request body too large")
+)
+
+// Sign returns the hex encoded HMAC-SHA256 of "timestamp.method.path.body"
+func Sign(secret []byte, timestamp int64, method string, path string, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "." + method + "." + path + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// SignRequest sets the timestamp and signature headers, the body can still be read afterwards
+func SignRequest(r *http.Request, secret []byte, now time.Time) error {
+	if len(secret) == 0 {
+		return ErrNoSecret
+	}
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	timestamp := now.Unix()
+	r.Header.Set(TimestampHeader, strconv.FormatInt(timestamp, 10))
+	r.Header.Set(SignatureHeader, Sign(secret, timestamp, r.Method, r.URL.RequestURI(), body))
+	return nil
+}
+
+// Verifier checks the signature of incoming requests and remembers the signatures to reject replays
+type Verifier struct {
+	secret []byte
+	mu     sync.Mutex
+	seen   map[string]time.Time
+	now    func() time.Time
+}
+
+func NewVerifier(secret []byte) *Verifier {
+	return &Verifier{secret: secret, seen: map[string]time.Time{}, now: time.Now}
+}
+
+// Verify checks the request, the body can still be read afterwards
+func (v *Verifier) Verify(r *http.Request) error {
+	if len(v.secret) == 0 {
+		return ErrNoSecret
+	}
+	signature := r.Header.Get(SignatureHeader)
+	timestampHeader := r.Header.Get(TimestampHeader)
+	if signature == "" || timestampHeader == "" {
+		return ErrMissingSignature
+	}
+	timestamp, err := strconv.ParseInt(timestampHeader, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	now := v.now()
+	age := now.Sub(time.Unix(timestamp, 0))
+	if age > MaxClockSkew || age < -MaxClockSkew {
+		return ErrExpiredTimestamp
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+	expected := Sign(v.secret, timestamp, r.Method, r.URL.RequestURI(), body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		return ErrInvalidSignature
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for seenSignature, expiresAt := range v.seen {
+		if now.After(expiresAt) {
+			delete(v.seen, seenSignature)
+		}
+	}
+	if _, ok := v.seen[signature]; ok {
+		return ErrReplayed
+	}
+	v.seen[signature] = time.Unix(timestamp, 0).Add(MaxClockSkew)
+	return nil
+}
+
+// Transport signs all requests of a http client
+type Transport struct {
+	Secret []byte
+	Base   http.RoundTripper
+}
+
+func (t *Transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	// the clone shares the body, which is consumed by the transport anyway
+	signed := r.Clone(r.Context())
+	err := SignRequest(signed, t.Secret, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return base.RoundTrip(signed)
+}
+
+// readBody reads the body and replaces it, so it can be read again
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil, nil
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxBodyBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxBodyBytes {
+		return nil, ErrBodyTooLarge
+	}
+	_ = r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
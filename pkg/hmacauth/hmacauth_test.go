@@ -0,0 +1,74 @@
+package hmacauth
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+var secret = []byte("shared-secret")
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1650000000, 0)
+	verifier := NewVerifier(secret)
+	verifier.now = func() time.Time { return now }
+
+	r := httptest.NewRequest("PUT", "/api/internal/payment/webhook", strings.NewReader(`{"paymentState":"finished"}`))
+	if err := SignRequest(r, secret, now); err != nil {
+		t.Fatalf("SignRequest: got error %s", err.Error())
+	}
+	if err := verifier.Verify(r); err != nil {
+		t.Fatalf("Expected signed request to be valid, but got %s", err.Error())
+	}
+	body, _ := io.ReadAll(r.Body)
+	if string(body) != `{"paymentState":"finished"}` {
+		t.Errorf("Expected body to be readable after verification, but got %s", body)
+	}
+
+	r.Body = io.NopCloser(strings.NewReader(string(body)))
+	if err := verifier.Verify(r); !errors.Is(err, ErrReplayed) {
+		t.Errorf("Expected error %v, but got %v", ErrReplayed, err)
+	}
+}
+
+func TestVerifyRejects(t *testing.T) {
+	now := time.Unix(1650000000, 0)
+	tests := []struct {
+		name   string
+		modify func(r *http.Request)
+		want   error
+	}{
+		{"unsigned", func(r *http.Request) { r.Header.Del(SignatureHeader) }, ErrMissingSignature},
+		{"tampered body", func(r *http.Request) { r.Body = io.NopCloser(strings.NewReader(`{"paymentState":"finished"}`)) }, ErrInvalidSignature},
+		{"other path", func(r *http.Request) { r.URL.Path = "/api/internal/other" }, ErrInvalidSignature},
+		{"old timestamp", func(r *http.Request) {
+			r.Header.Set(TimestampHeader, "1649999000")
+		}, ErrExpiredTimestamp},
+	}
+	for _, tt := range tests {
+		verifier := NewVerifier(secret)
+		verifier.now = func() time.Time { return now }
+		r := httptest.NewRequest("PUT", "/api/internal/payment/webhook", strings.NewReader(`{"paymentState":"paid"}`))
+		if err := SignRequest(r, secret, now); err != nil {
+			t.Fatalf("SignRequest: got error %s", err.Error())
+		}
+		tt.modify(r)
+		if err := verifier.Verify(r); !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected error %v, but got %v", tt.name, tt.want, err)
+		}
+	}
+}
+
+func TestVerifyWithoutSecret(t *testing.T) {
+	r := httptest.NewRequest("PUT", "/api/internal/payment/webhook", nil)
+	if err := SignRequest(r, nil, time.Now()); !errors.Is(err, ErrNoSecret) {
+		t.Errorf("Expected error %v, but got %v", ErrNoSecret, err)
+	}
+	if err := NewVerifier(nil).Verify(r); !errors.Is(err, ErrNoSecret) {
+		t.Errorf("Expected error %v, but got %v", ErrNoSecret, err)
+	}
+}
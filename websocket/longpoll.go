@@ -0,0 +1,93 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CHainGate/backend/internal/config"
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+)
+
+const (
+	defaultLongPollTimeout = 25 * time.Second
+	maxLongPollTimeout     = 55 * time.Second
+	// longPollWriteWait is the time allowed to write the events after the poll
+	longPollWriteWait = 10 * time.Second
+)
+
+// ServeLongPoll returns the events after the lastEventId query parameter. If there are none
+// yet the request is held open until an event arrives or the timeout (in seconds) is over.
+// Without lastEventId or if it is not resumable anymore the current state is returned immediately.
+func ServeLongPoll(w http.ResponseWriter, r *http.Request, paymentRepository repository.IPaymentRepository) {
+	payment, ok := findPayment(w, r, paymentRepository)
+	if !ok {
+		return
+	}
+
+	lastEventId, resume := parseLastEventId(r.URL.Query().Get("lastEventId"), r.Header.Get("Last-Event-ID"))
+
+	timeout := defaultLongPollTimeout
+	if seconds, err := strconv.Atoi(r.URL.Query().Get("timeout")); err == nil && seconds >= 0 {
+		timeout = time.Duration(seconds) * time.Second
+		if timeout > maxLongPollTimeout {
+			timeout = maxLongPollTimeout
+		}
+	}
+
+	// the poll may be longer than the write timeout of the server
+	_ = http.NewResponseController(w).SetWriteDeadline(time.Now().Add(timeout + longPollWriteWait))
+
+	pool := config.AcquirePool(payment.ID)
+	defer config.ReleasePool(pool)
+	if !resume || !pool.CanResume(lastEventId) {
+		writeEvents(w, []model.Event{{Id: pool.LastEventId(), Message: model.NewInitialMessage(payment)}})
+		return
+	}
+
+	subscriber := model.NewSubscriber(lastEventId)
+	pool.Subscribe <- subscriber
+	defer func() { pool.Unsubscribe <- subscriber }()
+
+	events := make([]model.Event, 0)
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-r.Context().Done():
+		return
+	case <-timer.C:
+	case event, ok := <-subscriber.Events:
+		if ok {
+			events = append(events, event)
+			events = append(events, drainEvents(subscriber)...)
+		}
+	}
+
+	writeEvents(w, events)
+}
+
+// drainEvents collects the events which are already waiting in the subscriber channel
+func drainEvents(subscriber *model.Subscriber) []model.Event {
+	var events []model.Event
+	for {
+		select {
+		case event, ok := <-subscriber.Events:
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func writeEvents(w http.ResponseWriter, events []model.Event) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(events)
+}
@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/CHainGate/backend/internal/config"
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/pkg/enum"
+)
+
+func poll(t *testing.T, url string) []model.Event {
+	t.Helper()
+	response, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Get: got error %s", err.Error())
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, but got %d", http.StatusOK, response.StatusCode)
+	}
+	var events []model.Event
+	if err := json.NewDecoder(response.Body).Decode(&events); err != nil {
+		t.Fatalf("Decode: got error %s", err.Error())
+	}
+	return events
+}
+
+func TestServeLongPoll(t *testing.T) {
+	server, payment := newStreamServer(t)
+	url := server.URL + "/api/public/payment/" + payment.ID.String() + "/poll"
+
+	events := poll(t, url)
+	if len(events) != 1 || events[0].Id != 1 || events[0].Message.MessageType != enum.CurrencySelection.String() {
+		t.Fatalf("Expected the initial state with id 1, but got %+v", events)
+	}
+
+	// the poll is held open until the event is published
+	pool, ok := config.GetPool(payment.ID)
+	if !ok {
+		t.Fatal("Expected the pool to be kept between the polls")
+	}
+	published := make(chan struct{})
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		pool.Publish(model.Message{MessageType: "waiting"})
+		close(published)
+	}()
+	events = poll(t, url+"?lastEventId=1&timeout=5")
+	if len(events) != 1 || events[0].Id != 2 || events[0].Message.MessageType != "waiting" {
+		t.Fatalf("Expected event 2 with message waiting, but got %+v", events)
+	}
+	<-published
+
+	// the event is published between two polls
+	publish(t, payment, "paid")
+	events = poll(t, url+"?lastEventId=2&timeout=5")
+	if len(events) != 1 || events[0].Id != 3 || events[0].Message.MessageType != "paid" {
+		t.Fatalf("Expected the missed event 3 with message paid, but got %+v", events)
+	}
+
+	events = poll(t, url+"?lastEventId=3&timeout=0")
+	if len(events) != 0 {
+		t.Errorf("Expected no events after the timeout, but got %+v", events)
+	}
+}
+
+func TestServeLongPollInitialStateWithoutResumableId(t *testing.T) {
+	server, payment := newStreamServer(t)
+	url := server.URL + "/api/public/payment/" + payment.ID.String() + "/poll"
+
+	for _, lastEventId := range []string{"0", "42", "invalid"} {
+		events := poll(t, url+"?timeout=5&lastEventId="+lastEventId)
+		if len(events) != 1 || events[0].Id != 1 || events[0].Message.MessageType != enum.CurrencySelection.String() {
+			t.Errorf("Expected the initial state with id 1 for last event id %s, but got %+v", lastEventId, events)
+		}
+	}
+}
@@ -1,75 +1,104 @@
 package websocket
 
 import (
-	"fmt"
+	"context"
+	"errors"
+	"log/slog"
 	"net/http"
-	"time"
 
+	"github.com/CHainGate/backend/internal/config"
 	"github.com/CHainGate/backend/internal/model"
+	"gorm.io/gorm"
 
 	"github.com/CHainGate/backend/internal/repository"
 	"github.com/CHainGate/backend/internal/service"
 	"github.com/CHainGate/backend/pkg/enum"
 	"github.com/google/uuid"
+	"github.com/gorilla/websocket"
 )
 
-func ServeWs(pool *model.Pool, w http.ResponseWriter, r *http.Request, publicPaymentService service.IPublicPaymentService, paymentRepository repository.IPaymentRepository, paymentId uuid.UUID) {
-	fmt.Println("WebSocket Endpoint Hit")
+// Close codes of the private range (4000-4999) sent before the connection is closed
+const (
+	CloseInvalidPaymentId = 4000
+	ClosePaymentNotFound  = 4004
+	CloseInternalError    = 4500
+)
+
+func ServeWs(w http.ResponseWriter, r *http.Request, publicPaymentService service.IPublicPaymentService, paymentRepository repository.IPaymentRepository) {
 	conn, err := Upgrade(w, r)
 	if err != nil {
-		fmt.Fprintf(w, "%+v\n", err)
+		return
 	}
 
-	client := &model.Client{
-		Conn: conn,
-		Pool: pool,
+	paymentId, err := uuid.Parse(r.URL.Query().Get("pid"))
+	if err != nil {
+		closeWithCode(conn, CloseInvalidPaymentId, "invalid payment id")
+		return
 	}
 
-	pool.Register <- client
-
-	payment, err := paymentRepository.FindByPaymentId(paymentId)
+	payment, err := paymentRepository.FindByPaymentId(r.Context(), paymentId)
 	if err != nil {
-		fmt.Fprintf(w, "%+v\n", err)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			closeWithCode(conn, ClosePaymentNotFound, "payment not found")
+			return
+		}
+		closeWithCode(conn, CloseInternalError, "payment could not be loaded")
+		return
 	}
 
-	state := payment.PaymentStates[0].PaymentState
-	var body model.SocketBody
-	if state != enum.CurrencySelection {
-		body = model.SocketBody{
-			InitialState:   true,
-			Currency:       payment.PayCurrency.String(),
-			PayAddress:     payment.PayAddress,
-			PayAmount:      payment.PaymentStates[0].PayAmount.String(),
-			ActuallyPaid:   payment.PaymentStates[0].ActuallyPaid.String(),
-			ExpireTime:     model.GetWaitingCreateDate(payment).Add(15 * time.Minute),
-			Mode:           payment.Mode.String(),
-			SuccessPageURL: payment.SuccessPageUrl,
-			FailurePageURL: payment.FailurePageUrl,
+	slog.DebugContext(r.Context(), "WebSocket connected", "payment_id", paymentId)
+	pool := config.AcquirePool(paymentId)
+	defer config.ReleasePool(pool)
+	client := model.NewClient(conn, pool)
+	// queued before the client is registered, so a broadcast cannot overtake it
+	client.Queue(model.NewInitialMessage(payment))
+	pool.Register <- client
+
+	go client.WritePump()
+	client.ReadPump(func(message *model.ClientMessage) {
+		handleClientMessage(r.Context(), client, message, paymentId, publicPaymentService, paymentRepository)
+	})
+}
+
+func handleClientMessage(ctx context.Context, client *model.Client, message *model.ClientMessage, paymentId uuid.UUID, publicPaymentService service.IPublicPaymentService, paymentRepository repository.IPaymentRepository) {
+	switch message.Type {
+	case model.SelectCurrencyMessage:
+		payment, err := paymentRepository.FindByPaymentId(ctx, paymentId)
+		if err != nil {
+			client.SendError(model.PaymentFailedError, "payment could not be loaded")
+			return
+		}
+		if payment.PaymentStates[0].PaymentState != enum.CurrencySelection {
+			client.SendError(model.InvalidStateError, "currency already selected")
+			return
+		}
+		// the new state is broadcast to all clients of the pool
+		_, err = publicPaymentService.HandleNewInvoice(ctx, payment, message.Currency)
+		if err != nil {
+			client.SendError(model.PaymentFailedError, err.Error())
+		}
+	case model.ChangeCurrencyMessage:
+		payment, err := paymentRepository.FindByPaymentId(ctx, paymentId)
+		if err != nil {
+			client.SendError(model.PaymentFailedError, "payment could not be loaded")
+			return
+		}
+		_, err = publicPaymentService.HandleCurrencyChange(ctx, payment, message.Currency)
+		if errors.Is(err, service.ErrCurrencyChangeNotAllowed) || errors.Is(err, service.ErrSameCurrency) {
+			client.SendError(model.InvalidStateError, err.Error())
+			return
+		}
+		if errors.Is(err, service.ErrNoWalletForCurrency) {
+			client.SendError(model.InvalidCurrencyError, err.Error())
+			return
+		}
+		if err != nil {
+			client.SendError(model.PaymentFailedError, err.Error())
 		}
 	}
+}
 
-	switch state {
-	case enum.CurrencySelection:
-		client.SendInitialCoins()
-		currency := client.Read()
-		payCurrency, _ := enum.ParseStringToCryptoCurrencyEnum(currency)
-		publicPaymentService.HandleNewInvoice(payment, payCurrency)
-	case enum.Waiting:
-		client.SendWaiting(body)
-	case enum.PartiallyPaid:
-		client.SendPartiallyPaid(body)
-	case enum.Paid:
-		client.SendReceivedTX(body)
-	case enum.Confirmed:
-		client.SendConfirmed(body)
-	case enum.Forwarded:
-		client.SendConfirmed(body)
-	case enum.Finished:
-		client.SendConfirmed(body)
-	case enum.Expired:
-		client.SendExpired(body)
-	case enum.Failed:
-		client.SendFailed(body)
-	}
-	client.Read()
+func closeWithCode(conn *websocket.Conn, code int, reason string) {
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason))
+	conn.Close()
 }
@@ -0,0 +1,122 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CHainGate/backend/internal/config"
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// sseKeepAliveInterval keeps proxies from closing an idle event stream
+const sseKeepAliveInterval = 15 * time.Second
+
+// sseWriteWait is the time allowed to write an event, the stream itself is open longer than the write timeout of the server
+const sseWriteWait = 10 * time.Second
+
+// ServeSse streams the payment updates as server-sent events. A reconnecting
+// EventSource sends the Last-Event-ID header and only receives the missed events.
+func ServeSse(w http.ResponseWriter, r *http.Request, paymentRepository repository.IPaymentRepository) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	payment, ok := findPayment(w, r, paymentRepository)
+	if !ok {
+		return
+	}
+
+	lastEventId, resume := parseLastEventId(r.Header.Get("Last-Event-ID"), r.URL.Query().Get("lastEventId"))
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	controller := http.NewResponseController(w)
+	_ = controller.SetWriteDeadline(time.Now().Add(sseWriteWait))
+	w.WriteHeader(http.StatusOK)
+
+	pool := config.AcquirePool(payment.ID)
+	defer config.ReleasePool(pool)
+	if !resume || !pool.CanResume(lastEventId) {
+		lastEventId = pool.LastEventId()
+		err := writeSseEvent(w, model.Event{Id: lastEventId, Message: model.NewInitialMessage(payment)})
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+	}
+
+	subscriber := model.NewSubscriber(lastEventId)
+	pool.Subscribe <- subscriber
+	defer func() { pool.Unsubscribe <- subscriber }()
+
+	keepAlive := time.NewTicker(sseKeepAliveInterval)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-subscriber.Events:
+			if !ok {
+				return
+			}
+			_ = controller.SetWriteDeadline(time.Now().Add(sseWriteWait))
+			if err := writeSseEvent(w, event); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			_ = controller.SetWriteDeadline(time.Now().Add(sseWriteWait))
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeSseEvent(w http.ResponseWriter, event model.Event) error {
+	data, err := json.Marshal(event.Message)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "id: %d\ndata: %s\n\n", event.Id, data)
+	return err
+}
+
+func findPayment(w http.ResponseWriter, r *http.Request, paymentRepository repository.IPaymentRepository) (*model.Payment, bool) {
+	paymentId, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "invalid payment id", http.StatusBadRequest)
+		return nil, false
+	}
+
+	payment, err := paymentRepository.FindByPaymentId(r.Context(), paymentId)
+	if err != nil {
+		http.Error(w, "payment not found", http.StatusNotFound)
+		return nil, false
+	}
+	return payment, true
+}
+
+// parseLastEventId returns the first event id which is set, false if the client did not send one
+func parseLastEventId(values ...string) (uint64, bool) {
+	for _, value := range values {
+		if value == "" {
+			continue
+		}
+		lastEventId, err := strconv.ParseUint(value, 10, 64)
+		return lastEventId, err == nil
+	}
+	return 0, false
+}
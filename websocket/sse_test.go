@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CHainGate/backend/internal/config"
+	"github.com/CHainGate/backend/internal/model"
+	"github.com/CHainGate/backend/internal/repository/memory"
+	"github.com/CHainGate/backend/pkg/enum"
+	"github.com/gorilla/mux"
+)
+
+// newStreamServer serves the event stream and the long-poll of a payment waiting for a currency selection
+func newStreamServer(t *testing.T) (*httptest.Server, *model.Payment) {
+	t.Helper()
+	repos := memory.NewRepositories(memory.NewDB())
+	merchant := &model.Merchant{Email: "momo@mail.com"}
+	if err := repos.Merchant.Create(context.Background(), merchant); err != nil {
+		t.Fatalf("Create: got error %s", err.Error())
+	}
+	payment := &model.Payment{
+		MerchantId:    merchant.ID,
+		Mode:          enum.Test,
+		PriceAmount:   10,
+		PriceCurrency: enum.USD,
+		PaymentStates: []model.PaymentState{{PaymentState: enum.CurrencySelection, PayAmount: model.NewBigIntFromInt(0)}},
+	}
+	if err := repos.Payment.Create(context.Background(), payment); err != nil {
+		t.Fatalf("Create: got error %s", err.Error())
+	}
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/public/payment/{id}/events", func(w http.ResponseWriter, r *http.Request) {
+		ServeSse(w, r, repos.Payment)
+	})
+	router.HandleFunc("/api/public/payment/{id}/poll", func(w http.ResponseWriter, r *http.Request) {
+		ServeLongPoll(w, r, repos.Payment)
+	})
+	server := httptest.NewServer(router)
+	t.Cleanup(server.Close)
+	return server, payment
+}
+
+// publish broadcasts a message to the pool of the payment, it has to exist already
+func publish(t *testing.T, payment *model.Payment, messageType string) {
+	t.Helper()
+	pool, ok := config.GetPool(payment.ID)
+	if !ok {
+		t.Fatal("Expected a pool for the payment")
+	}
+	pool.Publish(model.Message{MessageType: messageType})
+}
+
+func openEventStream(t *testing.T, ctx context.Context, url string, lastEventId string) *bufio.Reader {
+	t.Helper()
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		t.Fatalf("NewRequest: got error %s", err.Error())
+	}
+	if lastEventId != "" {
+		request.Header.Set("Last-Event-ID", lastEventId)
+	}
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		t.Fatalf("Do: got error %s", err.Error())
+	}
+	t.Cleanup(func() { response.Body.Close() })
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status %d, but got %d", http.StatusOK, response.StatusCode)
+	}
+	return bufio.NewReader(response.Body)
+}
+
+// readSseEvent returns the id and data lines of the next event, keep-alive comments are skipped
+func readSseEvent(t *testing.T, reader *bufio.Reader) (string, string) {
+	t.Helper()
+	var id, data string
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			t.Fatalf("ReadString: got error %s", err.Error())
+		}
+		line = strings.TrimSuffix(line, "\n")
+		switch {
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		case line == "" && id != "":
+			return id, data
+		}
+	}
+}
+
+func TestServeSseResumesAfterReconnect(t *testing.T) {
+	server, payment := newStreamServer(t)
+	url := server.URL + "/api/public/payment/" + payment.ID.String() + "/events"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	reader := openEventStream(t, ctx, url, "")
+	id, data := readSseEvent(t, reader)
+	if id != "1" || !strings.Contains(data, enum.CurrencySelection.String()) {
+		t.Fatalf("Expected the initial state with id 1, but got %s with %s", id, data)
+	}
+
+	publish(t, payment, "waiting")
+	id, data = readSseEvent(t, reader)
+	if id != "2" || !strings.Contains(data, "waiting") {
+		t.Fatalf("Expected event 2 with message waiting, but got %s with %s", id, data)
+	}
+	cancel()
+
+	// the event is published while the event source is reconnecting
+	publish(t, payment, "paid")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reader = openEventStream(t, ctx, url, "2")
+	id, data = readSseEvent(t, reader)
+	if id != "3" || !strings.Contains(data, "paid") {
+		t.Errorf("Expected the missed event 3 with message paid, but got %s with %s", id, data)
+	}
+}
+
+func TestServeSseInitialStateWithoutResumableId(t *testing.T) {
+	server, payment := newStreamServer(t)
+	url := server.URL + "/api/public/payment/" + payment.ID.String() + "/events"
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	reader := openEventStream(t, ctx, url, "42")
+	id, data := readSseEvent(t, reader)
+	if id != "1" || !strings.Contains(data, enum.CurrencySelection.String()) {
+		t.Errorf("Expected the initial state with id 1, but got %s with %s", id, data)
+	}
+}